@@ -1,5 +1,7 @@
 package flamingo
 
+import "time"
+
 // AnswerChecker is a function that will determine if the provided
 // answer is correct analysing the answer Message. If the function
 // returns nil it is assumed the answer is valid, otherwise it is
@@ -78,6 +80,18 @@ type Bot interface {
 	// the given user. A call to InvokeAction does not block, it adds an action to the
 	// action queue and it will be processed asynchronously.
 	InvokeAction(id string, user User, action UserAction)
+
+	// History returns the last n entries of the message log of the current
+	// conversation, from the oldest to the newest, or all of them if n is
+	// zero. It returns ErrNoMessageLog if the client has no message log.
+	History(n int) ([]LogEntry, error)
+
+	// HistoryBetween returns the last n entries of the message log of the
+	// current conversation that were logged at or after since and before
+	// until, from the oldest to the newest. Zero times and a zero n do not
+	// limit the entries. It returns ErrNoMessageLog if the client has no
+	// message log.
+	HistoryBetween(since, until time.Time, n int) ([]LogEntry, error)
 }
 
 // Sendable is the interface that must implements structs that are being sent by a Bot
@@ -0,0 +1,280 @@
+package flamingo
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBroadcastConcurrency is the number of messages sent at the same
+// time during a broadcast if no concurrency is specified.
+const DefaultBroadcastConcurrency = 4
+
+// BroadcastOptions are the options to control how a broadcast is delivered.
+type BroadcastOptions struct {
+	// Concurrency is the maximum number of messages being sent at the same
+	// time. If zero, DefaultBroadcastConcurrency will be used.
+	Concurrency int
+	// Interval is the minimum time between two messages sent by the same bot.
+	// It is used to stay under the rate limits of the platform.
+	Interval time.Duration
+	// Retries is the number of times the delivery to a conversation is retried
+	// after failing with a transient error.
+	Retries int
+	// RetryDelay is the time to wait before the first retry. It is doubled
+	// after every retry. If zero, one second will be used.
+	RetryDelay time.Duration
+	// IsTransient reports if an error is transient and the delivery can be
+	// retried. If nil, the platform-specific default of the client is used.
+	IsTransient func(error) bool
+	// Progress, if not nil, is called after every delivery with the delivery
+	// itself, the number of finished deliveries and the total number of them.
+	// Calls are never made concurrently.
+	Progress func(delivery Delivery, done, total int)
+	// Clock is the clock used to wait between messages and retries. If nil,
+	// the system time is used.
+	Clock Clock
+}
+
+// BroadcastTarget is a conversation a message is going to be broadcasted to.
+type BroadcastTarget struct {
+	// BotID is the ID of the bot the conversation belongs to.
+	BotID string
+	// Channel is the channel of the conversation.
+	Channel Channel
+	// Conversation is the stored data of the conversation.
+	Conversation StoredConversation
+	// Bot is the bot that will be used to send the message. It is not set
+	// yet when the target is passed to a BroadcastFilter.
+	Bot Bot
+}
+
+// Delivery is the result of sending a broadcasted message to a single
+// conversation.
+type Delivery struct {
+	// BotID is the ID of the bot that sent the message.
+	BotID string
+	// ChannelID is the ID of the channel the message was sent to.
+	ChannelID string
+	// MessageID is the ID of the message sent, if it was delivered.
+	MessageID string
+	// Attempts is the number of times the message was tried to be sent.
+	Attempts int
+	// Error is the text of the last error that occurred, if the message
+	// could not be delivered. It is a string so the delivery can be persisted.
+	Error string
+	// Time is the time of the last attempt.
+	Time time.Time
+}
+
+// Delivered reports whether the message was delivered.
+func (d Delivery) Delivered() bool {
+	return d.Error == ""
+}
+
+// BroadcastReport is the report of the deliveries of a broadcast.
+type BroadcastReport struct {
+	// Start is the time the broadcast started.
+	Start time.Time
+	// End is the time the broadcast finished.
+	End time.Time
+	// Deliveries are the deliveries to every conversation.
+	Deliveries []Delivery
+}
+
+// Bots returns the number of bots that took part in the broadcast.
+func (r BroadcastReport) Bots() uint64 {
+	var bots = make(map[string]struct{})
+	for _, d := range r.Deliveries {
+		bots[d.BotID] = struct{}{}
+	}
+	return uint64(len(bots))
+}
+
+// Conversations returns the number of conversations the message was
+// broadcasted to.
+func (r BroadcastReport) Conversations() uint64 {
+	return uint64(len(r.Deliveries))
+}
+
+// Errors returns the number of conversations the message could not be
+// delivered to.
+func (r BroadcastReport) Errors() uint64 {
+	var errors uint64
+	for _, d := range r.Deliveries {
+		if !d.Delivered() {
+			errors++
+		}
+	}
+	return errors
+}
+
+// Err returns ErrAllMessagesLost if no message was delivered,
+// ErrSomeMessagesLost if some of them were not delivered or nil
+// if all the messages were delivered.
+func (r BroadcastReport) Err() error {
+	errors := r.Errors()
+	if errors == r.Conversations() {
+		return ErrAllMessagesLost
+	} else if errors > 0 {
+		return ErrSomeMessagesLost
+	}
+	return nil
+}
+
+// Send sends the given Sendable using the bot and returns the ID of the
+// message sent.
+func Send(bot Bot, msg Sendable) (string, error) {
+	switch msg := msg.(type) {
+	case OutgoingMessage:
+		return bot.Say(msg)
+	case Form:
+		return bot.Form(msg)
+	case Image:
+		return bot.Image(msg)
+	}
+	return "", ErrInvalidMessage
+}
+
+// IsTemporary reports whether the error reports itself as temporary or as a
+// timeout, as network errors do.
+func IsTemporary(err error) bool {
+	if e, ok := err.(interface {
+		Temporary() bool
+	}); ok && e.Temporary() {
+		return true
+	}
+
+	if e, ok := err.(interface {
+		Timeout() bool
+	}); ok && e.Timeout() {
+		return true
+	}
+
+	return false
+}
+
+// RunBroadcast sends the message to all the targets according to the given
+// options and returns a channel where the report will be sent once all the
+// deliveries have finished. It is meant to be used by the implementations of
+// Broadcaster.
+func RunBroadcast(msg Sendable, targets []BroadcastTarget, opts BroadcastOptions) <-chan BroadcastReport {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = DefaultBroadcastConcurrency
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+
+	if opts.IsTransient == nil {
+		opts.IsTransient = IsTemporary
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	var (
+		report  = make(chan BroadcastReport, 1)
+		pending = make(chan int, len(targets))
+		done    = make(chan int, len(targets))
+		limiter = newRateLimiter(opts.Interval, opts.Clock)
+		result  = BroadcastReport{
+			Start:      opts.Clock.Now(),
+			Deliveries: make([]Delivery, len(targets)),
+		}
+	)
+
+	for i := range targets {
+		pending <- i
+	}
+	close(pending)
+
+	for i := 0; i < opts.Concurrency; i++ {
+		go func() {
+			for idx := range pending {
+				result.Deliveries[idx] = deliver(msg, targets[idx], limiter, opts)
+				done <- idx
+			}
+		}()
+	}
+
+	go func() {
+		for i := 0; i < len(targets); i++ {
+			idx := <-done
+			if opts.Progress != nil {
+				opts.Progress(result.Deliveries[idx], i+1, len(targets))
+			}
+		}
+
+		result.End = opts.Clock.Now()
+		report <- result
+	}()
+
+	return report
+}
+
+func deliver(msg Sendable, target BroadcastTarget, limiter *rateLimiter, opts BroadcastOptions) Delivery {
+	var (
+		delivery = Delivery{
+			BotID:     target.BotID,
+			ChannelID: target.Channel.ID,
+		}
+		delay = opts.RetryDelay
+	)
+
+	for {
+		limiter.wait(target.BotID)
+		delivery.Attempts++
+		delivery.Time = opts.Clock.Now()
+		id, err := Send(target.Bot, msg)
+		if err == nil {
+			delivery.MessageID = id
+			delivery.Error = ""
+			return delivery
+		}
+
+		delivery.Error = err.Error()
+		if delivery.Attempts > opts.Retries || !opts.IsTransient(err) {
+			return delivery
+		}
+
+		<-opts.Clock.After(delay)
+		delay *= 2
+	}
+}
+
+type rateLimiter struct {
+	sync.Mutex
+	interval time.Duration
+	clock    Clock
+	next     map[string]time.Time
+}
+
+func newRateLimiter(interval time.Duration, clock Clock) *rateLimiter {
+	return &rateLimiter{
+		interval: interval,
+		clock:    clock,
+		next:     make(map[string]time.Time),
+	}
+}
+
+// wait blocks until the given key is allowed to perform an operation again.
+func (l *rateLimiter) wait(key string) {
+	if l.interval <= 0 {
+		return
+	}
+
+	l.Lock()
+	now := l.clock.Now()
+	at := l.next[key]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[key] = at.Add(l.interval)
+	l.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		<-l.clock.After(d)
+	}
+}
@@ -0,0 +1,168 @@
+package flamingo
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Temporary() bool { return true }
+
+type sendMock struct {
+	Bot
+	channel string
+	sent    map[string]int
+	fails   map[string][]error
+}
+
+func newSendMock() *sendMock {
+	return &sendMock{
+		sent:  make(map[string]int),
+		fails: make(map[string][]error),
+	}
+}
+
+func (m *sendMock) forChannel(ch string) Bot {
+	return &sendMock{
+		channel: ch,
+		sent:    m.sent,
+		fails:   m.fails,
+	}
+}
+
+func (m *sendMock) Say(msg OutgoingMessage) (string, error) {
+	return m.send()
+}
+
+func (m *sendMock) Form(Form) (string, error) {
+	return m.send()
+}
+
+func (m *sendMock) Image(Image) (string, error) {
+	return m.send()
+}
+
+var sendMockMut sync.Mutex
+
+func (m *sendMock) send() (string, error) {
+	sendMockMut.Lock()
+	defer sendMockMut.Unlock()
+	if errs := m.fails[m.channel]; len(errs) > 0 {
+		m.fails[m.channel] = errs[1:]
+		return "", errs[0]
+	}
+
+	m.sent[m.channel]++
+	return fmt.Sprintf("%s-%d", m.channel, m.sent[m.channel]), nil
+}
+
+func targetsFor(mock *sendMock, bot string, channels ...string) []BroadcastTarget {
+	var targets []BroadcastTarget
+	for _, ch := range channels {
+		targets = append(targets, BroadcastTarget{
+			BotID:   bot,
+			Channel: Channel{ID: ch},
+			Bot:     mock.forChannel(ch),
+		})
+	}
+	return targets
+}
+
+func TestRunBroadcast(t *testing.T) {
+	require := require.New(t)
+	mock := newSendMock()
+	targets := append(
+		targetsFor(mock, "bot1", "a", "b"),
+		targetsFor(mock, "bot2", "c")...,
+	)
+	mock.fails["b"] = []error{errors.New("fatal")}
+	mock.fails["c"] = []error{temporaryError{}, temporaryError{}}
+
+	var (
+		progress []int
+		total    int
+	)
+	report := <-RunBroadcast(NewOutgoingMessage("hi"), targets, BroadcastOptions{
+		Concurrency: 2,
+		Retries:     2,
+		RetryDelay:  time.Millisecond,
+		Progress: func(d Delivery, done, t int) {
+			progress = append(progress, done)
+			total = t
+		},
+	})
+
+	require.Equal([]int{1, 2, 3}, progress)
+	require.Equal(3, total)
+	require.Equal(uint64(2), report.Bots())
+	require.Equal(uint64(3), report.Conversations())
+	require.Equal(uint64(1), report.Errors())
+	require.Equal(ErrSomeMessagesLost, report.Err())
+	require.False(report.End.Before(report.Start))
+
+	require.Equal("a-1", report.Deliveries[0].MessageID)
+	require.Equal(1, report.Deliveries[0].Attempts)
+
+	require.False(report.Deliveries[1].Delivered())
+	require.Equal("fatal", report.Deliveries[1].Error)
+	require.Equal(1, report.Deliveries[1].Attempts)
+
+	require.True(report.Deliveries[2].Delivered())
+	require.Equal("c-1", report.Deliveries[2].MessageID)
+	require.Equal(3, report.Deliveries[2].Attempts)
+}
+
+func TestRunBroadcastRetriesExhausted(t *testing.T) {
+	require := require.New(t)
+	mock := newSendMock()
+	mock.fails["a"] = []error{temporaryError{}, temporaryError{}}
+
+	report := <-RunBroadcast(NewOutgoingMessage("hi"), targetsFor(mock, "bot", "a"), BroadcastOptions{
+		Retries:    1,
+		RetryDelay: time.Millisecond,
+	})
+	require.Equal(ErrAllMessagesLost, report.Err())
+	require.Equal(2, report.Deliveries[0].Attempts)
+	require.Equal("temporary", report.Deliveries[0].Error)
+}
+
+func TestRunBroadcastInterval(t *testing.T) {
+	require := require.New(t)
+	mock := newSendMock()
+	clock := NewFakeClock(epoch)
+	reports := RunBroadcast(NewOutgoingMessage("hi"), targetsFor(mock, "bot", "a", "b", "c"), BroadcastOptions{
+		Concurrency: 3,
+		Interval:    time.Second,
+		Clock:       clock,
+	})
+
+	require.True(clock.BlockUntil(2, time.Second))
+	clock.Advance(2 * time.Second)
+	report := <-reports
+	require.Nil(report.Err())
+	require.Equal(epoch, report.Start)
+	require.Equal(epoch.Add(2*time.Second), report.End)
+}
+
+func TestRunBroadcastInvalidMessage(t *testing.T) {
+	report := <-RunBroadcast(nil, targetsFor(newSendMock(), "bot", "a"), BroadcastOptions{})
+	require.Equal(t, ErrInvalidMessage.Error(), report.Deliveries[0].Error)
+}
+
+func TestRunBroadcastNoTargets(t *testing.T) {
+	report := <-RunBroadcast(NewOutgoingMessage("hi"), nil, BroadcastOptions{})
+	require.Equal(t, uint64(0), report.Conversations())
+	require.Equal(t, ErrAllMessagesLost, report.Err())
+}
+
+func TestIsTemporary(t *testing.T) {
+	require.True(t, IsTemporary(temporaryError{}))
+	require.False(t, IsTemporary(errors.New("foo")))
+}
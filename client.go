@@ -7,8 +7,8 @@ import (
 
 // Client is an abstract interface of a platforms-specific client.
 // A client can only run for one platform. If you need to handle
-// more than one platform you will have to start several clients
-// for different platforms.
+// more than one platform you can add several clients for different
+// platforms to a Hub.
 type Client interface {
 	Broadcaster
 
@@ -43,8 +43,38 @@ type Client interface {
 	SetStorage(Storage)
 
 	// AddScheduledJob will run the given Job forever after the given
-	// duration from the last execution.
-	AddScheduledJob(ScheduleTime, Job)
+	// duration from the last execution. The name identifies the job
+	// and must be unique, ErrJobExists is returned otherwise.
+	AddScheduledJob(name string, schedule ScheduleTime, job Job) error
+
+	// RemoveScheduledJob removes the scheduled job with the given name,
+	// which will not be run anymore.
+	RemoveScheduledJob(name string) error
+
+	// RunJob runs the scheduled job with the given name immediately,
+	// even if it is paused, and returns the record of the run.
+	RunJob(name string) (JobRun, error)
+
+	// PauseJob stops the scheduled job with the given name from being run
+	// until ResumeJob is called.
+	PauseJob(name string) error
+
+	// ResumeJob resumes a previously paused scheduled job.
+	ResumeJob(name string) error
+
+	// JobHistory returns the last recorded runs of the scheduled job with
+	// the given name.
+	JobHistory(name string) ([]JobRun, error)
+
+	// SetJobHistory sets the store in which the runs of the scheduled jobs
+	// are recorded.
+	SetJobHistory(JobHistory)
+
+	// SetMessageLog sets the log in which the messages and actions received
+	// and the messages sent by the bots are recorded. By default, nothing is
+	// recorded. It must be set before calling the Run method. The client does
+	// not prune the log, see MessageLog.Prune.
+	SetMessageLog(MessageLog)
 
 	// Run starts the client.
 	Run() error
@@ -57,8 +87,15 @@ type Client interface {
 // following certain condition
 type Broadcaster interface {
 	// Broadcast tries to send a message to all running conversations except the ones
-	// that are not filtered.
-	Broadcast(Sendable, BroadcastFilter) (bots uint64, convs uint64, errCount uint64, err error)
+	// that are not filtered. It blocks until all the messages have been sent and
+	// returns the report of the deliveries along with ErrSomeMessagesLost or
+	// ErrAllMessagesLost if not all of them could be delivered.
+	Broadcast(Sendable, BroadcastFilter, BroadcastOptions) (BroadcastReport, error)
+
+	// BroadcastAsync is the same as Broadcast, but it does not block. Instead,
+	// it returns a channel where the report will be sent when the broadcast
+	// finishes.
+	BroadcastAsync(Sendable, BroadcastFilter, BroadcastOptions) <-chan BroadcastReport
 }
 
 var (
@@ -86,11 +123,27 @@ type ClientType uint
 const (
 	// SlackClient is a client for Slack.
 	SlackClient ClientType = 1 << iota
+	// TelegramClient is a client for Telegram.
+	TelegramClient
+	// DiscordClient is a client for Discord.
+	DiscordClient
+	// MattermostClient is a client for Mattermost.
+	MattermostClient
+	// IRCClient is a client for IRC.
+	IRCClient
+	// MatrixClient is a client for Matrix.
+	MatrixClient
+	// ConsoleClient is a client for the terminal, used to try bots locally.
+	ConsoleClient
+	// HTTPAPIClient is a client for custom frontends using an HTTP API.
+	HTTPAPIClient
 )
 
 // Job is a function that will execute like a cron job after a
 // certain amount of time to perform some kind of task.
 type Job func(Bot, Channel) error
 
-// BroadcastFilter returns true if message can be broadcasted to bot and Channel
-type BroadcastFilter func(string, Channel) bool
+// BroadcastFilter returns true if message can be broadcasted to the given target.
+// Take a look at the filters and combinators in this package before writing
+// your own.
+type BroadcastFilter func(BroadcastTarget) bool
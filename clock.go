@@ -0,0 +1,137 @@
+package flamingo
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time used by clients and schedulers. It allows
+// replacing the system time with a fake one in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current
+	// time on the returned channel.
+	After(time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// NewClock returns a Clock backed by the system time.
+func NewClock() Clock {
+	return systemClock{}
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// FakeClock is a Clock whose time only moves when it is told to. It is meant
+// to be used in tests to check time-dependant behaviour without sleeping.
+type FakeClock struct {
+	sync.RWMutex
+	now     time.Time
+	timers  []*fakeTimer
+	waiters chan struct{}
+}
+
+// NewFakeClock creates a new FakeClock set at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		waiters: make(chan struct{}),
+	}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.now
+}
+
+// After returns a channel that will receive the time of the clock once it
+// has been advanced past the given duration.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.timers = append(c.timers, &fakeTimer{c.now.Add(d), ch})
+	close(c.waiters)
+	c.waiters = make(chan struct{})
+	return ch
+}
+
+// Advance moves the time of the clock forward by the given duration, firing
+// all the timers whose deadline has been reached, in order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set changes the time of the clock to the given one, firing all the timers
+// whose deadline has been reached, in order.
+func (c *FakeClock) Set(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = now
+
+	sort.Sort(byDeadline(c.timers))
+	var pending []*fakeTimer
+	for _, t := range c.timers {
+		if t.deadline.After(now) {
+			pending = append(pending, t)
+		} else {
+			t.ch <- now
+		}
+	}
+	c.timers = pending
+}
+
+// Timers returns the number of timers waiting for the clock to advance.
+func (c *FakeClock) Timers() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.timers)
+}
+
+// BlockUntil blocks until there are, at least, the given number of timers
+// waiting for the clock to advance or the timeout expires. It reports whether
+// the number of timers was reached. The timeout is measured in real time.
+func (c *FakeClock) BlockUntil(timers int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		c.RLock()
+		n, waiters := len(c.timers), c.waiters
+		c.RUnlock()
+		if n >= timers {
+			return true
+		}
+
+		select {
+		case <-waiters:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+type byDeadline []*fakeTimer
+
+func (s byDeadline) Len() int           { return len(s) }
+func (s byDeadline) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDeadline) Less(i, j int) bool { return s[i].deadline.Before(s[j].deadline) }
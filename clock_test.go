@@ -0,0 +1,56 @@
+package flamingo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	require := require.New(t)
+	clock := NewFakeClock(epoch)
+	require.Equal(epoch, clock.Now())
+
+	first := clock.After(2 * time.Second)
+	second := clock.After(time.Second)
+	require.Equal(2, clock.Timers())
+
+	clock.Advance(time.Second)
+	require.Equal(epoch.Add(time.Second), clock.Now())
+	require.Equal(1, clock.Timers())
+	select {
+	case now := <-second:
+		require.Equal(epoch.Add(time.Second), now)
+	default:
+		require.FailNow("timer did not fire")
+	}
+
+	select {
+	case <-first:
+		require.FailNow("timer fired too early")
+	default:
+	}
+
+	clock.Set(epoch.Add(time.Hour))
+	require.Equal(0, clock.Timers())
+	<-first
+
+	select {
+	case <-clock.After(0):
+	default:
+		require.FailNow("timer with no duration did not fire")
+	}
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	require := require.New(t)
+	clock := NewFakeClock(epoch)
+	require.False(clock.BlockUntil(1, 10*time.Millisecond))
+
+	go func() {
+		<-time.After(10 * time.Millisecond)
+		clock.After(time.Second)
+	}()
+	require.True(clock.BlockUntil(1, time.Second))
+}
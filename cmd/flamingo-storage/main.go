@@ -0,0 +1,267 @@
+// Command flamingo-storage exports, imports and copies the bots and
+// conversations of flamingo storages, such as to move them from a file
+// storage to a SQL database:
+//
+//	flamingo-storage copy file:./bots.json postgres:"dbname=bots sslmode=disable"
+//	flamingo-storage export -o dump.json bolt:./bots.db
+//	flamingo-storage import -dry-run -conflict fail sqlite:./bots.sqlite dump.json
+//
+// Storages are given as a kind and an address separated by a colon:
+//
+//	file:PATH            file storage
+//	bolt:PATH            bbolt storage
+//	sqlite:PATH          SQL storage in a SQLite database
+//	postgres:DSN         SQL storage in a PostgreSQL database
+//	redis:ADDR[,PREFIX]  Redis storage with the given key prefix
+//
+// Encrypted tokens and Extra are copied as they are, so the destination must
+// be used with the same keys as the source.
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"go.etcd.io/bbolt"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/storage"
+)
+
+const usage = `usage: flamingo-storage <command> [options] <arguments>
+
+commands:
+  export [-o FILE] STORAGE               write a JSON dump of the storage
+  import [options] STORAGE [FILE]        import a JSON dump into the storage
+  copy [options] SOURCE DESTINATION      copy a storage into another
+
+options of import and copy:
+  -conflict skip|overwrite|fail  what to do with the data that already exists
+  -dry-run                       report what would be copied, without saving
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "flamingo-storage: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("no command given\n\n" + usage)
+	}
+
+	switch args[0] {
+	case "export":
+		return runExport(args[1:], stdout)
+	case "import":
+		return runImport(args[1:], stdin, stdout)
+	case "copy":
+		return runCopy(args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+}
+
+func runExport(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the dump to, instead of the standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("export expects a storage\n\n" + usage)
+	}
+
+	s, closeStorage, err := openStorage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	w := stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return storage.Export(s, w)
+}
+
+func runImport(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	options := copyFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("import expects a storage and, optionally, a dump file\n\n" + usage)
+	}
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	s, closeStorage, err := openStorage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	r := stdin
+	if flags.NArg() == 2 {
+		f, err := os.Open(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	report, err := storage.Import(r, s, opts)
+	printReport(stdout, report, opts)
+	return err
+}
+
+func runCopy(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	options := copyFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("copy expects a source and a destination storage\n\n" + usage)
+	}
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	src, closeSrc, err := openStorage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	dst, closeDst, err := openStorage(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer closeDst()
+
+	report, err := storage.Copy(src, dst, opts)
+	printReport(stdout, report, opts)
+	return err
+}
+
+// copyFlags defines the flags of the copy options in the flag set, and
+// returns a function that returns the options once the flags are parsed.
+func copyFlags(flags *flag.FlagSet) func() (storage.CopyOptions, error) {
+	conflict := flags.String("conflict", "skip", "what to do with the data that already exists: skip, overwrite or fail")
+	dryRun := flags.Bool("dry-run", false, "report what would be copied, without saving anything")
+
+	return func() (storage.CopyOptions, error) {
+		opts := storage.CopyOptions{DryRun: *dryRun}
+		switch *conflict {
+		case "skip":
+			opts.Conflict = storage.Skip
+		case "overwrite":
+			opts.Conflict = storage.Overwrite
+		case "fail":
+			opts.Conflict = storage.Fail
+		default:
+			return opts, fmt.Errorf("unknown conflict policy %q", *conflict)
+		}
+		return opts, nil
+	}
+}
+
+func printReport(w io.Writer, report storage.CopyReport, opts storage.CopyOptions) {
+	for _, c := range report.Conflicts {
+		fmt.Fprintf(w, "already exists: %s\n", c)
+	}
+
+	verb := "copied"
+	if opts.DryRun {
+		verb = "would copy"
+	}
+	fmt.Fprintf(w, "%s %d bots and %d conversations\n", verb, report.Bots, report.Conversations)
+}
+
+// openStorage opens the storage with the given kind and address, and returns
+// it along with a function to close it.
+func openStorage(spec string) (flamingo.Storage, func(), error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, nil, fmt.Errorf("invalid storage %q, expecting kind:address", spec)
+	}
+
+	kind, addr := parts[0], parts[1]
+	switch kind {
+	case "file":
+		s, err := storage.NewFile(addr)
+		return s, func() {}, err
+	case "bolt":
+		db, err := bbolt.Open(addr, 0600, &bbolt.Options{Timeout: time.Second})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s, err := storage.NewBolt(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return s, func() { db.Close() }, nil
+	case "sqlite", "postgres":
+		driver, dialect := "sqlite3", storage.SQLite
+		if kind == "postgres" {
+			driver, dialect = "postgres", storage.PostgreSQL
+		}
+
+		db, err := sql.Open(driver, addr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s, err := storage.NewSQL(db, dialect)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return s, func() { db.Close() }, nil
+	case "redis":
+		var prefix string
+		if i := strings.Index(addr, ","); i >= 0 {
+			addr, prefix = addr[:i], addr[i+1:]
+		}
+
+		pool := &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr)
+			},
+		}
+		return storage.NewRedis(pool, prefix), func() { pool.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage kind %q", kind)
+	}
+}
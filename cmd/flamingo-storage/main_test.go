@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/storage"
+)
+
+func TestRun(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-storage")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "bots.json")
+	s, err := storage.NewFile(file)
+	require.Nil(err)
+	require.Nil(s.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo"}))
+	require.Nil(s.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1"}))
+
+	sqlite := "sqlite:" + filepath.Join(dir, "bots.sqlite")
+	var out bytes.Buffer
+	require.Nil(run([]string{"copy", "-dry-run", "file:" + file, sqlite}, nil, &out))
+	require.Equal("would copy 1 bots and 1 conversations\n", out.String())
+
+	out.Reset()
+	require.Nil(run([]string{"copy", "file:" + file, sqlite}, nil, &out))
+	require.Equal("copied 1 bots and 1 conversations\n", out.String())
+
+	out.Reset()
+	err = run([]string{"copy", "-conflict", "fail", "file:" + file, sqlite}, nil, &out)
+	require.Equal(storage.ErrConflict, err)
+	require.Equal("already exists: bot 1\nalready exists: conversation 2 of bot 1\ncopied 0 bots and 0 conversations\n", out.String())
+
+	dump := filepath.Join(dir, "dump.json")
+	require.Nil(run([]string{"export", "-o", dump, sqlite}, nil, &out))
+
+	out.Reset()
+	bolt := "bolt:" + filepath.Join(dir, "bots.db")
+	require.Nil(run([]string{"import", bolt, dump}, nil, &out))
+	require.Equal("copied 1 bots and 1 conversations\n", out.String())
+
+	var exported bytes.Buffer
+	require.Nil(run([]string{"export", bolt}, nil, &exported))
+
+	out.Reset()
+	require.Nil(run([]string{"import", "-conflict", "overwrite", "file:" + filepath.Join(dir, "copy.json")}, &exported, &out))
+	require.Equal("copied 1 bots and 1 conversations\n", out.String())
+
+	s, err = storage.NewFile(filepath.Join(dir, "copy.json"))
+	require.Nil(err)
+	bots, err := s.LoadBots()
+	require.Nil(err)
+	require.Equal(1, len(bots))
+	require.Equal("foo", bots[0].Token)
+}
+
+func TestRunErrors(t *testing.T) {
+	require := require.New(t)
+	cases := [][]string{
+		{},
+		{"foo"},
+		{"export"},
+		{"export", "foo"},
+		{"export", "foo:bar"},
+		{"export", "file:"},
+		{"import"},
+		{"import", "-conflict", "foo", "file:foo.json"},
+		{"copy", "file:foo.json"},
+		{"copy", "-foo"},
+	}
+
+	for _, args := range cases {
+		err := run(args, strings.NewReader(""), ioutil.Discard)
+		require.NotNil(err, "%v", args)
+	}
+}
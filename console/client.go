@@ -0,0 +1,260 @@
+// Package console provides a flamingo.Client that runs the bots in a
+// terminal, so controllers can be developed and tried without connecting to
+// any chat platform.
+//
+// Every line read from the input is a message sent by the current user to
+// the current bot in the current channel, and all of them can be changed
+// with commands:
+//
+//	/user NAME      talk as the user NAME
+//	/channel NAME   talk in the channel NAME
+//	/dm             talk in the direct conversation of the user with the bot
+//	/bot ID         talk to the bot with the given ID
+//	/help           print the available commands
+//
+// Lines starting with "//" are sent as messages starting with "/". The
+// messages, forms and images posted by the bots are printed to the output,
+// along with the values of the buttons of the forms. Typing the value of a
+// button of a form of the current channel clicks it, which delivers an
+// action to the ActionHandler registered with the ID of the button group
+// instead of a message.
+//
+// Bots join every channel the first time they talk in it, which calls the
+// IntroHandler. Logs are written to the standard error instead of the
+// standard output so they do not get mixed with the conversation.
+package console
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+const help = `/user NAME      talk as the user NAME
+/channel NAME   talk in the channel NAME
+/dm             talk in the direct conversation of the user with the bot
+/bot ID         talk to the bot with the given ID
+/help           print this help
+Type the value of a button of a form to click it.`
+
+// ClientOptions are the configurable options of the console client.
+type ClientOptions struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+	// Input is where the lines typed by the user are read from. If nil, the
+	// standard input is used.
+	Input io.Reader
+	// Output is where the messages of the bots are written to. If nil, the
+	// standard output is used.
+	Output io.Writer
+	// User is the name of the initial user. If empty, "user" is used.
+	User string
+	// Channel is the name of the initial channel. If empty, "general" is
+	// used.
+	Channel string
+}
+
+type consolePlatform struct {
+	options ClientOptions
+	session *session
+}
+
+func (p *consolePlatform) Type() flamingo.ClientType {
+	return flamingo.ConsoleClient
+}
+
+func (p *consolePlatform) Connect(bot flamingo.StoredBot, events platform.Events) (platform.Connection, error) {
+	return newConnection(bot, events, p.session, p.options.Clock), nil
+}
+
+type consoleClient struct {
+	*platform.Client
+	options ClientOptions
+	session *session
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewClient creates a new console Client with the given options.
+func NewClient(options ClientOptions) flamingo.Client {
+	if options.Clock == nil {
+		options.Clock = flamingo.NewClock()
+	}
+
+	if options.Input == nil {
+		options.Input = os.Stdin
+	}
+
+	if options.Output == nil {
+		options.Output = os.Stdout
+	}
+
+	if options.User == "" {
+		options.User = "user"
+	}
+
+	options.Channel = strings.TrimPrefix(options.Channel, "#")
+	if options.Channel == "" {
+		options.Channel = "general"
+	}
+
+	s := newSession(options.Output, options.User, options.Channel)
+	c := &consoleClient{
+		Client: platform.NewClient(&consolePlatform{options, s}, platform.Options{
+			Debug: options.Debug,
+			Clock: options.Clock,
+		}),
+		options: options,
+		session: s,
+		stopped: make(chan struct{}),
+	}
+	c.SetLogOutput(nil)
+	return c
+}
+
+// SetLogOutput will write the logs to the given io.Writer or, if nil, to the
+// standard error.
+func (c *consoleClient) SetLogOutput(w io.Writer) {
+	if w == nil {
+		w = os.Stderr
+	}
+
+	var maxLvl = log15.LvlInfo
+	if c.options.Debug {
+		maxLvl = log15.LvlDebug
+	}
+
+	log15.Root().SetHandler(log15.LvlFilterHandler(
+		maxLvl,
+		log15.StreamHandler(w, log15.LogfmtFormat()),
+	))
+}
+
+// Run reads the input in the background and blocks until the client is
+// stopped.
+func (c *consoleClient) Run() error {
+	go c.readInput()
+	return c.Client.Run()
+}
+
+func (c *consoleClient) Stop() error {
+	c.once.Do(func() {
+		close(c.stopped)
+	})
+	return c.Client.Stop()
+}
+
+func (c *consoleClient) readInput() {
+	c.session.printf("* talking as %s in %s, type /help to see the commands", c.options.User, channelName(c.options.Channel))
+
+	scanner := bufio.NewScanner(c.options.Input)
+	for scanner.Scan() {
+		select {
+		case <-c.stopped:
+			return
+		default:
+		}
+
+		c.handleLine(scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		log15.Error("error reading console input", "err", err.Error())
+	}
+}
+
+func (c *consoleClient) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "":
+		return
+	case strings.HasPrefix(line, "//"):
+		line = line[1:]
+	case strings.HasPrefix(line, "/"):
+		c.handleCommand(strings.Fields(line))
+		return
+	}
+
+	conn, user, channel := c.session.current()
+	if conn == nil {
+		c.session.printf("* there is no bot to talk to, use /bot to choose one")
+		return
+	}
+
+	conn.input(user, channel, line)
+}
+
+func (c *consoleClient) handleCommand(args []string) {
+	s := c.session
+	if len(args) == 1 {
+		switch args[0] {
+		case "/dm":
+			s.mut.Lock()
+			s.channel = "@" + s.user
+			s.mut.Unlock()
+			c.switched()
+			return
+		case "/help":
+			s.printf("%s", help)
+			return
+		}
+	}
+
+	if len(args) != 2 {
+		s.printf("* unknown command, type /help to see the commands")
+		return
+	}
+
+	name := args[1]
+	switch args[0] {
+	case "/user":
+		s.mut.Lock()
+		s.user = strings.TrimPrefix(name, "@")
+		s.mut.Unlock()
+		s.printf("* talking as %s", name)
+	case "/channel":
+		s.mut.Lock()
+		s.channel = strings.TrimPrefix(name, "#")
+		s.mut.Unlock()
+		c.switched()
+	case "/bot":
+		s.mut.Lock()
+		_, ok := s.conns[name]
+		if ok {
+			s.bot = name
+		}
+		s.mut.Unlock()
+
+		if !ok {
+			s.printf("* there is no bot %s", name)
+			return
+		}
+		s.printf("* talking to %s", name)
+		c.switched()
+	default:
+		s.printf("* unknown command, type /help to see the commands")
+	}
+}
+
+// switched announces the current channel and joins the current bot to it,
+// which does nothing if the bot already has a conversation there. The bot
+// joins in the background because its IntroHandler may be waiting for the
+// input of the user.
+func (c *consoleClient) switched() {
+	conn, _, channel := c.session.current()
+	c.session.printf("* talking in %s", channelName(channel))
+
+	if conn != nil {
+		go conn.join(channel)
+	}
+}
@@ -0,0 +1,145 @@
+package console
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+// output is an io.Writer safe for concurrent use that can wait for some
+// text to be written.
+type output struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.Lock()
+	defer o.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *output) String() string {
+	o.Lock()
+	defer o.Unlock()
+	return o.buf.String()
+}
+
+func (o *output) waitFor(t *testing.T, text string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(o.String(), text) {
+		if time.Now().After(deadline) {
+			require.FailNow(t, "expected output not written", "expected %q in:\n%s", text, o.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type testController struct{}
+
+func (testController) CanHandle(flamingo.Message) bool { return true }
+
+func (testController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	if msg.Text != "deploy" {
+		_, err := bot.Reply(msg, flamingo.NewOutgoingMessage("echo: "+msg.Text))
+		return err
+	}
+
+	_, err := bot.Form(flamingo.Form{
+		Title: "Deploy?",
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewButtonGroup("deploy",
+				flamingo.NewButton("Yes", "yes"),
+				flamingo.NewButton("No", "no"),
+			),
+		},
+	})
+	return err
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
+
+func TestClient(t *testing.T) {
+	require := require.New(t)
+	in, input := io.Pipe()
+	out := new(output)
+	send := func(line string) {
+		_, err := io.WriteString(input, line+"\n")
+		require.Nil(err)
+	}
+
+	cli := NewClient(ClientOptions{Input: in, Output: out, Channel: "#general"})
+	cli.AddController(testController{})
+	cli.SetIntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		_, err := b.Say(flamingo.NewOutgoingMessage("hello " + ch.Name))
+		return err
+	}))
+
+	actions := make(chan flamingo.Action, 1)
+	cli.AddActionHandler("deploy", func(b flamingo.Bot, a flamingo.Action) {
+		actions <- a
+		_, err := b.UpdateMessage(a.OriginalMessage.ID, "deploying as "+a.User.Username)
+		require.Nil(err)
+	})
+
+	go cli.Run()
+	cli.AddBot("flamingo", "", nil)
+
+	out.waitFor(t, "* talking as user in #general, type /help to see the commands\n")
+	out.waitFor(t, "[1] #general <flamingo> hello general\n")
+
+	send("hi")
+	out.waitFor(t, "[3] #general <flamingo> @user: echo: hi\n")
+
+	send("/user @jane")
+	send("/channel #random")
+	out.waitFor(t, "* talking in #random\n")
+	out.waitFor(t, "<flamingo> hello random\n")
+
+	send("deploy")
+	out.waitFor(t, "<flamingo> form:\n    *Deploy?*\n    [Yes: yes] [No: no]\n")
+
+	send("no")
+	select {
+	case a := <-actions:
+		require.Equal(flamingo.UserAction{Name: "no", Value: "no"}, a.UserAction)
+		require.Equal("jane", a.User.ID)
+		require.Equal("random", a.Channel.ID)
+		require.Equal(flamingo.ConsoleClient, a.Channel.Type)
+		require.Equal("*Deploy?*\n[Yes: yes] [No: no]", a.OriginalMessage.Text)
+	case <-time.After(2 * time.Second):
+		require.FailNow("action not received")
+	}
+	out.waitFor(t, "<flamingo> (edited) deploying as jane\n")
+
+	// the form was replaced, so its buttons are no longer clickable
+	send("no")
+	out.waitFor(t, "<flamingo> @jane: echo: no\n")
+
+	send("//help")
+	out.waitFor(t, "<flamingo> @jane: echo: /help\n")
+
+	send("/dm")
+	out.waitFor(t, "@jane <flamingo> hello jane\n")
+
+	send("/bot nope")
+	out.waitFor(t, "* there is no bot nope\n")
+	send("/nope")
+	out.waitFor(t, "* unknown command, type /help to see the commands\n")
+	send("/help")
+	out.waitFor(t, help)
+
+	require.Nil(cli.Stop())
+	input.Close()
+}
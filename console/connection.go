@@ -0,0 +1,288 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// session is the state shared by the client and the connections of all its
+// bots: the output, the IDs of the messages and who is talking to whom.
+type session struct {
+	mut     sync.Mutex
+	out     io.Writer
+	lastID  int
+	user    string
+	channel string
+	bot     string
+	conns   map[string]*connection
+}
+
+func newSession(out io.Writer, user, channel string) *session {
+	return &session{
+		out:     out,
+		user:    user,
+		channel: channel,
+		conns:   make(map[string]*connection),
+	}
+}
+
+func (s *session) nextID() string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.lastID++
+	return strconv.Itoa(s.lastID)
+}
+
+// printf writes a line to the output.
+func (s *session) printf(format string, args ...interface{}) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	fmt.Fprintf(s.out, format+"\n", args...)
+}
+
+// post writes a message of a bot to the output. The first line goes along
+// with the ID of the message, its channel and the bot, and the rest are
+// indented below.
+func (s *session) post(id, channel, bot string, lines []string) {
+	var first string
+	if len(lines) > 0 {
+		first, lines = lines[0], lines[1:]
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	fmt.Fprintf(s.out, "[%s] %s <%s> %s\n", id, channelName(channel), bot, first)
+	for _, l := range lines {
+		fmt.Fprintf(s.out, "    %s\n", l)
+	}
+}
+
+func (s *session) register(c *connection) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.conns[c.id] = c
+	if s.bot == "" {
+		s.bot = c.id
+	}
+}
+
+func (s *session) unregister(c *connection) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.conns[c.id] == c {
+		delete(s.conns, c.id)
+	}
+
+	if s.bot == c.id {
+		s.bot = ""
+	}
+}
+
+// current returns the connection of the bot being talked to, the user
+// talking and the channel.
+func (s *session) current() (*connection, string, string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.conns[s.bot], s.user, s.channel
+}
+
+// postedForm is a form posted by a bot whose buttons can be clicked.
+type postedForm struct {
+	msg  flamingo.Message
+	form flamingo.Form
+}
+
+type connection struct {
+	id      string
+	session *session
+	events  platform.Events
+	clock   flamingo.Clock
+
+	mut    sync.Mutex
+	forms  map[string][]*postedForm
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnection(bot flamingo.StoredBot, events platform.Events, s *session, clock flamingo.Clock) *connection {
+	return &connection{
+		id:      bot.ID,
+		session: s,
+		events:  events,
+		clock:   clock,
+		forms:   make(map[string][]*postedForm),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Run makes the bot available to talk to and joins it to the current
+// channel until the connection is closed.
+func (c *connection) Run() error {
+	c.session.register(c)
+	_, _, channel := c.session.current()
+	c.join(channel)
+	<-c.closed
+	return nil
+}
+
+func (c *connection) Close() error {
+	c.once.Do(func() {
+		c.session.unregister(c)
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *connection) join(channel string) {
+	ch, _ := c.Channel(channel)
+	c.events.Joined(ch)
+}
+
+// input delivers a line typed by the user in the channel. If the line is
+// the value of a button of a form posted in the channel, the button is
+// clicked; otherwise, it is a message.
+func (c *connection) input(user, channel, text string) {
+	ch, _ := c.Channel(channel)
+	u := convertUser(user)
+
+	if f, group, b, ok := c.findButton(channel, strings.TrimSpace(text)); ok {
+		c.events.Action(platform.ActionEvent{
+			ID: group,
+			Action: flamingo.Action{
+				UserAction: flamingo.UserAction{
+					Name:  b.Name,
+					Value: b.Value,
+				},
+				User:            u,
+				Channel:         ch,
+				OriginalMessage: f.msg,
+			},
+		})
+		return
+	}
+
+	c.events.Message(flamingo.Message{
+		ID:      c.session.nextID(),
+		Type:    flamingo.ConsoleClient,
+		User:    u,
+		Channel: ch,
+		Time:    c.clock.Now(),
+		Text:    text,
+	})
+}
+
+// findButton returns the button with the given value of the most recent
+// form of the channel that has one, along with the ID of its group.
+func (c *connection) findButton(channel, value string) (*postedForm, string, flamingo.Button, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	forms := c.forms[channel]
+	for i := len(forms) - 1; i >= 0; i-- {
+		for _, g := range forms[i].form.Fields {
+			for _, item := range g.Items() {
+				if b, ok := item.(flamingo.Button); ok && b.Value == value {
+					return forms[i], g.ID(), b, true
+				}
+			}
+		}
+	}
+
+	return nil, "", flamingo.Button{}, false
+}
+
+// trackForm keeps the form so its buttons can be clicked, replacing the
+// previous version of it, if any.
+func (c *connection) trackForm(channel string, msg flamingo.Message, form *flamingo.Form) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	var forms []*postedForm
+	for _, f := range c.forms[channel] {
+		if f.msg.ID != msg.ID {
+			forms = append(forms, f)
+		}
+	}
+
+	if form != nil {
+		forms = append(forms, &postedForm{msg, *form})
+	}
+	c.forms[channel] = forms
+}
+
+func convertUser(name string) flamingo.User {
+	return flamingo.User{
+		ID:       name,
+		Username: name,
+		Name:     name,
+		Type:     flamingo.ConsoleClient,
+	}
+}
+
+// Channel returns the channel with the given ID, which is its name or, for
+// direct conversations, the name of the user prefixed by @.
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	return flamingo.Channel{
+		ID:   id,
+		Name: strings.TrimPrefix(id, "@"),
+		IsDM: isDirect(id),
+		Type: flamingo.ConsoleClient,
+	}, nil
+}
+
+func (c *connection) message(id, channel, text string) flamingo.Message {
+	ch, _ := c.Channel(channel)
+	return flamingo.Message{
+		ID:      id,
+		Type:    flamingo.ConsoleClient,
+		User:    flamingo.User{ID: c.id, Username: c.id, IsBot: true, Type: flamingo.ConsoleClient},
+		Channel: ch,
+		Time:    c.clock.Now(),
+		Text:    text,
+	}
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	id := c.session.nextID()
+	c.session.post(id, channel, c.id, strings.Split(msg.Text, "\n"))
+	return id, nil
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	id := c.session.nextID()
+	lines := formToLines(form)
+	c.session.post(id, channel, c.id, append([]string{"form:"}, lines...))
+	c.trackForm(channel, c.message(id, channel, strings.Join(lines, "\n")), &form)
+	return id, nil
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	id := c.session.nextID()
+	c.session.post(id, channel, c.id, []string{imageToText(img)})
+	return id, nil
+}
+
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	lines := strings.Split(text, "\n")
+	lines[0] = "(edited) " + lines[0]
+	c.session.post(id, channel, c.id, lines)
+	c.trackForm(channel, c.message(id, channel, text), nil)
+	return id, nil
+}
+
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	lines := formToLines(form)
+	c.session.post(id, channel, c.id, append([]string{"(edited) form:"}, lines...))
+	c.trackForm(channel, c.message(id, channel, strings.Join(lines, "\n")), &form)
+	return id, nil
+}
+
+func (c *connection) DirectChannel(user string) (string, error) {
+	return "@" + strings.TrimPrefix(user, "@"), nil
+}
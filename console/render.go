@@ -0,0 +1,72 @@
+package console
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/src-d/flamingo"
+)
+
+// formToLines renders the form as lines of plain text. Buttons are rendered
+// along with the value to type to click them.
+func formToLines(form flamingo.Form) []string {
+	var lines []string
+
+	if form.AuthorName != "" {
+		lines = append(lines, form.AuthorName)
+	}
+
+	if form.Title != "" {
+		lines = append(lines, "*"+form.Title+"*")
+	}
+
+	if form.Text != "" {
+		lines = append(lines, strings.Split(form.Text, "\n")...)
+	}
+
+	for _, g := range form.Fields {
+		var buttons []string
+		for _, i := range g.Items() {
+			switch f := i.(type) {
+			case flamingo.Button:
+				buttons = append(buttons, fmt.Sprintf("[%s: %s]", f.Text, f.Value))
+			case flamingo.TextField:
+				lines = append(lines, fmt.Sprintf("%s: %s", f.Title, f.Value))
+			case flamingo.Image:
+				lines = append(lines, imageToText(f))
+			case flamingo.Text:
+				lines = append(lines, strings.Split(string(f), "\n")...)
+			}
+		}
+
+		if len(buttons) > 0 {
+			lines = append(lines, strings.Join(buttons, " "))
+		}
+	}
+
+	if form.Footer != "" {
+		lines = append(lines, "-- "+form.Footer)
+	}
+
+	return lines
+}
+
+func imageToText(img flamingo.Image) string {
+	if img.Text == "" {
+		return fmt.Sprintf("[image] <%s>", img.URL)
+	}
+	return fmt.Sprintf("[image] %s <%s>", img.Text, img.URL)
+}
+
+// channelName returns the name of the channel with the given ID as shown in
+// the output: direct messages are prefixed by @ and channels by #.
+func channelName(id string) string {
+	if isDirect(id) {
+		return id
+	}
+	return "#" + id
+}
+
+func isDirect(id string) bool {
+	return strings.HasPrefix(id, "@")
+}
@@ -0,0 +1,46 @@
+package console
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+func TestFormToLines(t *testing.T) {
+	lines := formToLines(flamingo.Form{
+		AuthorName: "flamingo",
+		Title:      "Deploy",
+		Text:       "Choose\nwisely",
+		Footer:     "footer",
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewTextFieldGroup(flamingo.NewTextField("Env", "prod")),
+			flamingo.Image{URL: "http://img", Text: "graph"},
+			flamingo.Image{URL: "http://img2"},
+			flamingo.Text("some text"),
+			flamingo.NewButtonGroup("deploy",
+				flamingo.NewButton("Yes", "yes"),
+				flamingo.NewDangerButton("No", "no"),
+			),
+		},
+	})
+
+	require.Equal(t, []string{
+		"flamingo",
+		"*Deploy*",
+		"Choose",
+		"wisely",
+		"Env: prod",
+		"[image] graph <http://img>",
+		"[image] <http://img2>",
+		"some text",
+		"[Yes: yes] [No: no]",
+		"-- footer",
+	}, lines)
+}
+
+func TestChannelName(t *testing.T) {
+	require.Equal(t, "#general", channelName("general"))
+	require.Equal(t, "@jane", channelName("@jane"))
+}
@@ -0,0 +1,82 @@
+// Package discord provides a flamingo.Client for Discord bots, which receive
+// events through the Discord gateway and talk to the users through the REST
+// API.
+//
+// Both guild text channels and direct messages are conversations of the bot.
+// When the bot is added to a guild, the IntroHandler is called with the system
+// channel of the guild or, if it has none, its first text channel. Forms are
+// rendered as embeds and their button groups as message components; the
+// buttons clicked are delivered as actions to the ActionHandler registered
+// with the ID of the group. Because the ID of the group, the name and the
+// value of a button are sent back by Discord as the custom ID of the button,
+// all together must not be longer than 100 characters and the ID of the group
+// can not contain the "|" character.
+//
+// Bots need the privileged message content intent enabled to read the text of
+// the messages they receive in guilds.
+package discord
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// ClientOptions are the configurable options of the discord client.
+type ClientOptions struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+	// APIURL is the base URL of the REST API. If empty, DefaultAPIURL is used.
+	APIURL string
+	// GatewayURL is the URL of the gateway. If empty, it is requested to the
+	// REST API every time a bot connects.
+	GatewayURL string
+	// HTTPClient is the client used to perform the requests to the REST API.
+	// If nil, a client with a 10 seconds timeout is used.
+	HTTPClient *http.Client
+	// Intents are the gateway intents the bots identify with. If zero,
+	// DefaultIntents are used.
+	Intents int
+}
+
+type discordPlatform struct {
+	options ClientOptions
+}
+
+func (p *discordPlatform) Type() flamingo.ClientType {
+	return flamingo.DiscordClient
+}
+
+func (p *discordPlatform) Connect(bot flamingo.StoredBot, events platform.Events) (platform.Connection, error) {
+	if bot.Token == "" {
+		return nil, errors.New("discord: empty bot token")
+	}
+
+	return newConnection(bot, events, p.options), nil
+}
+
+// NewClient creates a new Discord Client with the given options.
+func NewClient(options ClientOptions) flamingo.Client {
+	if options.APIURL == "" {
+		options.APIURL = DefaultAPIURL
+	}
+
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if options.Intents == 0 {
+		options.Intents = DefaultIntents
+	}
+
+	return platform.NewClient(&discordPlatform{options}, platform.Options{
+		Debug: options.Debug,
+		Clock: options.Clock,
+	})
+}
@@ -0,0 +1,382 @@
+package discord
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+type apiCall struct {
+	method string
+	path   string
+	auth   string
+	body   map[string]interface{}
+}
+
+// fakeDiscord is a local stand-in of the Discord REST API and gateway.
+type fakeDiscord struct {
+	sync.Mutex
+	*httptest.Server
+	calls      []apiCall
+	identifies []identify
+	heartbeats int
+	lastID     int
+	fail       map[string]int
+	events     chan payload
+}
+
+var botUser = user{ID: "100", Username: "flamingo", Bot: true}
+
+func newFakeDiscord() *fakeDiscord {
+	d := &fakeDiscord{
+		fail:   make(map[string]int),
+		events: make(chan payload, 10),
+	}
+	d.Server = httptest.NewServer(d)
+	return d
+}
+
+func (d *fakeDiscord) gatewayURL() string {
+	return "ws" + strings.TrimPrefix(d.URL, "http") + "/gateway"
+}
+
+func (d *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/gateway" {
+		d.serveGateway(w, r)
+		return
+	}
+
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	key := r.Method + " " + r.URL.Path
+
+	d.Lock()
+	defer d.Unlock()
+	if code, ok := d.fail[key]; ok {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":        0,
+			"message":     "failed",
+			"retry_after": 0.5,
+		})
+		return
+	}
+
+	d.calls = append(d.calls, apiCall{r.Method, r.URL.Path, r.Header.Get("Authorization"), body})
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	var result interface{}
+	switch {
+	case key == "GET /gateway/bot":
+		result = map[string]string{"url": d.gatewayURL()}
+	case r.Method == "POST" && parts[0] == "channels":
+		d.lastID++
+		result = message{ID: strconv.Itoa(d.lastID), ChannelID: parts[1]}
+	case r.Method == "PATCH" && parts[0] == "channels":
+		result = message{ID: parts[3], ChannelID: parts[1]}
+	case r.Method == "GET" && parts[0] == "channels":
+		result = channel{ID: parts[1], Name: "general", GuildID: "g1"}
+	case key == "POST /users/@me/channels":
+		result = channel{
+			ID:         "dm" + body["recipient_id"].(string),
+			Type:       channelDM,
+			Recipients: []user{{ID: body["recipient_id"].(string), Username: "jane"}},
+		}
+	case parts[0] == "interactions":
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+var upgrader = websocket.Upgrader{}
+
+func (d *fakeDiscord) serveGateway(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	var writeMut sync.Mutex
+	write := func(p payload) error {
+		writeMut.Lock()
+		defer writeMut.Unlock()
+		return ws.WriteJSON(p)
+	}
+
+	write(payload{Op: opHello, Data: raw(hello{HeartbeatInterval: 20})})
+
+	var p payload
+	if err := ws.ReadJSON(&p); err != nil || p.Op != opIdentify {
+		return
+	}
+
+	var id identify
+	json.Unmarshal(p.Data, &id)
+	d.Lock()
+	d.identifies = append(d.identifies, id)
+	d.Unlock()
+
+	write(payload{Op: opDispatch, Type: "READY", Seq: 1, Data: raw(ready{
+		User:   botUser,
+		Guilds: []guild{{ID: "g1", Unavailable: true}},
+	})})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var p payload
+			if err := ws.ReadJSON(&p); err != nil {
+				return
+			}
+
+			if p.Op == opHeartbeat {
+				d.Lock()
+				d.heartbeats++
+				d.Unlock()
+				write(payload{Op: opHeartbeatACK})
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case p := <-d.events:
+			if write(p) != nil || p.Op == opReconnect {
+				return
+			}
+		}
+	}
+}
+
+func raw(v interface{}) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+func (d *fakeDiscord) dispatch(typ string, data interface{}) {
+	d.events <- payload{Op: opDispatch, Type: typ, Data: raw(data)}
+}
+
+func (d *fakeDiscord) setFail(key string, code int) {
+	d.Lock()
+	defer d.Unlock()
+	d.fail[key] = code
+}
+
+func (d *fakeDiscord) callsTo(method, path string) []apiCall {
+	d.Lock()
+	defer d.Unlock()
+	var calls []apiCall
+	for _, c := range d.calls {
+		if c.method == method && c.path == path {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+func (d *fakeDiscord) waitFor(t *testing.T, method, path string, n int) []apiCall {
+	waitUntil(t, method+" "+path, func() bool {
+		return len(d.callsTo(method, path)) >= n
+	})
+	return d.callsTo(method, path)
+}
+
+func (d *fakeDiscord) identified() []identify {
+	d.Lock()
+	defer d.Unlock()
+	return append([]identify(nil), d.identifies...)
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			require.FailNow(t, "condition not met", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type echoController struct{}
+
+func (echoController) CanHandle(flamingo.Message) bool { return true }
+
+func (echoController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	_, err := bot.Reply(msg, flamingo.NewOutgoingMessage("echo: "+msg.Text))
+	return err
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
+
+var (
+	sender = user{ID: "7", Username: "jane", GlobalName: "Jane Doe"}
+	devs   = guild{
+		ID:              "g2",
+		Name:            "devs",
+		SystemChannelID: "c3",
+		Channels: []channel{
+			{ID: "c1", Type: 2, Name: "voice"},
+			{ID: "c2", Type: channelGuildText, Name: "general"},
+			{ID: "c3", Type: channelGuildText, Name: "welcome"},
+		},
+	}
+)
+
+func newTestClient(d *fakeDiscord, clock flamingo.Clock) flamingo.Client {
+	return NewClient(ClientOptions{
+		APIURL: d.URL,
+		Clock:  clock,
+	})
+}
+
+func TestMessages(t *testing.T) {
+	require := require.New(t)
+	d := newFakeDiscord()
+	defer d.Close()
+
+	cli := newTestClient(d, nil)
+	cli.AddController(echoController{})
+	cli.AddBot("bot", "s3cr3t", nil)
+
+	d.dispatch("GUILD_CREATE", guild{ID: "g1", Channels: []channel{{ID: "c1", Name: "general"}}})
+	d.dispatch("MESSAGE_CREATE", message{ID: "1", ChannelID: "c1", GuildID: "g1", Author: botUser, Content: "self"})
+	d.dispatch("MESSAGE_CREATE", message{ID: "2", ChannelID: "c1", GuildID: "g1", Author: sender, Content: "hello"})
+
+	calls := d.waitFor(t, "POST", "/channels/c1/messages", 1)
+	require.Equal("echo: hello", calls[0].body["content"])
+	require.Equal(map[string]interface{}{"message_id": "2"}, calls[0].body["message_reference"])
+	require.Equal("Bot s3cr3t", calls[0].auth)
+	require.Equal(1, len(d.callsTo("GET", "/gateway/bot")))
+
+	ids := d.identified()
+	require.Equal(1, len(ids))
+	require.Equal("s3cr3t", ids[0].Token)
+	require.Equal(DefaultIntents, ids[0].Intents)
+
+	waitUntil(t, "heartbeat", func() bool {
+		d.Lock()
+		defer d.Unlock()
+		return d.heartbeats > 0
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(1, len(d.callsTo("POST", "/channels/c1/messages")))
+	require.Nil(cli.Stop())
+}
+
+func TestGuildJoin(t *testing.T) {
+	require := require.New(t)
+	d := newFakeDiscord()
+	defer d.Close()
+
+	cli := newTestClient(d, nil)
+	intros := make(chan flamingo.Channel, 2)
+	cli.SetIntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		intros <- ch
+		_, err := b.Form(flamingo.Form{
+			Title:  "Hi",
+			Fields: []flamingo.FieldGroup{flamingo.NewButtonGroup("start", flamingo.NewButton("Start", "start"))},
+		})
+		return err
+	}))
+	cli.AddBot("bot", "s3cr3t", nil)
+
+	d.dispatch("GUILD_CREATE", guild{ID: "g1", Channels: []channel{{ID: "c0", Name: "known"}}})
+	d.dispatch("GUILD_CREATE", devs)
+
+	select {
+	case ch := <-intros:
+		require.Equal("c3", ch.ID)
+		require.Equal("welcome", ch.Name)
+		require.False(ch.IsDM)
+		require.Equal(flamingo.DiscordClient, ch.Type)
+	case <-time.After(2 * time.Second):
+		require.FailNow("intro not handled")
+	}
+
+	calls := d.waitFor(t, "POST", "/channels/c3/messages", 1)
+	embeds := calls[0].body["embeds"].([]interface{})
+	require.Equal("Hi", embeds[0].(map[string]interface{})["title"])
+	require.NotNil(calls[0].body["components"])
+
+	require.Equal(0, len(intros))
+	require.Nil(cli.Stop())
+}
+
+func TestButtonClick(t *testing.T) {
+	require := require.New(t)
+	d := newFakeDiscord()
+	defer d.Close()
+
+	actions := make(chan flamingo.Action, 1)
+	cli := newTestClient(d, nil)
+	cli.AddActionHandler("confirm", func(b flamingo.Bot, a flamingo.Action) {
+		actions <- a
+	})
+	cli.AddBot("bot", "s3cr3t", nil)
+
+	d.dispatch("INTERACTION_CREATE", map[string]interface{}{
+		"id":         "i1",
+		"type":       interactionComponent,
+		"token":      "tok",
+		"channel_id": "dm7",
+		"user":       sender,
+		"message":    message{ID: "3", ChannelID: "dm7", Author: botUser, Content: "sure?"},
+		"data":       map[string]interface{}{"custom_id": "confirm|yes|y", "component_type": componentButton},
+	})
+
+	select {
+	case a := <-actions:
+		require.Equal(flamingo.UserAction{Name: "yes", Value: "y"}, a.UserAction)
+		require.Equal("7", a.User.ID)
+		require.Equal("Jane Doe", a.User.Name)
+		require.True(a.Channel.IsDM)
+		require.Equal("3", a.OriginalMessage.ID)
+	case <-time.After(2 * time.Second):
+		require.FailNow("action not received")
+	}
+
+	calls := d.waitFor(t, "POST", "/interactions/i1/tok/callback", 1)
+	require.Equal(float64(interactionDeferredUpdate), calls[0].body["type"])
+	require.Nil(cli.Stop())
+}
+
+func TestReconnect(t *testing.T) {
+	require := require.New(t)
+	d := newFakeDiscord()
+	defer d.Close()
+
+	clock := flamingo.NewFakeClock(time.Now())
+	cli := newTestClient(d, clock)
+	cli.AddBot("bot", "s3cr3t", nil)
+
+	waitUntil(t, "identify", func() bool { return len(d.identified()) == 1 })
+	d.events <- payload{Op: opReconnect}
+
+	waitUntil(t, "reconnect timer", func() bool { return clock.Timers() > 0 })
+	clock.Advance(time.Minute)
+
+	waitUntil(t, "second identify", func() bool { return len(d.identified()) == 2 })
+	require.Nil(cli.Stop())
+}
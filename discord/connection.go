@@ -0,0 +1,510 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// ErrUnknownUser occurs when a direct message is sent to a user by username
+// and the bot has not seen that user yet. Discord bots can not look up users
+// by their username.
+var ErrUnknownUser = errors.New("discord: unknown user, use the user ID")
+
+// Channel types.
+const (
+	channelGuildText = 0
+	channelDM        = 1
+)
+
+// interactionComponent is the type of the interactions sent when a user
+// clicks a button.
+const interactionComponent = 3
+
+type interaction struct {
+	ID        string   `json:"id"`
+	Type      int      `json:"type"`
+	Token     string   `json:"token"`
+	ChannelID string   `json:"channel_id"`
+	GuildID   string   `json:"guild_id"`
+	Member    *member  `json:"member"`
+	User      *user    `json:"user"`
+	Message   *message `json:"message"`
+	Data      struct {
+		CustomID string `json:"custom_id"`
+	} `json:"data"`
+}
+
+type ready struct {
+	User   user    `json:"user"`
+	Guilds []guild `json:"guilds"`
+}
+
+type connection struct {
+	id      string
+	token   string
+	rest    *rest
+	events  platform.Events
+	options ClientOptions
+
+	mut      sync.RWMutex
+	self     string
+	guilds   map[string]bool
+	channels map[string]channel
+	users    map[string]string
+	gateway  *gateway
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newConnection(bot flamingo.StoredBot, events platform.Events, options ClientOptions) *connection {
+	return &connection{
+		id:       bot.ID,
+		token:    bot.Token,
+		rest:     newREST(options.APIURL, bot.Token, options.HTTPClient),
+		events:   events,
+		options:  options,
+		guilds:   make(map[string]bool),
+		channels: make(map[string]channel),
+		users:    make(map[string]string),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Run starts a new gateway session and handles its events until the
+// connection is closed or the session is lost.
+func (c *connection) Run() error {
+	url := c.options.GatewayURL
+	if url == "" {
+		var err error
+		if url, err = c.rest.gatewayURL(); err != nil {
+			return err
+		}
+	}
+
+	g, err := dialGateway(url)
+	if err != nil {
+		return err
+	}
+	defer g.close()
+
+	c.mut.Lock()
+	select {
+	case <-c.closed:
+		c.mut.Unlock()
+		return nil
+	default:
+		c.gateway = g
+	}
+	c.mut.Unlock()
+
+	if err := g.start(c.token, c.options.Intents); err != nil {
+		return c.runError(err)
+	}
+
+	for {
+		p, err := g.next()
+		if err != nil {
+			return c.runError(err)
+		}
+
+		c.dispatch(p)
+	}
+}
+
+func (c *connection) runError(err error) error {
+	select {
+	case <-c.closed:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (c *connection) Close() error {
+	c.once.Do(func() {
+		c.mut.Lock()
+		close(c.closed)
+		g := c.gateway
+		c.mut.Unlock()
+
+		if g != nil {
+			g.close()
+		}
+	})
+	return nil
+}
+
+func (c *connection) dispatch(p *payload) {
+	var err error
+	switch p.Type {
+	case "READY":
+		var r ready
+		if err = json.Unmarshal(p.Data, &r); err == nil {
+			c.handleReady(r)
+		}
+	case "GUILD_CREATE":
+		var g guild
+		if err = json.Unmarshal(p.Data, &g); err == nil {
+			c.handleGuildCreate(g)
+		}
+	case "GUILD_DELETE":
+		var g guild
+		if err = json.Unmarshal(p.Data, &g); err == nil {
+			c.handleGuildDelete(g)
+		}
+	case "CHANNEL_CREATE", "CHANNEL_UPDATE":
+		var ch channel
+		if err = json.Unmarshal(p.Data, &ch); err == nil {
+			c.cacheChannel(ch)
+		}
+	case "CHANNEL_DELETE":
+		var ch channel
+		if err = json.Unmarshal(p.Data, &ch); err == nil {
+			c.mut.Lock()
+			delete(c.channels, ch.ID)
+			c.mut.Unlock()
+			c.events.Left(ch.ID)
+		}
+	case "MESSAGE_CREATE":
+		var msg message
+		if err = json.Unmarshal(p.Data, &msg); err == nil {
+			c.handleMessage(&msg)
+		}
+	case "INTERACTION_CREATE":
+		var i interaction
+		if err = json.Unmarshal(p.Data, &i); err == nil {
+			c.handleInteraction(&i)
+		}
+	default:
+		log15.Debug("ignoring discord event", "type", p.Type)
+	}
+
+	if err != nil {
+		log15.Error("error decoding discord event", "type", p.Type, "err", err.Error())
+	}
+}
+
+func (c *connection) handleReady(r ready) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.self = r.User.ID
+	for _, g := range r.Guilds {
+		c.guilds[g.ID] = true
+	}
+}
+
+// handleGuildCreate caches the channels of the guild and, if the guild was
+// not one the bot was in when the session started, notifies that the bot
+// joined it in its system channel or its first text channel.
+func (c *connection) handleGuildCreate(g guild) {
+	for i := range g.Channels {
+		g.Channels[i].GuildID = g.ID
+		c.cacheChannel(g.Channels[i])
+	}
+
+	c.mut.Lock()
+	known := c.guilds[g.ID]
+	c.guilds[g.ID] = true
+	c.mut.Unlock()
+
+	if known {
+		return
+	}
+
+	ch, ok := introChannel(g)
+	if !ok {
+		log15.Warn("joined discord guild without text channels", "guild", g.ID)
+		return
+	}
+
+	c.events.Joined(convertChannel(ch))
+}
+
+func introChannel(g guild) (channel, bool) {
+	var first *channel
+	for i, ch := range g.Channels {
+		if ch.Type != channelGuildText {
+			continue
+		}
+
+		if ch.ID == g.SystemChannelID {
+			return ch, true
+		}
+
+		if first == nil {
+			first = &g.Channels[i]
+		}
+	}
+
+	if first == nil {
+		return channel{}, false
+	}
+
+	return *first, true
+}
+
+// handleGuildDelete notifies that the bot left all the channels of the
+// guild, unless the guild was just made unavailable by an outage.
+func (c *connection) handleGuildDelete(g guild) {
+	if g.Unavailable {
+		return
+	}
+
+	var left []string
+	c.mut.Lock()
+	delete(c.guilds, g.ID)
+	for id, ch := range c.channels {
+		if ch.GuildID == g.ID {
+			left = append(left, id)
+			delete(c.channels, id)
+		}
+	}
+	c.mut.Unlock()
+
+	for _, id := range left {
+		c.events.Left(id)
+	}
+}
+
+func (c *connection) handleMessage(msg *message) {
+	if c.isSelf(msg.Author) {
+		log15.Debug("got message from self, ignoring")
+		return
+	}
+
+	if msg.Content == "" {
+		return
+	}
+
+	c.events.Message(c.convertMessage(msg))
+}
+
+func (c *connection) handleInteraction(i *interaction) {
+	if i.Type != interactionComponent {
+		log15.Debug("ignoring discord interaction", "type", i.Type)
+		return
+	}
+
+	if err := c.rest.acknowledge(i.ID, i.Token); err != nil {
+		log15.Error("unable to acknowledge interaction", "id", i.ID, "err", err.Error())
+	}
+
+	u := i.User
+	if i.Member != nil && i.Member.User != nil {
+		u = i.Member.User
+	}
+
+	if u == nil || i.Message == nil {
+		log15.Warn("interaction without user or message, ignoring", "id", i.ID)
+		return
+	}
+
+	id, action, ok := decodeCustomID(i.Data.CustomID)
+	if !ok {
+		log15.Error("invalid button custom ID", "custom_id", i.Data.CustomID)
+		return
+	}
+
+	if i.Message.GuildID == "" {
+		i.Message.GuildID = i.GuildID
+	}
+
+	c.events.Action(platform.ActionEvent{
+		ID: id,
+		Action: flamingo.Action{
+			UserAction:      action,
+			User:            c.convertUser(*u),
+			Channel:         c.channelFor(i.ChannelID, i.GuildID),
+			OriginalMessage: c.convertMessage(i.Message),
+			Extra:           i,
+		},
+	})
+}
+
+func (c *connection) isSelf(u user) bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return u.ID == c.self
+}
+
+func (c *connection) cacheChannel(ch channel) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.channels[ch.ID] = ch
+}
+
+func (c *connection) cachedChannel(id string) (channel, bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	ch, ok := c.channels[id]
+	return ch, ok
+}
+
+// channelFor returns the channel with the given ID from the cache. Channels
+// outside guilds that are not cached yet are direct messages.
+func (c *connection) channelFor(id, guild string) flamingo.Channel {
+	if ch, ok := c.cachedChannel(id); ok {
+		return convertChannel(ch)
+	}
+
+	typ := channelGuildText
+	if guild == "" {
+		typ = channelDM
+	}
+
+	return convertChannel(channel{ID: id, Type: typ, GuildID: guild})
+}
+
+func (c *connection) convertUser(u user) flamingo.User {
+	c.mut.Lock()
+	c.users[strings.ToLower(u.Username)] = u.ID
+	c.mut.Unlock()
+
+	name := u.GlobalName
+	if name == "" {
+		name = u.Username
+	}
+
+	return flamingo.User{
+		ID:       u.ID,
+		Username: u.Username,
+		Name:     name,
+		IsBot:    u.Bot,
+		Type:     flamingo.DiscordClient,
+		Extra:    u,
+	}
+}
+
+func (c *connection) convertMessage(msg *message) flamingo.Message {
+	t, err := time.Parse(time.RFC3339, msg.Timestamp)
+	if err != nil {
+		t = time.Now()
+	}
+
+	return flamingo.Message{
+		ID:      msg.ID,
+		Type:    flamingo.DiscordClient,
+		User:    c.convertUser(msg.Author),
+		Channel: c.channelFor(msg.ChannelID, msg.GuildID),
+		Time:    t,
+		Text:    msg.Content,
+		Extra:   msg,
+	}
+}
+
+func convertChannel(ch channel) flamingo.Channel {
+	name := ch.Name
+	if name == "" && len(ch.Recipients) > 0 {
+		name = ch.Recipients[0].Username
+	}
+
+	return flamingo.Channel{
+		ID:    ch.ID,
+		Name:  name,
+		IsDM:  ch.Type == channelDM,
+		Type:  flamingo.DiscordClient,
+		Extra: ch,
+	}
+}
+
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	if ch, ok := c.cachedChannel(id); ok {
+		return convertChannel(ch), nil
+	}
+
+	ch, err := c.rest.channel(id)
+	if err != nil {
+		return flamingo.Channel{}, err
+	}
+
+	c.cacheChannel(*ch)
+	return convertChannel(*ch), nil
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	return c.create(channel, messageParams{Content: msg.Text})
+}
+
+func (c *connection) ReplyMessage(channel string, replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error) {
+	params := messageParams{Content: msg.Text}
+	if replyTo.Channel.ID == channel && replyTo.ID != "" {
+		params.MessageReference = &messageReference{MessageID: replyTo.ID}
+	}
+
+	return c.create(channel, params)
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	return c.create(channel, formToMessage(form))
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	return c.create(channel, messageParams{Embeds: []embed{imageEmbed(img)}})
+}
+
+func (c *connection) create(channel string, params messageParams) (string, error) {
+	msg, err := c.rest.createMessage(channel, params)
+	if err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	msg, err := c.rest.editMessage(channel, id, messageParams{Content: text})
+	if err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	params := formToMessage(form)
+	edit := formEdit{
+		Embeds:     params.Embeds,
+		Components: params.Components,
+	}
+
+	if edit.Components == nil {
+		edit.Components = []component{}
+	}
+
+	msg, err := c.rest.editMessage(channel, id, edit)
+	if err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// DirectChannel opens the direct message channel with the user. Users can be
+// referred to by username only if the bot has already received a message or
+// action from them.
+func (c *connection) DirectChannel(user string) (string, error) {
+	if _, err := strconv.ParseUint(user, 10, 64); err != nil {
+		c.mut.RLock()
+		id, ok := c.users[strings.ToLower(strings.TrimPrefix(user, "@"))]
+		c.mut.RUnlock()
+		if !ok {
+			return "", ErrUnknownUser
+		}
+		user = id
+	}
+
+	ch, err := c.rest.createDM(user)
+	if err != nil {
+		return "", err
+	}
+
+	c.cacheChannel(*ch)
+	return ch.ID, nil
+}
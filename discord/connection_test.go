@@ -0,0 +1,167 @@
+package discord
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+type eventsMock struct {
+	messages []flamingo.Message
+	actions  []platform.ActionEvent
+	joined   []flamingo.Channel
+	left     []string
+}
+
+func (e *eventsMock) Message(m flamingo.Message)    { e.messages = append(e.messages, m) }
+func (e *eventsMock) Action(a platform.ActionEvent) { e.actions = append(e.actions, a) }
+func (e *eventsMock) Joined(ch flamingo.Channel)    { e.joined = append(e.joined, ch) }
+func (e *eventsMock) Left(ch string)                { e.left = append(e.left, ch) }
+
+func newTestConnection(d *fakeDiscord, events platform.Events) *connection {
+	return newConnection(flamingo.StoredBot{ID: "bot", Token: "s3cr3t"}, events, ClientOptions{
+		APIURL:     d.URL,
+		HTTPClient: http.DefaultClient,
+	})
+}
+
+func TestDispatch(t *testing.T) {
+	require := require.New(t)
+	d := newFakeDiscord()
+	defer d.Close()
+
+	events := new(eventsMock)
+	conn := newTestConnection(d, events)
+
+	conn.dispatch(&payload{Type: "READY", Data: raw(ready{User: botUser, Guilds: []guild{{ID: "g1"}}})})
+	conn.dispatch(&payload{Type: "GUILD_CREATE", Data: raw(guild{ID: "g1", Channels: []channel{{ID: "c0", Name: "known"}}})})
+	conn.dispatch(&payload{Type: "GUILD_CREATE", Data: raw(devs)})
+	conn.dispatch(&payload{Type: "MESSAGE_CREATE", Data: raw(message{ID: "1", ChannelID: "c2", GuildID: "g2", Author: sender, Content: "hi", Timestamp: "2016-10-03T12:00:00+00:00"})})
+	conn.dispatch(&payload{Type: "MESSAGE_CREATE", Data: raw(message{ID: "2", ChannelID: "dm7", Author: sender, Content: "psst"})})
+	conn.dispatch(&payload{Type: "MESSAGE_CREATE", Data: raw(message{ID: "3", ChannelID: "c2", GuildID: "g2", Author: sender})})
+	conn.dispatch(&payload{Type: "MESSAGE_CREATE", Data: raw(message{ID: "4", ChannelID: "c2", GuildID: "g2", Author: botUser, Content: "me"})})
+	conn.dispatch(&payload{Type: "INTERACTION_CREATE", Data: raw(map[string]interface{}{"id": "i", "type": 2})})
+	conn.dispatch(&payload{Type: "GUILD_DELETE", Data: raw(guild{ID: "g1", Unavailable: true})})
+	conn.dispatch(&payload{Type: "CHANNEL_DELETE", Data: raw(channel{ID: "c0"})})
+	conn.dispatch(&payload{Type: "GUILD_DELETE", Data: raw(guild{ID: "g2"})})
+	conn.dispatch(&payload{Type: "MESSAGE_CREATE", Data: []byte("invalid")})
+
+	require.Equal(1, len(events.joined))
+	require.Equal("c3", events.joined[0].ID)
+
+	require.Equal(2, len(events.messages))
+	msg := events.messages[0]
+	require.Equal("1", msg.ID)
+	require.Equal("hi", msg.Text)
+	require.Equal(flamingo.DiscordClient, msg.Type)
+	require.Equal("jane", msg.User.Username)
+	require.Equal("Jane Doe", msg.User.Name)
+	require.Equal("general", msg.Channel.Name)
+	require.False(msg.Channel.IsDM)
+	require.Equal(int64(1475496000), msg.Time.Unix())
+	require.True(events.messages[1].Channel.IsDM)
+
+	require.Equal("c0", events.left[0])
+	require.Equal(4, len(events.left))
+	require.Equal(0, len(events.actions))
+	require.Equal(0, len(d.callsTo("POST", "/interactions/i/callback")))
+}
+
+func TestIntroChannel(t *testing.T) {
+	require := require.New(t)
+
+	ch, ok := introChannel(devs)
+	require.True(ok)
+	require.Equal("c3", ch.ID)
+
+	ch, ok = introChannel(guild{Channels: devs.Channels})
+	require.True(ok)
+	require.Equal("c2", ch.ID)
+
+	_, ok = introChannel(guild{Channels: devs.Channels[:1]})
+	require.False(ok)
+}
+
+func TestDirectChannel(t *testing.T) {
+	require := require.New(t)
+	d := newFakeDiscord()
+	defer d.Close()
+
+	conn := newTestConnection(d, new(eventsMock))
+	id, err := conn.DirectChannel("99")
+	require.Nil(err)
+	require.Equal("dm99", id)
+
+	_, err = conn.DirectChannel("jane")
+	require.Equal(ErrUnknownUser, err)
+
+	conn.dispatch(&payload{Type: "MESSAGE_CREATE", Data: raw(message{ID: "1", ChannelID: "dm7", Author: sender, Content: "hi"})})
+	id, err = conn.DirectChannel("@Jane")
+	require.Nil(err)
+	require.Equal("dm7", id)
+
+	ch, err := conn.Channel("dm7")
+	require.Nil(err)
+	require.True(ch.IsDM)
+	require.Equal("jane", ch.Name)
+	require.Equal(0, len(d.callsTo("GET", "/channels/dm7")))
+}
+
+func TestConnectionAPI(t *testing.T) {
+	require := require.New(t)
+	d := newFakeDiscord()
+	defer d.Close()
+
+	conn := newTestConnection(d, new(eventsMock))
+	id, err := conn.PostImage("c1", flamingo.Image{URL: "http://img.png", Text: "look"})
+	require.Nil(err)
+	require.Equal("1", id)
+
+	id, err = conn.UpdateMessage("c1", "1", "changed")
+	require.Nil(err)
+	require.Equal("1", id)
+
+	_, err = conn.UpdateForm("c1", "1", flamingo.Form{Title: "form"})
+	require.Nil(err)
+
+	ch, err := conn.Channel("42")
+	require.Nil(err)
+	require.Equal("general", ch.Name)
+	require.False(ch.IsDM)
+
+	image := d.callsTo("POST", "/channels/c1/messages")[0].body
+	require.Equal([]interface{}{map[string]interface{}{
+		"description": "look",
+		"image":       map[string]interface{}{"url": "http://img.png"},
+	}}, image["embeds"])
+
+	edits := d.callsTo("PATCH", "/channels/c1/messages/1")
+	require.Equal(2, len(edits))
+	require.Equal(map[string]interface{}{"content": "changed"}, edits[0].body)
+	require.Equal("", edits[1].body["content"])
+	require.Equal([]interface{}{}, edits[1].body["components"])
+}
+
+func TestAPIError(t *testing.T) {
+	require := require.New(t)
+	d := newFakeDiscord()
+	defer d.Close()
+	d.setFail("POST /channels/c1/messages", http.StatusTooManyRequests)
+
+	conn := newTestConnection(d, new(eventsMock))
+	_, err := conn.PostMessage("c1", flamingo.NewOutgoingMessage("hi"))
+	require.NotNil(err)
+	require.True(flamingo.IsTemporary(err))
+	require.Equal(0.5, err.(*APIError).RetryAfter)
+
+	d.setFail("POST /channels/c1/messages", http.StatusForbidden)
+	_, err = conn.PostMessage("c1", flamingo.NewOutgoingMessage("hi"))
+	require.False(flamingo.IsTemporary(err))
+	require.Equal("discord: 403 failed", err.Error())
+	require.False(flamingo.IsTemporary(errors.New("foo")))
+}
@@ -0,0 +1,174 @@
+package discord
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/src-d/flamingo"
+)
+
+const (
+	// customIDSeparator separates the group ID, the name and the value of a
+	// button in its custom ID.
+	customIDSeparator = "|"
+	// maxButtonsPerRow is the maximum number of buttons in an action row.
+	maxButtonsPerRow = 5
+)
+
+var namedColors = map[string]int{
+	"good":    0x2eb886,
+	"warning": 0xdaa038,
+	"danger":  0xa30200,
+}
+
+// parseColor converts a color given as an hex string, with or without the
+// leading "#", or as one of the slack-like names good, warning and danger.
+func parseColor(color string) int {
+	if c, ok := namedColors[color]; ok {
+		return c
+	}
+
+	c, err := strconv.ParseInt(strings.TrimPrefix(color, "#"), 16, 32)
+	if err != nil {
+		return 0
+	}
+	return int(c)
+}
+
+func buttonStyle(t flamingo.ButtonType) int {
+	switch t {
+	case flamingo.PrimaryButton:
+		return buttonPrimary
+	case flamingo.DangerButton:
+		return buttonDanger
+	default:
+		return buttonSecondary
+	}
+}
+
+// formToMessage renders the form as an embed followed by the embeds of its
+// images, and its button groups as action rows.
+func formToMessage(form flamingo.Form) messageParams {
+	e := embed{
+		Title:       form.Title,
+		Description: form.Text,
+		Color:       parseColor(form.Color),
+	}
+
+	if form.AuthorName != "" || form.AuthorIconURL != "" {
+		e.Author = &embedAuthor{Name: form.AuthorName, IconURL: form.AuthorIconURL}
+	}
+
+	var (
+		images     []embed
+		components []component
+		texts      []string
+	)
+
+	for _, g := range form.Fields {
+		switch g.Type() {
+		case flamingo.ButtonGroup:
+			components = append(components, buttonRows(g)...)
+
+		case flamingo.TextFieldGroup:
+			for _, f := range g.Items() {
+				if tf, ok := f.(flamingo.TextField); ok {
+					e.Fields = append(e.Fields, embedField{
+						Name:   tf.Title,
+						Value:  tf.Value,
+						Inline: tf.Short,
+					})
+				}
+			}
+
+		case flamingo.ImageGroup:
+			if img, ok := g.(flamingo.Image); ok {
+				if e.Image == nil {
+					e.Image = &embedImage{URL: img.URL}
+				} else {
+					images = append(images, imageEmbed(img))
+				}
+			}
+
+		case flamingo.TextGroup:
+			if t, ok := g.(flamingo.Text); ok {
+				texts = append(texts, string(t))
+			}
+		}
+	}
+
+	if len(texts) > 0 {
+		e.Description = strings.Join(append([]string{e.Description}, texts...), "\n")
+		e.Description = strings.TrimPrefix(e.Description, "\n")
+	}
+
+	if form.Footer != "" {
+		e.Footer = &embedFooter{Text: form.Footer}
+	}
+
+	return messageParams{
+		Embeds:     append([]embed{e}, images...),
+		Components: components,
+	}
+}
+
+func imageEmbed(img flamingo.Image) embed {
+	e := embed{
+		Description: img.Text,
+		Image:       &embedImage{URL: img.URL},
+	}
+
+	if img.ThumbnailURL != "" {
+		e.Thumbnail = &embedImage{URL: img.ThumbnailURL}
+	}
+
+	return e
+}
+
+// buttonRows returns the action rows needed to hold all the buttons of the
+// group.
+func buttonRows(g flamingo.FieldGroup) []component {
+	var (
+		rows []component
+		row  component
+	)
+
+	for _, f := range g.Items() {
+		b, ok := f.(flamingo.Button)
+		if !ok {
+			continue
+		}
+
+		if len(row.Components) == maxButtonsPerRow {
+			rows = append(rows, row)
+			row = component{}
+		}
+
+		row.Type = componentActionRow
+		row.Components = append(row.Components, component{
+			Type:     componentButton,
+			Style:    buttonStyle(b.Type),
+			Label:    b.Text,
+			CustomID: encodeCustomID(g.ID(), b),
+		})
+	}
+
+	if len(row.Components) > 0 {
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func encodeCustomID(group string, b flamingo.Button) string {
+	return strings.Join([]string{group, b.Name, b.Value}, customIDSeparator)
+}
+
+func decodeCustomID(id string) (string, flamingo.UserAction, bool) {
+	parts := strings.SplitN(id, customIDSeparator, 3)
+	if len(parts) < 3 {
+		return "", flamingo.UserAction{}, false
+	}
+
+	return parts[0], flamingo.UserAction{Name: parts[1], Value: parts[2]}, true
+}
@@ -0,0 +1,98 @@
+package discord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+func TestFormToMessage(t *testing.T) {
+	require := require.New(t)
+	msg := formToMessage(flamingo.Form{
+		AuthorName: "Flamingo",
+		Title:      "Deploy",
+		Text:       "Are you sure?",
+		Color:      "#ff0000",
+		Footer:     "footer",
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewTextFieldGroup(
+				flamingo.NewTextField("Branch", "master"),
+				flamingo.NewShortTextField("Env", "prod"),
+			),
+			flamingo.Text("free text"),
+			flamingo.Image{URL: "http://img.png"},
+			flamingo.Image{URL: "http://other.png", Text: "graph", ThumbnailURL: "http://thumb.png"},
+			flamingo.NewButtonGroup("deploy",
+				flamingo.NewPrimaryButton("Yes", "yes"),
+				flamingo.NewDangerButton("No", "no"),
+			),
+			flamingo.NewButtonGroup("other",
+				flamingo.Button{Text: "Later", Name: "later", Value: "1h"},
+			),
+		},
+	})
+
+	require.Equal([]embed{
+		{
+			Title:       "Deploy",
+			Description: "Are you sure?\nfree text",
+			Color:       0xff0000,
+			Author:      &embedAuthor{Name: "Flamingo"},
+			Footer:      &embedFooter{Text: "footer"},
+			Fields: []embedField{
+				{Name: "Branch", Value: "master"},
+				{Name: "Env", Value: "prod", Inline: true},
+			},
+			Image: &embedImage{URL: "http://img.png"},
+		},
+		{
+			Description: "graph",
+			Image:       &embedImage{URL: "http://other.png"},
+			Thumbnail:   &embedImage{URL: "http://thumb.png"},
+		},
+	}, msg.Embeds)
+
+	require.Equal([]component{
+		{Type: componentActionRow, Components: []component{
+			{Type: componentButton, Style: buttonPrimary, Label: "Yes", CustomID: "deploy|yes|yes"},
+			{Type: componentButton, Style: buttonDanger, Label: "No", CustomID: "deploy|no|no"},
+		}},
+		{Type: componentActionRow, Components: []component{
+			{Type: componentButton, Style: buttonSecondary, Label: "Later", CustomID: "other|later|1h"},
+		}},
+	}, msg.Components)
+}
+
+func TestButtonRows(t *testing.T) {
+	var buttons []flamingo.Button
+	for i := 0; i < 7; i++ {
+		buttons = append(buttons, flamingo.NewButton("b", "b"))
+	}
+
+	rows := buttonRows(flamingo.NewButtonGroup("g", buttons...))
+	require.Equal(t, 2, len(rows))
+	require.Equal(t, maxButtonsPerRow, len(rows[0].Components))
+	require.Equal(t, 2, len(rows[1].Components))
+}
+
+func TestParseColor(t *testing.T) {
+	require := require.New(t)
+	require.Equal(0x2eb886, parseColor("good"))
+	require.Equal(0x00ff00, parseColor("#00ff00"))
+	require.Equal(0x0000ff, parseColor("0000ff"))
+	require.Equal(0, parseColor("blue"))
+	require.Equal(0, parseColor(""))
+}
+
+func TestDecodeCustomID(t *testing.T) {
+	require := require.New(t)
+	id, action, ok := decodeCustomID("group|name|a|b")
+	require.True(ok)
+	require.Equal("group", id)
+	require.Equal(flamingo.UserAction{Name: "name", Value: "a|b"}, action)
+
+	_, _, ok = decodeCustomID("group|name")
+	require.False(ok)
+}
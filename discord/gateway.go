@@ -0,0 +1,230 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Gateway opcodes.
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// Gateway intents. DefaultIntents is the set of intents the bots identify
+// with if no others are given.
+const (
+	IntentGuilds         = 1 << 0
+	IntentGuildMessages  = 1 << 9
+	IntentDirectMessages = 1 << 12
+	IntentMessageContent = 1 << 15
+
+	DefaultIntents = IntentGuilds | IntentGuildMessages | IntentDirectMessages | IntentMessageContent
+)
+
+var (
+	errReconnect      = errors.New("discord: gateway requested a reconnection")
+	errInvalidSession = errors.New("discord: invalid gateway session")
+	errHeartbeat      = errors.New("discord: gateway did not acknowledge the heartbeat")
+)
+
+type payload struct {
+	Op   int             `json:"op"`
+	Data json.RawMessage `json:"d,omitempty"`
+	Seq  int64           `json:"s,omitempty"`
+	Type string          `json:"t,omitempty"`
+}
+
+type hello struct {
+	HeartbeatInterval int64 `json:"heartbeat_interval"`
+}
+
+type identify struct {
+	Token      string             `json:"token"`
+	Intents    int                `json:"intents"`
+	Properties identifyProperties `json:"properties"`
+}
+
+type identifyProperties struct {
+	OS      string `json:"os"`
+	Browser string `json:"browser"`
+	Device  string `json:"device"`
+}
+
+// gateway is a single session with the Discord gateway. Sessions are never
+// resumed; when one is lost a new one is started from scratch.
+type gateway struct {
+	ws       *websocket.Conn
+	writeMut sync.Mutex
+	mut      sync.Mutex
+	seq      int64
+	acked    bool
+	done     chan struct{}
+	once     sync.Once
+}
+
+func dialGateway(url string) (*gateway, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(url+"?v=10&encoding=json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gateway{
+		ws:    ws,
+		acked: true,
+		done:  make(chan struct{}),
+	}, nil
+}
+
+// start waits for the hello of the gateway, identifies the bot and starts
+// sending heartbeats.
+func (g *gateway) start(token string, intents int) error {
+	var p payload
+	if err := g.ws.ReadJSON(&p); err != nil {
+		return err
+	}
+
+	if p.Op != opHello {
+		return fmt.Errorf("discord: expecting hello from gateway, got opcode %d", p.Op)
+	}
+
+	var h hello
+	if err := json.Unmarshal(p.Data, &h); err != nil {
+		return err
+	}
+
+	if h.HeartbeatInterval <= 0 {
+		return fmt.Errorf("discord: invalid heartbeat interval %d", h.HeartbeatInterval)
+	}
+
+	err := g.send(opIdentify, identify{
+		Token:   token,
+		Intents: intents,
+		Properties: identifyProperties{
+			OS:      runtime.GOOS,
+			Browser: "flamingo",
+			Device:  "flamingo",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	go g.heartbeat(time.Duration(h.HeartbeatInterval) * time.Millisecond)
+	return nil
+}
+
+func (g *gateway) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+		}
+
+		g.mut.Lock()
+		acked := g.acked
+		g.acked = false
+		g.mut.Unlock()
+
+		// a zombied connection is closed so the read loop fails and a new
+		// session is started
+		if !acked {
+			g.fail(errHeartbeat)
+			return
+		}
+
+		if err := g.sendHeartbeat(); err != nil {
+			g.fail(err)
+			return
+		}
+	}
+}
+
+func (g *gateway) send(op int, data interface{}) error {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	g.writeMut.Lock()
+	defer g.writeMut.Unlock()
+	return g.ws.WriteJSON(payload{Op: op, Data: d})
+}
+
+// next returns the next dispatch event received from the gateway, handling
+// all the other opcodes along the way.
+func (g *gateway) next() (*payload, error) {
+	for {
+		var p payload
+		if err := g.ws.ReadJSON(&p); err != nil {
+			return nil, err
+		}
+
+		switch p.Op {
+		case opDispatch:
+			g.mut.Lock()
+			if p.Seq > g.seq {
+				g.seq = p.Seq
+			}
+			g.mut.Unlock()
+			return &p, nil
+		case opHeartbeat:
+			if err := g.sendHeartbeat(); err != nil {
+				return nil, err
+			}
+		case opHeartbeatACK:
+			g.mut.Lock()
+			g.acked = true
+			g.mut.Unlock()
+		case opReconnect:
+			return nil, errReconnect
+		case opInvalidSession:
+			return nil, errInvalidSession
+		}
+	}
+}
+
+// sendHeartbeat sends a heartbeat with the last sequence number received,
+// or null if no dispatch was received yet.
+func (g *gateway) sendHeartbeat() error {
+	g.mut.Lock()
+	seq := g.seq
+	g.mut.Unlock()
+
+	var data interface{}
+	if seq > 0 {
+		data = seq
+	}
+
+	return g.send(opHeartbeat, data)
+}
+
+func (g *gateway) fail(err error) {
+	log15.Warn("closing discord gateway session", "err", err.Error())
+	g.close()
+}
+
+func (g *gateway) close() error {
+	var err error
+	g.once.Do(func() {
+		close(g.done)
+		err = g.ws.Close()
+	})
+	return err
+}
@@ -0,0 +1,269 @@
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// DefaultAPIURL is the URL of the Discord REST API.
+const DefaultAPIURL = "https://discord.com/api/v10"
+
+// APIError is an error returned by the Discord REST API.
+type APIError struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// Code is the Discord error code, if any.
+	Code int
+	// Message is the description of the error.
+	Message string
+	// RetryAfter is the number of seconds to wait before repeating the
+	// request if it was rate limited.
+	RetryAfter float64
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("discord: %d %s", e.Status, e.Message)
+}
+
+// Temporary reports whether the request can be retried later.
+func (e *APIError) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+type user struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Avatar     string `json:"avatar"`
+	Bot        bool   `json:"bot"`
+}
+
+type member struct {
+	User *user  `json:"user"`
+	Nick string `json:"nick"`
+}
+
+type channel struct {
+	ID         string `json:"id"`
+	Type       int    `json:"type"`
+	GuildID    string `json:"guild_id"`
+	Name       string `json:"name"`
+	Recipients []user `json:"recipients"`
+}
+
+type message struct {
+	ID        string  `json:"id"`
+	ChannelID string  `json:"channel_id"`
+	GuildID   string  `json:"guild_id"`
+	Author    user    `json:"author"`
+	Content   string  `json:"content"`
+	Timestamp string  `json:"timestamp"`
+	Embeds    []embed `json:"embeds"`
+}
+
+type guild struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	Unavailable     bool      `json:"unavailable"`
+	SystemChannelID string    `json:"system_channel_id"`
+	Channels        []channel `json:"channels"`
+}
+
+type embedAuthor struct {
+	Name    string `json:"name,omitempty"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type embedFooter struct {
+	Text string `json:"text"`
+}
+
+type embedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type embedImage struct {
+	URL string `json:"url"`
+}
+
+type embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Author      *embedAuthor `json:"author,omitempty"`
+	Footer      *embedFooter `json:"footer,omitempty"`
+	Fields      []embedField `json:"fields,omitempty"`
+	Image       *embedImage  `json:"image,omitempty"`
+	Thumbnail   *embedImage  `json:"thumbnail,omitempty"`
+}
+
+const (
+	componentActionRow = 1
+	componentButton    = 2
+)
+
+const (
+	buttonPrimary   = 1
+	buttonSecondary = 2
+	buttonDanger    = 4
+)
+
+type component struct {
+	Type       int         `json:"type"`
+	Style      int         `json:"style,omitempty"`
+	Label      string      `json:"label,omitempty"`
+	CustomID   string      `json:"custom_id,omitempty"`
+	Components []component `json:"components,omitempty"`
+}
+
+type messageReference struct {
+	MessageID string `json:"message_id"`
+}
+
+type messageParams struct {
+	Content          string            `json:"content"`
+	Embeds           []embed           `json:"embeds,omitempty"`
+	Components       []component       `json:"components,omitempty"`
+	MessageReference *messageReference `json:"message_reference,omitempty"`
+}
+
+// formEdit replaces all the content of a message. Unlike messageParams, it
+// always sends the embeds and components so the ones the message had before
+// are removed.
+type formEdit struct {
+	Content    string      `json:"content"`
+	Embeds     []embed     `json:"embeds"`
+	Components []component `json:"components"`
+}
+
+type rest struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newREST(url, token string, client *http.Client) *rest {
+	return &rest{
+		url:    url,
+		token:  token,
+		client: client,
+	}
+}
+
+// do performs a request with the given method to the given path, sending
+// body as JSON if it is not nil and decoding the response in result if it is
+// not nil.
+func (r *rest) do(method, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, r.url+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bot "+r.token)
+	req.Header.Set("User-Agent", "DiscordBot (https://github.com/src-d/flamingo, 1.0)")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Status: resp.StatusCode, Message: resp.Status}
+		var e struct {
+			Code       int     `json:"code"`
+			Message    string  `json:"message"`
+			RetryAfter float64 `json:"retry_after"`
+		}
+
+		if json.Unmarshal(data, &e) == nil && e.Message != "" {
+			apiErr.Code = e.Code
+			apiErr.Message = e.Message
+			apiErr.RetryAfter = e.RetryAfter
+		}
+
+		return apiErr
+	}
+
+	if result != nil && len(data) > 0 {
+		return json.Unmarshal(data, result)
+	}
+
+	return nil
+}
+
+func (r *rest) gatewayURL() (string, error) {
+	var result struct {
+		URL string `json:"url"`
+	}
+
+	if err := r.do("GET", "/gateway/bot", nil, &result); err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
+
+func (r *rest) createMessage(channel string, params messageParams) (*message, error) {
+	var msg message
+	if err := r.do("POST", "/channels/"+channel+"/messages", params, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (r *rest) editMessage(channel, id string, params interface{}) (*message, error) {
+	var msg message
+	if err := r.do("PATCH", "/channels/"+channel+"/messages/"+id, params, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (r *rest) channel(id string) (*channel, error) {
+	var ch channel
+	if err := r.do("GET", "/channels/"+id, nil, &ch); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func (r *rest) createDM(user string) (*channel, error) {
+	var ch channel
+	err := r.do("POST", "/users/@me/channels", map[string]string{"recipient_id": user}, &ch)
+	if err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+// interactionDeferredUpdate acknowledges a component interaction without
+// changing the message it comes from.
+const interactionDeferredUpdate = 6
+
+func (r *rest) acknowledge(id, token string) error {
+	return r.do("POST", "/interactions/"+id+"/"+token+"/callback", map[string]int{
+		"type": interactionDeferredUpdate,
+	}, nil)
+}
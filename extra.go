@@ -0,0 +1,121 @@
+package flamingo
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var extraTypes = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+// RegisterExtra registers the type of the given value with the given name, so
+// the Extra of stored bots and conversations with values of that type is
+// decoded back to the same type when it is loaded from storages that encode
+// it as JSON. Extra stored without a name is decoded as generic JSON values,
+// such as map[string]interface{}, and extra stored with a name no type is
+// registered with when it is loaded is decoded as an UnknownExtra, so code
+// that inspects the type of the Extra should handle both. Both values and
+// pointers can be registered, and they are decoded as what was registered.
+// Clients usually register their types in the init function of their
+// packages. RegisterExtra panics if the name or the type are already
+// registered with a different type or name.
+func RegisterExtra(name string, value interface{}) {
+	typ := reflect.TypeOf(value)
+	if typ == nil {
+		panic("flamingo: cannot register the type of a nil extra")
+	}
+
+	extraTypes.Lock()
+	defer extraTypes.Unlock()
+	if t, ok := extraTypes.byName[name]; ok && t != typ {
+		panic(fmt.Sprintf("flamingo: extra name %q registered twice for %s and %s", name, t, typ))
+	}
+
+	if n, ok := extraTypes.byType[typ]; ok && n != name {
+		panic(fmt.Sprintf("flamingo: extra type %s registered twice as %q and %q", typ, n, name))
+	}
+
+	extraTypes.byName[name] = typ
+	extraTypes.byType[typ] = name
+}
+
+// UnknownExtra is an Extra whose type was registered when it was stored,
+// but not when it was loaded, such as the Extra loaded by tools that move
+// data between storages. It is encoded back exactly as it was loaded, along
+// with the name of its type.
+type UnknownExtra struct {
+	// Name is the name the type of the extra was registered with.
+	Name string
+	// Value is the extra encoded as JSON.
+	Value json.RawMessage
+}
+
+// MarshalJSON returns the value of the extra.
+func (e UnknownExtra) MarshalJSON() ([]byte, error) {
+	return e.Value, nil
+}
+
+// ExtraName returns the name the type of the given extra was registered
+// with, or an empty string if it was not registered.
+func ExtraName(extra interface{}) string {
+	if extra == nil {
+		return ""
+	}
+
+	if e, ok := extra.(UnknownExtra); ok {
+		return e.Name
+	}
+
+	extraTypes.RLock()
+	defer extraTypes.RUnlock()
+	return extraTypes.byType[reflect.TypeOf(extra)]
+}
+
+// DecodeExtra decodes the JSON-encoded extra into a value of the type
+// registered with the given name. Extra without a name is decoded as generic
+// JSON values, and extra with a name no type was registered with is decoded
+// as an UnknownExtra.
+func DecodeExtra(name string, data []byte) (interface{}, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	extraTypes.RLock()
+	typ, ok := extraTypes.byName[name]
+	extraTypes.RUnlock()
+	if !ok && name != "" {
+		value := make(json.RawMessage, len(data))
+		copy(value, data)
+		return UnknownExtra{Name: name, Value: value}, nil
+	}
+
+	if !ok {
+		var extra interface{}
+		if err := json.Unmarshal(data, &extra); err != nil {
+			return nil, err
+		}
+		return extra, nil
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		v := reflect.New(typ.Elem())
+		if err := json.Unmarshal(data, v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+	}
+
+	v := reflect.New(typ)
+	if err := json.Unmarshal(data, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
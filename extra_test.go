@@ -0,0 +1,106 @@
+package flamingo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testExtra struct {
+	Foo string
+	N   int
+}
+
+func init() {
+	RegisterExtra("flamingo.testExtra", testExtra{})
+	RegisterExtra("flamingo.*testExtra", &testExtra{})
+}
+
+func TestRegisterExtra(t *testing.T) {
+	require := require.New(t)
+	require.Equal("flamingo.testExtra", ExtraName(testExtra{}))
+	require.Equal("flamingo.*testExtra", ExtraName(&testExtra{}))
+	require.Equal("", ExtraName(map[string]interface{}{}))
+	require.Equal("", ExtraName(nil))
+
+	require.NotPanics(func() {
+		RegisterExtra("flamingo.testExtra", testExtra{})
+	})
+	require.Panics(func() {
+		RegisterExtra("flamingo.testExtra", "foo")
+	})
+	require.Panics(func() {
+		RegisterExtra("flamingo.otherExtra", testExtra{})
+	})
+	require.Panics(func() {
+		RegisterExtra("flamingo.nil", nil)
+	})
+}
+
+func TestDecodeExtra(t *testing.T) {
+	require := require.New(t)
+	data := []byte(`{"Foo":"bar","N":2}`)
+
+	extra, err := DecodeExtra("flamingo.testExtra", data)
+	require.Nil(err)
+	require.Equal(testExtra{"bar", 2}, extra)
+
+	extra, err = DecodeExtra("flamingo.*testExtra", data)
+	require.Nil(err)
+	require.Equal(&testExtra{"bar", 2}, extra)
+
+	extra, err = DecodeExtra("", data)
+	require.Nil(err)
+	require.Equal(map[string]interface{}{"Foo": "bar", "N": float64(2)}, extra)
+
+	extra, err = DecodeExtra("flamingo.testExtra", []byte("null"))
+	require.Nil(err)
+	require.Nil(extra)
+
+	_, err = DecodeExtra("flamingo.testExtra", []byte(`"foo"`))
+	require.NotNil(err)
+}
+
+func TestStoredJSON(t *testing.T) {
+	require := require.New(t)
+	created := time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)
+
+	bytes, err := json.Marshal(StoredBot{ID: "1", Token: "foo", CreatedAt: created, Extra: testExtra{Foo: "bar"}})
+	require.Nil(err)
+
+	var bot StoredBot
+	require.Nil(json.Unmarshal(bytes, &bot))
+	require.Equal(StoredBot{ID: "1", Token: "foo", CreatedAt: created, Extra: testExtra{Foo: "bar"}}, bot)
+
+	bytes, err = json.Marshal(StoredConversation{ID: "2", BotID: "1", Extra: &testExtra{N: 1}})
+	require.Nil(err)
+
+	var conv StoredConversation
+	require.Nil(json.Unmarshal(bytes, &conv))
+	require.Equal(StoredConversation{ID: "2", BotID: "1", Extra: &testExtra{N: 1}}, conv)
+
+	// bots stored before their extra was registered
+	bot = StoredBot{}
+	require.Nil(json.Unmarshal([]byte(`{"ID":"1","Extra":{"Foo":"bar"}}`), &bot))
+	require.Equal(map[string]interface{}{"Foo": "bar"}, bot.Extra)
+
+	bot = StoredBot{}
+	require.Nil(json.Unmarshal([]byte(`{"ID":"1"}`), &bot))
+	require.Nil(bot.Extra)
+
+	require.NotNil(json.Unmarshal([]byte(`{"ID":"1","Extra":1,"ExtraType":"flamingo.testExtra"}`), &bot))
+}
+
+func TestUnknownExtra(t *testing.T) {
+	require := require.New(t)
+	extra, err := DecodeExtra("unknown.Extra", []byte(`{"Foo":"bar"}`))
+	require.Nil(err)
+	require.Equal(UnknownExtra{Name: "unknown.Extra", Value: []byte(`{"Foo":"bar"}`)}, extra)
+	require.Equal("unknown.Extra", ExtraName(extra))
+
+	bytes, err := json.Marshal(StoredBot{ID: "1", Extra: extra})
+	require.Nil(err)
+	require.Equal(`{"ID":"1","Token":"","CreatedAt":"0001-01-01T00:00:00Z","Extra":{"Foo":"bar"},"ExtraType":"unknown.Extra"}`, string(bytes))
+}
@@ -0,0 +1,106 @@
+package flamingo
+
+import (
+	"regexp"
+	"time"
+)
+
+// ChannelFilter converts a function that receives the ID of the bot and the
+// channel into a BroadcastFilter.
+func ChannelFilter(fn func(bot string, channel Channel) bool) BroadcastFilter {
+	return func(t BroadcastTarget) bool {
+		return fn(t.BotID, t.Channel)
+	}
+}
+
+// All returns a BroadcastFilter that accepts every target.
+func All() BroadcastFilter {
+	return func(BroadcastTarget) bool {
+		return true
+	}
+}
+
+// And returns a BroadcastFilter that accepts a target only if all the given
+// filters accept it.
+func And(filters ...BroadcastFilter) BroadcastFilter {
+	return func(t BroadcastTarget) bool {
+		for _, f := range filters {
+			if !f(t) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns a BroadcastFilter that accepts a target if any of the given
+// filters accepts it.
+func Or(filters ...BroadcastFilter) BroadcastFilter {
+	return func(t BroadcastTarget) bool {
+		for _, f := range filters {
+			if f(t) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a BroadcastFilter that accepts a target only if the given
+// filter does not accept it.
+func Not(filter BroadcastFilter) BroadcastFilter {
+	return func(t BroadcastTarget) bool {
+		return !filter(t)
+	}
+}
+
+// OnlyDMs returns a BroadcastFilter that only accepts direct messages.
+func OnlyDMs() BroadcastFilter {
+	return func(t BroadcastTarget) bool {
+		return t.Channel.IsDM
+	}
+}
+
+// OnlyBots returns a BroadcastFilter that only accepts the conversations
+// of the bots with the given IDs.
+func OnlyBots(ids ...string) BroadcastFilter {
+	var bots = make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		bots[id] = struct{}{}
+	}
+
+	return func(t BroadcastTarget) bool {
+		_, ok := bots[t.BotID]
+		return ok
+	}
+}
+
+// ChannelNameMatches returns a BroadcastFilter that only accepts the channels
+// whose name matches the given regexp.
+func ChannelNameMatches(regex *regexp.Regexp) BroadcastFilter {
+	return func(t BroadcastTarget) bool {
+		return regex.MatchString(t.Channel.Name)
+	}
+}
+
+// HasMember returns a BroadcastFilter that only accepts the channels the
+// user with the given ID is a member of. Note that some clients may not
+// provide the users of a channel.
+func HasMember(userID string) BroadcastFilter {
+	return func(t BroadcastTarget) bool {
+		for _, u := range t.Channel.Users {
+			if u.ID == userID {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// CreatedBefore returns a BroadcastFilter that only accepts the conversations
+// stored before the given time.
+func CreatedBefore(when time.Time) BroadcastFilter {
+	return func(t BroadcastTarget) bool {
+		return t.Conversation.CreatedAt.Before(when)
+	}
+}
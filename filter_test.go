@@ -0,0 +1,80 @@
+package flamingo
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var filterTargets = []BroadcastTarget{
+	{
+		BotID: "bot1",
+		Channel: Channel{
+			ID:    "C1",
+			Name:  "general",
+			Users: []User{{ID: "U1"}, {ID: "U2"}},
+		},
+		Conversation: StoredConversation{ID: "C1", BotID: "bot1", CreatedAt: epoch},
+	},
+	{
+		BotID: "bot1",
+		Channel: Channel{
+			ID:    "D1",
+			IsDM:  true,
+			Users: []User{{ID: "U2"}},
+		},
+		Conversation: StoredConversation{ID: "D1", BotID: "bot1", CreatedAt: epoch.Add(time.Hour)},
+	},
+	{
+		BotID: "bot2",
+		Channel: Channel{
+			ID:    "C2",
+			Name:  "dev-team",
+			Users: []User{{ID: "U3"}},
+		},
+		Conversation: StoredConversation{ID: "C2", BotID: "bot2", CreatedAt: epoch.Add(2 * time.Hour)},
+	},
+}
+
+func filtered(filter BroadcastFilter) []string {
+	var ids []string
+	for _, t := range filterTargets {
+		if filter(t) {
+			ids = append(ids, t.Channel.ID)
+		}
+	}
+	return ids
+}
+
+func TestFilters(t *testing.T) {
+	cases := []struct {
+		name     string
+		filter   BroadcastFilter
+		expected []string
+	}{
+		{"All", All(), []string{"C1", "D1", "C2"}},
+		{"OnlyDMs", OnlyDMs(), []string{"D1"}},
+		{"OnlyBots", OnlyBots("bot2", "bot3"), []string{"C2"}},
+		{"ChannelNameMatches", ChannelNameMatches(regexp.MustCompile(`^dev-`)), []string{"C2"}},
+		{"HasMember", HasMember("U2"), []string{"C1", "D1"}},
+		{"CreatedBefore", CreatedBefore(epoch.Add(time.Hour)), []string{"C1"}},
+		{"Not", Not(OnlyDMs()), []string{"C1", "C2"}},
+		{"And", And(OnlyBots("bot1"), HasMember("U1")), []string{"C1"}},
+		{"AndEmpty", And(), []string{"C1", "D1", "C2"}},
+		{"Or", Or(OnlyDMs(), OnlyBots("bot2")), []string{"D1", "C2"}},
+		{"OrEmpty", Or(), nil},
+		{
+			"ChannelFilter",
+			ChannelFilter(func(bot string, ch Channel) bool {
+				return bot == "bot1" && ch.ID == "C1"
+			}),
+			[]string{"C1"},
+		},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.expected, filtered(c.filter), c.name)
+	}
+}
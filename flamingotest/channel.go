@@ -0,0 +1,246 @@
+package flamingotest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// Channel is a channel of a bot in which the tests talk as the users and
+// expect what the bot posts. Expectations consume the posts of the bot in
+// the order they were posted, so every post is expected only once.
+type Channel struct {
+	client  *Client
+	bot     string
+	conn    *connection
+	channel flamingo.Channel
+
+	mut  sync.Mutex
+	next int
+}
+
+// ID returns the ID of the channel.
+func (c *Channel) ID() string {
+	return c.channel.ID
+}
+
+func (c *Channel) events() platform.Events {
+	events, err := c.client.Events(c.bot)
+	if err != nil {
+		c.client.t.Fatalf("bot %s is not running: %s", c.bot, err)
+	}
+	return events
+}
+
+func (c *Channel) user(name string) flamingo.User {
+	return flamingo.User{
+		ID:       name,
+		Username: name,
+		Name:     name,
+		Type:     c.conn.typ,
+	}
+}
+
+// Join makes the bot join the channel, which runs the IntroHandler if the
+// bot did not have a conversation in the channel yet. The IntroHandler is
+// run in the background, so it can wait for the messages of the users.
+func (c *Channel) Join() {
+	go c.events().Joined(c.channel)
+}
+
+// Leave makes the bot leave the channel, which stops its conversation.
+func (c *Channel) Leave() {
+	c.events().Left(c.channel.ID)
+}
+
+// Say sends a message with the given text as the user with the given
+// username and returns it.
+func (c *Channel) Say(user, text string) flamingo.Message {
+	return c.Send(flamingo.Message{User: c.user(user), Text: text})
+}
+
+// Send sends the given message to the bot and returns it. The ID, type,
+// channel and time of the message are set if they are empty. If it is the
+// first message of the channel, the bot introduces itself before handling
+// it, as it does with Join.
+func (c *Channel) Send(msg flamingo.Message) flamingo.Message {
+	if msg.ID == "" {
+		msg.ID = c.conn.nextID()
+	}
+
+	if msg.Type == 0 {
+		msg.Type = c.conn.typ
+	}
+
+	if msg.Channel.ID == "" {
+		msg.Channel = c.channel
+	}
+
+	if msg.Time.IsZero() {
+		msg.Time = c.client.Clock().Now()
+	}
+
+	events := c.events()
+	c.deliver(func() { events.Message(msg) })
+	return msg
+}
+
+// deliver runs the delivery of an event and waits for it to finish, unless
+// it takes longer than the timeout. Deliveries block while the bot
+// introduces itself in new conversations, and the IntroHandler may be
+// waiting for the very message being delivered.
+func (c *Channel) deliver(fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(c.client.options.Timeout):
+	}
+}
+
+// Click clicks, as the user with the given username, the button with the
+// given value of the group with the given ID of the form, which delivers
+// an action to the ActionHandler registered with the ID of the group. It
+// fails the test if the form has no such button.
+func (c *Channel) Click(user string, form Post, group, value string) {
+	button, ok := form.Button(group, value)
+	if !ok {
+		c.client.t.Fatalf("%s %s in %s has no button %q in group %q", form.kind(), form.ID, c.channel.ID, value, group)
+		return
+	}
+
+	events := c.events()
+	action := platform.ActionEvent{
+		ID: group,
+		Action: flamingo.Action{
+			UserAction: flamingo.UserAction{
+				Name:  button.Name,
+				Value: button.Value,
+			},
+			User:    c.user(user),
+			Channel: c.channel,
+			OriginalMessage: flamingo.Message{
+				ID:      form.ID,
+				Type:    c.conn.typ,
+				User:    flamingo.User{ID: c.bot, Username: c.bot, IsBot: true, Type: c.conn.typ},
+				Channel: c.channel,
+				Time:    c.client.Clock().Now(),
+				Text:    form.Form.Text,
+			},
+		},
+	}
+	c.deliver(func() { events.Action(action) })
+}
+
+// Posts returns everything the bot posted in the channel, including the
+// posts already expected.
+func (c *Channel) Posts() []Post {
+	posts, _ := c.conn.postsOf(c.channel.ID)
+	return posts
+}
+
+// Next waits for the next post of the bot in the channel and returns it. It
+// fails the test if the bot does not post anything before the timeout.
+func (c *Channel) Next() Post {
+	p, ok := c.wait(c.client.options.Timeout)
+	if !ok {
+		c.client.t.Fatalf("bot did not post anything in %s after %s", c.channel.ID, c.client.options.Timeout)
+	}
+	return p
+}
+
+func (c *Channel) wait(timeout time.Duration) (Post, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	deadline := time.After(timeout)
+	for {
+		posts, changed := c.conn.postsOf(c.channel.ID)
+		if len(posts) > c.next {
+			c.next++
+			return posts[c.next-1], true
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return Post{}, false
+		}
+	}
+}
+
+// ExpectMessage waits for the next post of the bot in the channel and fails
+// the test if it is not a message with the given text.
+func (c *Channel) ExpectMessage(text string) Post {
+	p := c.expect("message")
+	if p.Text != text {
+		c.client.t.Fatalf("expected message %q in %s, got %q", text, c.channel.ID, p.Text)
+	}
+	return p
+}
+
+// ExpectReply waits for the next post of the bot in the channel and fails
+// the test if it is not a reply to the given message with the given text.
+func (c *Channel) ExpectReply(to flamingo.Message, text string) Post {
+	p := c.ExpectMessage(text)
+	if p.ReplyTo != to.ID {
+		c.client.t.Fatalf("expected reply to message %s in %s, got reply to %q", to.ID, c.channel.ID, p.ReplyTo)
+	}
+	return p
+}
+
+// ExpectForm waits for the next post of the bot in the channel and fails the
+// test if it is not a form.
+func (c *Channel) ExpectForm() Post {
+	return c.expect("form")
+}
+
+// ExpectImage waits for the next post of the bot in the channel and fails
+// the test if it is not an image.
+func (c *Channel) ExpectImage() Post {
+	return c.expect("image")
+}
+
+// ExpectUpdatedMessage waits for the next post of the bot in the channel and
+// fails the test if it is not the replacement of the message with the given
+// ID by the given text.
+func (c *Channel) ExpectUpdatedMessage(id, text string) Post {
+	p := c.expect("updated message")
+	if p.ID != id || p.Text != text {
+		c.client.t.Fatalf("expected message %s in %s updated to %q, got message %s updated to %q", id, c.channel.ID, text, p.ID, p.Text)
+	}
+	return p
+}
+
+// ExpectUpdatedForm waits for the next post of the bot in the channel and
+// fails the test if it is not the replacement of the message with the given
+// ID by a form.
+func (c *Channel) ExpectUpdatedForm(id string) Post {
+	p := c.expect("updated form")
+	if p.ID != id {
+		c.client.t.Fatalf("expected message %s in %s updated to a form, got message %s", id, c.channel.ID, p.ID)
+	}
+	return p
+}
+
+// ExpectNothing fails the test if the bot posts anything in the channel
+// during the given time.
+func (c *Channel) ExpectNothing(d time.Duration) {
+	if p, ok := c.wait(d); ok {
+		c.client.t.Fatalf("expected nothing in %s, got %s %s: %q", c.channel.ID, p.kind(), p.ID, p.Text)
+	}
+}
+
+func (c *Channel) expect(kind string) Post {
+	p := c.Next()
+	if p.kind() != kind {
+		c.client.t.Fatalf("expected %s in %s, got %s %s: %q", kind, c.channel.ID, p.kind(), p.ID, p.Text)
+	}
+	return p
+}
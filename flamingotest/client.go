@@ -0,0 +1,208 @@
+// Package flamingotest provides a fake flamingo.Client to test controllers,
+// action handlers, intro handlers, middlewares and jobs with scripted
+// conversations, without connecting to any chat platform.
+//
+// The client runs the bots exactly as the real clients do, but everything
+// the bots post is recorded instead of being sent, and the tests play the
+// users of the channels:
+//
+//	func TestDeploy(t *testing.T) {
+//		cli := flamingotest.NewClient(t, flamingotest.Options{})
+//		defer cli.Stop()
+//		cli.AddController(&deployController{})
+//		cli.AddActionHandler("deploy", handleDeploy)
+//
+//		general := cli.Channel("general")
+//		general.Say("jane", "deploy")
+//		form := general.ExpectForm()
+//		general.Click("jane", form, "deploy", "yes")
+//		general.ExpectUpdatedMessage(form.ID, "deploying...")
+//	}
+//
+// All the expectations wait for the bot up to the timeout of the client and
+// fail the test if it does not post what is expected.
+//
+// Conversations recorded in production with the record package can be
+// turned into golden tests with Replay, which feeds the transcript to the
+// bots and fails the test if they do not send what they sent back then.
+package flamingotest
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+const (
+	// DefaultTimeout is the time expectations wait for the bot if no other
+	// timeout is given.
+	DefaultTimeout = 2 * time.Second
+	// DefaultBot is the ID of the bot of the client if no other is given.
+	DefaultBot = "bot"
+)
+
+// Options are the configurable options of the test client.
+type Options struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+	// Timeout is the time expectations wait for the bot before failing the
+	// test. If zero, DefaultTimeout is used.
+	Timeout time.Duration
+	// Bot is the ID of the bot. If empty, DefaultBot is used.
+	Bot string
+	// Type is the type of client the messages, users and channels come from,
+	// for controllers that behave differently on each platform. If zero,
+	// SlackClient is used.
+	Type flamingo.ClientType
+}
+
+// TestingT is the subset of testing.TB used to fail the tests, so the client
+// can be used with any testing library.
+type TestingT interface {
+	Fatalf(format string, args ...interface{})
+}
+
+type testPlatform struct {
+	options Options
+	client  *Client
+}
+
+func (p *testPlatform) Type() flamingo.ClientType {
+	return p.options.Type
+}
+
+func (p *testPlatform) Connect(bot flamingo.StoredBot, events platform.Events) (platform.Connection, error) {
+	conn := newConnection(p.options.Type)
+	p.client.mut.Lock()
+	p.client.conns[bot.ID] = conn
+	p.client.mut.Unlock()
+	return conn, nil
+}
+
+// Client is a flamingo.Client whose bots talk to the tests instead of to the
+// users of a chat platform.
+type Client struct {
+	*platform.Client
+	t        TestingT
+	options  Options
+	mut      sync.Mutex
+	conns    map[string]*connection
+	channels map[string]*Channel
+}
+
+// NewClient creates a new test Client with the given options, which fails
+// the given test when expectations are not met. The bot of the client is
+// added and running, and there is no need to call Run unless the client
+// has to load bots and conversations from a storage or run the scheduled
+// jobs by their schedule.
+func NewClient(t TestingT, options Options) *Client {
+	if options.Clock == nil {
+		options.Clock = flamingo.NewClock()
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+
+	if options.Bot == "" {
+		options.Bot = DefaultBot
+	}
+
+	if options.Type == 0 {
+		options.Type = flamingo.SlackClient
+	}
+
+	p := &testPlatform{options: options}
+	c := &Client{
+		Client: platform.NewClient(p, platform.Options{
+			Debug: options.Debug,
+			Clock: options.Clock,
+		}),
+		t:        t,
+		options:  options,
+		conns:    make(map[string]*connection),
+		channels: make(map[string]*Channel),
+	}
+	p.client = c
+	c.SetLogOutput(nil)
+	c.AddBot(options.Bot, "", nil)
+	return c
+}
+
+// SetLogOutput will write the logs to the given io.Writer or, if nil,
+// discard them, so they do not get mixed with the output of the tests.
+func (c *Client) SetLogOutput(w io.Writer) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+
+	var maxLvl = log15.LvlInfo
+	if c.options.Debug {
+		maxLvl = log15.LvlDebug
+	}
+
+	log15.Root().SetHandler(log15.LvlFilterHandler(
+		maxLvl,
+		log15.StreamHandler(w, log15.LogfmtFormat()),
+	))
+}
+
+// SetError makes all the calls of the bots to the platform fail with the
+// given error, until it is set again to nil.
+func (c *Client) SetError(err error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for _, conn := range c.conns {
+		conn.setError(err)
+	}
+}
+
+// Channel returns the channel of the bot of the client with the given ID.
+// IDs starting with @ are the direct conversations with the user named
+// after the rest of the ID.
+func (c *Client) Channel(id string) *Channel {
+	return c.BotChannel(c.options.Bot, id)
+}
+
+// DirectChannel returns the direct conversation of the bot of the client
+// with the given user.
+func (c *Client) DirectChannel(user string) *Channel {
+	return c.Channel("@" + user)
+}
+
+// BotChannel returns the channel with the given ID of the bot with the given
+// ID, which must have been added with AddBot.
+func (c *Client) BotChannel(bot, id string) *Channel {
+	key := bot + "\x00" + id
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if ch, ok := c.channels[key]; ok {
+		return ch
+	}
+
+	conn, ok := c.conns[bot]
+	if !ok {
+		c.t.Fatalf("there is no bot %s", bot)
+		return nil
+	}
+
+	ch, _ := conn.Channel(id)
+	channel := &Channel{
+		client:  c,
+		bot:     bot,
+		conn:    conn,
+		channel: ch,
+	}
+	c.channels[key] = channel
+	return channel
+}
@@ -0,0 +1,198 @@
+package flamingotest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+type deployController struct{}
+
+func (deployController) CanHandle(msg flamingo.Message) bool {
+	return !strings.HasPrefix(msg.Text, "ignore")
+}
+
+func (deployController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	switch msg.Text {
+	case "deploy":
+		_, err := bot.Form(flamingo.Form{
+			Text: "Deploy?",
+			Fields: []flamingo.FieldGroup{
+				flamingo.NewButtonGroup("deploy",
+					flamingo.NewButton("Yes", "yes"),
+					flamingo.NewButton("No", "no"),
+				),
+			},
+		})
+		return err
+	case "cat":
+		_, err := bot.Image(flamingo.Image{URL: "http://cat.jpg"})
+		return err
+	case "name":
+		_, answer, err := bot.Ask(flamingo.NewOutgoingMessage("what's your name?"))
+		if err != nil {
+			return err
+		}
+
+		_, err = bot.Say(flamingo.NewOutgoingMessage("hi, " + answer.Text))
+		return err
+	case "secret":
+		_, _, err := bot.SayTo(msg.User.Username, flamingo.NewOutgoingMessage("psst"))
+		return err
+	}
+
+	_, err := bot.Reply(msg, flamingo.NewOutgoingMessage("echo: "+msg.Text))
+	return err
+}
+
+func handleDeploy(bot flamingo.Bot, action flamingo.Action) {
+	bot.UpdateMessage(action.OriginalMessage.ID, fmt.Sprintf("%s said %s", action.User.Username, action.UserAction.Value))
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
+
+func newTestClient(t TestingT) *Client {
+	cli := NewClient(t, Options{Timeout: 500 * time.Millisecond})
+	cli.AddController(deployController{})
+	cli.AddActionHandler("deploy", handleDeploy)
+	cli.SetIntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		_, err := b.Say(flamingo.NewOutgoingMessage("hello " + ch.Name))
+		return err
+	}))
+	return cli
+}
+
+func TestClient(t *testing.T) {
+	require := require.New(t)
+	cli := newTestClient(t)
+	defer cli.Stop()
+
+	var middlewareCalls int
+	cli.Use(func(b flamingo.Bot, msg flamingo.Message, next flamingo.HandlerFunc) error {
+		middlewareCalls++
+		return next(b, msg)
+	})
+
+	general := cli.Channel("general")
+	require.Equal(general, cli.Channel("general"))
+
+	general.Join()
+	general.ExpectMessage("hello general")
+
+	msg := general.Say("jane", "hi")
+	require.Equal(flamingo.SlackClient, msg.Type)
+	require.Equal("jane", msg.User.Username)
+	general.ExpectReply(msg, "echo: hi")
+
+	general.Say("jane", "deploy")
+	form := general.ExpectForm()
+	require.Equal("Deploy?", form.Form.Text)
+	general.Click("jane", form, "deploy", "no")
+	general.ExpectUpdatedMessage(form.ID, "jane said no")
+
+	general.Say("jane", "cat")
+	require.Equal("http://cat.jpg", general.ExpectImage().Image.URL)
+
+	general.Say("jane", "name")
+	general.ExpectMessage("what's your name?")
+	general.Say("jane", "Jane")
+	general.ExpectMessage("hi, Jane")
+
+	general.Say("jane", "secret")
+	dm := cli.DirectChannel("jane")
+	require.True(dm.ExpectMessage("psst").Channel == "@jane")
+
+	general.Say("jane", "ignore me")
+	general.ExpectNothing(50 * time.Millisecond)
+	require.Equal(5, middlewareCalls)
+	require.Equal(7, len(general.Posts()))
+}
+
+func TestClientJobs(t *testing.T) {
+	require := require.New(t)
+	cli := newTestClient(t)
+	defer cli.Stop()
+
+	cli.AddScheduledJob("report", flamingo.NewIntervalSchedule(time.Hour), func(b flamingo.Bot, ch flamingo.Channel) error {
+		_, err := b.Say(flamingo.NewOutgoingMessage("report for " + ch.ID))
+		return err
+	})
+
+	general, random := cli.Channel("general"), cli.Channel("random")
+	general.Join()
+	general.ExpectMessage("hello general")
+	random.Join()
+	random.ExpectMessage("hello random")
+
+	run, err := cli.RunJob("report")
+	require.Nil(err)
+	require.Equal(uint64(2), run.Conversations)
+	general.ExpectMessage("report for general")
+	random.ExpectMessage("report for random")
+
+	cli.SetError(errors.New("platform is down"))
+	run, err = cli.RunJob("report")
+	require.Nil(err)
+	require.Equal(uint64(2), run.Errors)
+	general.ExpectNothing(50 * time.Millisecond)
+
+	cli.SetError(nil)
+	random.Leave()
+	run, err = cli.RunJob("report")
+	require.Nil(err)
+	require.Equal(uint64(1), run.Conversations)
+}
+
+type fakeT struct {
+	sync.Mutex
+	failures []string
+}
+
+func (t *fakeT) Fatalf(format string, args ...interface{}) {
+	t.Lock()
+	defer t.Unlock()
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+}
+
+func TestExpectationFailures(t *testing.T) {
+	require := require.New(t)
+	ft := new(fakeT)
+	cli := newTestClient(ft)
+	defer cli.Stop()
+
+	general := cli.Channel("general")
+	general.Join()
+	general.ExpectMessage("bye general")
+
+	general.Say("jane", "deploy")
+	form := general.ExpectMessage("deploy?")
+	general.Click("jane", form, "deploy", "maybe")
+
+	general.Say("jane", "hi")
+	general.ExpectNothing(500 * time.Millisecond)
+	general.ExpectForm()
+
+	cli.BotChannel("nope", "general")
+
+	require.Equal([]string{
+		`expected message "bye general" in general, got "hello general"`,
+		`expected message in general, got form 3: ""`,
+		`expected message "deploy?" in general, got ""`,
+		`form 3 in general has no button "maybe" in group "deploy"`,
+		`expected nothing in general, got message 5: "echo: hi"`,
+		`bot did not post anything in general after 500ms`,
+		`expected form in general, got message : ""`,
+		`there is no bot nope`,
+	}, ft.failures)
+}
@@ -0,0 +1,210 @@
+package flamingotest
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/src-d/flamingo"
+)
+
+// Post is a message, form or image posted by a bot, or the update of one.
+type Post struct {
+	// ID is the ID of the message. Updates have the ID of the message they
+	// replace.
+	ID string
+	// Channel is the ID of the channel of the message.
+	Channel string
+	// Text is the text of messages and updated messages.
+	Text string
+	// Sender is the sender of messages, if the bot changed it.
+	Sender *flamingo.MessageSender
+	// ReplyTo is the ID of the message replied, if the message is a reply.
+	ReplyTo string
+	// Form is the form of forms and updated forms.
+	Form *flamingo.Form
+	// Image is the image of images.
+	Image *flamingo.Image
+	// Updated is true if the post replaces a previous message.
+	Updated bool
+}
+
+// Button returns the button with the given value of the group with the given
+// ID of the form of the post, if any.
+func (p Post) Button(group, value string) (flamingo.Button, bool) {
+	if p.Form == nil {
+		return flamingo.Button{}, false
+	}
+
+	for _, g := range p.Form.Fields {
+		if g.ID() != group {
+			continue
+		}
+
+		for _, i := range g.Items() {
+			if b, ok := i.(flamingo.Button); ok && b.Value == value {
+				return b, true
+			}
+		}
+	}
+
+	return flamingo.Button{}, false
+}
+
+func (p Post) kind() string {
+	kind := "message"
+	switch {
+	case p.Form != nil:
+		kind = "form"
+	case p.Image != nil:
+		kind = "image"
+	}
+
+	if p.Updated {
+		return "updated " + kind
+	}
+	return kind
+}
+
+// connection records everything the bot posts instead of sending it to a
+// chat platform.
+type connection struct {
+	typ     flamingo.ClientType
+	mut     sync.Mutex
+	lastID  int
+	posts   map[string][]Post
+	all     []Post
+	err     error
+	changed chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newConnection(typ flamingo.ClientType) *connection {
+	return &connection{
+		typ:     typ,
+		posts:   make(map[string][]Post),
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (c *connection) Run() error {
+	<-c.closed
+	return nil
+}
+
+func (c *connection) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// Channel returns the channel with the given ID. IDs starting with @ are the
+// direct conversations with the user named after the rest of the ID.
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	return flamingo.Channel{
+		ID:   id,
+		Name: strings.TrimPrefix(id, "@"),
+		IsDM: strings.HasPrefix(id, "@"),
+		Type: c.typ,
+	}, nil
+}
+
+func (c *connection) nextID() string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.lastID++
+	return strconv.Itoa(c.lastID)
+}
+
+func (c *connection) setError(err error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.err = err
+}
+
+// record adds the post to its channel and wakes up everyone waiting for
+// posts, unless an error was set to make all the calls fail.
+func (c *connection) record(p Post) (string, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.err != nil {
+		return "", c.err
+	}
+
+	c.posts[p.Channel] = append(c.posts[p.Channel], p)
+	c.all = append(c.all, p)
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return p.ID, nil
+}
+
+// postsOf returns the posts of the channel along with a channel that is
+// closed when there are new posts.
+func (c *connection) postsOf(channel string) ([]Post, <-chan struct{}) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	posts := make([]Post, len(c.posts[channel]))
+	copy(posts, c.posts[channel])
+	return posts, c.changed
+}
+
+// allPosts returns the posts of all the channels in the order they were
+// posted along with a channel that is closed when there are new posts.
+func (c *connection) allPosts() ([]Post, <-chan struct{}) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	posts := make([]Post, len(c.all))
+	copy(posts, c.all)
+	return posts, c.changed
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	return c.record(Post{
+		ID:      c.nextID(),
+		Channel: channel,
+		Text:    msg.Text,
+		Sender:  msg.Sender,
+	})
+}
+
+func (c *connection) ReplyMessage(channel string, replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error) {
+	return c.record(Post{
+		ID:      c.nextID(),
+		Channel: channel,
+		Text:    msg.Text,
+		Sender:  msg.Sender,
+		ReplyTo: replyTo.ID,
+	})
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	return c.record(Post{ID: c.nextID(), Channel: channel, Form: &form})
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	return c.record(Post{ID: c.nextID(), Channel: channel, Image: &img})
+}
+
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	return c.record(Post{ID: id, Channel: channel, Text: text, Updated: true})
+}
+
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	return c.record(Post{ID: id, Channel: channel, Form: &form, Updated: true})
+}
+
+func (c *connection) DirectChannel(user string) (string, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.err != nil {
+		return "", c.err
+	}
+	return "@" + strings.TrimPrefix(user, "@"), nil
+}
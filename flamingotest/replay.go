@@ -0,0 +1,292 @@
+package flamingotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+	"github.com/src-d/flamingo/record"
+)
+
+// output is what a bot sent, in a form that can be compared between the
+// transcript and the replay.
+type output struct {
+	Kind    string
+	Channel string
+	ID      string                  `json:",omitempty"`
+	Text    string                  `json:",omitempty"`
+	Sender  *flamingo.MessageSender `json:",omitempty"`
+	ReplyTo string                  `json:",omitempty"`
+	Form    *record.Form            `json:",omitempty"`
+	Image   *flamingo.Image         `json:",omitempty"`
+}
+
+func (o output) String() string {
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+// replay holds the state of the replay of a transcript. Messages received
+// and sent have different IDs than when they were recorded, so the recorded
+// IDs are translated to the new ones to compare replies and updates and to
+// replay actions.
+type replay struct {
+	client *Client
+	ids    map[string]string
+	posts  map[string]record.Entry
+	diffs  []string
+}
+
+// Replay feeds the messages, actions and joins of the transcript recorded
+// with the record package to the bots of the client, in order, and fails
+// the test if what the bots send differs from what they sent when it was
+// recorded. Bots of the transcript that were not added yet are added to the
+// client. If the clock of the client is a flamingo.FakeClock, it is set to
+// the time of each message before delivering it.
+func (c *Client) Replay(transcript io.Reader) {
+	entries, err := record.ReadTranscript(transcript)
+	if err != nil {
+		c.t.Fatalf("invalid transcript: %s", err)
+		return
+	}
+
+	r := &replay{
+		client: c,
+		ids:    make(map[string]string),
+		posts:  make(map[string]record.Entry),
+	}
+
+	for i := 0; i < len(entries); {
+		start := i
+		if entries[i].Incoming() {
+			i++
+		}
+
+		end := i
+		for end < len(entries) && !entries[end].Incoming() {
+			end++
+		}
+
+		r.step(entries[start], entries[i:end], i)
+		i = end
+	}
+
+	if len(r.diffs) > 0 {
+		c.t.Fatalf("replay differs from the transcript:\n%s", strings.Join(r.diffs, "\n"))
+	}
+}
+
+// step delivers the input, if it is an incoming entry, and compares what the
+// bot sends with the expected outputs, which start at the given index of the
+// transcript.
+func (r *replay) step(input record.Entry, expected []record.Entry, index int) {
+	conn := r.conn(input.Bot)
+	before, _ := conn.allPosts()
+
+	if input.Incoming() {
+		r.setTime(input.Time)
+		r.deliver(input)
+	}
+
+	timeout := r.client.options.Timeout
+	if len(expected) == 0 {
+		timeout = timeout / 10
+	}
+	posts := r.wait(conn, len(before), len(expected), timeout)
+
+	for i := 0; i < len(expected) || i < len(posts); i++ {
+		line := index + i + 1
+		switch {
+		case i >= len(posts):
+			r.diffs = append(r.diffs, fmt.Sprintf("line %d: expected %s, got nothing", line, r.expected(expected[i])))
+		case i >= len(expected):
+			r.diffs = append(r.diffs, fmt.Sprintf("after line %d: unexpected %s", index, r.got(posts[i])))
+		default:
+			want, got := r.expected(expected[i]).String(), r.got(posts[i]).String()
+			if want != got {
+				r.diffs = append(r.diffs, fmt.Sprintf("line %d: expected %s, got %s", line, want, got))
+			}
+
+			if expected[i].ID != "" {
+				r.ids[expected[i].ID] = posts[i].ID
+				r.posts[expected[i].ID] = expected[i]
+			}
+		}
+	}
+}
+
+func (r *replay) conn(bot string) *connection {
+	if bot == "" {
+		bot = r.client.options.Bot
+	}
+
+	r.client.mut.Lock()
+	conn, ok := r.client.conns[bot]
+	r.client.mut.Unlock()
+	if ok {
+		return conn
+	}
+
+	r.client.AddBot(bot, "", nil)
+	r.client.mut.Lock()
+	defer r.client.mut.Unlock()
+	return r.client.conns[bot]
+}
+
+func (r *replay) setTime(t time.Time) {
+	clock, ok := r.client.Clock().(*flamingo.FakeClock)
+	if ok && t.After(clock.Now()) {
+		clock.Set(t)
+	}
+}
+
+func (r *replay) id(recorded string) string {
+	if id, ok := r.ids[recorded]; ok {
+		return id
+	}
+	return recorded
+}
+
+func (r *replay) deliver(e record.Entry) {
+	bot := e.Bot
+	if bot == "" {
+		bot = r.client.options.Bot
+	}
+	ch := r.client.BotChannel(bot, e.Channel.ID)
+
+	switch e.Kind {
+	case record.KindJoin:
+		go ch.events().Joined(e.Channel)
+	case record.KindMessage:
+		msg := flamingo.Message{
+			Type:    e.Channel.Type,
+			Channel: e.Channel,
+			Time:    e.Time,
+			Text:    e.Text,
+		}
+		if e.User != nil {
+			msg.User = *e.User
+		}
+
+		sent := ch.Send(msg)
+		if e.ID != "" {
+			r.ids[e.ID] = sent.ID
+		}
+	case record.KindAction:
+		r.deliverAction(ch, e)
+	}
+}
+
+func (r *replay) deliverAction(ch *Channel, e record.Entry) {
+	original := r.posts[e.Action.Message]
+	group := e.Action.Group
+	if group == "" && original.Form != nil {
+		_, group, _ = original.Form.Button("", e.Action.Value)
+	}
+
+	action := flamingo.Action{
+		UserAction: flamingo.UserAction{
+			Name:  e.Action.Name,
+			Value: e.Action.Value,
+		},
+		Channel: e.Channel,
+		OriginalMessage: flamingo.Message{
+			ID:      r.id(e.Action.Message),
+			Type:    ch.conn.typ,
+			User:    flamingo.User{ID: ch.bot, Username: ch.bot, IsBot: true, Type: ch.conn.typ},
+			Channel: e.Channel,
+			Time:    original.Time,
+			Text:    original.Text,
+		},
+	}
+	if original.Form != nil {
+		action.OriginalMessage.Text = original.Form.Text
+	}
+	if e.User != nil {
+		action.User = *e.User
+	}
+
+	events := ch.events()
+	ch.deliver(func() { events.Action(platform.ActionEvent{ID: group, Action: action}) })
+}
+
+// wait waits until there are n posts after the first ones or the timeout
+// expires, and returns the posts after the first ones.
+func (r *replay) wait(conn *connection, first, n int, timeout time.Duration) []Post {
+	deadline := time.After(timeout)
+	for {
+		posts, changed := conn.allPosts()
+		if len(posts)-first >= n && n > 0 {
+			return posts[first:]
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			posts, _ = conn.allPosts()
+			return posts[first:]
+		}
+	}
+}
+
+func (r *replay) expected(e record.Entry) output {
+	o := output{
+		Channel: e.Channel.ID,
+		Text:    e.Text,
+		Sender:  e.Sender,
+		Form:    e.Form,
+		Image:   e.Image,
+	}
+
+	switch e.Kind {
+	case record.KindSay:
+		o.Kind = "message"
+	case record.KindReply:
+		o.Kind = "message"
+		o.ReplyTo = r.id(e.ReplyTo)
+	case record.KindSayTo:
+		o.Kind = "message"
+		o.Channel = "@" + strings.TrimPrefix(e.To, "@")
+	case record.KindForm:
+		o.Kind = "form"
+	case record.KindFormTo:
+		o.Kind = "form"
+		o.Channel = "@" + strings.TrimPrefix(e.To, "@")
+	case record.KindImage:
+		o.Kind = "image"
+	case record.KindUpdateMessage:
+		o.Kind = "updated message"
+		o.ID = r.id(e.ID)
+	case record.KindUpdateForm:
+		o.Kind = "updated form"
+		o.ID = r.id(e.ID)
+	default:
+		o.Kind = string(e.Kind)
+	}
+
+	return o
+}
+
+func (r *replay) got(p Post) output {
+	o := output{
+		Kind:    p.kind(),
+		Channel: p.Channel,
+		Text:    p.Text,
+		Sender:  p.Sender,
+		ReplyTo: p.ReplyTo,
+		Image:   p.Image,
+	}
+
+	if p.Updated {
+		o.ID = p.ID
+	}
+
+	if p.Form != nil {
+		o.Form = record.NewForm(*p.Form)
+	}
+	return o
+}
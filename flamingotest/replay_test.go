@@ -0,0 +1,118 @@
+package flamingotest
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/record"
+)
+
+func newRecordedClient(t TestingT, rec *record.Recorder, intro string) *Client {
+	cli := NewClient(t, Options{Timeout: 500 * time.Millisecond})
+	cli.Use(rec.Middleware())
+	cli.AddController(deployController{})
+	cli.AddActionHandler("deploy", rec.ActionHandler("deploy", handleDeploy))
+	cli.SetIntroHandler(rec.IntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		_, err := b.Say(flamingo.NewOutgoingMessage(intro + " " + ch.Name))
+		return err
+	})))
+	return cli
+}
+
+// transcriptBuffer is a buffer that can be written by the recorder while the
+// test waits for the transcript.
+type transcriptBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *transcriptBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+// wait waits until the transcript has the given number of entries, as the
+// last ones are written after the bot posts them.
+func (b *transcriptBuffer) wait(entries int) []byte {
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.Lock()
+		data := append([]byte(nil), b.buf.Bytes()...)
+		b.Unlock()
+
+		if bytes.Count(data, []byte("\n")) >= entries || time.Now().After(deadline) {
+			return data
+		}
+		<-time.After(time.Millisecond)
+	}
+}
+
+func recordSession(t *testing.T) []byte {
+	var buf transcriptBuffer
+	cli := newRecordedClient(t, record.NewRecorder(&buf, nil), "hello")
+	defer cli.Stop()
+
+	general := cli.Channel("general")
+	general.Join()
+	general.ExpectMessage("hello general")
+
+	msg := general.Say("jane", "hi")
+	general.ExpectReply(msg, "echo: hi")
+
+	general.Say("jane", "deploy")
+	form := general.ExpectForm()
+	general.Click("jane", form, "deploy", "yes")
+	general.ExpectUpdatedMessage(form.ID, "jane said yes")
+
+	general.Say("jane", "name")
+	general.ExpectMessage("what's your name?")
+	general.Say("jane", "Jane")
+	general.ExpectMessage("hi, Jane")
+
+	general.Say("jane", "secret")
+	cli.DirectChannel("jane").ExpectMessage("psst")
+	return buf.wait(14)
+}
+
+func TestReplay(t *testing.T) {
+	require := require.New(t)
+	transcript := recordSession(t)
+
+	entries, err := record.ReadTranscript(bytes.NewReader(transcript))
+	require.Nil(err)
+	require.Equal(14, len(entries))
+
+	ft := new(fakeT)
+	cli := newRecordedClient(ft, record.NewRecorder(new(bytes.Buffer), nil), "hello")
+	defer cli.Stop()
+	cli.Replay(bytes.NewReader(transcript))
+	require.Equal(0, len(ft.failures), "%v", ft.failures)
+}
+
+func TestReplayDifferences(t *testing.T) {
+	require := require.New(t)
+	transcript := recordSession(t)
+
+	ft := new(fakeT)
+	cli := newRecordedClient(ft, record.NewRecorder(new(bytes.Buffer), nil), "hi")
+	defer cli.Stop()
+	cli.Replay(bytes.NewReader(transcript))
+	require.Equal(1, len(ft.failures))
+	require.True(strings.HasPrefix(ft.failures[0], "replay differs from the transcript:\nline 2: expected "), ft.failures[0])
+	require.Equal(2, len(strings.Split(ft.failures[0], "\n")), ft.failures[0])
+	require.Contains(ft.failures[0], `"Text":"hi general"`)
+
+	ft = new(fakeT)
+	cli = newRecordedClient(ft, record.NewRecorder(new(bytes.Buffer), nil), "hello")
+	defer cli.Stop()
+	cli.Replay(strings.NewReader("{"))
+	require.Equal(1, len(ft.failures))
+	require.True(strings.HasPrefix(ft.failures[0], "invalid transcript: "), ft.failures[0])
+}
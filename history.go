@@ -0,0 +1,232 @@
+package flamingo
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrNoMessageLog is returned by Bot.History when the client has no message
+// log to record the messages in.
+var ErrNoMessageLog = errors.New("there is no message log to read the history from")
+
+// LogEntryKind is the kind of event recorded in a message log.
+type LogEntryKind byte
+
+const (
+	// MessageReceived is a message sent by a user to the bot.
+	MessageReceived LogEntryKind = iota + 1
+	// ActionReceived is an action performed by a user.
+	ActionReceived
+	// MessageSent is a message, form or image sent by the bot.
+	MessageSent
+	// MessageUpdated is a message or form of the bot that was updated.
+	MessageUpdated
+)
+
+// LogEntry is a message or action recorded in a message log.
+type LogEntry struct {
+	// Kind is the kind of the entry.
+	Kind LogEntryKind
+	// Bot is the ID of the bot of the conversation.
+	Bot string
+	// Channel is the ID of the channel of the conversation.
+	Channel string
+	// ID is the ID of the message, if any. For actions, it is the ID of the
+	// message the action originated from.
+	ID string
+	// Time is the time the message was received or sent.
+	Time time.Time
+	// User is the user that sent the message or performed the action. It is
+	// empty for the messages sent by the bot.
+	User User
+	// Text is the text of the message. For forms and images it is their
+	// text, and for actions the value of the action.
+	Text string
+	// Action is the action performed by the user, only for ActionReceived
+	// entries.
+	Action *UserAction `json:",omitempty"`
+}
+
+// LogQuery selects the entries of a conversation in a message log.
+type LogQuery struct {
+	// Bot is the ID of the bot of the conversation.
+	Bot string
+	// Channel is the ID of the channel of the conversation.
+	Channel string
+	// Since, if not zero, selects only the entries logged at or after it.
+	Since time.Time
+	// Until, if not zero, selects only the entries logged before it.
+	Until time.Time
+	// Limit, if greater than zero, selects only the last entries, up to the
+	// given number.
+	Limit int
+}
+
+// Match reports whether the entry is selected by the query, without
+// taking the limit into account.
+func (q LogQuery) Match(e LogEntry) bool {
+	return e.Bot == q.Bot &&
+		e.Channel == q.Channel &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+// Select returns the given entries of a conversation that are selected by
+// the query, including the limit.
+func (q LogQuery) Select(entries []LogEntry) []LogEntry {
+	var result []LogEntry
+	for _, e := range entries {
+		if q.Match(e) {
+			result = append(result, e)
+		}
+	}
+
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[len(result)-q.Limit:]
+	}
+	return result
+}
+
+// RetentionPolicy decides how long the entries of a message log are kept.
+type RetentionPolicy struct {
+	// MaxAge, if greater than zero, is the time after which the entries are
+	// removed.
+	MaxAge time.Duration
+	// MaxEntries, if greater than zero, is the number of entries kept for
+	// every conversation. When it is reached, the oldest entries are removed.
+	MaxEntries int
+}
+
+// Expired reports whether an entry logged at the given time must be removed
+// at the time now.
+func (p RetentionPolicy) Expired(logged, now time.Time) bool {
+	return p.MaxAge > 0 && now.Sub(logged) > p.MaxAge
+}
+
+// Retain returns the entries of a conversation, from the oldest to the
+// newest, that must be kept at the time now. The entries are returned in a
+// new slice, so the removed ones can be garbage collected.
+func (p RetentionPolicy) Retain(entries []LogEntry, now time.Time) []LogEntry {
+	var i int
+	for i < len(entries) && p.Expired(entries[i].Time, now) {
+		i++
+	}
+	entries = entries[i:]
+
+	if p.MaxEntries > 0 && len(entries) > p.MaxEntries {
+		entries = entries[len(entries)-p.MaxEntries:]
+	}
+
+	return append([]LogEntry(nil), entries...)
+}
+
+// MessageLog is a store for the messages and actions of the conversations,
+// which clients feed with the messages and actions they receive and the
+// messages their bots send.
+type MessageLog interface {
+	// Log records the given entry and enforces the retention policy of the
+	// log on the conversation of the entry.
+	Log(LogEntry) error
+	// History returns the entries selected by the query, from the oldest to
+	// the newest.
+	History(LogQuery) ([]LogEntry, error)
+	// Prune enforces the retention policy of the log on all the
+	// conversations, taking now as the current time. It is needed to remove
+	// the old entries of the conversations with no new messages. Neither the
+	// logs nor the clients call it, so the callers must do it periodically,
+	// such as from a goroutine with a time.Ticker.
+	Prune(now time.Time) error
+}
+
+type conversationKey struct {
+	bot     string
+	channel string
+}
+
+type memoryMessageLog struct {
+	sync.RWMutex
+	policy  RetentionPolicy
+	entries map[conversationKey][]LogEntry
+}
+
+// NewMessageLog creates an in-memory MessageLog with the given retention
+// policy. The entries are lost when the process stops, so it is mostly
+// useful for tests and bots that only need the recent history.
+func NewMessageLog(policy RetentionPolicy) MessageLog {
+	return &memoryMessageLog{
+		policy:  policy,
+		entries: make(map[conversationKey][]LogEntry),
+	}
+}
+
+func (l *memoryMessageLog) Log(entry LogEntry) error {
+	l.Lock()
+	defer l.Unlock()
+	key := conversationKey{entry.Bot, entry.Channel}
+	l.entries[key] = l.policy.Retain(append(l.entries[key], entry), entry.Time)
+	return nil
+}
+
+func (l *memoryMessageLog) History(q LogQuery) ([]LogEntry, error) {
+	l.RLock()
+	defer l.RUnlock()
+	return q.Select(l.entries[conversationKey{q.Bot, q.Channel}]), nil
+}
+
+func (l *memoryMessageLog) Prune(now time.Time) error {
+	l.Lock()
+	defer l.Unlock()
+	for key, entries := range l.entries {
+		entries = l.policy.Retain(entries, now)
+		if len(entries) == 0 {
+			delete(l.entries, key)
+		} else {
+			l.entries[key] = entries
+		}
+	}
+	return nil
+}
+
+// Redactor is a hook that changes the entries before they are recorded in a
+// message log, such as to remove passwords or personal data. It returns the
+// entry to record and whether it must be recorded at all.
+type Redactor func(LogEntry) (LogEntry, bool)
+
+// RedactPattern returns a Redactor that replaces the parts of the text of
+// the entries that match the given regular expression with the replacement,
+// which can refer to the submatches as in regexp.ReplaceAllString.
+func RedactPattern(re *regexp.Regexp, replacement string) Redactor {
+	return func(e LogEntry) (LogEntry, bool) {
+		e.Text = re.ReplaceAllString(e.Text, replacement)
+		if e.Action != nil {
+			action := *e.Action
+			action.Value = re.ReplaceAllString(action.Value, replacement)
+			e.Action = &action
+		}
+		return e, true
+	}
+}
+
+type redactedMessageLog struct {
+	MessageLog
+	redactors []Redactor
+}
+
+// NewRedactedLog creates a MessageLog that passes all the entries through
+// the given redactors, in order, before recording them in the given log.
+// Entries discarded by any of the redactors are not recorded.
+func NewRedactedLog(log MessageLog, redactors ...Redactor) MessageLog {
+	return &redactedMessageLog{log, redactors}
+}
+
+func (l *redactedMessageLog) Log(entry LogEntry) error {
+	for _, redact := range l.redactors {
+		var ok bool
+		if entry, ok = redact(entry); !ok {
+			return nil
+		}
+	}
+	return l.MessageLog.Log(entry)
+}
@@ -0,0 +1,73 @@
+package flamingo
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageLog(t *testing.T) {
+	require := require.New(t)
+	log := NewMessageLog(RetentionPolicy{MaxEntries: 2})
+	base := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, text := range []string{"a", "b", "c"} {
+		require.Nil(log.Log(LogEntry{Bot: "1", Channel: "a", Time: base.Add(time.Duration(i) * time.Second), Text: text}))
+	}
+	require.Nil(log.Log(LogEntry{Bot: "1", Channel: "b", Time: base, Text: "d"}))
+
+	entries, err := log.History(LogQuery{Bot: "1", Channel: "a"})
+	require.Nil(err)
+	require.Equal(2, len(entries))
+	require.Equal("b", entries[0].Text)
+	require.Equal("c", entries[1].Text)
+
+	entries, err = log.History(LogQuery{Bot: "1", Channel: "a", Until: base.Add(2 * time.Second)})
+	require.Nil(err)
+	require.Equal(1, len(entries))
+	require.Equal("b", entries[0].Text)
+
+	entries, err = log.History(LogQuery{Bot: "1", Channel: "b", Limit: 5})
+	require.Nil(err)
+	require.Equal(1, len(entries))
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	require := require.New(t)
+	base := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	entries := []LogEntry{
+		{Time: base, Text: "a"},
+		{Time: base.Add(time.Minute), Text: "b"},
+		{Time: base.Add(2 * time.Minute), Text: "c"},
+	}
+
+	require.Equal(entries, RetentionPolicy{}.Retain(entries, base.Add(time.Hour)))
+	require.Equal(entries[1:], RetentionPolicy{MaxAge: time.Minute}.Retain(entries, base.Add(90*time.Second)))
+	require.Equal(entries[2:], RetentionPolicy{MaxEntries: 1}.Retain(entries, base))
+	require.Equal(0, len(RetentionPolicy{MaxAge: time.Second, MaxEntries: 5}.Retain(entries, base.Add(time.Hour))))
+}
+
+func TestRedactedLog(t *testing.T) {
+	require := require.New(t)
+	inner := NewMessageLog(RetentionPolicy{})
+	log := NewRedactedLog(
+		inner,
+		RedactPattern(regexp.MustCompile(`password: \S+`), "password: [redacted]"),
+		func(e LogEntry) (LogEntry, bool) {
+			return e, e.User.ID != "secret"
+		},
+	)
+
+	action := &UserAction{Name: "login", Value: "password: 1234"}
+	require.Nil(log.Log(LogEntry{Bot: "1", Channel: "a", Text: "my password: 1234 ok"}))
+	require.Nil(log.Log(LogEntry{Bot: "1", Channel: "a", User: User{ID: "secret"}, Text: "hidden"}))
+	require.Nil(log.Log(LogEntry{Bot: "1", Channel: "a", Text: "password: 1234", Action: action}))
+
+	entries, err := log.History(LogQuery{Bot: "1", Channel: "a"})
+	require.Nil(err)
+	require.Equal(2, len(entries))
+	require.Equal("my password: [redacted] ok", entries[0].Text)
+	require.Equal("password: [redacted]", entries[1].Action.Value)
+	require.Equal("password: 1234", action.Value, "the original action is not modified")
+}
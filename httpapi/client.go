@@ -0,0 +1,422 @@
+// Package httpapi provides a flamingo.Client that exposes the bots through a
+// JSON API over HTTP, so any custom frontend, such as a web chat or a mobile
+// app, can talk to the existing controllers.
+//
+// All the endpoints are under the path of a bot, /bots/{bot}:
+//
+//	POST /bots/{bot}/messages  sends a Message to the bot and responds with
+//	                           the ID of the message: {"id": "1"}
+//	POST /bots/{bot}/actions   clicks the button of a form with an Action
+//	POST /bots/{bot}/join      adds the bot to a channel with a Join, which
+//	                           makes it introduce itself
+//	GET  /bots/{bot}/events    responds with the buffered events of the bot:
+//	                           {"events": [...]}
+//	GET  /bots/{bot}/stream    streams the events of the bot as server-sent
+//	                           events
+//
+// The events can be filtered by channel with the channel query parameter and,
+// to get only the new ones, the ID of the last event received can be given
+// with the after query parameter or, for the stream, the Last-Event-ID
+// header, so browsers resume the stream where it was left on reconnection.
+// Every server-sent event has the ID of the Event as its id, the Type as its
+// event name and the Event encoded as JSON as its data.
+//
+// Errors are responded with the appropriate status code and their message as
+// JSON: {"error": "..."}. If a Token is set in the options, all requests must
+// include it in the Authorization header: "Authorization: Bearer TOKEN".
+//
+// The events are only kept in memory, and just the last BufferSize of every
+// bot, so frontends must keep the history of the conversations themselves if
+// they need it. Forms that are no longer buffered can not be clicked anymore.
+package httpapi
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+const (
+	// DefaultBufferSize is the number of events kept for every bot if no
+	// other is given.
+	DefaultBufferSize = 1000
+	// KeepAliveInterval is how often a comment is sent to the streams with no
+	// events, so proxies do not close them.
+	KeepAliveInterval = 15 * time.Second
+	// maxBodySize is the maximum size of the bodies of the requests.
+	maxBodySize = 1 << 20
+)
+
+// ClientOptions are the configurable options of the HTTP API client.
+type ClientOptions struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+	// Addr is the address on which the API will be served when the client
+	// is run. If empty, the API is not served by the client, and the client
+	// must be used as the http.Handler of an existing HTTP server.
+	Addr string
+	// CertFile is the path to the SSL certificate. If given along with
+	// KeyFile, the API is served using HTTPS.
+	CertFile string
+	// KeyFile is the path to the SSL key.
+	KeyFile string
+	// Token, if not empty, must be sent by the frontends in the
+	// Authorization header of every request as a bearer token.
+	Token string
+	// BufferSize is the number of events kept for every bot. If zero,
+	// DefaultBufferSize is used.
+	BufferSize int
+}
+
+type httpPlatform struct {
+	options ClientOptions
+}
+
+func (p *httpPlatform) Type() flamingo.ClientType {
+	return flamingo.HTTPAPIClient
+}
+
+func (p *httpPlatform) Connect(bot flamingo.StoredBot, events platform.Events) (platform.Connection, error) {
+	return newConnection(bot, events, p.options), nil
+}
+
+type httpClient struct {
+	*platform.Client
+	options  ClientOptions
+	mut      sync.Mutex
+	listener net.Listener
+	shutdown chan struct{}
+}
+
+// NewClient creates a new HTTP API Client with the given options. The
+// returned client is also an http.Handler serving the API.
+func NewClient(options ClientOptions) flamingo.Client {
+	if options.Clock == nil {
+		options.Clock = flamingo.NewClock()
+	}
+
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultBufferSize
+	}
+
+	return &httpClient{
+		Client: platform.NewClient(&httpPlatform{options}, platform.Options{
+			Debug: options.Debug,
+			Clock: options.Clock,
+		}),
+		options:  options,
+		shutdown: make(chan struct{}),
+	}
+}
+
+func (c *httpClient) Run() error {
+	if c.options.Addr != "" {
+		listener, err := c.listen()
+		if err != nil {
+			return err
+		}
+
+		c.mut.Lock()
+		c.listener = listener
+		c.mut.Unlock()
+
+		log15.Info("Starting HTTP API server", "address", c.options.Addr)
+		go c.serve(listener)
+	}
+
+	return c.Client.Run()
+}
+
+func (c *httpClient) listen() (net.Listener, error) {
+	listener, err := net.Listen("tcp", c.options.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.options.CertFile == "" || c.options.KeyFile == "" {
+		return listener, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.options.CertFile, c.options.KeyFile)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(listener, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}), nil
+}
+
+func (c *httpClient) serve(listener net.Listener) {
+	// there is no write timeout because streams are long-lived responses
+	err := (&http.Server{
+		ReadTimeout: 10 * time.Second,
+		Handler:     c,
+	}).Serve(listener)
+
+	if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		log15.Error("HTTP API server stopped", "err", err.Error())
+	}
+}
+
+func (c *httpClient) Stop() error {
+	c.mut.Lock()
+	if c.listener != nil {
+		c.listener.Close()
+		c.listener = nil
+	}
+
+	select {
+	case <-c.shutdown:
+	default:
+		close(c.shutdown)
+	}
+	c.mut.Unlock()
+
+	return c.Client.Stop()
+}
+
+// ServeHTTP serves the API, so it can be served by an existing HTTP server
+// instead of giving an address to the client.
+func (c *httpClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !c.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "bots" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	bot, endpoint := parts[1], parts[2]
+	method := "POST"
+	if endpoint == "events" || endpoint == "stream" {
+		method = "GET"
+	}
+
+	switch endpoint {
+	case "messages", "actions", "join", "events", "stream":
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	conn, err := c.Connection(bot)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("bot %s not found", bot))
+		return
+	}
+
+	c.handle(conn.(*connection), endpoint, w, r)
+}
+
+func (c *httpClient) handle(conn *connection, endpoint string, w http.ResponseWriter, r *http.Request) {
+	switch endpoint {
+	case "messages":
+		var msg Message
+		if !readRequest(w, r, &msg) {
+			return
+		}
+
+		if msg.Channel == "" || msg.User.ID == "" {
+			writeError(w, http.StatusBadRequest, errors.New("channel and user id are required"))
+			return
+		}
+
+		log15.Debug("message received", "bot", conn.id, "channel", msg.Channel, "user", msg.User.ID)
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": conn.handleMessage(msg)})
+	case "actions":
+		var action Action
+		if !readRequest(w, r, &action) {
+			return
+		}
+
+		if action.Channel == "" || action.User.ID == "" {
+			writeError(w, http.StatusBadRequest, errors.New("channel and user id are required"))
+			return
+		}
+
+		log15.Debug("action received", "bot", conn.id, "channel", action.Channel, "group", action.Group)
+		if err := conn.handleAction(action); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case "join":
+		var join Join
+		if !readRequest(w, r, &join) {
+			return
+		}
+
+		if join.Channel == "" {
+			writeError(w, http.StatusBadRequest, errors.New("channel is required"))
+			return
+		}
+
+		// the intro is run in the background so slow IntroHandlers do not
+		// block the request
+		go conn.join(join.Channel)
+		w.WriteHeader(http.StatusAccepted)
+	case "events":
+		after, ok := lastEventID(w, r)
+		if !ok {
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string][]*Event{
+			"events": conn.eventsAfter(r.URL.Query().Get("channel"), after),
+		})
+	case "stream":
+		after, ok := lastEventID(w, r)
+		if !ok {
+			return
+		}
+
+		c.stream(conn, r.URL.Query().Get("channel"), after, w)
+	}
+}
+
+func (c *httpClient) authorized(r *http.Request) bool {
+	if c.options.Token == "" {
+		return true
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.options.Token)) == 1
+}
+
+// stream writes the events of the channel with an ID greater than after as
+// server-sent events as they are published, until the frontend disconnects,
+// the bot is removed or the client is stopped.
+func (c *httpClient) stream(conn *connection, channel string, after int64, w http.ResponseWriter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	var disconnected <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		disconnected = cn.CloseNotify()
+	}
+
+	notify := conn.subscribe()
+	defer conn.unsubscribe(notify)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		for _, e := range conn.eventsAfter(channel, after) {
+			if err := writeEvent(w, e); err != nil {
+				log15.Debug("unable to write event", "bot", conn.id, "err", err.Error())
+				return
+			}
+			after = e.ID
+		}
+		flusher.Flush()
+
+		select {
+		case <-notify:
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-disconnected:
+			return
+		case <-conn.closed:
+			return
+		case <-c.shutdown:
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// lastEventID returns the ID of the last event received by the frontend,
+// taken from the Last-Event-ID header or the after query parameter.
+func lastEventID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("after")
+	}
+
+	if id == "" {
+		return 0, true
+	}
+
+	after, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid event id: %s", id))
+		return 0, false
+	}
+
+	return after, true
+}
+
+func readRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %s", err))
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log15.Error("unable to write response", "err", err.Error())
+	}
+}
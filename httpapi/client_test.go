@@ -0,0 +1,263 @@
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+const testToken = "secret"
+
+type testController struct{}
+
+func (testController) CanHandle(flamingo.Message) bool { return true }
+
+func (testController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	if msg.Text != "deploy" {
+		_, err := bot.Say(flamingo.NewOutgoingMessage("echo: " + msg.Text))
+		return err
+	}
+
+	_, err := bot.Form(testForm)
+	return err
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
+
+type testAPI struct {
+	*httptest.Server
+	t *testing.T
+}
+
+func newTestAPI(t *testing.T) (*testAPI, flamingo.Client) {
+	cli := NewClient(ClientOptions{Token: testToken})
+	cli.AddController(testController{})
+	cli.SetIntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		_, err := b.Say(flamingo.NewOutgoingMessage("hello " + ch.Name))
+		return err
+	}))
+
+	go cli.Run()
+	cli.AddBot("bot", "", nil)
+
+	return &testAPI{httptest.NewServer(cli.(http.Handler)), t}, cli
+}
+
+func (a *testAPI) request(method, path string, body interface{}, result interface{}) int {
+	var buf bytes.Buffer
+	if body != nil {
+		require.Nil(a.t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	req, err := http.NewRequest(method, a.URL+path, &buf)
+	require.Nil(a.t, err)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(a.t, err)
+	defer resp.Body.Close()
+
+	if result != nil {
+		require.Nil(a.t, json.NewDecoder(resp.Body).Decode(result))
+	}
+	return resp.StatusCode
+}
+
+// waitEvents waits until the bot has the given number of events in the
+// channel after the given one.
+func (a *testAPI) waitEvents(channel string, after int64, n int) []*Event {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var result struct {
+			Events []*Event `json:"events"`
+		}
+		path := "/bots/bot/events?channel=" + channel + "&after=" + itoa(after)
+		require.Equal(a.t, http.StatusOK, a.request("GET", path, nil, &result))
+		if len(result.Events) >= n {
+			return result.Events
+		}
+
+		if time.Now().After(deadline) {
+			require.FailNow(a.t, "events not received", "expected %d events, got %d", n, len(result.Events))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func TestClient(t *testing.T) {
+	require := require.New(t)
+	api, cli := newTestAPI(t)
+	defer api.Close()
+	defer cli.Stop()
+
+	var resp map[string]string
+	require.Equal(http.StatusAccepted, api.request("POST", "/bots/bot/join", Join{Channel: "general"}, nil))
+	events := api.waitEvents("general", 0, 1)
+	require.Equal("hello general", events[0].Text)
+	require.Equal(EventMessage, events[0].Type)
+
+	require.Equal(http.StatusAccepted, api.request("POST", "/bots/bot/messages", Message{
+		Channel: "general",
+		User:    User{ID: "jane"},
+		Text:    "hi",
+	}, &resp))
+	require.NotEqual("", resp["id"])
+
+	events = api.waitEvents("general", events[0].ID, 1)
+	require.Equal("echo: hi", events[0].Text)
+
+	require.Equal(http.StatusAccepted, api.request("POST", "/bots/bot/messages", Message{
+		Channel: "general",
+		User:    User{ID: "jane"},
+		Text:    "deploy",
+	}, nil))
+	events = api.waitEvents("general", events[0].ID, 1)
+	form := events[0]
+	require.Equal(EventForm, form.Type)
+	require.Equal("deploy", form.Form.Fields[0].ID)
+	require.Equal("yes", form.Form.Fields[0].Buttons[0].Value)
+
+	actions := make(chan flamingo.Action, 1)
+	cli.AddActionHandler("deploy", func(b flamingo.Bot, a flamingo.Action) {
+		actions <- a
+		_, err := b.UpdateMessage(a.OriginalMessage.ID, "deploying")
+		require.Nil(err)
+	})
+
+	require.Equal(http.StatusNotFound, api.request("POST", "/bots/bot/actions", Action{
+		Channel:   "general",
+		User:      User{ID: "jane"},
+		MessageID: form.MessageID,
+		Group:     "deploy",
+		Value:     "maybe",
+	}, &resp))
+	require.Equal(ErrButtonNotFound.Error(), resp["error"])
+
+	require.Equal(http.StatusAccepted, api.request("POST", "/bots/bot/actions", Action{
+		Channel:   "general",
+		User:      User{ID: "jane"},
+		MessageID: form.MessageID,
+		Group:     "deploy",
+		Value:     "yes",
+	}, nil))
+
+	select {
+	case a := <-actions:
+		require.Equal("yes", a.UserAction.Value)
+		require.Equal("jane", a.User.ID)
+	case <-time.After(2 * time.Second):
+		require.FailNow("action not received")
+	}
+
+	events = api.waitEvents("general", form.ID, 1)
+	require.Equal(form.MessageID, events[0].MessageID)
+	require.True(events[0].Edited)
+	require.Equal("deploying", events[0].Text)
+}
+
+func TestClientErrors(t *testing.T) {
+	require := require.New(t)
+	api, cli := newTestAPI(t)
+	defer api.Close()
+	defer cli.Stop()
+
+	resp, err := http.Get(api.URL + "/bots/bot/events")
+	require.Nil(err)
+	resp.Body.Close()
+	require.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	var result map[string]string
+	require.Equal(http.StatusNotFound, api.request("GET", "/bots/nope/events", nil, &result))
+	require.Equal("bot nope not found", result["error"])
+	require.Equal(http.StatusNotFound, api.request("GET", "/bots/bot/nope", nil, nil))
+	require.Equal(http.StatusNotFound, api.request("GET", "/users", nil, nil))
+	require.Equal(http.StatusMethodNotAllowed, api.request("GET", "/bots/bot/messages", nil, nil))
+	require.Equal(http.StatusMethodNotAllowed, api.request("POST", "/bots/bot/events", nil, nil))
+	require.Equal(http.StatusBadRequest, api.request("GET", "/bots/bot/events?after=x", nil, nil))
+	require.Equal(http.StatusBadRequest, api.request("POST", "/bots/bot/messages", Message{Text: "hi"}, nil))
+	require.Equal(http.StatusBadRequest, api.request("POST", "/bots/bot/join", Join{}, nil))
+	require.Equal(http.StatusBadRequest, api.request("POST", "/bots/bot/messages", nil, nil))
+}
+
+func TestStream(t *testing.T) {
+	require := require.New(t)
+	api, cli := newTestAPI(t)
+	defer api.Close()
+	defer cli.Stop()
+
+	require.Equal(http.StatusAccepted, api.request("POST", "/bots/bot/join", Join{Channel: "general"}, nil))
+	require.Equal(http.StatusAccepted, api.request("POST", "/bots/bot/join", Join{Channel: "random"}, nil))
+	first := api.waitEvents("general", 0, 1)[0]
+
+	req, err := http.NewRequest("GET", api.URL+"/bots/bot/stream?channel=general", nil)
+	require.Nil(err)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Last-Event-ID", itoa(first.ID))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(err)
+	defer resp.Body.Close()
+	require.Equal(http.StatusOK, resp.StatusCode)
+	require.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.Equal(http.StatusAccepted, api.request("POST", "/bots/bot/messages", Message{
+		Channel: "random",
+		User:    User{ID: "jane"},
+		Text:    "ignored",
+	}, nil))
+	api.waitEvents("random", 0, 2)
+
+	require.Equal(http.StatusAccepted, api.request("POST", "/bots/bot/messages", Message{
+		Channel: "general",
+		User:    User{ID: "jane"},
+		Text:    "hi",
+	}, nil))
+
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var fields []string
+	for len(fields) < 3 {
+		select {
+		case l := <-lines:
+			if l != "" && !strings.HasPrefix(l, ":") {
+				fields = append(fields, l)
+			}
+		case <-time.After(2 * time.Second):
+			require.FailNow("event not streamed")
+		}
+	}
+
+	require.True(strings.HasPrefix(fields[0], "id: "))
+	require.Equal("event: message", fields[1])
+
+	var e Event
+	require.Nil(json.Unmarshal([]byte(strings.TrimPrefix(fields[2], "data: ")), &e))
+	require.Equal("general", e.Channel)
+	require.Equal("echo: hi", e.Text)
+	require.Equal(fields[0], "id: "+itoa(e.ID))
+}
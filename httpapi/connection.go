@@ -0,0 +1,313 @@
+package httpapi
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+var (
+	// ErrFormNotFound is returned when an action refers to a form that was
+	// not posted by the bot in the channel or is no longer buffered.
+	ErrFormNotFound = errors.New("httpapi: form not found")
+	// ErrButtonNotFound is returned when an action refers to a button the
+	// form does not have.
+	ErrButtonNotFound = errors.New("httpapi: button not found")
+)
+
+type connection struct {
+	id         string
+	events     platform.Events
+	clock      flamingo.Clock
+	bufferSize int
+
+	mut         sync.Mutex
+	lastEvent   int64
+	lastMessage int64
+	buffer      []*Event
+	forms       map[string]flamingo.Form
+	subscribers map[chan struct{}]struct{}
+	closed      chan struct{}
+	once        sync.Once
+}
+
+func newConnection(bot flamingo.StoredBot, events platform.Events, options ClientOptions) *connection {
+	return &connection{
+		id:          bot.ID,
+		events:      events,
+		clock:       options.Clock,
+		bufferSize:  options.BufferSize,
+		forms:       make(map[string]flamingo.Form),
+		subscribers: make(map[chan struct{}]struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Run blocks until the connection is closed, as there is nothing to read:
+// the events of the users are delivered by the HTTP handlers.
+func (c *connection) Run() error {
+	<-c.closed
+	return nil
+}
+
+func (c *connection) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// Channel returns the channel with the given ID. IDs starting with @ are the
+// direct conversations with the user named after the rest of the ID.
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	return flamingo.Channel{
+		ID:   id,
+		Name: strings.TrimPrefix(id, "@"),
+		IsDM: strings.HasPrefix(id, "@"),
+		Type: flamingo.HTTPAPIClient,
+	}, nil
+}
+
+func (c *connection) join(channel string) {
+	ch, _ := c.Channel(channel)
+	c.events.Joined(ch)
+}
+
+func (c *connection) handleMessage(msg Message) string {
+	ch, _ := c.Channel(msg.Channel)
+	id := c.nextMessageID()
+	c.events.Message(flamingo.Message{
+		ID:      id,
+		Type:    flamingo.HTTPAPIClient,
+		User:    convertUser(msg.User),
+		Channel: ch,
+		Time:    c.clock.Now(),
+		Text:    msg.Text,
+	})
+	return id
+}
+
+// handleAction delivers the click on a button of a form posted by the bot in
+// the channel.
+func (c *connection) handleAction(action Action) error {
+	c.mut.Lock()
+	form, ok := c.forms[formKey(action.Channel, action.MessageID)]
+	c.mut.Unlock()
+	if !ok {
+		return ErrFormNotFound
+	}
+
+	button, ok := findButton(form, action.Group, action.Value)
+	if !ok {
+		return ErrButtonNotFound
+	}
+
+	ch, _ := c.Channel(action.Channel)
+	c.events.Action(platform.ActionEvent{
+		ID: action.Group,
+		Action: flamingo.Action{
+			UserAction: flamingo.UserAction{
+				Name:  button.Name,
+				Value: button.Value,
+			},
+			User:    convertUser(action.User),
+			Channel: ch,
+			OriginalMessage: flamingo.Message{
+				ID:      action.MessageID,
+				Type:    flamingo.HTTPAPIClient,
+				User:    c.botUser(),
+				Channel: ch,
+				Time:    c.clock.Now(),
+				Text:    form.Text,
+			},
+		},
+	})
+	return nil
+}
+
+func findButton(form flamingo.Form, group, value string) (flamingo.Button, bool) {
+	for _, g := range form.Fields {
+		if g.ID() != group {
+			continue
+		}
+
+		for _, i := range g.Items() {
+			if b, ok := i.(flamingo.Button); ok && b.Value == value {
+				return b, true
+			}
+		}
+	}
+
+	return flamingo.Button{}, false
+}
+
+func formKey(channel, id string) string {
+	return channel + "\x00" + id
+}
+
+func convertUser(u User) flamingo.User {
+	username := u.Username
+	if username == "" {
+		username = u.ID
+	}
+
+	return flamingo.User{
+		ID:       u.ID,
+		Username: username,
+		Name:     u.Name,
+		Type:     flamingo.HTTPAPIClient,
+	}
+}
+
+func (c *connection) botUser() flamingo.User {
+	return flamingo.User{
+		ID:       c.id,
+		Username: c.id,
+		IsBot:    true,
+		Type:     flamingo.HTTPAPIClient,
+	}
+}
+
+func (c *connection) nextMessageID() string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.lastMessage++
+	return strconv.FormatInt(c.lastMessage, 10)
+}
+
+// publish adds the event to the buffer, dropping the oldest one if the
+// buffer is full, and notifies the subscribers.
+func (c *connection) publish(e *Event) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.lastEvent++
+	e.ID = c.lastEvent
+	e.Bot = c.id
+	e.Time = c.clock.Now()
+
+	c.buffer = append(c.buffer, e)
+	if len(c.buffer) > c.bufferSize {
+		c.buffer = c.buffer[len(c.buffer)-c.bufferSize:]
+	}
+
+	for ch := range c.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// eventsAfter returns the buffered events of the channel with an ID greater
+// than the given one. If channel is empty, the events of all channels are
+// returned.
+func (c *connection) eventsAfter(channel string, after int64) []*Event {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	events := []*Event{}
+	for _, e := range c.buffer {
+		if e.ID > after && (channel == "" || e.Channel == channel) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// subscribe returns a channel that receives a value every time there are new
+// events, until it is unsubscribed.
+func (c *connection) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	c.mut.Lock()
+	c.subscribers[ch] = struct{}{}
+	c.mut.Unlock()
+	return ch
+}
+
+func (c *connection) unsubscribe(ch chan struct{}) {
+	c.mut.Lock()
+	delete(c.subscribers, ch)
+	c.mut.Unlock()
+}
+
+func (c *connection) post(channel string, e *Event) string {
+	e.Channel = channel
+	if e.MessageID == "" {
+		e.MessageID = c.nextMessageID()
+	}
+	c.publish(e)
+	return e.MessageID
+}
+
+func (c *connection) update(channel, id string, e *Event) string {
+	e.MessageID = id
+	e.Edited = true
+	return c.post(channel, e)
+}
+
+func (c *connection) trackForm(channel, id string, form *flamingo.Form) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	key := formKey(channel, id)
+	if form == nil {
+		delete(c.forms, key)
+	} else {
+		c.forms[key] = *form
+	}
+
+	if len(c.forms) > c.bufferSize {
+		c.pruneForms(key)
+	}
+}
+
+// pruneForms forgets the forms that are no longer in the buffer but the one
+// with the given key, which may be about to be published, so their buttons
+// can not be clicked anymore. It must be called with the lock held.
+func (c *connection) pruneForms(keep string) {
+	buffered := map[string]struct{}{keep: {}}
+	for _, e := range c.buffer {
+		buffered[formKey(e.Channel, e.MessageID)] = struct{}{}
+	}
+
+	for k := range c.forms {
+		if _, ok := buffered[k]; !ok {
+			delete(c.forms, k)
+		}
+	}
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	return c.post(channel, &Event{Type: EventMessage, Text: msg.Text}), nil
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	// the form is tracked before it is published, so its buttons can be
+	// clicked as soon as a frontend receives it
+	id := c.nextMessageID()
+	c.trackForm(channel, id, &form)
+	return c.post(channel, &Event{Type: EventForm, MessageID: id, Form: convertForm(form)}), nil
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	return c.post(channel, &Event{Type: EventImage, Image: convertImage(img)}), nil
+}
+
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	c.trackForm(channel, id, nil)
+	return c.update(channel, id, &Event{Type: EventMessage, Text: text}), nil
+}
+
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	c.trackForm(channel, id, &form)
+	return c.update(channel, id, &Event{Type: EventForm, Form: convertForm(form)}), nil
+}
+
+func (c *connection) DirectChannel(user string) (string, error) {
+	return "@" + strings.TrimPrefix(user, "@"), nil
+}
@@ -0,0 +1,168 @@
+package httpapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+type eventsMock struct {
+	messages []flamingo.Message
+	actions  []platform.ActionEvent
+	joined   []flamingo.Channel
+	left     []string
+}
+
+func (e *eventsMock) Message(m flamingo.Message)    { e.messages = append(e.messages, m) }
+func (e *eventsMock) Action(a platform.ActionEvent) { e.actions = append(e.actions, a) }
+func (e *eventsMock) Joined(ch flamingo.Channel)    { e.joined = append(e.joined, ch) }
+func (e *eventsMock) Left(ch string)                { e.left = append(e.left, ch) }
+
+var testTime = time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)
+
+func newTestConnection(events platform.Events, bufferSize int) *connection {
+	return newConnection(flamingo.StoredBot{ID: "bot"}, events, ClientOptions{
+		Clock:      flamingo.NewFakeClock(testTime),
+		BufferSize: bufferSize,
+	})
+}
+
+var testForm = flamingo.Form{
+	Text: "Deploy?",
+	Fields: []flamingo.FieldGroup{
+		flamingo.NewButtonGroup("deploy",
+			flamingo.NewButton("Yes", "yes"),
+			flamingo.NewButton("No", "no"),
+		),
+	},
+}
+
+func TestHandleMessage(t *testing.T) {
+	require := require.New(t)
+	events := new(eventsMock)
+	conn := newTestConnection(events, 10)
+
+	require.Equal("1", conn.handleMessage(Message{Channel: "general", User: User{ID: "jane"}, Text: "hi"}))
+	require.Equal("2", conn.handleMessage(Message{Channel: "@jane", User: User{ID: "u1", Username: "jane", Name: "Jane"}, Text: "hey"}))
+
+	require.Equal([]flamingo.Message{
+		{
+			ID:      "1",
+			Type:    flamingo.HTTPAPIClient,
+			User:    flamingo.User{ID: "jane", Username: "jane", Type: flamingo.HTTPAPIClient},
+			Channel: flamingo.Channel{ID: "general", Name: "general", Type: flamingo.HTTPAPIClient},
+			Time:    testTime,
+			Text:    "hi",
+		},
+		{
+			ID:      "2",
+			Type:    flamingo.HTTPAPIClient,
+			User:    flamingo.User{ID: "u1", Username: "jane", Name: "Jane", Type: flamingo.HTTPAPIClient},
+			Channel: flamingo.Channel{ID: "@jane", Name: "jane", IsDM: true, Type: flamingo.HTTPAPIClient},
+			Time:    testTime,
+			Text:    "hey",
+		},
+	}, events.messages)
+}
+
+func TestHandleAction(t *testing.T) {
+	require := require.New(t)
+	events := new(eventsMock)
+	conn := newTestConnection(events, 10)
+
+	id, err := conn.PostForm("general", testForm)
+	require.Nil(err)
+
+	user := User{ID: "jane"}
+	require.Equal(ErrFormNotFound, conn.handleAction(Action{Channel: "random", User: user, MessageID: id, Group: "deploy", Value: "yes"}))
+	require.Equal(ErrFormNotFound, conn.handleAction(Action{Channel: "general", User: user, MessageID: "404", Group: "deploy", Value: "yes"}))
+	require.Equal(ErrButtonNotFound, conn.handleAction(Action{Channel: "general", User: user, MessageID: id, Group: "other", Value: "yes"}))
+	require.Equal(ErrButtonNotFound, conn.handleAction(Action{Channel: "general", User: user, MessageID: id, Group: "deploy", Value: "maybe"}))
+	require.Equal(0, len(events.actions))
+
+	require.Nil(conn.handleAction(Action{Channel: "general", User: user, MessageID: id, Group: "deploy", Value: "no"}))
+	require.Equal(1, len(events.actions))
+	a := events.actions[0]
+	require.Equal("deploy", a.ID)
+	require.Equal(flamingo.UserAction{Name: "no", Value: "no"}, a.Action.UserAction)
+	require.Equal("jane", a.Action.User.Username)
+	require.Equal("general", a.Action.Channel.ID)
+	require.Equal(id, a.Action.OriginalMessage.ID)
+	require.Equal("Deploy?", a.Action.OriginalMessage.Text)
+
+	// once replaced by a message, the form can not be clicked anymore
+	_, err = conn.UpdateMessage("general", id, "deploying")
+	require.Nil(err)
+	require.Equal(ErrFormNotFound, conn.handleAction(Action{Channel: "general", User: user, MessageID: id, Group: "deploy", Value: "no"}))
+}
+
+func TestPostAndUpdate(t *testing.T) {
+	require := require.New(t)
+	conn := newTestConnection(new(eventsMock), 10)
+
+	id, err := conn.PostMessage("general", flamingo.NewOutgoingMessage("hello"))
+	require.Nil(err)
+	require.Equal("1", id)
+
+	id, err = conn.PostImage("@jane", flamingo.Image{URL: "http://img", Text: "cat"})
+	require.Nil(err)
+	require.Equal("2", id)
+
+	id, err = conn.UpdateForm("general", "1", testForm)
+	require.Nil(err)
+	require.Equal("1", id)
+
+	events := conn.eventsAfter("", 0)
+	require.Equal([]*Event{
+		{ID: 1, Type: EventMessage, Bot: "bot", Channel: "general", MessageID: "1", Time: testTime, Text: "hello"},
+		{ID: 2, Type: EventImage, Bot: "bot", Channel: "@jane", MessageID: "2", Time: testTime, Image: &Image{URL: "http://img", Text: "cat"}},
+		{ID: 3, Type: EventForm, Bot: "bot", Channel: "general", MessageID: "1", Edited: true, Time: testTime, Form: convertForm(testForm)},
+	}, events)
+
+	require.Equal(events[2:], conn.eventsAfter("general", 1))
+	require.Equal([]*Event{}, conn.eventsAfter("general", 3))
+
+	ch, err := conn.DirectChannel("jane")
+	require.Nil(err)
+	require.Equal("@jane", ch)
+}
+
+func TestBuffer(t *testing.T) {
+	require := require.New(t)
+	conn := newTestConnection(new(eventsMock), 2)
+
+	notify := conn.subscribe()
+	first, err := conn.PostForm("general", testForm)
+	require.Nil(err)
+	for i := 0; i < 3; i++ {
+		_, err := conn.PostForm("general", testForm)
+		require.Nil(err)
+	}
+
+	select {
+	case <-notify:
+	default:
+		require.FailNow("subscriber not notified")
+	}
+
+	events := conn.eventsAfter("", 0)
+	require.Equal(2, len(events))
+	require.Equal(int64(3), events[0].ID)
+	require.Equal(int64(4), events[1].ID)
+
+	// the forms no longer buffered are forgotten
+	require.Equal(ErrFormNotFound, conn.handleAction(Action{Channel: "general", User: User{ID: "jane"}, MessageID: first, Group: "deploy", Value: "yes"}))
+
+	conn.unsubscribe(notify)
+	_, err = conn.PostMessage("general", flamingo.NewOutgoingMessage("hello"))
+	require.Nil(err)
+	select {
+	case <-notify:
+		require.FailNow("unsubscribed channel notified")
+	default:
+	}
+}
@@ -0,0 +1,243 @@
+package httpapi
+
+import (
+	"time"
+
+	"github.com/src-d/flamingo"
+)
+
+// Types of the events sent to the frontends.
+const (
+	// EventMessage is a text message posted by a bot.
+	EventMessage = "message"
+	// EventForm is a form posted by a bot.
+	EventForm = "form"
+	// EventImage is an image posted by a bot.
+	EventImage = "image"
+)
+
+// Types of the field groups of a form.
+const (
+	// GroupButtons is a group of buttons.
+	GroupButtons = "buttons"
+	// GroupTextFields is a group of text fields.
+	GroupTextFields = "text_fields"
+	// GroupImage is a single image.
+	GroupImage = "image"
+	// GroupText is a single free text.
+	GroupText = "text"
+)
+
+// Styles of the buttons.
+const (
+	// ButtonDefault is the default button.
+	ButtonDefault = "default"
+	// ButtonPrimary is a button that stands out.
+	ButtonPrimary = "primary"
+	// ButtonDanger is a button for a dangerous action.
+	ButtonDanger = "danger"
+)
+
+// User is a user of the frontend.
+type User struct {
+	// ID is the unique identifier of the user.
+	ID string `json:"id"`
+	// Username is the handle of the user. If empty, the ID is used.
+	Username string `json:"username,omitempty"`
+	// Name is the real name of the user.
+	Name string `json:"name,omitempty"`
+}
+
+// Message is a message posted by a user to a bot.
+type Message struct {
+	// Channel is the ID of the channel the message is posted in. Channels
+	// whose ID starts with @ are direct conversations.
+	Channel string `json:"channel"`
+	// User is the user posting the message.
+	User User `json:"user"`
+	// Text is the text of the message.
+	Text string `json:"text"`
+}
+
+// Action is the click of a user on a button of a form posted by a bot.
+type Action struct {
+	// Channel is the ID of the channel of the form.
+	Channel string `json:"channel"`
+	// User is the user clicking the button.
+	User User `json:"user"`
+	// MessageID is the ID of the form.
+	MessageID string `json:"message_id"`
+	// Group is the ID of the group of the button.
+	Group string `json:"group"`
+	// Value is the value of the button.
+	Value string `json:"value"`
+}
+
+// Join is the request of a frontend to add a bot to a channel, which makes
+// the bot introduce itself if it was not in the channel yet.
+type Join struct {
+	// Channel is the ID of the channel.
+	Channel string `json:"channel"`
+}
+
+// Event is something a bot did in a channel.
+type Event struct {
+	// ID is the sequence number of the event. The IDs of the events of a bot
+	// always grow, so they can be used to get only the events after a given
+	// one.
+	ID int64 `json:"id"`
+	// Type is the type of the event: message, form or image.
+	Type string `json:"type"`
+	// Bot is the ID of the bot.
+	Bot string `json:"bot"`
+	// Channel is the ID of the channel.
+	Channel string `json:"channel"`
+	// MessageID is the ID of the message posted or edited.
+	MessageID string `json:"message_id"`
+	// Edited is true if the event replaces the previous message with the
+	// same ID.
+	Edited bool `json:"edited,omitempty"`
+	// Time is the time of the event.
+	Time time.Time `json:"time"`
+	// Text is the text of the message events.
+	Text string `json:"text,omitempty"`
+	// Form is the form of the form events.
+	Form *Form `json:"form,omitempty"`
+	// Image is the image of the image events.
+	Image *Image `json:"image,omitempty"`
+}
+
+// Form is a form posted by a bot.
+type Form struct {
+	Title         string       `json:"title,omitempty"`
+	Text          string       `json:"text,omitempty"`
+	AuthorName    string       `json:"author_name,omitempty"`
+	AuthorIconURL string       `json:"author_icon_url,omitempty"`
+	Color         string       `json:"color,omitempty"`
+	Footer        string       `json:"footer,omitempty"`
+	Fields        []FieldGroup `json:"fields"`
+}
+
+// FieldGroup is a group of fields of a form. Only the field matching its
+// type is set.
+type FieldGroup struct {
+	// Type is the type of the group: buttons, text_fields, image or text.
+	Type string `json:"type"`
+	// ID is the ID of the groups of buttons, which must be sent back with
+	// the actions.
+	ID         string      `json:"id,omitempty"`
+	Buttons    []Button    `json:"buttons,omitempty"`
+	TextFields []TextField `json:"text_fields,omitempty"`
+	Image      *Image      `json:"image,omitempty"`
+	Text       string      `json:"text,omitempty"`
+}
+
+// Button is a button of a form.
+type Button struct {
+	Text string `json:"text"`
+	// Value is the value of the button, which must be sent back with the
+	// actions.
+	Value string `json:"value"`
+	// Style is the style of the button: default, primary or danger.
+	Style        string        `json:"style"`
+	Confirmation *Confirmation `json:"confirmation,omitempty"`
+}
+
+// Confirmation is a confirmation to ask the user before performing the
+// action of a button.
+type Confirmation struct {
+	Title   string `json:"title,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Ok      string `json:"ok,omitempty"`
+	Dismiss string `json:"dismiss,omitempty"`
+}
+
+// TextField is a label along with its value.
+type TextField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty"`
+}
+
+// Image is an image posted by a bot.
+type Image struct {
+	URL          string `json:"url"`
+	Text         string `json:"text,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+func convertForm(form flamingo.Form) *Form {
+	f := &Form{
+		Title:         form.Title,
+		Text:          form.Text,
+		AuthorName:    form.AuthorName,
+		AuthorIconURL: form.AuthorIconURL,
+		Color:         form.Color,
+		Footer:        form.Footer,
+		Fields:        []FieldGroup{},
+	}
+
+	for _, g := range form.Fields {
+		group := FieldGroup{ID: g.ID()}
+		for _, i := range g.Items() {
+			switch field := i.(type) {
+			case flamingo.Button:
+				group.Type = GroupButtons
+				group.Buttons = append(group.Buttons, convertButton(field))
+			case flamingo.TextField:
+				group.Type = GroupTextFields
+				group.TextFields = append(group.TextFields, TextField{
+					Title: field.Title,
+					Value: field.Value,
+					Short: field.Short,
+				})
+			case flamingo.Image:
+				group.Type = GroupImage
+				group.Image = convertImage(field)
+			case flamingo.Text:
+				group.Type = GroupText
+				group.Text = string(field)
+			}
+		}
+
+		if group.Type != "" {
+			f.Fields = append(f.Fields, group)
+		}
+	}
+
+	return f
+}
+
+func convertButton(b flamingo.Button) Button {
+	button := Button{
+		Text:  b.Text,
+		Value: b.Value,
+		Style: ButtonDefault,
+	}
+
+	switch b.Type {
+	case flamingo.PrimaryButton:
+		button.Style = ButtonPrimary
+	case flamingo.DangerButton:
+		button.Style = ButtonDanger
+	}
+
+	if c := b.Confirmation; c != nil {
+		button.Confirmation = &Confirmation{
+			Title:   c.Title,
+			Text:    c.Text,
+			Ok:      c.Ok,
+			Dismiss: c.Dismiss,
+		}
+	}
+
+	return button
+}
+
+func convertImage(img flamingo.Image) *Image {
+	return &Image{
+		URL:          img.URL,
+		Text:         img.Text,
+		ThumbnailURL: img.ThumbnailURL,
+	}
+}
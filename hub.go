@@ -0,0 +1,494 @@
+package flamingo
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	// ErrClientExists occurs when a client is added to a hub with a name
+	// that is already in use.
+	ErrClientExists = errors.New("a client with the same name already exists")
+	// ErrClientNotFound occurs when there is no client with the given name
+	// in the hub.
+	ErrClientNotFound = errors.New("client not found")
+	// ErrHubRunning occurs when a hub is run while it is already running.
+	ErrHubRunning = errors.New("the hub is already running")
+)
+
+type hubJob struct {
+	name     string
+	schedule ScheduleTime
+	job      Job
+	paused   bool
+}
+
+type hubClient struct {
+	name    string
+	client  Client
+	running bool
+}
+
+// Hub runs several clients, usually for different platforms, behind a
+// single API. Controllers, action handlers, middlewares, handlers and
+// scheduled jobs are registered once in the hub and added to all of its
+// clients, including the ones added later. Bots and storage are still
+// configured in every client, as they are platform-specific.
+type Hub struct {
+	sync.RWMutex
+	clients      []*hubClient
+	controllers  []Controller
+	actions      map[string]ActionHandler
+	middlewares  []Middleware
+	introHandler IntroHandler
+	errorHandler ErrorHandler
+	logOutput    io.Writer
+	jobs         []*hubJob
+	running      bool
+	failed       error
+	wg           sync.WaitGroup
+}
+
+// NewHub creates a new empty Hub.
+func NewHub() *Hub {
+	return &Hub{
+		actions: make(map[string]ActionHandler),
+	}
+}
+
+// AddClient adds a new client to the hub with the given name. Everything
+// already registered in the hub is added to the client and, if the hub is
+// running, the client is started right away.
+func (h *Hub) AddClient(name string, client Client) error {
+	h.Lock()
+	defer h.Unlock()
+	if h.find(name) != nil {
+		return ErrClientExists
+	}
+
+	// the jobs are added first, because it is the only configuration that
+	// can fail and be undone
+	if err := addJobs(client, h.jobs); err != nil {
+		return err
+	}
+
+	if h.logOutput != nil {
+		client.SetLogOutput(h.logOutput)
+	}
+
+	if len(h.middlewares) > 0 {
+		client.Use(h.middlewares...)
+	}
+
+	for _, ctrl := range h.controllers {
+		client.AddController(ctrl)
+	}
+
+	for id, handler := range h.actions {
+		client.AddActionHandler(id, handler)
+	}
+
+	if h.introHandler != nil {
+		client.SetIntroHandler(h.introHandler)
+	}
+
+	if h.errorHandler != nil {
+		client.SetErrorHandler(h.errorHandler)
+	}
+
+	c := &hubClient{name: name, client: client}
+	h.clients = append(h.clients, c)
+	if h.running {
+		h.start(c)
+	}
+
+	return nil
+}
+
+// addJobs adds the given jobs to the client, paused if they are paused in the
+// hub. If any of them cannot be added or paused, the ones already added are
+// removed from the client.
+func addJobs(client Client, jobs []*hubJob) error {
+	for i, j := range jobs {
+		if err := client.AddScheduledJob(j.name, j.schedule, j.job); err != nil {
+			removeJobs(client, jobs[:i])
+			return err
+		}
+
+		if j.paused {
+			if err := client.PauseJob(j.name); err != nil {
+				removeJobs(client, jobs[:i+1])
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func removeJobs(client Client, jobs []*hubJob) {
+	for _, j := range jobs {
+		if err := client.RemoveScheduledJob(j.name); err != nil {
+			log15.Error("unable to remove scheduled job", "name", j.name, "err", err.Error())
+		}
+	}
+}
+
+// Client returns the client with the given name.
+func (h *Hub) Client(name string) (Client, error) {
+	h.RLock()
+	defer h.RUnlock()
+	c := h.find(name)
+	if c == nil {
+		return nil, ErrClientNotFound
+	}
+	return c.client, nil
+}
+
+// Clients returns the names of all the clients in the hub in the order they
+// were added.
+func (h *Hub) Clients() []string {
+	h.RLock()
+	defer h.RUnlock()
+	var names = make([]string, len(h.clients))
+	for i, c := range h.clients {
+		names[i] = c.name
+	}
+	return names
+}
+
+// SetLogOutput will write the logs of all clients to the given io.Writer.
+func (h *Hub) SetLogOutput(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	h.logOutput = w
+	for _, c := range h.clients {
+		c.client.SetLogOutput(w)
+	}
+}
+
+// Use adds one or more middlewares to all clients.
+func (h *Hub) Use(middlewares ...Middleware) {
+	h.Lock()
+	defer h.Unlock()
+	h.middlewares = append(h.middlewares, middlewares...)
+	for _, c := range h.clients {
+		c.client.Use(middlewares...)
+	}
+}
+
+// AddController adds a new Controller to all clients.
+func (h *Hub) AddController(ctrl Controller) {
+	h.Lock()
+	defer h.Unlock()
+	h.controllers = append(h.controllers, ctrl)
+	for _, c := range h.clients {
+		c.client.AddController(ctrl)
+	}
+}
+
+// AddActionHandler adds an ActionHandler for the given ID to all clients.
+func (h *Hub) AddActionHandler(id string, handler ActionHandler) {
+	h.Lock()
+	defer h.Unlock()
+	h.actions[id] = handler
+	for _, c := range h.clients {
+		c.client.AddActionHandler(id, handler)
+	}
+}
+
+// SetIntroHandler sets the IntroHandler of all clients.
+func (h *Hub) SetIntroHandler(handler IntroHandler) {
+	h.Lock()
+	defer h.Unlock()
+	h.introHandler = handler
+	for _, c := range h.clients {
+		c.client.SetIntroHandler(handler)
+	}
+}
+
+// SetErrorHandler sets the error handler of all clients.
+func (h *Hub) SetErrorHandler(handler ErrorHandler) {
+	h.Lock()
+	defer h.Unlock()
+	h.errorHandler = handler
+	for _, c := range h.clients {
+		c.client.SetErrorHandler(handler)
+	}
+}
+
+// AddScheduledJob adds a scheduled job with the given name to all clients.
+// Every client runs the job on its own conversations. If the job cannot be
+// added to some client, the first error is returned, but the job is still
+// added to the rest of them.
+func (h *Hub) AddScheduledJob(name string, schedule ScheduleTime, job Job) error {
+	h.Lock()
+	defer h.Unlock()
+	if h.findJob(name) != nil {
+		return ErrJobExists
+	}
+
+	h.jobs = append(h.jobs, &hubJob{name: name, schedule: schedule, job: job})
+	var err error
+	for _, c := range h.clients {
+		if e := c.client.AddScheduledJob(name, schedule, job); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// RunJob runs the scheduled job with the given name immediately in all
+// clients and returns a record of the run that merges the runs of all of them.
+func (h *Hub) RunJob(name string) (JobRun, error) {
+	clients, err := h.clientsWithJob(name)
+	if err != nil {
+		return JobRun{}, err
+	}
+
+	var (
+		runs = make([]JobRun, len(clients))
+		errs = make([]error, len(clients))
+		wg   sync.WaitGroup
+	)
+
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c Client) {
+			defer wg.Done()
+			runs[i], errs[i] = c.RunJob(name)
+		}(i, c)
+	}
+	wg.Wait()
+
+	run := JobRun{Job: name, Manual: true}
+	for i, r := range runs {
+		if errs[i] != nil {
+			if err == nil {
+				err = errs[i]
+			}
+			continue
+		}
+
+		if run.Start.IsZero() || r.Start.Before(run.Start) {
+			run.Start = r.Start
+		}
+
+		if r.End.After(run.End) {
+			run.End = r.End
+		}
+
+		run.Conversations += r.Conversations
+		run.Errors += r.Errors
+	}
+
+	return run, err
+}
+
+// PauseJob pauses the scheduled job with the given name in all clients.
+func (h *Hub) PauseJob(name string) error {
+	return h.setJobPaused(name, true)
+}
+
+// ResumeJob resumes the scheduled job with the given name in all clients.
+func (h *Hub) ResumeJob(name string) error {
+	return h.setJobPaused(name, false)
+}
+
+func (h *Hub) setJobPaused(name string, paused bool) error {
+	h.Lock()
+	defer h.Unlock()
+	j := h.findJob(name)
+	if j == nil {
+		return ErrJobNotFound
+	}
+
+	j.paused = paused
+	var err error
+	for _, c := range h.clients {
+		var e error
+		if paused {
+			e = c.client.PauseJob(name)
+		} else {
+			e = c.client.ResumeJob(name)
+		}
+
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// JobHistory returns the recorded runs of the scheduled job with the given
+// name in all clients, sorted by the time they started.
+func (h *Hub) JobHistory(name string) ([]JobRun, error) {
+	clients, err := h.clientsWithJob(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []JobRun
+	for _, c := range clients {
+		runs, err := c.JobHistory(name)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, runs...)
+	}
+
+	sort.Stable(byStart(history))
+	return history, nil
+}
+
+type byStart []JobRun
+
+func (s byStart) Len() int           { return len(s) }
+func (s byStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStart) Less(i, j int) bool { return s[i].Start.Before(s[j].Start) }
+
+func (h *Hub) clientsWithJob(name string) ([]Client, error) {
+	h.RLock()
+	defer h.RUnlock()
+	if h.findJob(name) == nil {
+		return nil, ErrJobNotFound
+	}
+
+	var clients = make([]Client, len(h.clients))
+	for i, c := range h.clients {
+		clients[i] = c.client
+	}
+	return clients, nil
+}
+
+// Broadcast sends the message to the conversations of all clients that pass
+// the filter. It blocks until all of them have finished and returns the
+// merged report of the deliveries.
+func (h *Hub) Broadcast(msg Sendable, filter BroadcastFilter, opts BroadcastOptions) (BroadcastReport, error) {
+	report := <-h.BroadcastAsync(msg, filter, opts)
+	return report, report.Err()
+}
+
+// BroadcastAsync is the same as Broadcast, but it does not block. Instead,
+// it returns a channel where the merged report will be sent when the
+// broadcasts of all clients finish.
+func (h *Hub) BroadcastAsync(msg Sendable, filter BroadcastFilter, opts BroadcastOptions) <-chan BroadcastReport {
+	h.RLock()
+	var reports = make([]<-chan BroadcastReport, len(h.clients))
+	for i, c := range h.clients {
+		reports[i] = c.client.BroadcastAsync(msg, filter, opts)
+	}
+	h.RUnlock()
+
+	var result = make(chan BroadcastReport, 1)
+	go func() {
+		var merged BroadcastReport
+		for i, ch := range reports {
+			report := <-ch
+			if i == 0 || report.Start.Before(merged.Start) {
+				merged.Start = report.Start
+			}
+
+			if report.End.After(merged.End) {
+				merged.End = report.End
+			}
+
+			merged.Deliveries = append(merged.Deliveries, report.Deliveries...)
+		}
+		result <- merged
+	}()
+
+	return result
+}
+
+// Run starts all the clients and blocks until all of them have stopped.
+// If any of the clients fails, the rest of them are stopped and the error
+// is returned.
+func (h *Hub) Run() error {
+	h.Lock()
+	if h.running {
+		h.Unlock()
+		return ErrHubRunning
+	}
+
+	h.running = true
+	h.failed = nil
+	for _, c := range h.clients {
+		h.start(c)
+	}
+	h.Unlock()
+
+	h.wg.Wait()
+
+	h.Lock()
+	defer h.Unlock()
+	h.running = false
+	return h.failed
+}
+
+func (h *Hub) start(c *hubClient) {
+	c.running = true
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		err := c.client.Run()
+
+		h.Lock()
+		c.running = false
+		if err != nil && h.failed == nil {
+			h.failed = err
+		}
+		h.Unlock()
+
+		if err != nil {
+			log15.Error("client stopped with an error, stopping hub", "client", c.name, "err", err.Error())
+			if err := h.Stop(); err != nil {
+				log15.Error("error stopping hub", "err", err.Error())
+			}
+		}
+	}()
+}
+
+// Stop stops all the running clients and returns the first error that
+// occurred while stopping them.
+func (h *Hub) Stop() error {
+	h.Lock()
+	var clients []*hubClient
+	for _, c := range h.clients {
+		if c.running {
+			c.running = false
+			clients = append(clients, c)
+		}
+	}
+	h.Unlock()
+
+	var err error
+	for _, c := range clients {
+		log15.Debug("stopping client", "client", c.name)
+		if e := c.client.Stop(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (h *Hub) find(name string) *hubClient {
+	for _, c := range h.clients {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (h *Hub) findJob(name string) *hubJob {
+	for _, j := range h.jobs {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
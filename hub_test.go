@@ -0,0 +1,317 @@
+package flamingo
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type clientMock struct {
+	sync.Mutex
+	controllers  []Controller
+	actions      map[string]ActionHandler
+	middlewares  []Middleware
+	introHandler IntroHandler
+	errorHandler ErrorHandler
+	jobs         map[string]bool
+	runs         []JobRun
+	report       BroadcastReport
+	runErr       error
+	pauseErr     error
+	stops        int
+	stop         chan struct{}
+}
+
+func newClientMock() *clientMock {
+	return &clientMock{
+		actions: make(map[string]ActionHandler),
+		jobs:    make(map[string]bool),
+		stop:    make(chan struct{}, 1),
+	}
+}
+
+func (c *clientMock) SetLogOutput(io.Writer) {}
+
+func (c *clientMock) Use(m ...Middleware) {
+	c.middlewares = append(c.middlewares, m...)
+}
+
+func (c *clientMock) AddController(ctrl Controller) {
+	c.controllers = append(c.controllers, ctrl)
+}
+
+func (c *clientMock) AddActionHandler(id string, h ActionHandler) {
+	c.actions[id] = h
+}
+
+func (c *clientMock) AddBot(string, string, interface{}) {}
+
+func (c *clientMock) SetIntroHandler(h IntroHandler) {
+	c.introHandler = h
+}
+
+func (c *clientMock) SetErrorHandler(h ErrorHandler) {
+	c.errorHandler = h
+}
+
+func (c *clientMock) SetStorage(Storage) {}
+
+func (c *clientMock) AddScheduledJob(name string, _ ScheduleTime, _ Job) error {
+	if _, ok := c.jobs[name]; ok {
+		return ErrJobExists
+	}
+	c.jobs[name] = false
+	return nil
+}
+
+func (c *clientMock) RemoveScheduledJob(name string) error {
+	if _, ok := c.jobs[name]; !ok {
+		return ErrJobNotFound
+	}
+	delete(c.jobs, name)
+	return nil
+}
+
+func (c *clientMock) RunJob(name string) (JobRun, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.jobs[name]; !ok {
+		return JobRun{}, ErrJobNotFound
+	}
+
+	run := JobRun{Job: name, Manual: true, Conversations: 2, Errors: 1}
+	if len(c.runs) > 0 {
+		run = c.runs[0]
+	}
+	return run, nil
+}
+
+func (c *clientMock) PauseJob(name string) error {
+	if c.pauseErr != nil {
+		return c.pauseErr
+	}
+	c.jobs[name] = true
+	return nil
+}
+
+func (c *clientMock) ResumeJob(name string) error {
+	c.jobs[name] = false
+	return nil
+}
+
+func (c *clientMock) JobHistory(name string) ([]JobRun, error) {
+	return c.runs, nil
+}
+
+func (c *clientMock) SetJobHistory(JobHistory) {}
+
+func (c *clientMock) SetMessageLog(MessageLog) {}
+
+func (c *clientMock) Broadcast(msg Sendable, f BroadcastFilter, o BroadcastOptions) (BroadcastReport, error) {
+	return c.report, c.report.Err()
+}
+
+func (c *clientMock) BroadcastAsync(msg Sendable, f BroadcastFilter, o BroadcastOptions) <-chan BroadcastReport {
+	ch := make(chan BroadcastReport, 1)
+	ch <- c.report
+	return ch
+}
+
+func (c *clientMock) Run() error {
+	if c.runErr != nil {
+		return c.runErr
+	}
+	<-c.stop
+	return nil
+}
+
+func (c *clientMock) Stop() error {
+	c.Lock()
+	c.stops++
+	c.Unlock()
+	c.stop <- struct{}{}
+	return nil
+}
+
+func (c *clientMock) stopCount() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.stops
+}
+
+type ctrlMock struct{}
+
+func (ctrlMock) CanHandle(Message) bool    { return true }
+func (ctrlMock) Handle(Bot, Message) error { return nil }
+
+func TestHubAddClient(t *testing.T) {
+	require := require.New(t)
+	hub := NewHub()
+	first := newClientMock()
+	require.Nil(hub.AddClient("first", first))
+	require.Equal(ErrClientExists, hub.AddClient("first", newClientMock()))
+
+	hub.AddController(ctrlMock{})
+	hub.AddActionHandler("foo", func(Bot, Action) {})
+	hub.Use(func(b Bot, m Message, next HandlerFunc) error { return next(b, m) })
+	hub.SetErrorHandler(func(interface{}) {})
+	require.Nil(hub.AddScheduledJob("job", NewIntervalSchedule(time.Minute), noopJob))
+	require.Equal(ErrJobExists, hub.AddScheduledJob("job", NewIntervalSchedule(time.Minute), noopJob))
+	require.Nil(hub.PauseJob("job"))
+
+	second := newClientMock()
+	require.Nil(hub.AddClient("second", second))
+
+	for _, c := range []*clientMock{first, second} {
+		require.Equal(1, len(c.controllers))
+		require.Equal(1, len(c.actions))
+		require.Equal(1, len(c.middlewares))
+		require.NotNil(c.errorHandler)
+		require.Equal(map[string]bool{"job": true}, c.jobs)
+	}
+
+	require.Equal([]string{"first", "second"}, hub.Clients())
+	c, err := hub.Client("second")
+	require.Nil(err)
+	require.Equal(second, c)
+
+	_, err = hub.Client("third")
+	require.Equal(ErrClientNotFound, err)
+}
+
+func TestHubAddClientFail(t *testing.T) {
+	require := require.New(t)
+	hub := NewHub()
+	hub.AddController(ctrlMock{})
+	hub.SetErrorHandler(func(interface{}) {})
+	require.Nil(hub.AddScheduledJob("first", NewIntervalSchedule(time.Minute), noopJob))
+	require.Nil(hub.AddScheduledJob("second", NewIntervalSchedule(time.Minute), noopJob))
+	require.Nil(hub.PauseJob("second"))
+
+	client := newClientMock()
+	client.pauseErr = errors.New("fail")
+	require.Equal(client.pauseErr, hub.AddClient("client", client))
+	require.Equal(0, len(client.jobs))
+	require.Equal(0, len(client.controllers))
+	require.Nil(client.errorHandler)
+	require.Equal(0, len(hub.Clients()))
+
+	client = newClientMock()
+	client.jobs["second"] = false
+	require.Equal(ErrJobExists, hub.AddClient("client", client))
+	require.Equal(map[string]bool{"second": false}, client.jobs)
+	require.Equal(0, len(client.controllers))
+}
+
+func TestHubJobs(t *testing.T) {
+	require := require.New(t)
+	hub := NewHub()
+	first, second := newClientMock(), newClientMock()
+	first.runs = []JobRun{{Job: "job", Start: epoch.Add(time.Minute), End: epoch.Add(2 * time.Minute), Conversations: 3}}
+	second.runs = []JobRun{{Job: "job", Start: epoch, End: epoch.Add(time.Minute), Conversations: 2, Errors: 1}}
+	require.Nil(hub.AddClient("first", first))
+	require.Nil(hub.AddClient("second", second))
+	require.Nil(hub.AddScheduledJob("job", NewIntervalSchedule(time.Minute), noopJob))
+
+	run, err := hub.RunJob("job")
+	require.Nil(err)
+	require.True(run.Manual)
+	require.Equal(uint64(5), run.Conversations)
+	require.Equal(uint64(1), run.Errors)
+	require.Equal(epoch, run.Start)
+	require.Equal(epoch.Add(2*time.Minute), run.End)
+
+	history, err := hub.JobHistory("job")
+	require.Nil(err)
+	require.Equal(2, len(history))
+	require.Equal(epoch, history[0].Start)
+
+	require.Nil(hub.PauseJob("job"))
+	require.True(first.jobs["job"])
+	require.Nil(hub.ResumeJob("job"))
+	require.False(second.jobs["job"])
+
+	_, err = hub.RunJob("foo")
+	require.Equal(ErrJobNotFound, err)
+	require.Equal(ErrJobNotFound, hub.PauseJob("foo"))
+	_, err = hub.JobHistory("foo")
+	require.Equal(ErrJobNotFound, err)
+}
+
+func TestHubBroadcast(t *testing.T) {
+	require := require.New(t)
+	hub := NewHub()
+	first, second := newClientMock(), newClientMock()
+	first.report = BroadcastReport{
+		Start:      epoch.Add(time.Second),
+		End:        epoch.Add(3 * time.Second),
+		Deliveries: []Delivery{{BotID: "a", ChannelID: "1", MessageID: "x"}},
+	}
+	second.report = BroadcastReport{
+		Start:      epoch,
+		End:        epoch.Add(2 * time.Second),
+		Deliveries: []Delivery{{BotID: "b", ChannelID: "2", Error: "fail"}},
+	}
+	require.Nil(hub.AddClient("first", first))
+	require.Nil(hub.AddClient("second", second))
+
+	report, err := hub.Broadcast(NewOutgoingMessage("hi"), All(), BroadcastOptions{})
+	require.Equal(ErrSomeMessagesLost, err)
+	require.Equal(uint64(2), report.Bots())
+	require.Equal(uint64(2), report.Conversations())
+	require.Equal(uint64(1), report.Errors())
+	require.Equal(epoch, report.Start)
+	require.Equal(epoch.Add(3*time.Second), report.End)
+}
+
+func TestHubRunAndStop(t *testing.T) {
+	require := require.New(t)
+	hub := NewHub()
+	first, second := newClientMock(), newClientMock()
+	require.Nil(hub.AddClient("first", first))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- hub.Run()
+	}()
+
+	for !hub.isRunning() {
+		time.Sleep(time.Millisecond)
+	}
+
+	require.Nil(hub.AddClient("second", second))
+	require.Nil(hub.Stop())
+
+	select {
+	case err := <-done:
+		require.Nil(err)
+	case <-time.After(time.Second):
+		require.FailNow("hub did not stop")
+	}
+
+	require.Equal(1, first.stopCount())
+	require.Equal(1, second.stopCount())
+}
+
+func (h *Hub) isRunning() bool {
+	h.RLock()
+	defer h.RUnlock()
+	return h.running
+}
+
+func TestHubRunFails(t *testing.T) {
+	require := require.New(t)
+	hub := NewHub()
+	ok, failing := newClientMock(), newClientMock()
+	failing.runErr = errors.New("fail")
+	require.Nil(hub.AddClient("ok", ok))
+	require.Nil(hub.AddClient("failing", failing))
+
+	require.Equal(failing.runErr, hub.Run())
+	require.Equal(1, ok.stopCount())
+	require.Equal(0, failing.stopCount())
+}
@@ -0,0 +1,101 @@
+// Package irc provides a flamingo.Client for IRC bots.
+//
+// Every bot is a connection to the server whose nick is the ID of the bot
+// and, if its token is not empty, authenticates with SASL PLAIN using its
+// nick as account and its token as password. Bots join the configured
+// channels, the channels of their stored conversations and the channels they
+// are invited to; each of them is a conversation, and so are the queries
+// with other users, which are direct messages.
+//
+// Forms are rendered as plain text and their buttons as numbered options.
+// When a user replies with the number of an option of the last form posted
+// in the channel, the reply is delivered as an action to the ActionHandler
+// registered with the ID of the button group instead of as a message.
+//
+// Messages are sent obeying the flood control of the servers, and long
+// messages are split in several lines.
+package irc
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+const (
+	// DefaultFloodBurst is the default number of lines that can be sent at
+	// once before the flood control delays them.
+	DefaultFloodBurst = 4
+	// DefaultFloodDelay is the default time between lines once the burst
+	// is exhausted.
+	DefaultFloodDelay = 2 * time.Second
+)
+
+// ClientOptions are the configurable options of the IRC client.
+type ClientOptions struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+	// Server is the address of the server, such as irc.libera.chat:6697.
+	Server string
+	// TLS will connect to the server using TLS if true.
+	TLS bool
+	// TLSConfig is the configuration of the TLS connection. If nil, the
+	// default configuration is used.
+	TLSConfig *tls.Config
+	// Channels are the channels all the bots join.
+	Channels []string
+	// RealName is the real name of the bots. If empty, "flamingo" is used.
+	RealName string
+	// FloodBurst is the number of lines that can be sent at once. If zero,
+	// DefaultFloodBurst is used.
+	FloodBurst int
+	// FloodDelay is the time between lines once the burst is exhausted. If
+	// zero, DefaultFloodDelay is used.
+	FloodDelay time.Duration
+}
+
+type ircPlatform struct {
+	options ClientOptions
+}
+
+func (p *ircPlatform) Type() flamingo.ClientType {
+	return flamingo.IRCClient
+}
+
+func (p *ircPlatform) Connect(bot flamingo.StoredBot, events platform.Events) (platform.Connection, error) {
+	if bot.ID == "" || isChannel(bot.ID) {
+		return nil, errors.New("irc: invalid nick " + bot.ID)
+	}
+
+	return newConnection(bot, events, p.options), nil
+}
+
+// NewClient creates a new IRC Client with the given options.
+func NewClient(options ClientOptions) flamingo.Client {
+	if options.Clock == nil {
+		options.Clock = flamingo.NewClock()
+	}
+
+	if options.RealName == "" {
+		options.RealName = "flamingo"
+	}
+
+	if options.FloodBurst <= 0 {
+		options.FloodBurst = DefaultFloodBurst
+	}
+
+	if options.FloodDelay <= 0 {
+		options.FloodDelay = DefaultFloodDelay
+	}
+
+	return platform.NewClient(&ircPlatform{options}, platform.Options{
+		Debug: options.Debug,
+		Clock: options.Clock,
+	})
+}
@@ -0,0 +1,366 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+// fakeServer is an in-process IRC server that accepts a single client at a
+// time and records all the lines it receives.
+type fakeServer struct {
+	sync.Mutex
+	listener net.Listener
+	password string
+	taken    map[string]bool
+	conn     net.Conn
+	nick     string
+	received []string
+}
+
+func newFakeServer(t *testing.T, password string, tlsConfig *tls.Config) *fakeServer {
+	var (
+		l   net.Listener
+		err error
+	)
+
+	if tlsConfig != nil {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.Nil(t, err)
+
+	s := &fakeServer{
+		listener: l,
+		password: password,
+		taken:    make(map[string]bool),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.Lock()
+		s.conn = conn
+		s.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// like real servers, the client is only welcomed once it has a valid
+	// nick, has sent USER and has finished the capability negotiation
+	var user, negotiating, welcomed bool
+	welcome := func() {
+		s.Lock()
+		nick := s.nick
+		s.Unlock()
+
+		if !welcomed && user && !negotiating && nick != "" {
+			welcomed = true
+			s.send(":server 001 " + nick + " :Welcome to the fake network")
+		}
+	}
+
+	for {
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		l := parseLine(raw)
+		s.Lock()
+		s.received = append(s.received, strings.TrimRight(raw, "\r\n"))
+		nick := s.nick
+		s.Unlock()
+
+		switch l.Command {
+		case "CAP":
+			if l.param(0) == "REQ" {
+				negotiating = true
+				s.send("CAP * ACK :sasl")
+			} else if l.param(0) == "END" {
+				negotiating = false
+				welcome()
+			}
+		case "AUTHENTICATE":
+			if l.param(0) == "PLAIN" {
+				s.send("AUTHENTICATE +")
+				continue
+			}
+
+			creds, _ := base64.StdEncoding.DecodeString(l.param(0))
+			if string(creds) == "flamingo\x00flamingo\x00"+s.password {
+				s.send(":server 903 flamingo :SASL authentication successful")
+			} else {
+				s.send(":server 904 flamingo :SASL authentication failed")
+			}
+		case "NICK":
+			s.Lock()
+			taken := s.taken[l.param(0)]
+			if !taken {
+				s.nick = l.param(0)
+			}
+			s.Unlock()
+
+			if taken {
+				s.send(":server 433 * " + l.param(0) + " :Nickname is already in use")
+			} else {
+				welcome()
+			}
+		case "USER":
+			user = true
+			welcome()
+		case "JOIN":
+			for _, ch := range strings.Split(l.param(0), ",") {
+				s.send(":" + nick + "!bot@host JOIN " + ch)
+			}
+		case "PART":
+			s.send(":" + nick + "!bot@host PART " + l.param(0))
+		case "QUIT":
+			return
+		}
+	}
+}
+
+func (s *fakeServer) send(l string) {
+	s.Lock()
+	conn := s.conn
+	s.Unlock()
+	if conn != nil {
+		conn.Write([]byte(l + "\r\n"))
+	}
+}
+
+// linesFor returns the lines received with the given command.
+func (s *fakeServer) linesFor(command string) []string {
+	s.Lock()
+	defer s.Unlock()
+	var lines []string
+	for _, l := range s.received {
+		if strings.HasPrefix(l, command+" ") {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func (s *fakeServer) waitFor(t *testing.T, command string, n int) []string {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		lines := s.linesFor(command)
+		if len(lines) >= n {
+			return lines
+		}
+
+		if time.Now().After(deadline) {
+			require.FailNow(t, "expected line was not received", "command %s, got %v", command, lines)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (s *fakeServer) close() {
+	s.listener.Close()
+	s.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.Unlock()
+}
+
+type echoController struct{}
+
+func (echoController) CanHandle(flamingo.Message) bool { return true }
+
+func (echoController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	_, err := bot.Reply(msg, flamingo.NewOutgoingMessage("echo: "+msg.Text))
+	return err
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
+
+func newTestClient(s *fakeServer, options ClientOptions) flamingo.Client {
+	options.Server = s.addr()
+	options.FloodDelay = time.Millisecond
+	return NewClient(options)
+}
+
+func TestMessages(t *testing.T) {
+	require := require.New(t)
+	s := newFakeServer(t, "", nil)
+	defer s.close()
+
+	cli := newTestClient(s, ClientOptions{Channels: []string{"#Flamingo"}})
+	cli.AddController(echoController{})
+	cli.AddBot("flamingo", "", nil)
+
+	s.waitFor(t, "JOIN", 1)
+	require.Equal("USER flamingo 0 * :flamingo", s.linesFor("USER")[0])
+
+	s.send(":flamingo!bot@host PRIVMSG #flamingo :self")
+	s.send(":jane!j@host PRIVMSG #Flamingo :\x01VERSION\x01")
+	s.send(":jane!j@host PRIVMSG #Flamingo :hello")
+	s.send(":john!j@host PRIVMSG flamingo :psst")
+	s.send("PING :server")
+
+	lines := s.waitFor(t, "PRIVMSG", 2)
+	require.Equal("PONG :server", s.waitFor(t, "PONG", 1)[0])
+	require.Contains(lines, "PRIVMSG #flamingo :jane: echo: hello")
+	require.Contains(lines, "PRIVMSG john :echo: psst")
+
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(2, len(s.linesFor("PRIVMSG")))
+	require.Nil(cli.Stop())
+}
+
+func TestNickInUse(t *testing.T) {
+	require := require.New(t)
+	s := newFakeServer(t, "", nil)
+	defer s.close()
+	s.Lock()
+	s.taken["flamingo"] = true
+	s.Unlock()
+
+	cli := newTestClient(s, ClientOptions{})
+	cli.AddController(echoController{})
+	cli.AddBot("flamingo", "", nil)
+
+	require.Equal([]string{"NICK flamingo", "NICK flamingo_"}, s.waitFor(t, "NICK", 2))
+
+	s.send(":flamingo_!bot@host PRIVMSG #c :self")
+	s.send(":jane!j@host PRIVMSG flamingo_ :hi")
+	require.Equal("PRIVMSG jane :echo: hi", s.waitFor(t, "PRIVMSG", 1)[0])
+	require.Nil(cli.Stop())
+}
+
+func TestIntroAndOptions(t *testing.T) {
+	require := require.New(t)
+	s := newFakeServer(t, "", nil)
+	defer s.close()
+
+	cli := newTestClient(s, ClientOptions{})
+	intros := make(chan flamingo.Channel, 1)
+	actions := make(chan flamingo.Action, 1)
+	cli.SetIntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		intros <- ch
+		_, err := b.Form(flamingo.Form{
+			Title: "Deploy?",
+			Fields: []flamingo.FieldGroup{
+				flamingo.NewButtonGroup("deploy",
+					flamingo.NewButton("Yes", "yes"),
+					flamingo.NewButton("No", "no"),
+				),
+			},
+		})
+		return err
+	}))
+	cli.AddActionHandler("deploy", func(b flamingo.Bot, a flamingo.Action) {
+		actions <- a
+	})
+	cli.AddBot("flamingo", "", nil)
+
+	s.waitFor(t, "USER", 1)
+	time.Sleep(20 * time.Millisecond)
+	s.send(":jane!j@host INVITE flamingo #Ops")
+
+	select {
+	case ch := <-intros:
+		require.Equal("#ops", ch.ID)
+		require.Equal("#Ops", ch.Name)
+		require.False(ch.IsDM)
+		require.Equal(flamingo.IRCClient, ch.Type)
+	case <-time.After(2 * time.Second):
+		require.FailNow("intro not handled")
+	}
+
+	require.Equal([]string{
+		"PRIVMSG #ops :\x02Deploy?\x02",
+		"PRIVMSG #ops :[1] Yes  [2] No",
+		"PRIVMSG #ops :" + optionsHint,
+	}, s.waitFor(t, "PRIVMSG", 3))
+
+	s.send(":jane!j@host PRIVMSG #Ops :3")
+	s.send(":jane!j@host PRIVMSG #Ops : 2 ")
+
+	select {
+	case a := <-actions:
+		require.Equal(flamingo.UserAction{Name: "no", Value: "no"}, a.UserAction)
+		require.Equal("jane", a.User.Username)
+		require.Equal("#ops", a.Channel.ID)
+		require.Equal("1", a.OriginalMessage.ID)
+	case <-time.After(2 * time.Second):
+		require.FailNow("action not received")
+	}
+
+	require.Nil(cli.Stop())
+}
+
+func TestSASLOverTLS(t *testing.T) {
+	require := require.New(t)
+
+	// borrow the certificate of a TLS test server for the fake IRC server
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	cert := srv.TLS.Certificates
+	srv.Close()
+
+	s := newFakeServer(t, "hunter2", &tls.Config{Certificates: cert})
+	defer s.close()
+
+	cli := newTestClient(s, ClientOptions{
+		TLS:       true,
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Channels:  []string{"#flamingo"},
+	})
+	cli.AddBot("flamingo", "hunter2", nil)
+
+	require.Equal("JOIN #flamingo", s.waitFor(t, "JOIN", 1)[0])
+	require.Equal([]string{"CAP REQ :sasl", "CAP END"}, s.linesFor("CAP"))
+	require.Equal(2, len(s.linesFor("AUTHENTICATE")))
+	require.Nil(cli.Stop())
+}
+
+func TestSASLFailed(t *testing.T) {
+	s := newFakeServer(t, "hunter2", nil)
+	defer s.close()
+
+	events := new(eventsMock)
+	conn := newConnection(flamingo.StoredBot{ID: "flamingo", Token: "wrong"}, events, ClientOptions{
+		Server:     s.addr(),
+		Clock:      flamingo.NewClock(),
+		FloodBurst: 1,
+		FloodDelay: time.Millisecond,
+	})
+
+	require.Equal(t, ErrSASLFailed, conn.Run())
+	require.Equal(t, []string{"CAP REQ :sasl"}, s.linesFor("CAP"))
+}
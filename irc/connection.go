@@ -0,0 +1,515 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+var (
+	// ErrNotConnected occurs when a message is sent while the bot is not
+	// connected to the server.
+	ErrNotConnected = errors.New("irc: not connected")
+	// ErrSASLFailed occurs when the server rejects the SASL authentication
+	// of the bot or does not support it.
+	ErrSASLFailed = errors.New("irc: SASL authentication failed")
+)
+
+const (
+	// readTimeout is the time after which the connection is considered dead
+	// if nothing, not even a PING, is received from the server.
+	readTimeout = 5 * time.Minute
+	// writeTimeout is the maximum time to write a line.
+	writeTimeout = 10 * time.Second
+	// dialTimeout is the maximum time to establish the connection.
+	dialTimeout = 30 * time.Second
+)
+
+// pendingForm is the last form with options posted in a channel, whose
+// options can be chosen by replying with their number.
+type pendingForm struct {
+	msg     flamingo.Message
+	options []option
+}
+
+type connection struct {
+	id       string
+	password string
+	events   platform.Events
+	options  ClientOptions
+	limiter  *limiter
+
+	mut        sync.RWMutex
+	conn       net.Conn
+	nick       string
+	registered bool
+	wanted     map[string]string
+	joined     map[string]bool
+	forms      map[string]*pendingForm
+	lastID     int64
+
+	writeMut sync.Mutex
+	sendMut  sync.Mutex
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newConnection(bot flamingo.StoredBot, events platform.Events, options ClientOptions) *connection {
+	c := &connection{
+		id:       bot.ID,
+		password: bot.Token,
+		events:   events,
+		options:  options,
+		limiter:  newLimiter(options.Clock, options.FloodBurst, options.FloodDelay),
+		nick:     bot.ID,
+		wanted:   make(map[string]string),
+		joined:   make(map[string]bool),
+		forms:    make(map[string]*pendingForm),
+		closed:   make(chan struct{}),
+	}
+
+	for _, ch := range options.Channels {
+		c.wanted[normalize(ch)] = ch
+	}
+
+	return c
+}
+
+func (c *connection) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if c.options.TLS {
+		return tls.DialWithDialer(dialer, "tcp", c.options.Server, c.options.TLSConfig)
+	}
+	return dialer.Dial("tcp", c.options.Server)
+}
+
+// Run connects to the server, registers the bot and handles the lines
+// received until the connection is closed or lost.
+func (c *connection) Run() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	c.mut.Lock()
+	select {
+	case <-c.closed:
+		c.mut.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+
+	c.conn = conn
+	c.nick = c.id
+	c.registered = false
+	c.joined = make(map[string]bool)
+	c.mut.Unlock()
+
+	defer func() {
+		c.mut.Lock()
+		c.conn = nil
+		c.registered = false
+		c.mut.Unlock()
+		conn.Close()
+	}()
+
+	if c.password != "" {
+		if err := c.write("CAP REQ :sasl"); err != nil {
+			return err
+		}
+	}
+
+	if err := c.write("NICK " + c.id); err != nil {
+		return err
+	}
+
+	if err := c.write("USER " + c.id + " 0 * :" + c.options.RealName); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			select {
+			case <-c.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		log15.Debug("irc line received", "bot", c.id, "line", strings.TrimSpace(raw))
+		if err := c.handleLine(parseLine(raw)); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *connection) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+
+		c.mut.RLock()
+		conn := c.conn
+		c.mut.RUnlock()
+
+		if conn != nil {
+			c.write("QUIT :bye")
+			conn.Close()
+		}
+	})
+	return nil
+}
+
+// write sends a raw line to the server.
+func (c *connection) write(l string) error {
+	c.mut.RLock()
+	conn := c.conn
+	c.mut.RUnlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeMut.Lock()
+	defer c.writeMut.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := conn.Write([]byte(l + "\r\n"))
+	return err
+}
+
+func (c *connection) handleLine(l line) error {
+	switch l.Command {
+	case "PING":
+		return c.write("PONG :" + l.param(0))
+
+	case "CAP":
+		switch strings.ToUpper(l.param(1)) {
+		case "ACK":
+			return c.write("AUTHENTICATE PLAIN")
+		case "NAK":
+			return ErrSASLFailed
+		}
+
+	case "AUTHENTICATE":
+		if l.param(0) == "+" {
+			creds := c.id + "\x00" + c.id + "\x00" + c.password
+			return c.write("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(creds)))
+		}
+
+	case "903":
+		return c.write("CAP END")
+
+	case "902", "904", "905", "906":
+		return ErrSASLFailed
+
+	case "001":
+		c.mut.Lock()
+		c.registered = true
+		c.nick = l.param(0)
+		var channels []string
+		for _, ch := range c.wanted {
+			channels = append(channels, ch)
+		}
+		c.mut.Unlock()
+
+		log15.Info("irc bot registered", "bot", c.id, "nick", l.param(0))
+		for _, ch := range channels {
+			if err := c.write("JOIN " + ch); err != nil {
+				return err
+			}
+		}
+
+	case "433":
+		if !c.isRegistered() {
+			c.mut.Lock()
+			c.nick += "_"
+			nick := c.nick
+			c.mut.Unlock()
+			return c.write("NICK " + nick)
+		}
+
+	case "NICK":
+		if c.isSelf(l.nick()) {
+			c.mut.Lock()
+			c.nick = l.param(0)
+			c.mut.Unlock()
+		}
+
+	case "JOIN":
+		if c.isSelf(l.nick()) {
+			ch := l.param(0)
+			c.mut.Lock()
+			c.wanted[normalize(ch)] = ch
+			c.joined[normalize(ch)] = true
+			c.mut.Unlock()
+			c.events.Joined(channelFor(ch))
+		}
+
+	case "PART":
+		if c.isSelf(l.nick()) {
+			c.left(l.param(0))
+		}
+
+	case "KICK":
+		if c.isSelf(l.param(1)) {
+			c.left(l.param(0))
+		}
+
+	case "INVITE":
+		log15.Info("irc bot invited to channel", "bot", c.id, "channel", l.param(1), "by", l.nick())
+		return c.write("JOIN " + l.param(1))
+
+	case "PRIVMSG":
+		c.handlePrivmsg(l)
+
+	case "ERROR":
+		return errors.New("irc: " + l.param(0))
+	}
+
+	return nil
+}
+
+func (c *connection) left(ch string) {
+	id := normalize(ch)
+	c.mut.Lock()
+	delete(c.wanted, id)
+	delete(c.joined, id)
+	delete(c.forms, id)
+	c.mut.Unlock()
+	c.events.Left(id)
+}
+
+func (c *connection) handlePrivmsg(l line) {
+	sender := l.nick()
+	if c.isSelf(sender) {
+		log15.Debug("got message from self, ignoring")
+		return
+	}
+
+	text := l.param(1)
+	// CTCP requests, such as VERSION or ACTION, are not messages for the bot
+	if text == "" || strings.HasPrefix(text, "\x01") {
+		return
+	}
+
+	target := l.param(0)
+	if !isChannel(target) {
+		target = sender
+	}
+
+	ch := channelFor(target)
+	user := flamingo.User{
+		ID:       normalize(sender),
+		Username: sender,
+		Name:     sender,
+		Type:     flamingo.IRCClient,
+		Extra:    l.Prefix,
+	}
+
+	c.mut.RLock()
+	form := c.forms[ch.ID]
+	c.mut.RUnlock()
+
+	if form != nil {
+		if opt, ok := chooseOption(form.options, text); ok {
+			c.events.Action(platform.ActionEvent{
+				ID: opt.group,
+				Action: flamingo.Action{
+					UserAction: flamingo.UserAction{
+						Name:  opt.button.Name,
+						Value: opt.button.Value,
+					},
+					User:            user,
+					Channel:         ch,
+					OriginalMessage: form.msg,
+					Extra:           l,
+				},
+			})
+			return
+		}
+	}
+
+	c.events.Message(flamingo.Message{
+		ID:      c.nextID(),
+		Type:    flamingo.IRCClient,
+		User:    user,
+		Channel: ch,
+		Time:    c.options.Clock.Now(),
+		Text:    text,
+		Extra:   l,
+	})
+}
+
+func (c *connection) isSelf(nick string) bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return normalize(nick) == normalize(c.nick)
+}
+
+func (c *connection) isRegistered() bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.registered
+}
+
+// nextID returns a new ID for a message. IRC messages have no IDs, so they
+// are only unique for the connection.
+func (c *connection) nextID() string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.lastID++
+	return strconv.FormatInt(c.lastID, 10)
+}
+
+func channelFor(target string) flamingo.Channel {
+	return flamingo.Channel{
+		ID:    normalize(target),
+		Name:  target,
+		IsDM:  !isChannel(target),
+		Type:  flamingo.IRCClient,
+		Extra: target,
+	}
+}
+
+// Channel returns the channel or query with the given ID. Channels are
+// joined if the bot is not in them, so the channels of the conversations
+// loaded from the storage are joined again.
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	if isChannel(id) {
+		c.mut.Lock()
+		c.wanted[normalize(id)] = id
+		join := c.registered && !c.joined[normalize(id)]
+		c.mut.Unlock()
+
+		if join {
+			if err := c.write("JOIN " + id); err != nil {
+				return flamingo.Channel{}, err
+			}
+		}
+	}
+
+	return channelFor(id), nil
+}
+
+// send sends the text to the target, one line at a time and obeying the
+// flood control.
+func (c *connection) send(target, text string) error {
+	c.sendMut.Lock()
+	defer c.sendMut.Unlock()
+
+	for _, l := range splitText(text, maxLineLength-len(target)) {
+		if !c.limiter.wait(c.closed) {
+			return ErrNotConnected
+		}
+
+		if err := c.write("PRIVMSG " + target + " :" + l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	if err := c.send(channel, msg.Text); err != nil {
+		return "", err
+	}
+	return c.nextID(), nil
+}
+
+// ReplyMessage addresses the reply to the user with the usual "nick: text"
+// in channels.
+func (c *connection) ReplyMessage(channel string, replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error) {
+	if isChannel(channel) && replyTo.User.Username != "" {
+		msg.Text = replyTo.User.Username + ": " + msg.Text
+	}
+	return c.PostMessage(channel, msg)
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	return c.postForm(channel, "", form)
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	return c.PostMessage(channel, flamingo.NewOutgoingMessage(imageToText(img)))
+}
+
+// UpdateMessage posts the text again, because IRC messages can not be
+// edited. If the message was a form, its options can not be chosen anymore.
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	newID, err := c.PostMessage(channel, flamingo.NewOutgoingMessage(text))
+	if err != nil {
+		return "", err
+	}
+
+	c.replaceForm(channel, id, nil)
+	return newID, nil
+}
+
+// UpdateForm posts the form again, because IRC messages can not be edited.
+// The options of the new form replace the ones of the old one.
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	return c.postForm(channel, id, form)
+}
+
+func (c *connection) postForm(channel, replaces string, form flamingo.Form) (string, error) {
+	text, options := formToText(form)
+	if err := c.send(channel, text); err != nil {
+		return "", err
+	}
+
+	msg := flamingo.Message{
+		ID:      c.nextID(),
+		Type:    flamingo.IRCClient,
+		User:    flamingo.User{ID: normalize(c.id), Username: c.id, Name: c.id, IsBot: true, Type: flamingo.IRCClient},
+		Channel: channelFor(channel),
+		Time:    c.options.Clock.Now(),
+		Text:    text,
+	}
+
+	var pending *pendingForm
+	if len(options) > 0 {
+		pending = &pendingForm{msg, options}
+	}
+
+	c.replaceForm(channel, replaces, pending)
+	return msg.ID, nil
+}
+
+// replaceForm sets the form pending in the channel. If the form replaces a
+// message, it is only set if that message was the pending form, which is
+// removed if the new one has no options.
+func (c *connection) replaceForm(channel, replaces string, form *pendingForm) {
+	id := normalize(channel)
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	current := c.forms[id]
+	replacesCurrent := current != nil && replaces != "" && current.msg.ID == replaces
+	switch {
+	case form != nil:
+		c.forms[id] = form
+	case replacesCurrent:
+		delete(c.forms, id)
+	}
+}
+
+// DirectChannel returns the ID of the query with the user, which is the nick
+// of the user itself.
+func (c *connection) DirectChannel(user string) (string, error) {
+	return normalize(strings.TrimPrefix(user, "@")), nil
+}
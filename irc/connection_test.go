@@ -0,0 +1,147 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+type eventsMock struct {
+	messages []flamingo.Message
+	actions  []platform.ActionEvent
+	joined   []flamingo.Channel
+	left     []string
+}
+
+func (e *eventsMock) Message(m flamingo.Message)    { e.messages = append(e.messages, m) }
+func (e *eventsMock) Action(a platform.ActionEvent) { e.actions = append(e.actions, a) }
+func (e *eventsMock) Joined(ch flamingo.Channel)    { e.joined = append(e.joined, ch) }
+func (e *eventsMock) Left(ch string)                { e.left = append(e.left, ch) }
+
+func newTestConnection(events platform.Events) *connection {
+	return newConnection(flamingo.StoredBot{ID: "flamingo"}, events, ClientOptions{
+		Clock:      flamingo.NewFakeClock(time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)),
+		Channels:   []string{"#Flamingo"},
+		FloodBurst: 1,
+		FloodDelay: time.Second,
+	})
+}
+
+func TestHandleLine(t *testing.T) {
+	require := require.New(t)
+	events := new(eventsMock)
+	conn := newTestConnection(events)
+
+	lines := []string{
+		":flamingo!b@h JOIN #Flamingo",
+		":flamingo!b@h JOIN :#ops",
+		":jane!j@h JOIN #ops",
+		":jane!j@h PRIVMSG #OPS :hi all",
+		":flamingo!b@h NICK :bird",
+		":jane!j@h PRIVMSG bird :psst",
+		":jane!j@h PRIVMSG flamingo :old nick",
+		":jane!j@h KICK #ops bird :bye",
+		":jane!j@h KICK #flamingo john :bye",
+		":bird!b@h PART #Flamingo",
+		":jane!j@h NOTICE bird :notice",
+	}
+
+	for _, l := range lines {
+		require.Nil(conn.handleLine(parseLine(l)))
+	}
+	require.NotNil(conn.handleLine(parseLine("ERROR :Closing link")))
+
+	require.Equal(2, len(events.joined))
+	require.Equal("#flamingo", events.joined[0].ID)
+	require.Equal("#ops", events.joined[1].ID)
+	require.Equal([]string{"#ops", "#flamingo"}, events.left)
+	require.Equal(0, len(conn.wanted))
+
+	require.Equal(3, len(events.messages))
+	msg := events.messages[0]
+	require.Equal("hi all", msg.Text)
+	require.Equal("#ops", msg.Channel.ID)
+	require.Equal("jane", msg.User.ID)
+	require.Equal(flamingo.IRCClient, msg.Type)
+	require.Equal(2016, msg.Time.Year())
+
+	dm := events.messages[1]
+	require.Equal("jane", dm.Channel.ID)
+	require.True(dm.Channel.IsDM)
+	require.NotEqual(msg.ID, dm.ID)
+	require.True(events.messages[2].Channel.IsDM)
+}
+
+func TestReplaceForm(t *testing.T) {
+	require := require.New(t)
+	conn := newTestConnection(new(eventsMock))
+	form := &pendingForm{flamingo.Message{ID: "1"}, []option{{group: "g"}}}
+
+	conn.replaceForm("#A", "", form)
+	require.Equal(form, conn.forms["#a"])
+
+	conn.replaceForm("#a", "2", nil)
+	require.Equal(form, conn.forms["#a"])
+
+	conn.replaceForm("#a", "1", nil)
+	require.Nil(conn.forms["#a"])
+}
+
+func TestNotConnected(t *testing.T) {
+	conn := newTestConnection(new(eventsMock))
+	_, err := conn.PostMessage("#flamingo", flamingo.NewOutgoingMessage("hi"))
+	require.Equal(t, ErrNotConnected, err)
+
+	id, err := conn.DirectChannel("@Jane")
+	require.Nil(t, err)
+	require.Equal(t, "jane", id)
+
+	ch, err := conn.Channel("#New")
+	require.Nil(t, err)
+	require.Equal(t, "#new", ch.ID)
+	require.Equal(t, "#New", conn.wanted["#new"])
+}
+
+func TestLimiter(t *testing.T) {
+	require := require.New(t)
+	clock := flamingo.NewFakeClock(time.Now())
+	l := newLimiter(clock, 2, time.Second)
+	cancel := make(chan struct{})
+
+	require.True(l.wait(cancel))
+	require.True(l.wait(cancel))
+
+	done := make(chan bool)
+	go func() {
+		done <- l.wait(cancel)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for clock.Timers() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case <-done:
+		require.FailNow("line sent before flood delay")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	require.True(<-done)
+
+	// after a while, the whole burst is available again
+	clock.Advance(10 * time.Second)
+	require.True(l.wait(cancel))
+	require.True(l.wait(cancel))
+
+	go func() {
+		done <- l.wait(cancel)
+	}()
+	close(cancel)
+	require.False(<-done)
+}
@@ -0,0 +1,52 @@
+package irc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/src-d/flamingo"
+)
+
+// limiter implements the flood control most IRC servers apply to their
+// clients: every line sent moves a timer forward by delay, and lines are not
+// sent while the timer is more than burst lines ahead of the current time.
+type limiter struct {
+	mut   sync.Mutex
+	clock flamingo.Clock
+	burst int
+	delay time.Duration
+	timer time.Time
+}
+
+func newLimiter(clock flamingo.Clock, burst int, delay time.Duration) *limiter {
+	return &limiter{
+		clock: clock,
+		burst: burst,
+		delay: delay,
+	}
+}
+
+// wait blocks until a new line can be sent or the given channel is closed.
+// It returns false in the latter case.
+func (l *limiter) wait(cancel <-chan struct{}) bool {
+	l.mut.Lock()
+	now := l.clock.Now()
+	if l.timer.Before(now) {
+		l.timer = now
+	}
+
+	l.timer = l.timer.Add(l.delay)
+	wait := l.timer.Sub(now) - time.Duration(l.burst)*l.delay
+	l.mut.Unlock()
+
+	if wait <= 0 {
+		return true
+	}
+
+	select {
+	case <-l.clock.After(wait):
+		return true
+	case <-cancel:
+		return false
+	}
+}
@@ -0,0 +1,93 @@
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/src-d/flamingo"
+)
+
+const bold = "\x02"
+
+// optionsHint is appended to the forms with buttons to tell the users how to
+// choose one.
+const optionsHint = "(reply with the number of your choice)"
+
+// option is a button of a form, which users choose by replying with its
+// number.
+type option struct {
+	group  string
+	button flamingo.Button
+}
+
+// formToText renders the form as plain text. Buttons are rendered as
+// numbered options, which are returned in order so the number n refers to
+// the option at position n-1.
+func formToText(form flamingo.Form) (string, []option) {
+	var (
+		lines   []string
+		options []option
+	)
+
+	if form.AuthorName != "" {
+		lines = append(lines, form.AuthorName)
+	}
+
+	if form.Title != "" {
+		lines = append(lines, bold+form.Title+bold)
+	}
+
+	if form.Text != "" {
+		lines = append(lines, form.Text)
+	}
+
+	for _, g := range form.Fields {
+		var choices []string
+		for _, i := range g.Items() {
+			switch f := i.(type) {
+			case flamingo.Button:
+				options = append(options, option{g.ID(), f})
+				choices = append(choices, fmt.Sprintf("[%d] %s", len(options), f.Text))
+			case flamingo.TextField:
+				lines = append(lines, fmt.Sprintf("%s%s:%s %s", bold, f.Title, bold, f.Value))
+			case flamingo.Image:
+				lines = append(lines, imageToText(f))
+			case flamingo.Text:
+				lines = append(lines, string(f))
+			}
+		}
+
+		if len(choices) > 0 {
+			lines = append(lines, strings.Join(choices, "  "))
+		}
+	}
+
+	if len(options) > 0 {
+		lines = append(lines, optionsHint)
+	}
+
+	if form.Footer != "" {
+		lines = append(lines, form.Footer)
+	}
+
+	return strings.Join(lines, "\n"), options
+}
+
+func imageToText(img flamingo.Image) string {
+	if img.Text == "" {
+		return img.URL
+	}
+	return img.Text + ": " + img.URL
+}
+
+// chooseOption returns the option chosen with the given reply, if the reply
+// is the number of one of them.
+func chooseOption(options []option, reply string) (option, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(reply))
+	if err != nil || n < 1 || n > len(options) {
+		return option{}, false
+	}
+
+	return options[n-1], true
+}
@@ -0,0 +1,69 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+func TestFormToText(t *testing.T) {
+	require := require.New(t)
+	text, options := formToText(flamingo.Form{
+		AuthorName: "flamingo",
+		Title:      "Deploy",
+		Text:       "Choose wisely",
+		Footer:     "footer",
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewTextFieldGroup(flamingo.NewTextField("Env", "prod")),
+			flamingo.Image{URL: "http://img", Text: "graph"},
+			flamingo.Image{URL: "http://img2"},
+			flamingo.Text("some text"),
+			flamingo.NewButtonGroup("deploy",
+				flamingo.NewButton("Yes", "yes"),
+				flamingo.NewButton("No", "no"),
+			),
+			flamingo.NewButtonGroup("cancel",
+				flamingo.NewButton("Cancel", "cancel"),
+			),
+		},
+	})
+
+	require.Equal(`flamingo
+`+bold+`Deploy`+bold+`
+Choose wisely
+`+bold+`Env:`+bold+` prod
+graph: http://img
+http://img2
+some text
+[1] Yes  [2] No
+[3] Cancel
+`+optionsHint+`
+footer`, text)
+
+	require.Equal(3, len(options))
+	require.Equal("deploy", options[0].group)
+	require.Equal("no", options[1].button.Value)
+	require.Equal("cancel", options[2].group)
+
+	text, options = formToText(flamingo.Form{Title: "Info"})
+	require.Equal(bold+"Info"+bold, text)
+	require.Equal(0, len(options))
+}
+
+func TestChooseOption(t *testing.T) {
+	options := []option{
+		{"g", flamingo.NewButton("Yes", "yes")},
+		{"g", flamingo.NewButton("No", "no")},
+	}
+
+	opt, ok := chooseOption(options, " 2 ")
+	require.True(t, ok)
+	require.Equal(t, "no", opt.button.Value)
+
+	for _, reply := range []string{"0", "3", "yes", "", "1 please"} {
+		_, ok := chooseOption(options, reply)
+		require.False(t, ok, reply)
+	}
+}
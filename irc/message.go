@@ -0,0 +1,123 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength is the maximum length in bytes of the text of a message sent
+// in a single line. IRC lines are limited to 512 bytes including the command,
+// the target and the prefix the server adds when relaying them, so this
+// leaves enough room for those.
+const maxLineLength = 400
+
+// line is a single IRC protocol message.
+type line struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// parseLine parses a line received from the server, without the trailing
+// CRLF.
+func parseLine(raw string) line {
+	var l line
+	raw = strings.TrimRight(raw, "\r\n")
+
+	// message tags are not requested, but are skipped just in case
+	if strings.HasPrefix(raw, "@") {
+		if idx := strings.Index(raw, " "); idx >= 0 {
+			raw = strings.TrimLeft(raw[idx+1:], " ")
+		} else {
+			return l
+		}
+	}
+
+	if strings.HasPrefix(raw, ":") {
+		idx := strings.Index(raw, " ")
+		if idx < 0 {
+			l.Prefix = raw[1:]
+			return l
+		}
+		l.Prefix = raw[1:idx]
+		raw = strings.TrimLeft(raw[idx+1:], " ")
+	}
+
+	var trailing string
+	hasTrailing := false
+	if idx := strings.Index(raw, " :"); idx >= 0 {
+		trailing = raw[idx+2:]
+		raw = raw[:idx]
+		hasTrailing = true
+	} else if strings.HasPrefix(raw, ":") {
+		trailing = raw[1:]
+		raw = ""
+		hasTrailing = true
+	}
+
+	fields := strings.Fields(raw)
+	if len(fields) > 0 {
+		l.Command = strings.ToUpper(fields[0])
+		l.Params = fields[1:]
+	}
+
+	if hasTrailing {
+		l.Params = append(l.Params, trailing)
+	}
+
+	return l
+}
+
+// param returns the parameter at the given position or an empty string.
+func (l line) param(i int) string {
+	if i < len(l.Params) {
+		return l.Params[i]
+	}
+	return ""
+}
+
+// nick returns the nick of the sender of the line.
+func (l line) nick() string {
+	if idx := strings.Index(l.Prefix, "!"); idx >= 0 {
+		return l.Prefix[:idx]
+	}
+	return l.Prefix
+}
+
+// isChannel reports whether the target is a channel and not a user.
+func isChannel(target string) bool {
+	return target != "" && strings.ContainsAny(target[:1], "#&+!")
+}
+
+// normalize returns the canonical form of a nick or channel name, which are
+// case insensitive.
+func normalize(name string) string {
+	return strings.ToLower(name)
+}
+
+// splitText splits a text in lines that can be sent to the server, breaking
+// the lines that are too long by the last space or, if there is none, by
+// the last full character that fits.
+func splitText(text string, max int) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.Replace(text, "\r", "", -1), "\n") {
+		for len(l) > max {
+			cut := strings.LastIndex(l[:max+1], " ")
+			if cut <= 0 {
+				cut = max
+				for cut > 0 && !utf8.RuneStart(l[cut]) {
+					cut--
+				}
+			}
+
+			lines = append(lines, l[:cut])
+			l = strings.TrimLeft(l[cut:], " ")
+		}
+
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,51 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		raw      string
+		expected line
+	}{
+		{"PING :irc.example.com\r\n", line{"", "PING", []string{"irc.example.com"}}},
+		{":jane!j@host PRIVMSG #chan :hello: world", line{"jane!j@host", "PRIVMSG", []string{"#chan", "hello: world"}}},
+		{":server 001 bird :Welcome", line{"server", "001", []string{"bird", "Welcome"}}},
+		{"@time=2016 :bird!b@h join #chan", line{"bird!b@h", "JOIN", []string{"#chan"}}},
+		{":jane!j@h PRIVMSG bird :", line{"jane!j@h", "PRIVMSG", []string{"bird", ""}}},
+		{":server", line{Prefix: "server"}},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.expected, parseLine(c.raw), c.raw)
+	}
+
+	l := parseLine(":jane!j@host PART #chan")
+	require.Equal(t, "jane", l.nick())
+	require.Equal(t, "#chan", l.param(0))
+	require.Equal(t, "", l.param(1))
+	require.Equal(t, "server", parseLine(":server NOTICE * :hi").nick())
+}
+
+func TestIsChannel(t *testing.T) {
+	for _, ch := range []string{"#a", "&a", "+a", "!a"} {
+		require.True(t, isChannel(ch), ch)
+	}
+
+	for _, ch := range []string{"", "jane", "a#"} {
+		require.False(t, isChannel(ch), ch)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal([]string{"a", "b"}, splitText("a\r\n\nb\n", 10))
+	require.Equal([]string{"hello big", "world"}, splitText("hello big world", 10))
+	require.Equal([]string{"abcdefghij", "klm"}, splitText("abcdefghijklm", 10))
+	require.Equal([]string{"ñññññ", "ñ"}, splitText(strings.Repeat("ñ", 6), 11))
+}
@@ -0,0 +1,86 @@
+package flamingo
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrJobNotFound occurs when there is no scheduled job with the given name.
+	ErrJobNotFound = errors.New("scheduled job not found")
+	// ErrJobExists occurs when a scheduled job is added with a name that is
+	// already in use.
+	ErrJobExists = errors.New("a scheduled job with the same name already exists")
+)
+
+// JobRun is the record of a single execution of a scheduled job.
+type JobRun struct {
+	// Job is the name of the job.
+	Job string
+	// Manual will be true if the run was triggered by hand instead of by
+	// its schedule.
+	Manual bool
+	// Scheduled is the time the run was due. It is zero for manual runs.
+	Scheduled time.Time
+	// Misfires is the number of times the job should have been run before
+	// this run but could not, because the previous run took too long or the
+	// clock jumped forward. Missed runs are not made up for.
+	Misfires uint64
+	// Start is the time the run started.
+	Start time.Time
+	// End is the time the run finished.
+	End time.Time
+	// Conversations is the number of conversations the job was run on.
+	Conversations uint64
+	// Errors is the number of conversations in which the job returned an error.
+	Errors uint64
+}
+
+// JobHistory is a store for the runs of scheduled jobs.
+type JobHistory interface {
+	// Record saves the given run.
+	Record(JobRun) error
+	// Runs returns all the recorded runs of the job with the given name,
+	// from the oldest to the newest.
+	Runs(job string) ([]JobRun, error)
+}
+
+type memoryJobHistory struct {
+	sync.RWMutex
+	size int
+	runs map[string][]JobRun
+}
+
+// NewJobHistory creates an in-memory JobHistory that keeps, at most, the
+// given number of runs for every job. When the limit is reached the oldest
+// run is discarded.
+func NewJobHistory(size int) JobHistory {
+	if size < 1 {
+		size = 1
+	}
+
+	return &memoryJobHistory{
+		size: size,
+		runs: make(map[string][]JobRun),
+	}
+}
+
+func (h *memoryJobHistory) Record(run JobRun) error {
+	h.Lock()
+	defer h.Unlock()
+	runs := append(h.runs[run.Job], run)
+	if len(runs) > h.size {
+		runs = runs[len(runs)-h.size:]
+	}
+	h.runs[run.Job] = runs
+	return nil
+}
+
+func (h *memoryJobHistory) Runs(job string) ([]JobRun, error) {
+	h.RLock()
+	defer h.RUnlock()
+	var runs = make([]JobRun, len(h.runs[job]))
+	copy(runs, h.runs[job])
+	return runs, nil
+}
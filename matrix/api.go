@@ -0,0 +1,311 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// APIError is an error returned by the Matrix client-server API.
+type APIError struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// Code is the Matrix error code, such as M_FORBIDDEN.
+	Code string
+	// Message is the description of the error.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("matrix: %d %s %s", e.Status, e.Code, e.Message)
+}
+
+// Temporary reports whether the request can be retried later.
+func (e *APIError) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*APIError)
+	return ok && e.Status == http.StatusNotFound
+}
+
+const (
+	eventMessage  = "m.room.message"
+	eventReaction = "m.reaction"
+	eventMember   = "m.room.member"
+	eventName     = "m.room.name"
+	eventDirect   = "m.direct"
+
+	msgText    = "m.text"
+	msgImage   = "m.image"
+	formatHTML = "org.matrix.custom.html"
+
+	relAnnotation = "m.annotation"
+	relReplace    = "m.replace"
+
+	membershipJoin = "join"
+)
+
+type event struct {
+	Type           string          `json:"type"`
+	EventID        string          `json:"event_id"`
+	Sender         string          `json:"sender"`
+	StateKey       *string         `json:"state_key,omitempty"`
+	OriginServerTS int64           `json:"origin_server_ts"`
+	Content        json.RawMessage `json:"content"`
+}
+
+// isStateOf reports whether the event is a state event of the given type
+// with the given state key.
+func (e event) isStateOf(typ, key string) bool {
+	return e.Type == typ && e.StateKey != nil && *e.StateKey == key
+}
+
+type eventList struct {
+	Events []event `json:"events"`
+}
+
+type joinedRoom struct {
+	State    eventList `json:"state"`
+	Timeline eventList `json:"timeline"`
+}
+
+type invitedRoom struct {
+	InviteState eventList `json:"invite_state"`
+}
+
+type leftRoom struct {
+	Timeline eventList `json:"timeline"`
+}
+
+type syncResponse struct {
+	NextBatch   string    `json:"next_batch"`
+	AccountData eventList `json:"account_data"`
+	Rooms       struct {
+		Join   map[string]joinedRoom  `json:"join"`
+		Invite map[string]invitedRoom `json:"invite"`
+		Leave  map[string]leftRoom    `json:"leave"`
+	} `json:"rooms"`
+}
+
+type inReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+type relatesTo struct {
+	RelType   string     `json:"rel_type,omitempty"`
+	EventID   string     `json:"event_id,omitempty"`
+	Key       string     `json:"key,omitempty"`
+	InReplyTo *inReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type messageContent struct {
+	MsgType       string          `json:"msgtype,omitempty"`
+	Body          string          `json:"body,omitempty"`
+	Format        string          `json:"format,omitempty"`
+	FormattedBody string          `json:"formatted_body,omitempty"`
+	URL           string          `json:"url,omitempty"`
+	NewContent    *messageContent `json:"m.new_content,omitempty"`
+	RelatesTo     *relatesTo      `json:"m.relates_to,omitempty"`
+}
+
+type memberContent struct {
+	Membership  string `json:"membership"`
+	DisplayName string `json:"displayname"`
+	IsDirect    bool   `json:"is_direct"`
+}
+
+type nameContent struct {
+	Name string `json:"name"`
+}
+
+// directContent is the content of the m.direct account data, which maps
+// users to the IDs of the direct message rooms with them.
+type directContent map[string][]string
+
+type api struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newAPI(homeserver, token string, client *http.Client) *api {
+	return &api{
+		url:    strings.TrimRight(homeserver, "/") + "/_matrix/client/v3",
+		token:  token,
+		client: client,
+	}
+}
+
+// segment escapes an ID to be used as a segment of a request path.
+func segment(id string) string {
+	return url.QueryEscape(id)
+}
+
+// do performs a request with the given method to the given path, sending
+// body as JSON if it is not nil and decoding the response in result if it is
+// not nil. If cancel is not nil, the request is aborted when it is closed.
+func (a *api) do(method, path string, query url.Values, body, result interface{}, cancel <-chan struct{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	u := a.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Cancel = cancel
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Status: resp.StatusCode, Message: resp.Status}
+		var e struct {
+			Code    string `json:"errcode"`
+			Message string `json:"error"`
+		}
+
+		if json.Unmarshal(data, &e) == nil && e.Code != "" {
+			apiErr.Code = e.Code
+			apiErr.Message = e.Message
+		}
+
+		return apiErr
+	}
+
+	if result != nil && len(data) > 0 {
+		return json.Unmarshal(data, result)
+	}
+
+	return nil
+}
+
+func (a *api) sync(since, filter string, timeout time.Duration, cancel <-chan struct{}) (*syncResponse, error) {
+	query := url.Values{}
+	query.Set("timeout", fmt.Sprint(int64(timeout/time.Millisecond)))
+	if since != "" {
+		query.Set("since", since)
+	}
+	if filter != "" {
+		query.Set("filter", filter)
+	}
+
+	var resp syncResponse
+	if err := a.do("GET", "/sync", query, nil, &resp, cancel); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (a *api) join(room string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+
+	if err := a.do("POST", "/join/"+segment(room), nil, struct{}{}, &resp, nil); err != nil {
+		return "", err
+	}
+	return resp.RoomID, nil
+}
+
+func (a *api) send(room, eventType, txnID string, content interface{}) (string, error) {
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+
+	path := fmt.Sprintf("/rooms/%s/send/%s/%s", segment(room), eventType, segment(txnID))
+	if err := a.do("PUT", path, nil, content, &resp, nil); err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
+
+func (a *api) redact(room, eventID, txnID string) error {
+	path := fmt.Sprintf("/rooms/%s/redact/%s/%s", segment(room), segment(eventID), segment(txnID))
+	return a.do("PUT", path, nil, struct{}{}, nil, nil)
+}
+
+// roomName returns the name of the room, which is empty if the room has
+// none.
+func (a *api) roomName(room string) (string, error) {
+	var content nameContent
+	err := a.do("GET", "/rooms/"+segment(room)+"/state/"+eventName, nil, nil, &content, nil)
+	if err != nil && !isNotFound(err) {
+		return "", err
+	}
+	return content.Name, nil
+}
+
+// displayName returns the display name of the user, which is empty if the
+// user has none.
+func (a *api) displayName(user string) (string, error) {
+	var resp struct {
+		DisplayName string `json:"displayname"`
+	}
+
+	err := a.do("GET", "/profile/"+segment(user)+"/displayname", nil, nil, &resp, nil)
+	if err != nil && !isNotFound(err) {
+		return "", err
+	}
+	return resp.DisplayName, nil
+}
+
+func (a *api) createDirectRoom(user string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+
+	err := a.do("POST", "/createRoom", nil, map[string]interface{}{
+		"preset":    "trusted_private_chat",
+		"is_direct": true,
+		"invite":    []string{user},
+	}, &resp, nil)
+	if err != nil {
+		return "", err
+	}
+	return resp.RoomID, nil
+}
+
+// directRooms returns the m.direct account data of the user.
+func (a *api) directRooms(user string) (directContent, error) {
+	content := make(directContent)
+	err := a.do("GET", "/user/"+segment(user)+"/account_data/"+eventDirect, nil, nil, &content, nil)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	return content, nil
+}
+
+func (a *api) setDirectRooms(user string, content directContent) error {
+	return a.do("PUT", "/user/"+segment(user)+"/account_data/"+eventDirect, nil, content, nil, nil)
+}
@@ -0,0 +1,131 @@
+// Package matrix provides a flamingo.Client for Matrix bots, which receive
+// events by syncing with the homeserver through the client-server API.
+//
+// The ID of every bot is its user ID, such as @flamingo:example.com, and its
+// token is its access token. Bots join the rooms they are invited to, which
+// calls the IntroHandler, and every room they are in is a conversation; the
+// rooms marked as direct are direct messages.
+//
+// Messages are sent as m.text events with an HTML formatted body. Forms are
+// rendered as HTML too, and the bot reacts to them with the text of every
+// button. When a user reacts to a form with one of those, the reaction is
+// delivered as an action to the ActionHandler registered with the ID of the
+// button group.
+//
+// The client uses the Extra of the stored bots to keep the token of their
+// last sync, so the events are not received again when the bots are
+// restarted. The first time a bot is run, the messages sent before are not
+// delivered either.
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// DefaultPollTimeout is the time the homeserver waits for new events before
+// answering a sync request.
+const DefaultPollTimeout = 30 * time.Second
+
+// ClientOptions are the configurable options of the matrix client.
+type ClientOptions struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+	// HomeserverURL is the URL of the homeserver of the bots, such as
+	// https://matrix.example.com.
+	HomeserverURL string
+	// HTTPClient is the client used to perform the requests to the API. If
+	// nil, a client with a timeout long enough for the syncs is used.
+	HTTPClient *http.Client
+	// PollTimeout is the timeout of the sync requests. If zero,
+	// DefaultPollTimeout is used.
+	PollTimeout time.Duration
+}
+
+// BotExtra is the Extra of the bots stored by the client. It is registered
+// with flamingo.RegisterExtra as "matrix.BotExtra".
+type BotExtra struct {
+	// SyncToken is the token of the last sync of the bot, from which the
+	// next one is resumed.
+	SyncToken string
+}
+
+func init() {
+	flamingo.RegisterExtra("matrix.BotExtra", BotExtra{})
+}
+
+// syncToken returns the sync token of the Extra of a stored bot, which is a
+// map if it was stored before BotExtra was registered, or an UnknownExtra if
+// it was stored under a name BotExtra is not registered with.
+func syncToken(extra interface{}) string {
+	switch e := extra.(type) {
+	case BotExtra:
+		return e.SyncToken
+	case *BotExtra:
+		if e != nil {
+			return e.SyncToken
+		}
+	case map[string]interface{}:
+		if token, ok := e["SyncToken"].(string); ok {
+			return token
+		}
+	case flamingo.UnknownExtra:
+		var extra BotExtra
+		if err := json.Unmarshal(e.Value, &extra); err == nil {
+			return extra.SyncToken
+		}
+	}
+	return ""
+}
+
+type matrixPlatform struct {
+	options ClientOptions
+	client  *platform.Client
+}
+
+func (p *matrixPlatform) Type() flamingo.ClientType {
+	return flamingo.MatrixClient
+}
+
+func (p *matrixPlatform) Connect(bot flamingo.StoredBot, events platform.Events) (platform.Connection, error) {
+	if bot.Token == "" {
+		return nil, errors.New("matrix: empty bot token")
+	}
+
+	if !strings.HasPrefix(bot.ID, "@") || !strings.Contains(bot.ID, ":") {
+		return nil, errors.New("matrix: bot ID is not a user ID: " + bot.ID)
+	}
+
+	return newConnection(bot, events, p.options, p.client.Storage), nil
+}
+
+// NewClient creates a new Matrix Client with the given options.
+func NewClient(options ClientOptions) flamingo.Client {
+	if options.Clock == nil {
+		options.Clock = flamingo.NewClock()
+	}
+
+	if options.PollTimeout <= 0 {
+		options.PollTimeout = DefaultPollTimeout
+	}
+
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: options.PollTimeout + 10*time.Second}
+	}
+
+	p := &matrixPlatform{options: options}
+	p.client = platform.NewClient(p, platform.Options{
+		Debug: options.Debug,
+		Clock: options.Clock,
+	})
+	return p.client
+}
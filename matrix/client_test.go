@@ -0,0 +1,390 @@
+package matrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/storage"
+)
+
+const (
+	botID     = "@flamingo:example.com"
+	janeID    = "@jane:example.com"
+	townID    = "!town:example.com"
+	testToken = "s3cr3t"
+)
+
+type apiCall struct {
+	method string
+	path   string
+	query  map[string]string
+	body   map[string]interface{}
+}
+
+// fakeHomeserver is a local stand-in of a Matrix homeserver. Its syncs
+// return the queued responses in order and, once there are no more, wait
+// for a short while and return no events.
+type fakeHomeserver struct {
+	sync.Mutex
+	*httptest.Server
+	calls   []apiCall
+	syncs   []interface{}
+	batch   int
+	lastID  int
+	directs map[string]interface{}
+}
+
+func newFakeHomeserver() *fakeHomeserver {
+	m := &fakeHomeserver{directs: make(map[string]interface{})}
+	m.Server = httptest.NewServer(m)
+	return m
+}
+
+// queue adds a sync response with the given rooms and account data.
+func (m *fakeHomeserver) queue(rooms map[string]interface{}, accountData ...interface{}) {
+	m.Lock()
+	defer m.Unlock()
+	m.syncs = append(m.syncs, map[string]interface{}{
+		"rooms":        rooms,
+		"account_data": map[string]interface{}{"events": accountData},
+	})
+}
+
+func (m *fakeHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"errcode": "M_UNKNOWN_TOKEN"})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3")
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	query := make(map[string]string)
+	for k := range r.URL.Query() {
+		query[k] = r.URL.Query().Get(k)
+	}
+
+	if path == "/sync" {
+		m.serveSync(w, query)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, apiCall{r.Method, path, query, body})
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	key := r.Method + " " + path
+
+	var result interface{}
+	switch {
+	case key == "GET /rooms/"+townID+"/state/m.room.name":
+		result = map[string]string{"name": "Town"}
+	case key == "GET /profile/"+janeID+"/displayname":
+		result = map[string]string{"displayname": "Jane"}
+	case key == "GET /user/"+botID+"/account_data/m.direct":
+		result = m.directs
+	case key == "PUT /user/"+botID+"/account_data/m.direct":
+		m.directs = body
+		result = struct{}{}
+	case key == "POST /createRoom":
+		result = map[string]string{"room_id": "!new:example.com"}
+	case r.Method == "POST" && parts[0] == "join":
+		result = map[string]string{"room_id": parts[1]}
+	case r.Method == "PUT" && len(parts) == 5 && (parts[2] == "send" || parts[2] == "redact"):
+		m.lastID++
+		result = map[string]string{"event_id": "$e" + strconv.Itoa(m.lastID)}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		result = map[string]string{"errcode": "M_NOT_FOUND", "error": "not found"}
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func (m *fakeHomeserver) serveSync(w http.ResponseWriter, query map[string]string) {
+	m.Lock()
+	m.calls = append(m.calls, apiCall{"GET", "/sync", query, nil})
+	var resp map[string]interface{}
+	if len(m.syncs) > 0 {
+		resp = m.syncs[0].(map[string]interface{})
+		m.syncs = m.syncs[1:]
+		m.batch++
+	}
+	batch := "s" + strconv.Itoa(m.batch)
+	m.Unlock()
+
+	if resp == nil {
+		time.Sleep(20 * time.Millisecond)
+		resp = make(map[string]interface{})
+	}
+
+	resp["next_batch"] = batch
+	json.NewEncoder(w).Encode(resp)
+}
+
+// callsTo returns the calls with the given method and path prefix.
+func (m *fakeHomeserver) callsTo(method, prefix string) []apiCall {
+	m.Lock()
+	defer m.Unlock()
+	var calls []apiCall
+	for _, c := range m.calls {
+		if c.method == method && strings.HasPrefix(c.path, prefix) {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+func (m *fakeHomeserver) waitFor(t *testing.T, method, prefix string, n int) []apiCall {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		calls := m.callsTo(method, prefix)
+		if len(calls) >= n {
+			return calls
+		}
+
+		if time.Now().After(deadline) {
+			require.FailNow(t, "expected call was not made", "%s %s, got %d", method, prefix, len(calls))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func timelineEvent(id, sender, typ string, content interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"event_id":         id,
+		"sender":           sender,
+		"type":             typ,
+		"origin_server_ts": 1475496000000,
+		"content":          content,
+	}
+}
+
+func stateEvent(sender, typ, key string, content interface{}) map[string]interface{} {
+	e := timelineEvent("$state", sender, typ, content)
+	e["state_key"] = key
+	return e
+}
+
+func textEvent(id, sender, text string) map[string]interface{} {
+	return timelineEvent(id, sender, eventMessage, map[string]string{
+		"msgtype": msgText,
+		"body":    text,
+	})
+}
+
+func joinedRooms(rooms map[string][]map[string]interface{}) map[string]interface{} {
+	join := make(map[string]interface{})
+	for id, events := range rooms {
+		join[id] = map[string]interface{}{
+			"timeline": map[string]interface{}{"events": events},
+		}
+	}
+	return map[string]interface{}{"join": join}
+}
+
+type echoController struct{}
+
+func (echoController) CanHandle(flamingo.Message) bool { return true }
+
+func (echoController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	_, err := bot.Reply(msg, flamingo.NewOutgoingMessage("echo: <"+msg.Text+">"))
+	return err
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
+
+func newTestClient(m *fakeHomeserver) flamingo.Client {
+	return NewClient(ClientOptions{
+		HomeserverURL: m.URL,
+		PollTimeout:   time.Second,
+	})
+}
+
+func TestMessagesAndSyncToken(t *testing.T) {
+	require := require.New(t)
+	m := newFakeHomeserver()
+	defer m.Close()
+
+	m.queue(joinedRooms(map[string][]map[string]interface{}{
+		townID: {textEvent("$old", janeID, "old message")},
+	}))
+	m.queue(joinedRooms(map[string][]map[string]interface{}{
+		townID: {
+			textEvent("$self", botID, "self"),
+			textEvent("$hi", janeID, "hello"),
+		},
+	}))
+
+	store := storage.NewMemory()
+	cli := newTestClient(m)
+	cli.SetStorage(store)
+	cli.AddController(echoController{})
+	cli.AddBot(botID, testToken, nil)
+
+	sent := m.waitFor(t, "PUT", "/rooms/"+townID+"/send/m.room.message/", 1)
+	require.Equal(map[string]interface{}{
+		"msgtype":        msgText,
+		"body":           "echo: <hello>",
+		"format":         formatHTML,
+		"formatted_body": "echo: &lt;hello&gt;",
+		"m.relates_to": map[string]interface{}{
+			"m.in_reply_to": map[string]interface{}{"event_id": "$hi"},
+		},
+	}, sent[0].body)
+
+	syncs := m.waitFor(t, "GET", "/sync", 3)
+	require.Equal("", syncs[0].query["since"])
+	require.Equal("0", syncs[0].query["timeout"])
+	require.Equal(initialSyncFilter, syncs[0].query["filter"])
+	require.Equal("s1", syncs[1].query["since"])
+	require.Equal("1000", syncs[1].query["timeout"])
+	require.Equal("", syncs[1].query["filter"])
+	require.Equal("s2", syncs[2].query["since"])
+
+	require.Nil(cli.Stop())
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(1, len(m.callsTo("PUT", "/rooms/")))
+
+	bots, err := store.LoadBots()
+	require.Nil(err)
+	require.Equal(1, len(bots))
+	require.Equal(testToken, bots[0].Token)
+	require.Equal("s2", syncToken(bots[0].Extra))
+}
+
+func TestResumeFromStoredToken(t *testing.T) {
+	require := require.New(t)
+	m := newFakeHomeserver()
+	defer m.Close()
+
+	// storages encoding the bots as JSON return the extra as a map
+	created := time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)
+	store := storage.NewMemory()
+	require.Nil(store.StoreBot(flamingo.StoredBot{
+		ID:        botID,
+		Token:     testToken,
+		CreatedAt: created,
+		Extra:     map[string]interface{}{"SyncToken": "s41"},
+	}))
+
+	cli := newTestClient(m)
+	cli.SetStorage(store)
+	cli.AddBot(botID, testToken, nil)
+
+	syncs := m.waitFor(t, "GET", "/sync", 2)
+	require.Equal("s41", syncs[0].query["since"])
+	require.Equal("", syncs[0].query["filter"])
+	require.Nil(cli.Stop())
+
+	// only the sync token of the stored bot is changed
+	bots, err := store.LoadBots()
+	require.Nil(err)
+	require.Equal(1, len(bots))
+	require.NotEqual("s41", syncToken(bots[0].Extra))
+	require.Equal(created, bots[0].CreatedAt)
+}
+
+func TestInviteAndReactions(t *testing.T) {
+	require := require.New(t)
+	m := newFakeHomeserver()
+	defer m.Close()
+
+	cli := newTestClient(m)
+	intros := make(chan flamingo.Channel, 1)
+	actions := make(chan flamingo.Action, 1)
+	cli.SetIntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		intros <- ch
+		_, err := b.Form(flamingo.Form{
+			Title: "Deploy?",
+			Fields: []flamingo.FieldGroup{
+				flamingo.NewButtonGroup("deploy",
+					flamingo.NewButton("Yes", "yes"),
+					flamingo.NewButton("No", "no"),
+				),
+			},
+		})
+		return err
+	}))
+	cli.AddActionHandler("deploy", func(b flamingo.Bot, a flamingo.Action) {
+		actions <- a
+	})
+
+	m.queue(nil)
+	m.queue(map[string]interface{}{
+		"invite": map[string]interface{}{
+			"!dm:example.com": map[string]interface{}{
+				"invite_state": map[string]interface{}{
+					"events": []interface{}{
+						stateEvent(janeID, eventMember, botID, map[string]interface{}{
+							"membership": "invite",
+							"is_direct":  true,
+						}),
+					},
+				},
+			},
+		},
+	})
+	cli.AddBot(botID, testToken, nil)
+
+	select {
+	case ch := <-intros:
+		require.Equal("!dm:example.com", ch.ID)
+		require.True(ch.IsDM)
+		require.Equal(flamingo.MatrixClient, ch.Type)
+	case <-time.After(2 * time.Second):
+		require.FailNow("intro not handled")
+	}
+
+	require.Equal(1, len(m.callsTo("POST", "/join/!dm:example.com")))
+	reactions := m.waitFor(t, "PUT", "/rooms/!dm:example.com/send/m.reaction/", 2)
+	form := m.callsTo("PUT", "/rooms/!dm:example.com/send/m.room.message/")
+	require.Equal(1, len(form))
+	require.Equal("<b>Deploy?</b><br>[Yes] [No]<br><i>"+optionsHint+"</i>", form[0].body["formatted_body"])
+	require.Equal(map[string]interface{}{
+		"rel_type": relAnnotation,
+		"event_id": "$e1",
+		"key":      "No",
+	}, reactions[1].body["m.relates_to"])
+
+	m.queue(joinedRooms(map[string][]map[string]interface{}{
+		"!dm:example.com": {
+			timelineEvent("$r0", botID, eventReaction, reactions[0].body),
+			timelineEvent("$r1", janeID, eventReaction, map[string]interface{}{
+				"m.relates_to": map[string]string{"rel_type": relAnnotation, "event_id": "$e1", "key": "Maybe"},
+			}),
+			timelineEvent("$r2", janeID, eventReaction, map[string]interface{}{
+				"m.relates_to": map[string]string{"rel_type": relAnnotation, "event_id": "$e1", "key": "No"},
+			}),
+		},
+	}))
+
+	select {
+	case a := <-actions:
+		require.Equal(flamingo.UserAction{Name: "no", Value: "no"}, a.UserAction)
+		require.Equal(janeID, a.User.ID)
+		require.Equal("jane", a.User.Username)
+		require.Equal("Jane", a.User.Name)
+		require.Equal("!dm:example.com", a.Channel.ID)
+		require.Equal("$e1", a.OriginalMessage.ID)
+	case <-time.After(2 * time.Second):
+		require.FailNow("action not received")
+	}
+
+	require.Nil(cli.Stop())
+}
@@ -0,0 +1,692 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+	"golang.org/x/net/context"
+)
+
+// maxForms is the number of forms posted by a bot whose reactions are turned
+// into actions. Reactions to older forms are ignored.
+const maxForms = 1000
+
+// initialSyncFilter limits the events received in the first sync of a bot,
+// which are only used to know the state of its rooms and not delivered.
+const initialSyncFilter = `{"room":{"timeline":{"limit":1}}}`
+
+// pendingForm is a form posted by the bot along with its options and the
+// reactions the bot added to it, one per option.
+type pendingForm struct {
+	msg       flamingo.Message
+	options   []option
+	reactions []string
+}
+
+type connection struct {
+	bot     flamingo.StoredBot
+	api     *api
+	events  platform.Events
+	options ClientOptions
+	storage func() flamingo.Storage
+	since   string
+
+	mut       sync.RWMutex
+	names     map[string]string
+	users     map[string]string
+	directs   map[string]bool
+	dmRooms   map[string]string
+	loaded    bool
+	forms     map[string]*pendingForm
+	formIDs   []string
+	txnPrefix string
+	lastTxn   int64
+	closed    chan struct{}
+	once      sync.Once
+}
+
+func newConnection(bot flamingo.StoredBot, events platform.Events, options ClientOptions, storage func() flamingo.Storage) *connection {
+	return &connection{
+		bot:       bot,
+		api:       newAPI(options.HomeserverURL, bot.Token, options.HTTPClient),
+		events:    events,
+		options:   options,
+		storage:   storage,
+		since:     syncToken(bot.Extra),
+		names:     make(map[string]string),
+		users:     make(map[string]string),
+		directs:   make(map[string]bool),
+		dmRooms:   make(map[string]string),
+		forms:     make(map[string]*pendingForm),
+		txnPrefix: "flamingo" + strconv.FormatInt(options.Clock.Now().UnixNano(), 36),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Run syncs with the homeserver and handles the events received until the
+// connection is closed or a sync fails. The token of every sync is stored
+// so the events are never received twice, even after a restart. The first
+// sync of a bot, which has no token, is only used to get the state of the
+// rooms, and its messages are not delivered.
+func (c *connection) Run() error {
+	if c.since == "" {
+		c.since = c.storedSyncToken()
+	}
+
+	for {
+		select {
+		case <-c.closed:
+			return nil
+		default:
+		}
+
+		filter, timeout := "", c.options.PollTimeout
+		if c.since == "" {
+			filter, timeout = initialSyncFilter, 0
+		}
+
+		resp, err := c.api.sync(c.since, filter, timeout, c.closed)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		c.handleSync(resp, c.since == "")
+		if resp.NextBatch != "" && resp.NextBatch != c.since {
+			c.since = resp.NextBatch
+			c.saveSyncToken()
+		}
+	}
+}
+
+func (c *connection) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// storedBot returns the bot as it is saved in the storage, and whether it
+// was found.
+func (c *connection) storedBot() (flamingo.StoredBot, bool, error) {
+	page, err := flamingo.NewStorageV2(c.storage()).QueryBots(context.Background(), flamingo.BotQuery{
+		IDs:   []string{c.bot.ID},
+		Limit: 1,
+	})
+	if err != nil || len(page.Bots) == 0 {
+		return flamingo.StoredBot{}, false, err
+	}
+
+	return page.Bots[0], true, nil
+}
+
+// storedSyncToken returns the sync token of the bot saved in the storage,
+// if any.
+func (c *connection) storedSyncToken() string {
+	bot, ok, err := c.storedBot()
+	if err != nil {
+		log15.Error("unable to load stored bot", "bot", c.bot.ID, "err", err.Error())
+		return ""
+	}
+
+	if !ok {
+		return ""
+	}
+	return syncToken(bot.Extra)
+}
+
+// saveSyncToken saves the sync token in the Extra of the stored bot, keeping
+// the rest of its stored data.
+func (c *connection) saveSyncToken() {
+	bot, ok, err := c.storedBot()
+	if err != nil {
+		log15.Error("unable to load stored bot", "bot", c.bot.ID, "err", err.Error())
+		return
+	}
+
+	if !ok {
+		bot = c.bot
+	}
+
+	bot.Extra = BotExtra{SyncToken: c.since}
+	if err := c.storage().StoreBot(bot); err != nil {
+		log15.Error("unable to store sync token", "bot", c.bot.ID, "err", err.Error())
+	}
+}
+
+func (c *connection) handleSync(resp *syncResponse, initial bool) {
+	for _, e := range resp.AccountData.Events {
+		if e.Type != eventDirect {
+			continue
+		}
+
+		var content directContent
+		if err := json.Unmarshal(e.Content, &content); err != nil {
+			log15.Error("error decoding matrix direct rooms", "err", err.Error())
+			continue
+		}
+		c.addDirects(content)
+	}
+
+	for room := range resp.Rooms.Leave {
+		c.forget(room)
+		c.events.Left(room)
+	}
+
+	for room, r := range resp.Rooms.Invite {
+		c.handleInvite(room, r)
+	}
+
+	for room, r := range resp.Rooms.Join {
+		for _, e := range r.State.Events {
+			c.handleState(room, e)
+		}
+
+		if initial {
+			for _, e := range r.Timeline.Events {
+				c.handleState(room, e)
+			}
+			continue
+		}
+
+		c.handleTimeline(room, r.Timeline.Events)
+	}
+}
+
+func (c *connection) handleInvite(room string, r invitedRoom) {
+	for _, e := range r.InviteState.Events {
+		c.handleState(room, e)
+		if !e.isStateOf(eventMember, c.bot.ID) {
+			continue
+		}
+
+		var m memberContent
+		if err := json.Unmarshal(e.Content, &m); err == nil && m.IsDirect {
+			c.addDirects(directContent{e.Sender: {room}})
+		}
+	}
+
+	if _, err := c.api.join(room); err != nil {
+		log15.Error("unable to join room the bot was invited to", "room", room, "err", err.Error())
+		return
+	}
+
+	c.joined(room)
+}
+
+func (c *connection) handleState(room string, e event) {
+	if !e.isStateOf(eventName, "") {
+		return
+	}
+
+	var content nameContent
+	if err := json.Unmarshal(e.Content, &content); err != nil {
+		return
+	}
+
+	c.mut.Lock()
+	c.names[room] = content.Name
+	c.mut.Unlock()
+}
+
+// handleTimeline handles the new events of a room. If the bot has just
+// joined the room, the events before it joined are skipped.
+func (c *connection) handleTimeline(room string, events []event) {
+	start := 0
+	for i, e := range events {
+		c.handleState(room, e)
+		if e.isStateOf(eventMember, c.bot.ID) {
+			var m memberContent
+			if err := json.Unmarshal(e.Content, &m); err == nil && m.Membership == membershipJoin {
+				start = i + 1
+			}
+		}
+	}
+
+	if start > 0 {
+		c.joined(room)
+	}
+
+	for _, e := range events[start:] {
+		if e.Sender == c.bot.ID {
+			continue
+		}
+
+		switch e.Type {
+		case eventMessage:
+			c.handleMessage(room, e)
+		case eventReaction:
+			c.handleReaction(e)
+		}
+	}
+}
+
+func (c *connection) joined(room string) {
+	ch, err := c.Channel(room)
+	if err != nil {
+		log15.Error("unable to get room the bot joined", "room", room, "err", err.Error())
+		return
+	}
+	c.events.Joined(ch)
+}
+
+func (c *connection) handleMessage(room string, e event) {
+	var content messageContent
+	if err := json.Unmarshal(e.Content, &content); err != nil {
+		log15.Error("error decoding matrix message", "event", e.EventID, "err", err.Error())
+		return
+	}
+
+	// edits of previous messages are not new messages
+	if content.RelatesTo != nil && content.RelatesTo.RelType == relReplace {
+		return
+	}
+
+	if content.MsgType != msgText || content.Body == "" {
+		return
+	}
+
+	text := content.Body
+	if content.RelatesTo != nil && content.RelatesTo.InReplyTo != nil {
+		text = stripReplyFallback(text)
+	}
+
+	ch, err := c.Channel(room)
+	if err != nil {
+		log15.Error("unable to get room of the message", "room", room, "err", err.Error())
+		return
+	}
+
+	c.events.Message(flamingo.Message{
+		ID:      e.EventID,
+		Type:    flamingo.MatrixClient,
+		User:    c.user(e.Sender),
+		Channel: ch,
+		Time:    eventTime(e),
+		Text:    text,
+		Extra:   e,
+	})
+}
+
+// stripReplyFallback removes the quote of the original message that clients
+// prepend to the body of replies.
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+
+	if i == 0 {
+		return body
+	}
+
+	return strings.TrimLeft(strings.Join(lines[i:], "\n"), "\n")
+}
+
+func (c *connection) handleReaction(e event) {
+	var content messageContent
+	if err := json.Unmarshal(e.Content, &content); err != nil {
+		log15.Error("error decoding matrix reaction", "event", e.EventID, "err", err.Error())
+		return
+	}
+
+	rel := content.RelatesTo
+	if rel == nil || rel.RelType != relAnnotation {
+		return
+	}
+
+	c.mut.RLock()
+	form, ok := c.forms[rel.EventID]
+	c.mut.RUnlock()
+	if !ok {
+		return
+	}
+
+	for _, opt := range form.options {
+		if opt.button.Text != rel.Key {
+			continue
+		}
+
+		c.events.Action(platform.ActionEvent{
+			ID: opt.group,
+			Action: flamingo.Action{
+				UserAction: flamingo.UserAction{
+					Name:  opt.button.Name,
+					Value: opt.button.Value,
+				},
+				User:            c.user(e.Sender),
+				Channel:         form.msg.Channel,
+				OriginalMessage: form.msg,
+				Extra:           e,
+			},
+		})
+		return
+	}
+}
+
+func eventTime(e event) time.Time {
+	return time.Unix(0, e.OriginServerTS*int64(time.Millisecond))
+}
+
+// localpart returns the name of the user in a user ID such as
+// @name:example.com.
+func localpart(id string) string {
+	if idx := strings.Index(id, ":"); idx >= 0 {
+		id = id[:idx]
+	}
+	return strings.TrimPrefix(id, "@")
+}
+
+// qualify returns the user ID of the given user, which can be a user ID or
+// a name of a user of the homeserver of the bot.
+func (c *connection) qualify(user string) string {
+	user = strings.TrimPrefix(user, "@")
+	if !strings.Contains(user, ":") {
+		if idx := strings.Index(c.bot.ID, ":"); idx >= 0 {
+			user += c.bot.ID[idx:]
+		}
+	}
+	return "@" + user
+}
+
+func (c *connection) user(id string) flamingo.User {
+	c.mut.RLock()
+	name, ok := c.users[id]
+	c.mut.RUnlock()
+
+	if !ok {
+		var err error
+		name, err = c.api.displayName(id)
+		if err != nil {
+			log15.Warn("unable to get display name of user", "user", id, "err", err.Error())
+		} else {
+			c.mut.Lock()
+			c.users[id] = name
+			c.mut.Unlock()
+		}
+	}
+
+	return flamingo.User{
+		ID:       id,
+		Username: localpart(id),
+		Name:     name,
+		IsBot:    id == c.bot.ID,
+		Type:     flamingo.MatrixClient,
+	}
+}
+
+func (c *connection) addDirects(content directContent) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for user, rooms := range content {
+		for _, room := range rooms {
+			c.directs[room] = true
+		}
+
+		if _, ok := c.dmRooms[user]; !ok && len(rooms) > 0 {
+			c.dmRooms[user] = rooms[0]
+		}
+	}
+}
+
+// loadDirects requests the direct rooms of the bot if they have not been
+// received yet.
+func (c *connection) loadDirects() {
+	c.mut.RLock()
+	loaded := c.loaded
+	c.mut.RUnlock()
+	if loaded {
+		return
+	}
+
+	content, err := c.api.directRooms(c.bot.ID)
+	if err != nil {
+		log15.Warn("unable to get direct rooms of bot", "bot", c.bot.ID, "err", err.Error())
+		return
+	}
+
+	c.addDirects(content)
+	c.mut.Lock()
+	c.loaded = true
+	c.mut.Unlock()
+}
+
+func (c *connection) forget(room string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	delete(c.names, room)
+	for id, f := range c.forms {
+		if f.msg.Channel.ID == room {
+			delete(c.forms, id)
+		}
+	}
+}
+
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	c.mut.RLock()
+	name, ok := c.names[id]
+	c.mut.RUnlock()
+
+	if !ok {
+		var err error
+		name, err = c.api.roomName(id)
+		if err != nil {
+			return flamingo.Channel{}, err
+		}
+
+		c.mut.Lock()
+		c.names[id] = name
+		c.mut.Unlock()
+	}
+
+	c.loadDirects()
+	c.mut.RLock()
+	direct := c.directs[id]
+	c.mut.RUnlock()
+
+	return flamingo.Channel{
+		ID:   id,
+		Name: name,
+		IsDM: direct,
+		Type: flamingo.MatrixClient,
+	}, nil
+}
+
+// txnID returns a new transaction ID for the requests sending events, which
+// the homeserver uses to ignore retries of requests already done.
+func (c *connection) txnID() string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.lastTxn++
+	return fmt.Sprintf("%s.%d", c.txnPrefix, c.lastTxn)
+}
+
+func (c *connection) send(room, eventType string, content interface{}) (string, error) {
+	return c.api.send(room, eventType, c.txnID(), content)
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	return c.send(channel, eventMessage, textContent(msg.Text))
+}
+
+func (c *connection) ReplyMessage(channel string, replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error) {
+	content := textContent(msg.Text)
+	if replyTo.Channel.ID == channel && replyTo.ID != "" {
+		content.RelatesTo = &relatesTo{InReplyTo: &inReplyTo{EventID: replyTo.ID}}
+	}
+	return c.send(channel, eventMessage, content)
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	content, options := formToContent(form)
+	id, err := c.send(channel, eventMessage, content)
+	if err != nil {
+		return "", err
+	}
+
+	return id, c.trackForm(channel, id, content.Body, options)
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	return c.send(channel, eventMessage, imageContent(img))
+}
+
+// editContent returns the content of an edit of the message with the given
+// ID, which replaces its content with the given one.
+func editContent(id string, content messageContent) messageContent {
+	edit := messageContent{
+		MsgType:    content.MsgType,
+		Body:       "* " + content.Body,
+		NewContent: &content,
+		RelatesTo:  &relatesTo{RelType: relReplace, EventID: id},
+	}
+
+	if content.FormattedBody != "" {
+		edit.Format = content.Format
+		edit.FormattedBody = "* " + content.FormattedBody
+	}
+
+	return edit
+}
+
+// UpdateMessage edits the message. Edits are new events, but the ID of the
+// original message is returned, because further edits and reactions refer
+// to it.
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	if _, err := c.send(channel, eventMessage, editContent(id, textContent(text))); err != nil {
+		return "", err
+	}
+
+	c.untrackForm(channel, id)
+	return id, nil
+}
+
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	content, options := formToContent(form)
+	if _, err := c.send(channel, eventMessage, editContent(id, content)); err != nil {
+		return "", err
+	}
+
+	c.untrackForm(channel, id)
+	return id, c.trackForm(channel, id, content.Body, options)
+}
+
+// trackForm adds a reaction to the form for each one of its options and
+// keeps them so the reactions of the users to the form are delivered as
+// actions.
+func (c *connection) trackForm(channel, id, text string, options []option) error {
+	if len(options) == 0 {
+		return nil
+	}
+
+	ch, err := c.Channel(channel)
+	if err != nil {
+		return err
+	}
+
+	form := &pendingForm{
+		msg: flamingo.Message{
+			ID:      id,
+			Type:    flamingo.MatrixClient,
+			User:    c.user(c.bot.ID),
+			Channel: ch,
+			Time:    c.options.Clock.Now(),
+			Text:    text,
+		},
+		options: options,
+	}
+
+	c.mut.Lock()
+	c.forms[id] = form
+	c.formIDs = append(c.formIDs, id)
+	if len(c.formIDs) > maxForms {
+		delete(c.forms, c.formIDs[0])
+		c.formIDs = c.formIDs[1:]
+	}
+	c.mut.Unlock()
+
+	for _, opt := range options {
+		reaction, err := c.send(channel, eventReaction, messageContent{
+			RelatesTo: &relatesTo{
+				RelType: relAnnotation,
+				EventID: id,
+				Key:     opt.button.Text,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		c.mut.Lock()
+		form.reactions = append(form.reactions, reaction)
+		c.mut.Unlock()
+	}
+
+	return nil
+}
+
+// untrackForm stops delivering the reactions to the form with the given ID
+// and removes the reactions of the bot to it.
+func (c *connection) untrackForm(channel, id string) {
+	c.mut.Lock()
+	form, ok := c.forms[id]
+	delete(c.forms, id)
+	var reactions []string
+	if ok {
+		reactions = form.reactions
+	}
+	c.mut.Unlock()
+
+	for _, r := range reactions {
+		if err := c.api.redact(channel, r, c.txnID()); err != nil {
+			log15.Warn("unable to remove reaction of form", "form", id, "reaction", r, "err", err.Error())
+		}
+	}
+}
+
+// DirectChannel returns the direct message room with the user with the
+// given ID or name, creating it if there is none.
+func (c *connection) DirectChannel(user string) (string, error) {
+	id := c.qualify(user)
+
+	c.loadDirects()
+	c.mut.RLock()
+	room, ok := c.dmRooms[id]
+	c.mut.RUnlock()
+	if ok {
+		return room, nil
+	}
+
+	room, err := c.api.createDirectRoom(id)
+	if err != nil {
+		return "", err
+	}
+	c.addDirects(directContent{id: {room}})
+
+	// the room is added to the direct rooms of the account so all the
+	// clients of the bot, including itself after a restart, know about it
+	content, err := c.api.directRooms(c.bot.ID)
+	if err == nil {
+		content[id] = append(content[id], room)
+		err = c.api.setDirectRooms(c.bot.ID, content)
+	}
+
+	if err != nil {
+		log15.Warn("unable to save direct room", "bot", c.bot.ID, "room", room, "err", err.Error())
+	}
+
+	return room, nil
+}
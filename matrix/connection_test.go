@@ -0,0 +1,271 @@
+package matrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+	"github.com/src-d/flamingo/storage"
+)
+
+type eventsMock struct {
+	messages []flamingo.Message
+	actions  []platform.ActionEvent
+	joined   []flamingo.Channel
+	left     []string
+}
+
+func (e *eventsMock) Message(m flamingo.Message)    { e.messages = append(e.messages, m) }
+func (e *eventsMock) Action(a platform.ActionEvent) { e.actions = append(e.actions, a) }
+func (e *eventsMock) Joined(ch flamingo.Channel)    { e.joined = append(e.joined, ch) }
+func (e *eventsMock) Left(ch string)                { e.left = append(e.left, ch) }
+
+func newTestConnection(m *fakeHomeserver, events platform.Events) *connection {
+	store := storage.NewMemory()
+	return newConnection(
+		flamingo.StoredBot{ID: botID, Token: testToken},
+		events,
+		ClientOptions{
+			Clock:         flamingo.NewFakeClock(time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)),
+			HomeserverURL: m.URL,
+			HTTPClient:    http.DefaultClient,
+		},
+		func() flamingo.Storage { return store },
+	)
+}
+
+// decodeSync encodes the given response as JSON and decodes it as a sync
+// response.
+func decodeSync(t *testing.T, resp interface{}) *syncResponse {
+	data, err := json.Marshal(resp)
+	require.Nil(t, err)
+
+	var r syncResponse
+	require.Nil(t, json.Unmarshal(data, &r))
+	return &r
+}
+
+func TestHandleSync(t *testing.T) {
+	require := require.New(t)
+	m := newFakeHomeserver()
+	defer m.Close()
+
+	events := new(eventsMock)
+	conn := newTestConnection(m, events)
+
+	rooms := joinedRooms(map[string][]map[string]interface{}{
+		townID: {
+			textEvent("$1", janeID, "hello"),
+			timelineEvent("$2", janeID, eventMessage, map[string]interface{}{
+				"msgtype": msgText,
+				"body":    "> <@flamingo:example.com> hi\n> there\n\nhi back",
+				"m.relates_to": map[string]interface{}{
+					"m.in_reply_to": map[string]string{"event_id": "$0"},
+				},
+			}),
+			timelineEvent("$3", janeID, eventMessage, map[string]interface{}{
+				"msgtype":       msgText,
+				"body":          "* hello!",
+				"m.new_content": map[string]string{"msgtype": msgText, "body": "hello!"},
+				"m.relates_to":  map[string]string{"rel_type": relReplace, "event_id": "$1"},
+			}),
+			timelineEvent("$4", janeID, eventMessage, map[string]string{"msgtype": "m.notice", "body": "notice"}),
+			textEvent("$5", botID, "self"),
+		},
+		"!new:example.com": {
+			textEvent("$6", janeID, "before the bot joined"),
+			stateEvent(janeID, eventName, "", map[string]string{"name": "New"}),
+			stateEvent(botID, eventMember, botID, map[string]string{"membership": "join"}),
+			textEvent("$7", janeID, "welcome"),
+		},
+	})
+	rooms["leave"] = map[string]interface{}{"!old:example.com": map[string]interface{}{}}
+	conn.handleSync(decodeSync(t, map[string]interface{}{
+		"next_batch": "s2",
+		"rooms":      rooms,
+		"account_data": map[string]interface{}{
+			"events": []interface{}{
+				timelineEvent("", "", eventDirect, map[string][]string{janeID: {"!dm:example.com"}}),
+			},
+		},
+	}), false)
+
+	require.Equal([]string{"!old:example.com"}, events.left)
+	require.Equal(1, len(events.joined))
+	require.Equal("!new:example.com", events.joined[0].ID)
+	require.Equal("New", events.joined[0].Name)
+
+	require.Equal(3, len(events.messages))
+	byID := make(map[string]flamingo.Message)
+	for _, msg := range events.messages {
+		byID[msg.ID] = msg
+	}
+
+	msg := byID["$1"]
+	require.Equal("hello", msg.Text)
+	require.Equal(townID, msg.Channel.ID)
+	require.Equal("Town", msg.Channel.Name)
+	require.False(msg.Channel.IsDM)
+	require.Equal(flamingo.User{
+		ID:       janeID,
+		Username: "jane",
+		Name:     "Jane",
+		Type:     flamingo.MatrixClient,
+	}, msg.User)
+	require.Equal(flamingo.MatrixClient, msg.Type)
+	require.Equal(int64(1475496000), msg.Time.Unix())
+
+	require.Equal("hi back", byID["$2"].Text)
+	require.Equal("welcome", byID["$7"].Text)
+
+	ch, err := conn.Channel("!dm:example.com")
+	require.Nil(err)
+	require.True(ch.IsDM)
+	require.Equal("", ch.Name)
+
+	room, err := conn.DirectChannel("jane")
+	require.Nil(err)
+	require.Equal("!dm:example.com", room)
+}
+
+func TestInitialSync(t *testing.T) {
+	require := require.New(t)
+	m := newFakeHomeserver()
+	defer m.Close()
+
+	events := new(eventsMock)
+	conn := newTestConnection(m, events)
+	conn.handleSync(decodeSync(t, map[string]interface{}{
+		"next_batch": "s1",
+		"rooms": joinedRooms(map[string][]map[string]interface{}{
+			townID: {
+				stateEvent(janeID, eventName, "", map[string]string{"name": "Renamed"}),
+				textEvent("$1", janeID, "old"),
+			},
+		}),
+	}), true)
+
+	require.Equal(0, len(events.messages))
+	require.Equal(0, len(events.joined))
+
+	ch, err := conn.Channel(townID)
+	require.Nil(err)
+	require.Equal("Renamed", ch.Name)
+}
+
+func TestUpdateForm(t *testing.T) {
+	require := require.New(t)
+	m := newFakeHomeserver()
+	defer m.Close()
+
+	conn := newTestConnection(m, new(eventsMock))
+	form := flamingo.Form{
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewButtonGroup("g", flamingo.NewButton("Yes", "yes")),
+		},
+	}
+
+	id, err := conn.PostForm(townID, form)
+	require.Nil(err)
+	require.Equal("$e1", id)
+	require.Equal([]string{"$e2"}, conn.forms[id].reactions)
+	require.Equal("Town", conn.forms[id].msg.Channel.Name)
+
+	id, err = conn.UpdateForm(townID, id, form)
+	require.Nil(err)
+	require.Equal("$e1", id)
+
+	redacts := m.callsTo("PUT", "/rooms/"+townID+"/redact/$e2/")
+	require.Equal(1, len(redacts))
+
+	edits := m.callsTo("PUT", "/rooms/"+townID+"/send/m.room.message/")
+	require.Equal(2, len(edits))
+	require.Equal("* [Yes]\n"+optionsHint, edits[1].body["body"])
+	require.Equal(map[string]interface{}{
+		"rel_type": relReplace,
+		"event_id": "$e1",
+	}, edits[1].body["m.relates_to"])
+	require.Equal("[Yes]\n"+optionsHint, edits[1].body["m.new_content"].(map[string]interface{})["body"])
+	require.Equal([]string{"$e5"}, conn.forms[id].reactions)
+
+	id, err = conn.UpdateMessage(townID, id, "done")
+	require.Nil(err)
+	require.Equal("$e1", id)
+	require.Nil(conn.forms[id])
+	require.Equal(1, len(m.callsTo("PUT", "/rooms/"+townID+"/redact/$e5/")))
+
+	// reactions to forms no longer tracked are ignored
+	events := new(eventsMock)
+	conn.events = events
+	conn.handleReaction(event{
+		Type:    eventReaction,
+		Sender:  janeID,
+		Content: json.RawMessage(`{"m.relates_to":{"rel_type":"m.annotation","event_id":"$e1","key":"Yes"}}`),
+	})
+	require.Equal(0, len(events.actions))
+}
+
+func TestDirectChannel(t *testing.T) {
+	require := require.New(t)
+	m := newFakeHomeserver()
+	defer m.Close()
+	m.directs["@john:other.org"] = []string{"!john:example.com"}
+
+	conn := newTestConnection(m, new(eventsMock))
+	room, err := conn.DirectChannel("@jane")
+	require.Nil(err)
+	require.Equal("!new:example.com", room)
+
+	create := m.callsTo("POST", "/createRoom")
+	require.Equal(1, len(create))
+	require.Equal([]interface{}{janeID}, create[0].body["invite"])
+	require.Equal(true, create[0].body["is_direct"])
+	require.Equal(map[string]interface{}{
+		"@john:other.org": []interface{}{"!john:example.com"},
+		janeID:            []interface{}{"!new:example.com"},
+	}, m.directs)
+
+	room, err = conn.DirectChannel(janeID)
+	require.Nil(err)
+	require.Equal("!new:example.com", room)
+
+	room, err = conn.DirectChannel("@john:other.org")
+	require.Nil(err)
+	require.Equal("!john:example.com", room)
+	require.Equal(1, len(m.callsTo("POST", "/createRoom")))
+
+	ch, err := conn.Channel("!new:example.com")
+	require.Nil(err)
+	require.True(ch.IsDM)
+}
+
+func TestStripReplyFallback(t *testing.T) {
+	require.Equal(t, "reply", stripReplyFallback("> <@a:b> original\n\nreply"))
+	require.Equal(t, "no quote", stripReplyFallback("no quote"))
+}
+
+func TestSyncToken(t *testing.T) {
+	require.Equal(t, "a", syncToken(BotExtra{SyncToken: "a"}))
+	require.Equal(t, "b", syncToken(&BotExtra{SyncToken: "b"}))
+	require.Equal(t, "c", syncToken(map[string]interface{}{"SyncToken": "c"}))
+	require.Equal(t, "d", syncToken(flamingo.UnknownExtra{Name: "old.BotExtra", Value: []byte(`{"SyncToken":"d"}`)}))
+	require.Equal(t, "", syncToken(flamingo.UnknownExtra{Name: "old.BotExtra", Value: []byte(`[]`)}))
+	require.Equal(t, "", syncToken(nil))
+	require.Equal(t, "", syncToken((*BotExtra)(nil)))
+	require.Equal(t, "", syncToken("foo"))
+}
+
+func TestBotExtraJSON(t *testing.T) {
+	require := require.New(t)
+	bytes, err := json.Marshal(flamingo.StoredBot{ID: "@bot:b", Extra: BotExtra{SyncToken: "s1"}})
+	require.Nil(err)
+
+	var bot flamingo.StoredBot
+	require.Nil(json.Unmarshal(bytes, &bot))
+	require.Equal(BotExtra{SyncToken: "s1"}, bot.Extra)
+}
@@ -0,0 +1,151 @@
+package matrix
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/src-d/flamingo"
+)
+
+// optionsHint is appended to the forms with buttons to tell the users how to
+// choose one.
+const optionsHint = "(react with one of the options to choose it)"
+
+// option is a button of a form, which users choose by reacting to the form
+// with the text of the button.
+type option struct {
+	group  string
+	button flamingo.Button
+}
+
+// textContent returns the content of a text message with the given text and
+// its HTML version.
+func textContent(text string) messageContent {
+	return messageContent{
+		MsgType:       msgText,
+		Body:          text,
+		Format:        formatHTML,
+		FormattedBody: textToHTML(text),
+	}
+}
+
+func textToHTML(text string) string {
+	return strings.Replace(html.EscapeString(text), "\n", "<br>", -1)
+}
+
+// formToContent renders the form as a text message with an HTML body. Every
+// button is returned as an option, which is chosen with a reaction whose key
+// is the text of the button, so only the first of the buttons with the same
+// text is kept.
+func formToContent(form flamingo.Form) (messageContent, []option) {
+	var (
+		lines     []string
+		htmlLines []string
+		options   []option
+		keys      = make(map[string]bool)
+	)
+
+	add := func(text, html string) {
+		lines = append(lines, text)
+		htmlLines = append(htmlLines, html)
+	}
+
+	if form.AuthorName != "" {
+		add(form.AuthorName, fmt.Sprintf("<i>%s</i>", html.EscapeString(form.AuthorName)))
+	}
+
+	if form.Title != "" {
+		add(form.Title, fmt.Sprintf("<b>%s</b>", html.EscapeString(form.Title)))
+	}
+
+	if form.Text != "" {
+		add(form.Text, textToHTML(form.Text))
+	}
+
+	for _, g := range form.Fields {
+		var choices []string
+		for _, i := range g.Items() {
+			switch f := i.(type) {
+			case flamingo.Button:
+				if f.Text == "" || keys[f.Text] {
+					continue
+				}
+
+				keys[f.Text] = true
+				options = append(options, option{g.ID(), f})
+				choices = append(choices, "["+f.Text+"]")
+			case flamingo.TextField:
+				add(
+					fmt.Sprintf("%s: %s", f.Title, f.Value),
+					fmt.Sprintf("<b>%s</b>: %s", html.EscapeString(f.Title), textToHTML(f.Value)),
+				)
+			case flamingo.Image:
+				add(imageToText(f), imageToHTML(f))
+			case flamingo.Text:
+				add(string(f), textToHTML(string(f)))
+			}
+		}
+
+		if len(choices) > 0 {
+			text := strings.Join(choices, " ")
+			add(text, html.EscapeString(text))
+		}
+	}
+
+	if len(options) > 0 {
+		add(optionsHint, fmt.Sprintf("<i>%s</i>", optionsHint))
+	}
+
+	if form.Footer != "" {
+		add(form.Footer, fmt.Sprintf("<i>%s</i>", html.EscapeString(form.Footer)))
+	}
+
+	return messageContent{
+		MsgType:       msgText,
+		Body:          strings.Join(lines, "\n"),
+		Format:        formatHTML,
+		FormattedBody: strings.Join(htmlLines, "<br>"),
+	}, options
+}
+
+func imageToText(img flamingo.Image) string {
+	if img.Text == "" {
+		return img.URL
+	}
+	return img.Text + ": " + img.URL
+}
+
+func imageToHTML(img flamingo.Image) string {
+	text := img.Text
+	if text == "" {
+		text = img.URL
+	}
+
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(img.URL), html.EscapeString(text))
+}
+
+// imageContent returns the content of a message with the image. Only the
+// images uploaded to the homeserver, whose URL is an mxc:// URI, can be
+// posted as images; the rest are posted as links.
+func imageContent(img flamingo.Image) messageContent {
+	if !strings.HasPrefix(img.URL, "mxc://") {
+		return messageContent{
+			MsgType:       msgText,
+			Body:          imageToText(img),
+			Format:        formatHTML,
+			FormattedBody: imageToHTML(img),
+		}
+	}
+
+	body := img.Text
+	if body == "" {
+		body = "image"
+	}
+
+	return messageContent{
+		MsgType: msgImage,
+		Body:    body,
+		URL:     img.URL,
+	}
+}
@@ -0,0 +1,81 @@
+package matrix
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+func TestFormToContent(t *testing.T) {
+	require := require.New(t)
+	content, options := formToContent(flamingo.Form{
+		AuthorName: "flamingo",
+		Title:      "Deploy <prod>",
+		Text:       "Choose\nwisely",
+		Footer:     "footer",
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewTextFieldGroup(flamingo.NewTextField("Env", "prod & staging")),
+			flamingo.Image{URL: "http://img", Text: "graph"},
+			flamingo.Text("some text"),
+			flamingo.NewButtonGroup("deploy",
+				flamingo.NewButton("Yes", "yes"),
+				flamingo.NewButton("No", "no"),
+			),
+			flamingo.NewButtonGroup("cancel",
+				flamingo.NewButton("No", "cancel"),
+				flamingo.NewButton("Cancel", "cancel"),
+			),
+		},
+	})
+
+	require.Equal(msgText, content.MsgType)
+	require.Equal(formatHTML, content.Format)
+	require.Equal(`flamingo
+Deploy <prod>
+Choose
+wisely
+Env: prod & staging
+graph: http://img
+some text
+[Yes] [No]
+[Cancel]
+`+optionsHint+`
+footer`, content.Body)
+	require.Equal(`<i>flamingo</i><br>`+
+		`<b>Deploy &lt;prod&gt;</b><br>`+
+		`Choose<br>wisely<br>`+
+		`<b>Env</b>: prod &amp; staging<br>`+
+		`<a href="http://img">graph</a><br>`+
+		`some text<br>`+
+		`[Yes] [No]<br>`+
+		`[Cancel]<br>`+
+		`<i>`+optionsHint+`</i><br>`+
+		`<i>footer</i>`, content.FormattedBody)
+
+	require.Equal(3, len(options))
+	require.Equal("deploy", options[1].group)
+	require.Equal("no", options[1].button.Value)
+	require.Equal("cancel", options[2].group)
+	require.Equal("Cancel", options[2].button.Text)
+
+	content, options = formToContent(flamingo.Form{Title: "Info"})
+	require.Equal("Info", content.Body)
+	require.Equal(0, len(options))
+}
+
+func TestImageContent(t *testing.T) {
+	require.Equal(t, messageContent{
+		MsgType: msgImage,
+		Body:    "image",
+		URL:     "mxc://example.com/abc",
+	}, imageContent(flamingo.Image{URL: "mxc://example.com/abc"}))
+
+	require.Equal(t, messageContent{
+		MsgType:       msgText,
+		Body:          "cat: http://cat.jpg",
+		Format:        formatHTML,
+		FormattedBody: `<a href="http://cat.jpg">cat</a>`,
+	}, imageContent(flamingo.Image{URL: "http://cat.jpg", Text: "cat"}))
+}
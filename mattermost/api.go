@@ -0,0 +1,228 @@
+package mattermost
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// APIError is an error returned by the Mattermost REST API.
+type APIError struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// ID is the identifier of the error given by Mattermost.
+	ID string
+	// Message is the description of the error.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("mattermost: %d %s", e.Status, e.Message)
+}
+
+// Temporary reports whether the request can be retried later.
+func (e *APIError) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+type user struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	IsBot     bool   `json:"is_bot"`
+}
+
+// channelDirect is the type of the direct message channels.
+const channelDirect = "D"
+
+type channel struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+type post struct {
+	ID        string                 `json:"id,omitempty"`
+	CreateAt  int64                  `json:"create_at,omitempty"`
+	UserID    string                 `json:"user_id,omitempty"`
+	ChannelID string                 `json:"channel_id"`
+	RootID    string                 `json:"root_id,omitempty"`
+	Type      string                 `json:"type,omitempty"`
+	Message   string                 `json:"message"`
+	Props     map[string]interface{} `json:"props,omitempty"`
+}
+
+type postPatch struct {
+	Message *string                `json:"message,omitempty"`
+	Props   map[string]interface{} `json:"props,omitempty"`
+}
+
+type attachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type integration struct {
+	URL     string        `json:"url"`
+	Context ActionContext `json:"context"`
+}
+
+type attachmentAction struct {
+	Name        string       `json:"name"`
+	Type        string       `json:"type"`
+	Style       string       `json:"style,omitempty"`
+	Integration *integration `json:"integration,omitempty"`
+}
+
+type attachment struct {
+	Fallback   string             `json:"fallback,omitempty"`
+	Color      string             `json:"color,omitempty"`
+	AuthorName string             `json:"author_name,omitempty"`
+	AuthorIcon string             `json:"author_icon,omitempty"`
+	Title      string             `json:"title,omitempty"`
+	TitleLink  string             `json:"title_link,omitempty"`
+	Text       string             `json:"text,omitempty"`
+	Fields     []attachmentField  `json:"fields,omitempty"`
+	ImageURL   string             `json:"image_url,omitempty"`
+	ThumbURL   string             `json:"thumb_url,omitempty"`
+	Footer     string             `json:"footer,omitempty"`
+	Actions    []attachmentAction `json:"actions,omitempty"`
+}
+
+type api struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newAPI(server, token string, client *http.Client) *api {
+	return &api{
+		url:    strings.TrimRight(server, "/") + "/api/v4",
+		token:  token,
+		client: client,
+	}
+}
+
+// do performs a request with the given method to the given path, sending
+// body as JSON if it is not nil and decoding the response in result if it is
+// not nil.
+func (a *api) do(method, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, a.url+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Status: resp.StatusCode, Message: resp.Status}
+		var e struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+		}
+
+		if json.Unmarshal(data, &e) == nil && e.Message != "" {
+			apiErr.ID = e.ID
+			apiErr.Message = e.Message
+		}
+
+		return apiErr
+	}
+
+	if result != nil && len(data) > 0 {
+		return json.Unmarshal(data, result)
+	}
+
+	return nil
+}
+
+func (a *api) me() (*user, error) {
+	return a.user("me")
+}
+
+func (a *api) user(id string) (*user, error) {
+	var u user
+	if err := a.do("GET", "/users/"+id, nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (a *api) userByUsername(username string) (*user, error) {
+	var u user
+	if err := a.do("GET", "/users/username/"+username, nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (a *api) channel(id string) (*channel, error) {
+	var ch channel
+	if err := a.do("GET", "/channels/"+id, nil, &ch); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func (a *api) directChannel(self, other string) (*channel, error) {
+	var ch channel
+	if err := a.do("POST", "/channels/direct", []string{self, other}, &ch); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func (a *api) post(id string) (*post, error) {
+	var p post
+	if err := a.do("GET", "/posts/"+id, nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (a *api) createPost(p post) (*post, error) {
+	var created post
+	if err := a.do("POST", "/posts", p, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (a *api) patchPost(id string, patch postPatch) (*post, error) {
+	var patched post
+	if err := a.do("PUT", "/posts/"+id+"/patch", patch, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
@@ -0,0 +1,211 @@
+// Package mattermost provides a flamingo.Client for Mattermost bots, which
+// receive events through the websocket of the server and talk to the users
+// through its REST API.
+//
+// Both channels and direct messages are conversations of the bot, and the
+// IntroHandler is called when the bot is added to a channel. Forms are posted
+// as message attachments. Mattermost sends the clicks on their buttons to an
+// integration URL, so the webhook must be enabled and reachable from the
+// Mattermost server for the buttons to work; the actions are then delivered
+// to the ActionHandler registered with the ID of the button group.
+package mattermost
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// ClientOptions are the configurable options of the mattermost client.
+type ClientOptions struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+	// ServerURL is the URL of the Mattermost server, such as
+	// https://chat.example.com.
+	ServerURL string
+	// HTTPClient is the client used to perform the requests to the REST API.
+	// If nil, a client with a 10 seconds timeout is used.
+	HTTPClient *http.Client
+	// Webhook contains the options of the endpoint receiving the actions of
+	// the buttons.
+	Webhook WebhookOptions
+}
+
+// WebhookOptions are the configurable options of the mattermost webhook.
+type WebhookOptions struct {
+	// Enabled will start the webhook endpoint if true.
+	Enabled bool
+	// Addr is the address on which the webhook will be run.
+	Addr string
+	// URL is the public URL of the webhook, which is sent to Mattermost as
+	// the integration URL of every button.
+	URL string
+	// VerificationToken is sent along with every button and is used to check
+	// incoming actions come from a form posted by the bots.
+	VerificationToken string
+	// CertFile is the path to the SSL certificate. If given along with
+	// KeyFile, the webhook is served using HTTPS.
+	CertFile string
+	// KeyFile is the path to the SSL key.
+	KeyFile string
+}
+
+type mattermostPlatform struct {
+	options ClientOptions
+}
+
+func (p *mattermostPlatform) Type() flamingo.ClientType {
+	return flamingo.MattermostClient
+}
+
+func (p *mattermostPlatform) Connect(bot flamingo.StoredBot, events platform.Events) (platform.Connection, error) {
+	if bot.Token == "" {
+		return nil, errors.New("mattermost: empty bot token")
+	}
+
+	return newConnection(bot, events, p.options), nil
+}
+
+type mattermostClient struct {
+	*platform.Client
+	options  ClientOptions
+	webhook  *WebhookService
+	mut      sync.Mutex
+	listener net.Listener
+	shutdown chan struct{}
+}
+
+// NewClient creates a new Mattermost Client with the given options.
+func NewClient(options ClientOptions) flamingo.Client {
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if options.Webhook.Addr == "" {
+		options.Webhook.Addr = ":8080"
+	}
+
+	return &mattermostClient{
+		Client: platform.NewClient(&mattermostPlatform{options}, platform.Options{
+			Debug: options.Debug,
+			Clock: options.Clock,
+		}),
+		options:  options,
+		webhook:  NewWebhookService(options.Webhook.VerificationToken),
+		shutdown: make(chan struct{}),
+	}
+}
+
+func (c *mattermostClient) Run() error {
+	if c.options.Webhook.Enabled {
+		if c.options.Webhook.VerificationToken == "" {
+			return errors.New("webhook verification token is empty")
+		}
+
+		listener, err := c.listen()
+		if err != nil {
+			return err
+		}
+
+		c.mut.Lock()
+		c.listener = listener
+		c.mut.Unlock()
+
+		log15.Info("Starting webhook server endpoint", "address", c.options.Webhook.Addr)
+		go c.serveWebhook(listener)
+	}
+
+	go c.consumeActions()
+	return c.Client.Run()
+}
+
+func (c *mattermostClient) listen() (net.Listener, error) {
+	listener, err := net.Listen("tcp", c.options.Webhook.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.options.Webhook.CertFile == "" || c.options.Webhook.KeyFile == "" {
+		return listener, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.options.Webhook.CertFile, c.options.Webhook.KeyFile)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(listener, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}), nil
+}
+
+func (c *mattermostClient) serveWebhook(listener net.Listener) {
+	err := (&http.Server{
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 3 * time.Second,
+		Handler:      c.webhook,
+	}).Serve(listener)
+
+	if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		log15.Error("mattermost webhook stopped", "err", err.Error())
+	}
+}
+
+// ServeHTTP handles the actions sent by Mattermost, so the webhook can be
+// served by an existing HTTP server instead of enabling it.
+func (c *mattermostClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.webhook.ServeHTTP(w, r)
+}
+
+func (c *mattermostClient) consumeActions() {
+	actions := c.webhook.Consume()
+	for {
+		select {
+		case action := <-actions:
+			log15.Debug("action received", "bot", action.Context.Bot, "group", action.Context.Group)
+			c.handleActionCallback(action)
+		case <-c.shutdown:
+			return
+		}
+	}
+}
+
+func (c *mattermostClient) handleActionCallback(action ActionCallback) {
+	conn, err := c.Connection(action.Context.Bot)
+	if err != nil {
+		log15.Warn("bot not found", "id", action.Context.Bot)
+		return
+	}
+
+	conn.(*connection).handleAction(action)
+}
+
+func (c *mattermostClient) Stop() error {
+	c.mut.Lock()
+	if c.listener != nil {
+		c.listener.Close()
+		c.listener = nil
+	}
+
+	select {
+	case <-c.shutdown:
+	default:
+		close(c.shutdown)
+	}
+	c.mut.Unlock()
+
+	return c.Client.Stop()
+}
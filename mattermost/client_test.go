@@ -0,0 +1,349 @@
+package mattermost
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+const (
+	botID  = "bbbbbbbbbbbbbbbbbbbbbbbbbb"
+	janeID = "jjjjjjjjjjjjjjjjjjjjjjjjjj"
+)
+
+var (
+	botUser   = user{ID: botID, Username: "flamingo", IsBot: true}
+	jane      = user{ID: janeID, Username: "jane", FirstName: "Jane", LastName: "Doe"}
+	town      = channel{ID: "town", TeamID: "team", Type: "O", Name: "town-square", DisplayName: "Town Square"}
+	direct    = channel{ID: "dm", Type: channelDirect, Name: botID + "__" + janeID}
+	formPost  = post{ID: "form", CreateAt: 1475496000000, UserID: botID, ChannelID: "town", Message: ""}
+	testToken = "s3cr3t"
+)
+
+type apiCall struct {
+	method string
+	path   string
+	auth   string
+	body   interface{}
+}
+
+// fakeMattermost is a local stand-in of a Mattermost server.
+type fakeMattermost struct {
+	sync.Mutex
+	*httptest.Server
+	calls  []apiCall
+	lastID int
+	fail   map[string]int
+	events chan interface{}
+}
+
+func newFakeMattermost() *fakeMattermost {
+	m := &fakeMattermost{
+		fail:   make(map[string]int),
+		events: make(chan interface{}, 10),
+	}
+	m.Server = httptest.NewServer(m)
+	return m
+}
+
+func (m *fakeMattermost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v4")
+	if path == "/websocket" {
+		m.serveWebsocket(w, r)
+		return
+	}
+
+	var body interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	key := r.Method + " " + path
+
+	m.Lock()
+	defer m.Unlock()
+	if code, ok := m.fail[key]; ok {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "api.error",
+			"message": "failed",
+		})
+		return
+	}
+
+	m.calls = append(m.calls, apiCall{r.Method, path, r.Header.Get("Authorization"), body})
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	var result interface{}
+	switch {
+	case key == "GET /users/me" || key == "GET /users/"+botID:
+		result = botUser
+	case key == "GET /users/"+janeID || key == "GET /users/username/jane":
+		result = jane
+	case key == "GET /channels/town":
+		result = town
+	case key == "GET /channels/dm":
+		result = direct
+	case key == "POST /channels/direct":
+		ids := body.([]interface{})
+		result = channel{ID: "dm-" + ids[1].(string), Type: channelDirect}
+	case key == "GET /posts/form":
+		result = formPost
+	case key == "POST /posts":
+		m.lastID++
+		result = post{ID: "p" + strconv.Itoa(m.lastID)}
+	case r.Method == "PUT" && parts[0] == "posts":
+		result = post{ID: parts[1]}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		result = map[string]string{"id": "not_found", "message": "not found"}
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+var upgrader = websocket.Upgrader{}
+
+func (m *fakeMattermost) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	ws.WriteJSON(map[string]interface{}{"event": "hello", "data": map[string]string{"server_version": "9.0"}})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case e := <-m.events:
+			if ws.WriteJSON(e) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (m *fakeMattermost) posted(p post) {
+	m.events <- map[string]interface{}{
+		"event": "posted",
+		"data":  map[string]string{"post": string(raw(p))},
+	}
+}
+
+func (m *fakeMattermost) setFail(key string, code int) {
+	m.Lock()
+	defer m.Unlock()
+	m.fail[key] = code
+}
+
+func (m *fakeMattermost) callsTo(method, path string) []apiCall {
+	m.Lock()
+	defer m.Unlock()
+	var calls []apiCall
+	for _, c := range m.calls {
+		if c.method == method && c.path == path {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+func (m *fakeMattermost) waitFor(t *testing.T, method, path string, n int) []apiCall {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		calls := m.callsTo(method, path)
+		if len(calls) >= n {
+			return calls
+		}
+
+		if time.Now().After(deadline) {
+			require.FailNow(t, "expected call was not made", "%s %s", method, path)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type echoController struct{}
+
+func (echoController) CanHandle(flamingo.Message) bool { return true }
+
+func (echoController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	_, err := bot.Reply(msg, flamingo.NewOutgoingMessage("echo: "+msg.Text))
+	return err
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
+
+func newTestClient(m *fakeMattermost) flamingo.Client {
+	return NewClient(ClientOptions{
+		ServerURL: m.URL,
+		Webhook: WebhookOptions{
+			URL:               "https://bot.example.com/actions",
+			VerificationToken: "verify",
+		},
+	})
+}
+
+func TestMessages(t *testing.T) {
+	require := require.New(t)
+	m := newFakeMattermost()
+	defer m.Close()
+
+	cli := newTestClient(m)
+	cli.AddController(echoController{})
+	cli.AddBot("bot", testToken, nil)
+
+	m.posted(post{ID: "1", UserID: botID, ChannelID: "town", Message: "self"})
+	m.posted(post{ID: "2", UserID: janeID, ChannelID: "town", Type: "system_join_channel", Message: "joined"})
+	m.posted(post{ID: "3", UserID: janeID, ChannelID: "town", Message: "hello", CreateAt: 1475496000000})
+
+	calls := m.waitFor(t, "POST", "/posts", 1)
+	require.Equal(map[string]interface{}{
+		"channel_id": "town",
+		"message":    "@jane: echo: hello",
+	}, calls[0].body)
+	require.Equal("Bearer "+testToken, calls[0].auth)
+
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(1, len(m.callsTo("POST", "/posts")))
+	require.Nil(cli.Stop())
+}
+
+func TestJoinedChannel(t *testing.T) {
+	require := require.New(t)
+	m := newFakeMattermost()
+	defer m.Close()
+
+	cli := newTestClient(m)
+	intros := make(chan flamingo.Channel, 1)
+	cli.SetIntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		intros <- ch
+		_, err := b.Form(flamingo.Form{
+			Title:  "Hi",
+			Fields: []flamingo.FieldGroup{flamingo.NewButtonGroup("start", flamingo.NewButton("Start", "start"))},
+		})
+		return err
+	}))
+	cli.AddBot("bot", testToken, nil)
+
+	m.events <- map[string]interface{}{
+		"event":     "user_added",
+		"data":      map[string]string{"user_id": janeID, "team_id": "team"},
+		"broadcast": map[string]string{"channel_id": "dm"},
+	}
+	m.events <- map[string]interface{}{
+		"event":     "user_added",
+		"data":      map[string]string{"user_id": botID, "team_id": "team"},
+		"broadcast": map[string]string{"channel_id": "town"},
+	}
+
+	select {
+	case ch := <-intros:
+		require.Equal("town", ch.ID)
+		require.Equal("Town Square", ch.Name)
+		require.False(ch.IsDM)
+		require.Equal(flamingo.MattermostClient, ch.Type)
+	case <-time.After(2 * time.Second):
+		require.FailNow("intro not handled")
+	}
+
+	calls := m.waitFor(t, "POST", "/posts", 1)
+	body := calls[0].body.(map[string]interface{})
+	attachments := body["props"].(map[string]interface{})["attachments"].([]interface{})
+	require.Equal(2, len(attachments))
+
+	action := attachments[1].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})
+	require.Equal("Start", action["name"])
+	require.Equal(map[string]interface{}{
+		"url": "https://bot.example.com/actions",
+		"context": map[string]interface{}{
+			"token": "verify",
+			"bot":   "bot",
+			"group": "start",
+			"name":  "start",
+			"value": "start",
+		},
+	}, action["integration"])
+	require.Nil(cli.Stop())
+}
+
+func TestAction(t *testing.T) {
+	require := require.New(t)
+	m := newFakeMattermost()
+	defer m.Close()
+
+	actions := make(chan flamingo.Action, 1)
+	cli := newTestClient(m)
+	cli.AddActionHandler("confirm", func(b flamingo.Bot, a flamingo.Action) {
+		actions <- a
+	})
+	cli.AddBot("bot", testToken, nil)
+	go cli.Run()
+
+	body, err := json.Marshal(ActionCallback{
+		UserID:    janeID,
+		ChannelID: "town",
+		PostID:    "form",
+		Context: ActionContext{
+			Token: "verify",
+			Bot:   "bot",
+			Group: "confirm",
+			Name:  "yes",
+			Value: "y",
+		},
+	})
+	require.Nil(err)
+
+	req, _ := http.NewRequest("POST", "/actions", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	cli.(http.Handler).ServeHTTP(w, req)
+	require.Equal(http.StatusOK, w.Code)
+	require.Equal("{}", w.Body.String())
+
+	select {
+	case a := <-actions:
+		require.Equal(flamingo.UserAction{Name: "yes", Value: "y"}, a.UserAction)
+		require.Equal(janeID, a.User.ID)
+		require.Equal("Jane Doe", a.User.Name)
+		require.Equal("town", a.Channel.ID)
+		require.Equal("form", a.OriginalMessage.ID)
+	case <-time.After(2 * time.Second):
+		require.FailNow("action not received")
+	}
+
+	require.Nil(cli.Stop())
+}
+
+func raw(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
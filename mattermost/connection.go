@@ -0,0 +1,431 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// readTimeout is the time after which the websocket is considered dead if
+// nothing, not even a ping, is received from the server.
+const readTimeout = 2 * time.Minute
+
+// idLength is the length of all the IDs generated by Mattermost. Usernames
+// are never that long.
+const idLength = 26
+
+type event struct {
+	Event string `json:"event"`
+	Data  struct {
+		Post      string `json:"post"`
+		UserID    string `json:"user_id"`
+		ChannelID string `json:"channel_id"`
+	} `json:"data"`
+	Broadcast struct {
+		ChannelID string `json:"channel_id"`
+		UserID    string `json:"user_id"`
+	} `json:"broadcast"`
+}
+
+type connection struct {
+	id      string
+	token   string
+	api     *api
+	events  platform.Events
+	options ClientOptions
+
+	mut      sync.RWMutex
+	self     string
+	users    map[string]user
+	channels map[string]channel
+	ws       *websocket.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newConnection(bot flamingo.StoredBot, events platform.Events, options ClientOptions) *connection {
+	return &connection{
+		id:       bot.ID,
+		token:    bot.Token,
+		api:      newAPI(options.ServerURL, bot.Token, options.HTTPClient),
+		events:   events,
+		options:  options,
+		users:    make(map[string]user),
+		channels: make(map[string]channel),
+		closed:   make(chan struct{}),
+	}
+}
+
+func websocketURL(server string) string {
+	url := strings.TrimRight(server, "/") + "/api/v4/websocket"
+	if strings.HasPrefix(url, "https://") {
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	}
+	return "ws://" + strings.TrimPrefix(url, "http://")
+}
+
+// Run connects to the websocket of the server and handles its events until
+// the connection is closed or lost.
+func (c *connection) Run() error {
+	if _, err := c.fetchSelf(); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+c.token)
+	ws, _, err := websocket.DefaultDialer.Dial(websocketURL(c.options.ServerURL), header)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	c.mut.Lock()
+	select {
+	case <-c.closed:
+		c.mut.Unlock()
+		return nil
+	default:
+		c.ws = ws
+	}
+	c.mut.Unlock()
+
+	ws.SetPingHandler(func(data string) error {
+		ws.SetReadDeadline(time.Now().Add(readTimeout))
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(readTimeout))
+
+		var e event
+		if err := ws.ReadJSON(&e); err != nil {
+			select {
+			case <-c.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		c.handleEvent(&e)
+	}
+}
+
+func (c *connection) Close() error {
+	c.once.Do(func() {
+		c.mut.Lock()
+		close(c.closed)
+		ws := c.ws
+		c.mut.Unlock()
+
+		if ws != nil {
+			ws.Close()
+		}
+	})
+	return nil
+}
+
+func (c *connection) handleEvent(e *event) {
+	switch e.Event {
+	case "posted":
+		var p post
+		if err := json.Unmarshal([]byte(e.Data.Post), &p); err != nil {
+			log15.Error("error decoding mattermost post", "err", err.Error())
+			return
+		}
+		c.handlePost(&p)
+
+	case "user_added":
+		if e.Data.UserID != c.selfID() {
+			return
+		}
+
+		ch, err := c.Channel(e.Broadcast.ChannelID)
+		if err != nil {
+			log15.Error("unable to get channel the bot was added to", "channel", e.Broadcast.ChannelID, "err", err.Error())
+			return
+		}
+		c.events.Joined(ch)
+
+	case "user_removed":
+		if e.Broadcast.UserID != c.selfID() || e.Data.ChannelID == "" {
+			return
+		}
+		c.forget(e.Data.ChannelID)
+		c.events.Left(e.Data.ChannelID)
+
+	case "channel_deleted":
+		c.forget(e.Data.ChannelID)
+		c.events.Left(e.Data.ChannelID)
+
+	case "":
+		// status replies to websocket actions, which the client never sends
+
+	default:
+		log15.Debug("ignoring mattermost event", "event", e.Event)
+	}
+}
+
+func (c *connection) handlePost(p *post) {
+	if p.UserID == c.selfID() {
+		log15.Debug("got message from self, ignoring")
+		return
+	}
+
+	// posts with a type are system messages, such as users joining
+	if p.Type != "" || p.Message == "" {
+		return
+	}
+
+	msg, err := c.convertPost(p)
+	if err != nil {
+		log15.Error("unable to convert mattermost post", "post", p.ID, "err", err.Error())
+		return
+	}
+
+	c.events.Message(msg)
+}
+
+// handleAction delivers an action received by the webhook.
+func (c *connection) handleAction(cb ActionCallback) {
+	p, err := c.api.post(cb.PostID)
+	if err != nil {
+		log15.Error("unable to get post of the action", "post", cb.PostID, "err", err.Error())
+		return
+	}
+
+	msg, err := c.convertPost(p)
+	if err != nil {
+		log15.Error("unable to convert post of the action", "post", cb.PostID, "err", err.Error())
+		return
+	}
+
+	u, err := c.user(cb.UserID)
+	if err != nil {
+		log15.Error("unable to get user of the action", "user", cb.UserID, "err", err.Error())
+		return
+	}
+
+	c.events.Action(platform.ActionEvent{
+		ID: cb.Context.Group,
+		Action: flamingo.Action{
+			UserAction: flamingo.UserAction{
+				Name:  cb.Context.Name,
+				Value: cb.Context.Value,
+			},
+			User:            convertUser(u),
+			Channel:         msg.Channel,
+			OriginalMessage: msg,
+			Extra:           cb,
+		},
+	})
+}
+
+// fetchSelf requests the user of the bot to know its ID.
+func (c *connection) fetchSelf() (string, error) {
+	me, err := c.api.me()
+	if err != nil {
+		return "", err
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.self = me.ID
+	return me.ID, nil
+}
+
+func (c *connection) selfID() string {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.self
+}
+
+func (c *connection) forget(channel string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	delete(c.channels, channel)
+}
+
+func (c *connection) user(id string) (user, error) {
+	c.mut.RLock()
+	u, ok := c.users[id]
+	c.mut.RUnlock()
+	if ok {
+		return u, nil
+	}
+
+	fetched, err := c.api.user(id)
+	if err != nil {
+		return user{}, err
+	}
+
+	c.mut.Lock()
+	c.users[id] = *fetched
+	c.mut.Unlock()
+	return *fetched, nil
+}
+
+func (c *connection) convertPost(p *post) (flamingo.Message, error) {
+	u, err := c.user(p.UserID)
+	if err != nil {
+		return flamingo.Message{}, err
+	}
+
+	ch, err := c.Channel(p.ChannelID)
+	if err != nil {
+		return flamingo.Message{}, err
+	}
+
+	return flamingo.Message{
+		ID:      p.ID,
+		Type:    flamingo.MattermostClient,
+		User:    convertUser(u),
+		Channel: ch,
+		Time:    time.Unix(0, p.CreateAt*int64(time.Millisecond)),
+		Text:    p.Message,
+		Extra:   p,
+	}, nil
+}
+
+func convertUser(u user) flamingo.User {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if name == "" {
+		name = u.Nickname
+	}
+
+	return flamingo.User{
+		ID:       u.ID,
+		Username: u.Username,
+		Name:     name,
+		IsBot:    u.IsBot,
+		Type:     flamingo.MattermostClient,
+		Extra:    u,
+	}
+}
+
+func convertChannel(ch channel) flamingo.Channel {
+	name := ch.DisplayName
+	if name == "" {
+		name = ch.Name
+	}
+
+	return flamingo.Channel{
+		ID:    ch.ID,
+		Name:  name,
+		IsDM:  ch.Type == channelDirect,
+		Type:  flamingo.MattermostClient,
+		Extra: ch,
+	}
+}
+
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	c.mut.RLock()
+	ch, ok := c.channels[id]
+	c.mut.RUnlock()
+	if ok {
+		return convertChannel(ch), nil
+	}
+
+	fetched, err := c.api.channel(id)
+	if err != nil {
+		return flamingo.Channel{}, err
+	}
+
+	c.mut.Lock()
+	c.channels[id] = *fetched
+	c.mut.Unlock()
+	return convertChannel(*fetched), nil
+}
+
+func (c *connection) actionTarget() actionTarget {
+	return actionTarget{
+		bot:   c.id,
+		url:   c.options.Webhook.URL,
+		token: c.options.Webhook.VerificationToken,
+	}
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	return c.create(post{ChannelID: channel, Message: msg.Text})
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	return c.create(post{
+		ChannelID: channel,
+		Props:     attachmentsProps(formToAttachments(c.actionTarget(), form)),
+	})
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	return c.create(post{
+		ChannelID: channel,
+		Props:     attachmentsProps([]attachment{imageAttachment(img)}),
+	})
+}
+
+func (c *connection) create(p post) (string, error) {
+	created, err := c.api.createPost(p)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	return c.patch(id, postPatch{Message: &text})
+}
+
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	var text string
+	return c.patch(id, postPatch{
+		Message: &text,
+		Props:   attachmentsProps(formToAttachments(c.actionTarget(), form)),
+	})
+}
+
+func (c *connection) patch(id string, patch postPatch) (string, error) {
+	patched, err := c.api.patchPost(id, patch)
+	if err != nil {
+		return "", err
+	}
+	return patched.ID, nil
+}
+
+// DirectChannel opens the direct message channel between the bot and the
+// user with the given username or ID.
+func (c *connection) DirectChannel(username string) (string, error) {
+	id := username
+	if len(username) != idLength {
+		u, err := c.api.userByUsername(strings.TrimPrefix(username, "@"))
+		if err != nil {
+			return "", err
+		}
+		id = u.ID
+	}
+
+	self := c.selfID()
+	if self == "" {
+		var err error
+		if self, err = c.fetchSelf(); err != nil {
+			return "", err
+		}
+	}
+
+	ch, err := c.api.directChannel(self, id)
+	if err != nil {
+		return "", err
+	}
+
+	c.mut.Lock()
+	c.channels[ch.ID] = *ch
+	c.mut.Unlock()
+	return ch.ID, nil
+}
@@ -0,0 +1,169 @@
+package mattermost
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+type eventsMock struct {
+	messages []flamingo.Message
+	actions  []platform.ActionEvent
+	joined   []flamingo.Channel
+	left     []string
+}
+
+func (e *eventsMock) Message(m flamingo.Message)    { e.messages = append(e.messages, m) }
+func (e *eventsMock) Action(a platform.ActionEvent) { e.actions = append(e.actions, a) }
+func (e *eventsMock) Joined(ch flamingo.Channel)    { e.joined = append(e.joined, ch) }
+func (e *eventsMock) Left(ch string)                { e.left = append(e.left, ch) }
+
+func newTestConnection(m *fakeMattermost, events platform.Events) *connection {
+	conn := newConnection(flamingo.StoredBot{ID: "bot", Token: testToken}, events, ClientOptions{
+		ServerURL:  m.URL,
+		HTTPClient: http.DefaultClient,
+	})
+	conn.self = botID
+	return conn
+}
+
+func postedEvent(p post) *event {
+	var e event
+	e.Event = "posted"
+	e.Data.Post = string(raw(p))
+	return &e
+}
+
+func TestHandleEvent(t *testing.T) {
+	require := require.New(t)
+	m := newFakeMattermost()
+	defer m.Close()
+
+	events := new(eventsMock)
+	conn := newTestConnection(m, events)
+
+	conn.handleEvent(postedEvent(post{ID: "1", UserID: janeID, ChannelID: "dm", Message: "hi", CreateAt: 1475496000000}))
+	conn.handleEvent(postedEvent(post{ID: "2", UserID: janeID, ChannelID: "town"}))
+	conn.handleEvent(postedEvent(post{ID: "3", UserID: janeID, ChannelID: "unknown", Message: "lost"}))
+	conn.handleEvent(&event{Event: "posted"})
+	conn.handleEvent(&event{Event: "typing"})
+
+	var removed event
+	removed.Event = "user_removed"
+	removed.Data.ChannelID = "town"
+	removed.Broadcast.UserID = botID
+	conn.handleEvent(&removed)
+
+	removed.Broadcast.UserID = janeID
+	conn.handleEvent(&removed)
+
+	var deleted event
+	deleted.Event = "channel_deleted"
+	deleted.Data.ChannelID = "dm"
+	conn.handleEvent(&deleted)
+
+	require.Equal(1, len(events.messages))
+	msg := events.messages[0]
+	require.Equal("1", msg.ID)
+	require.Equal("hi", msg.Text)
+	require.Equal(flamingo.MattermostClient, msg.Type)
+	require.Equal("jane", msg.User.Username)
+	require.True(msg.Channel.IsDM)
+	require.Equal(int64(1475496000), msg.Time.Unix())
+
+	require.Equal([]string{"town", "dm"}, events.left)
+	require.Equal(0, len(events.joined))
+}
+
+func TestDirectChannel(t *testing.T) {
+	require := require.New(t)
+	m := newFakeMattermost()
+	defer m.Close()
+
+	conn := newTestConnection(m, new(eventsMock))
+	id, err := conn.DirectChannel(janeID)
+	require.Nil(err)
+	require.Equal("dm-"+janeID, id)
+
+	id, err = conn.DirectChannel("@jane")
+	require.Nil(err)
+	require.Equal("dm-"+janeID, id)
+
+	calls := m.callsTo("POST", "/channels/direct")
+	require.Equal([]interface{}{botID, janeID}, calls[0].body)
+
+	_, err = conn.DirectChannel("john")
+	require.NotNil(err)
+	require.Equal(http.StatusNotFound, err.(*APIError).Status)
+
+	conn.self = ""
+	_, err = conn.DirectChannel(janeID)
+	require.Nil(err)
+	require.Equal(1, len(m.callsTo("GET", "/users/me")))
+}
+
+func TestConnectionAPI(t *testing.T) {
+	require := require.New(t)
+	m := newFakeMattermost()
+	defer m.Close()
+
+	conn := newTestConnection(m, new(eventsMock))
+	id, err := conn.PostImage("town", flamingo.Image{URL: "http://img.png", Text: "look"})
+	require.Nil(err)
+	require.Equal("p1", id)
+
+	id, err = conn.UpdateMessage("town", "p1", "changed")
+	require.Nil(err)
+	require.Equal("p1", id)
+
+	_, err = conn.UpdateForm("town", "p1", flamingo.Form{Title: "form"})
+	require.Nil(err)
+
+	ch, err := conn.Channel("town")
+	require.Nil(err)
+	require.Equal("Town Square", ch.Name)
+	_, err = conn.Channel("town")
+	require.Nil(err)
+	require.Equal(1, len(m.callsTo("GET", "/channels/town")))
+
+	image := m.callsTo("POST", "/posts")[0].body.(map[string]interface{})
+	require.Equal(map[string]interface{}{"attachments": []interface{}{map[string]interface{}{
+		"fallback":   "look",
+		"title":      "look",
+		"title_link": "http://img.png",
+		"image_url":  "http://img.png",
+	}}}, image["props"])
+
+	edits := m.callsTo("PUT", "/posts/p1/patch")
+	require.Equal(2, len(edits))
+	require.Equal(map[string]interface{}{"message": "changed"}, edits[0].body)
+	require.Equal("", edits[1].body.(map[string]interface{})["message"])
+	require.NotNil(edits[1].body.(map[string]interface{})["props"])
+}
+
+func TestAPIError(t *testing.T) {
+	require := require.New(t)
+	m := newFakeMattermost()
+	defer m.Close()
+	m.setFail("POST /posts", http.StatusTooManyRequests)
+
+	conn := newTestConnection(m, new(eventsMock))
+	_, err := conn.PostMessage("town", flamingo.NewOutgoingMessage("hi"))
+	require.NotNil(err)
+	require.True(flamingo.IsTemporary(err))
+
+	m.setFail("POST /posts", http.StatusForbidden)
+	_, err = conn.PostMessage("town", flamingo.NewOutgoingMessage("hi"))
+	require.False(flamingo.IsTemporary(err))
+	require.Equal("mattermost: 403 failed", err.Error())
+	require.Equal("api.error", err.(*APIError).ID)
+}
+
+func TestWebsocketURL(t *testing.T) {
+	require.Equal(t, "wss://chat.example.com/api/v4/websocket", websocketURL("https://chat.example.com/"))
+	require.Equal(t, "ws://localhost:8065/api/v4/websocket", websocketURL("http://localhost:8065"))
+}
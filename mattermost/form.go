@@ -0,0 +1,125 @@
+package mattermost
+
+import (
+	"github.com/src-d/flamingo"
+)
+
+// actionTarget is where the buttons of the forms posted by a bot send the
+// actions to.
+type actionTarget struct {
+	bot   string
+	url   string
+	token string
+}
+
+func (t actionTarget) integration(group string, b flamingo.Button) *integration {
+	return &integration{
+		URL: t.url,
+		Context: ActionContext{
+			Token: t.token,
+			Bot:   t.bot,
+			Group: group,
+			Name:  b.Name,
+			Value: b.Value,
+		},
+	}
+}
+
+func buttonStyle(t flamingo.ButtonType) string {
+	switch t {
+	case flamingo.PrimaryButton:
+		return "primary"
+	case flamingo.DangerButton:
+		return "danger"
+	default:
+		return "default"
+	}
+}
+
+// formToAttachments converts the form to the message attachments of a post,
+// which Mattermost renders the same way as Slack.
+func formToAttachments(target actionTarget, form flamingo.Form) []attachment {
+	var attachments []attachment
+	if form.Combine {
+		attachments = append(attachments, combinedAttachment(target, form))
+	} else {
+		if form.Title != "" || form.Text != "" || form.AuthorName != "" {
+			attachments = append(attachments, headerAttachment(form))
+		}
+
+		for _, g := range form.Fields {
+			var a attachment
+			addGroupToAttachment(&a, target, g)
+			a.Color = form.Color
+			attachments = append(attachments, a)
+		}
+	}
+
+	if len(attachments) > 0 {
+		attachments[len(attachments)-1].Footer = form.Footer
+	}
+
+	return attachments
+}
+
+func combinedAttachment(target actionTarget, form flamingo.Form) attachment {
+	a := headerAttachment(form)
+	for _, g := range form.Fields {
+		addGroupToAttachment(&a, target, g)
+	}
+
+	return a
+}
+
+func headerAttachment(form flamingo.Form) attachment {
+	return attachment{
+		Fallback:   form.Title,
+		AuthorName: form.AuthorName,
+		AuthorIcon: form.AuthorIconURL,
+		Title:      form.Title,
+		Text:       form.Text,
+		Color:      form.Color,
+	}
+}
+
+func imageAttachment(img flamingo.Image) attachment {
+	return attachment{
+		Fallback:  img.Text,
+		Title:     img.Text,
+		TitleLink: img.URL,
+		ImageURL:  img.URL,
+		ThumbURL:  img.ThumbnailURL,
+	}
+}
+
+func addGroupToAttachment(a *attachment, target actionTarget, group flamingo.FieldGroup) {
+	for _, i := range group.Items() {
+		switch f := i.(type) {
+		case flamingo.Button:
+			a.Actions = append(a.Actions, attachmentAction{
+				Name:        f.Text,
+				Type:        "button",
+				Style:       buttonStyle(f.Type),
+				Integration: target.integration(group.ID(), f),
+			})
+		case flamingo.TextField:
+			a.Fields = append(a.Fields, attachmentField{
+				Title: f.Title,
+				Value: f.Value,
+				Short: f.Short,
+			})
+		case flamingo.Image:
+			img := imageAttachment(f)
+			a.ImageURL = img.ImageURL
+			a.ThumbURL = img.ThumbURL
+			a.Title = img.Title
+			a.TitleLink = img.TitleLink
+		case flamingo.Text:
+			a.Text = string(f)
+		}
+	}
+}
+
+func attachmentsProps(attachments []attachment) map[string]interface{} {
+	return map[string]interface{}{"attachments": attachments}
+}
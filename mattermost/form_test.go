@@ -0,0 +1,91 @@
+package mattermost
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+var target = actionTarget{bot: "bot", url: "http://hook", token: "tok"}
+
+func testForm(combine bool) flamingo.Form {
+	return flamingo.Form{
+		AuthorName: "Flamingo",
+		Title:      "Deploy",
+		Text:       "Are you sure?",
+		Color:      "#ff0000",
+		Footer:     "footer",
+		Combine:    combine,
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewTextFieldGroup(
+				flamingo.NewShortTextField("Branch", "master"),
+			),
+			flamingo.Image{URL: "http://img.png", Text: "graph"},
+			flamingo.NewButtonGroup("deploy",
+				flamingo.NewPrimaryButton("Yes", "yes"),
+				flamingo.NewDangerButton("No", "no"),
+			),
+		},
+	}
+}
+
+var deployActions = []attachmentAction{
+	{Name: "Yes", Type: "button", Style: "primary", Integration: &integration{
+		URL:     "http://hook",
+		Context: ActionContext{Token: "tok", Bot: "bot", Group: "deploy", Name: "yes", Value: "yes"},
+	}},
+	{Name: "No", Type: "button", Style: "danger", Integration: &integration{
+		URL:     "http://hook",
+		Context: ActionContext{Token: "tok", Bot: "bot", Group: "deploy", Name: "no", Value: "no"},
+	}},
+}
+
+func TestFormToAttachments(t *testing.T) {
+	require.Equal(t, []attachment{
+		{
+			Fallback:   "Deploy",
+			AuthorName: "Flamingo",
+			Title:      "Deploy",
+			Text:       "Are you sure?",
+			Color:      "#ff0000",
+		},
+		{
+			Color:  "#ff0000",
+			Fields: []attachmentField{{Title: "Branch", Value: "master", Short: true}},
+		},
+		{
+			Color:     "#ff0000",
+			Title:     "graph",
+			TitleLink: "http://img.png",
+			ImageURL:  "http://img.png",
+		},
+		{
+			Color:   "#ff0000",
+			Footer:  "footer",
+			Actions: deployActions,
+		},
+	}, formToAttachments(target, testForm(false)))
+}
+
+func TestFormToAttachmentsCombined(t *testing.T) {
+	require.Equal(t, []attachment{
+		{
+			Fallback:   "Deploy",
+			AuthorName: "Flamingo",
+			Title:      "graph",
+			TitleLink:  "http://img.png",
+			Text:       "Are you sure?",
+			Color:      "#ff0000",
+			Fields:     []attachmentField{{Title: "Branch", Value: "master", Short: true}},
+			ImageURL:   "http://img.png",
+			Footer:     "footer",
+			Actions:    deployActions,
+		},
+	}, formToAttachments(target, testForm(true)))
+}
+
+func TestFormToAttachmentsEmpty(t *testing.T) {
+	require.Equal(t, 0, len(formToAttachments(target, flamingo.Form{Footer: "footer"})))
+}
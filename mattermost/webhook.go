@@ -0,0 +1,76 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ActionContext is the context attached to every button of the forms posted
+// by the bots. Mattermost sends it back when the button is clicked.
+type ActionContext struct {
+	// Token is the verification token of the webhook.
+	Token string `json:"token"`
+	// Bot is the ID of the bot that posted the form.
+	Bot string `json:"bot"`
+	// Group is the ID of the button group.
+	Group string `json:"group"`
+	// Name is the name of the button.
+	Name string `json:"name"`
+	// Value is the value of the button.
+	Value string `json:"value"`
+}
+
+// ActionCallback is the request sent by Mattermost to the integration URL of
+// a button when it is clicked.
+type ActionCallback struct {
+	UserID      string        `json:"user_id"`
+	UserName    string        `json:"user_name"`
+	ChannelID   string        `json:"channel_id"`
+	ChannelName string        `json:"channel_name"`
+	TeamID      string        `json:"team_id"`
+	PostID      string        `json:"post_id"`
+	TriggerID   string        `json:"trigger_id"`
+	Context     ActionContext `json:"context"`
+}
+
+// WebhookService is a service to handle the integration actions of the
+// buttons of mattermost interactive messages.
+type WebhookService struct {
+	token     string
+	callbacks chan ActionCallback
+}
+
+// NewWebhookService returns a new WebhookService with the given token.
+func NewWebhookService(token string) *WebhookService {
+	return &WebhookService{
+		token:     token,
+		callbacks: make(chan ActionCallback, 1),
+	}
+}
+
+// Consume returns a channel where callbacks will be sent.
+func (s *WebhookService) Consume() <-chan ActionCallback {
+	return s.callbacks
+}
+
+// ServeHTTP is the actual HTTP handler of the service.
+func (s *WebhookService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var callback ActionCallback
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		log15.Error("error decoding request body", "err", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if callback.Context.Token != s.token {
+		log15.Warn("received action callback token does not match", "token", callback.Context.Token)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.callbacks <- callback
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
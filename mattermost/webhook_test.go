@@ -0,0 +1,66 @@
+package mattermost
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testCallback = `{
+  "user_id": "jjjjjjjjjjjjjjjjjjjjjjjjjj",
+  "user_name": "jane",
+  "channel_id": "town",
+  "channel_name": "town-square",
+  "team_id": "team",
+  "team_domain": "example",
+  "post_id": "form",
+  "trigger_id": "trigger",
+  "type": "",
+  "data_source": "",
+  "context": {
+    "token": "xAB3yVzGS4BQ3O9FACTa8Ho4",
+    "bot": "bot",
+    "group": "deploy",
+    "name": "yes",
+    "value": "yes"
+  }
+}`
+
+func TestWebhook(t *testing.T) {
+	require := require.New(t)
+	cases := []struct {
+		body          string
+		status        int
+		shouldConsume bool
+		group         string
+	}{
+		{"", http.StatusBadRequest, false, ""},
+		{"skdjadljsal", http.StatusBadRequest, false, ""},
+		{`{"context": {"token": "fooo"}}`, http.StatusUnauthorized, false, ""},
+		{testCallback, http.StatusOK, true, "deploy"},
+	}
+
+	for _, c := range cases {
+		w := NewWebhookService("xAB3yVzGS4BQ3O9FACTa8Ho4")
+		srv := httptest.NewServer(w)
+
+		resp, err := http.Post(srv.URL, "application/json", bytes.NewBufferString(c.body))
+		require.Nil(err)
+		require.Equal(resp.StatusCode, c.status)
+
+		if c.shouldConsume {
+			select {
+			case cb := <-w.Consume():
+				require.Equal(cb.Context.Group, c.group)
+				require.Equal(cb.PostID, "form")
+			case <-time.After(10 * time.Millisecond):
+				require.FailNow("timeout")
+			}
+		}
+		srv.Close()
+	}
+}
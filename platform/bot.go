@@ -0,0 +1,314 @@
+package platform
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+)
+
+type bot struct {
+	id      string
+	channel flamingo.Channel
+	api     API
+	msgs    <-chan flamingo.Message
+	actions chan ActionEvent
+	done    <-chan struct{}
+	clock   flamingo.Clock
+	log     flamingo.MessageLog
+}
+
+func (b *bot) ID() string {
+	return b.id
+}
+
+func (b *bot) Reply(replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error) {
+	if r, ok := b.api.(Replier); ok {
+		channel := b.channelFor(msg)
+		id, err := r.ReplyMessage(channel, replyTo, msg)
+		if err == nil {
+			b.logSent(flamingo.MessageSent, channel, id, msg.Text)
+		}
+		return id, err
+	}
+
+	msg.Text = fmt.Sprintf("@%s: %s", replyTo.User.Username, msg.Text)
+	return b.Say(msg)
+}
+
+func (b *bot) Ask(msg flamingo.OutgoingMessage) (string, flamingo.Message, error) {
+	id, err := b.Say(msg)
+	if err != nil {
+		return "", flamingo.Message{}, err
+	}
+
+	message, err := b.WaitForMessage()
+	return id, message, err
+}
+
+func (b *bot) WaitForMessage() (flamingo.Message, error) {
+	msg, ok := <-b.msgs
+	if !ok {
+		return flamingo.Message{}, nil
+	}
+	return msg, nil
+}
+
+func (b *bot) Conversation(convo flamingo.Conversation) ([]string, []flamingo.Message, error) {
+	var messages = make([]flamingo.Message, 0, len(convo))
+	var ids = make([]string, 0, len(convo))
+	for _, m := range convo {
+		id, err := b.Say(m)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ids = append(ids, id)
+
+		msg, err := b.WaitForMessage()
+		if err != nil {
+			return nil, nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return ids, messages, nil
+}
+
+func (b *bot) channelFor(msg flamingo.OutgoingMessage) string {
+	if msg.ChannelID != "" {
+		return msg.ChannelID
+	}
+	return b.channel.ID
+}
+
+func (b *bot) Say(msg flamingo.OutgoingMessage) (string, error) {
+	channel := b.channelFor(msg)
+	id, err := b.api.PostMessage(channel, msg)
+	if err != nil {
+		log15.Error("error posting message to channel", "channel", channel, "error", err.Error(), "text", msg.Text)
+	} else {
+		b.logSent(flamingo.MessageSent, channel, id, msg.Text)
+	}
+
+	return id, err
+}
+
+func (b *bot) SayTo(user string, msg flamingo.OutgoingMessage) (string, string, error) {
+	channel, err := b.api.DirectChannel(user)
+	if err != nil {
+		log15.Error("error opening direct channel", "user", user, "err", err.Error())
+		return "", "", err
+	}
+
+	id, err := b.api.PostMessage(channel, msg)
+	if err != nil {
+		log15.Error("error posting message to user", "user", user, "error", err.Error(), "text", msg.Text)
+	} else {
+		b.logSent(flamingo.MessageSent, channel, id, msg.Text)
+	}
+
+	return id, channel, err
+}
+
+func (b *bot) WaitForAction(id string, policy flamingo.ActionWaitingPolicy) (flamingo.Action, error) {
+	return b.WaitForActions([]string{id}, policy)
+}
+
+func (b *bot) WaitForActions(ids []string, policy flamingo.ActionWaitingPolicy) (flamingo.Action, error) {
+	for {
+		select {
+		case action, ok := <-b.actions:
+			if !ok {
+				continue
+			}
+
+			if inSlice(ids, action.ID) {
+				return action.Action, nil
+			} else if policy.Reply {
+				log15.Debug("received action with another id waiting for action", "id", action.ID)
+				if _, err := b.Say(flamingo.NewOutgoingMessage(policy.Message)); err != nil {
+					return flamingo.Action{}, err
+				}
+			}
+		case m, ok := <-b.msgs:
+			if !ok {
+				continue
+			}
+
+			if policy.Reply {
+				log15.Debug("received msg waiting for action, replying default msg", "text", m.Text)
+				if _, err := b.Say(flamingo.NewOutgoingMessage(policy.Message)); err != nil {
+					return flamingo.Action{}, err
+				}
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func inSlice(slice []string, str string) bool {
+	for _, s := range slice {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bot) Form(form flamingo.Form) (string, error) {
+	id, err := b.api.PostForm(b.channel.ID, form)
+	if err != nil {
+		log15.Error("error posting form", "err", err.Error())
+	} else {
+		b.logSent(flamingo.MessageSent, b.channel.ID, id, formText(form))
+	}
+
+	return id, err
+}
+
+func (b *bot) SendFormTo(user string, form flamingo.Form) (string, string, error) {
+	channel, err := b.api.DirectChannel(user)
+	if err != nil {
+		log15.Error("error opening direct channel", "user", user, "err", err.Error())
+		return "", "", err
+	}
+
+	id, err := b.api.PostForm(channel, form)
+	if err != nil {
+		log15.Error("error posting form", "err", err.Error())
+	} else {
+		b.logSent(flamingo.MessageSent, channel, id, formText(form))
+	}
+
+	return id, channel, err
+}
+
+func (b *bot) Image(img flamingo.Image) (string, error) {
+	id, err := b.api.PostImage(b.channel.ID, img)
+	if err != nil {
+		log15.Error("error posting image", "err", err.Error())
+	} else {
+		b.logSent(flamingo.MessageSent, b.channel.ID, id, img.Text)
+	}
+
+	return id, err
+}
+
+func (b *bot) UpdateMessage(id string, replacement string) (string, error) {
+	newID, err := b.api.UpdateMessage(b.channel.ID, id, replacement)
+	if err != nil {
+		log15.Error("error updating message", "id", id, "err", err.Error())
+	} else {
+		b.logSent(flamingo.MessageUpdated, b.channel.ID, newID, replacement)
+	}
+
+	return newID, err
+}
+
+func (b *bot) UpdateForm(id string, replacement flamingo.Form) (string, error) {
+	newID, err := b.api.UpdateForm(b.channel.ID, id, replacement)
+	if err != nil {
+		log15.Error("error updating form", "id", id, "err", err.Error())
+	} else {
+		b.logSent(flamingo.MessageUpdated, b.channel.ID, newID, formText(replacement))
+	}
+
+	return newID, err
+}
+
+func (b *bot) AskUntil(msg flamingo.OutgoingMessage, check flamingo.AnswerChecker) (string, flamingo.Message, error) {
+	for {
+		id, m, err := b.Ask(msg)
+		if err != nil {
+			return "", flamingo.Message{}, err
+		}
+
+		errMsg := check(m)
+		if errMsg == nil {
+			return id, m, nil
+		}
+		msg = *errMsg
+	}
+}
+
+func (b *bot) InvokeAction(id string, user flamingo.User, action flamingo.UserAction) {
+	event := ActionEvent{
+		ID: id,
+		Action: flamingo.Action{
+			UserAction: action,
+			User:       user,
+			Channel:    b.channel,
+		},
+	}
+
+	select {
+	case b.actions <- event:
+	case <-b.done:
+		log15.Debug("conversation stopped, dropping invoked action", "id", id)
+	}
+}
+
+func (b *bot) History(n int) ([]flamingo.LogEntry, error) {
+	return b.HistoryBetween(time.Time{}, time.Time{}, n)
+}
+
+func (b *bot) HistoryBetween(since, until time.Time, n int) ([]flamingo.LogEntry, error) {
+	if b.log == nil {
+		return nil, flamingo.ErrNoMessageLog
+	}
+
+	return b.log.History(flamingo.LogQuery{
+		Bot:     b.id,
+		Channel: b.channel.ID,
+		Since:   since,
+		Until:   until,
+		Limit:   n,
+	})
+}
+
+// logSent records a message sent by the bot in the message log, if any.
+func (b *bot) logSent(kind flamingo.LogEntryKind, channel, id, text string) {
+	if b.log == nil {
+		return
+	}
+
+	logEntry(b.log, flamingo.LogEntry{
+		Kind:    kind,
+		Bot:     b.id,
+		Channel: channel,
+		ID:      id,
+		Time:    b.clock.Now(),
+		Text:    text,
+	})
+}
+
+// logEntry records the entry in the given message log, if any. The extra
+// data of the user is not recorded. Errors are only logged, so a failing
+// message log does not stop the conversations.
+func logEntry(log flamingo.MessageLog, entry flamingo.LogEntry) {
+	if log == nil {
+		return
+	}
+
+	entry.User.Extra = nil
+	if err := log.Log(entry); err != nil {
+		log15.Error("error recording message in the message log", "bot", entry.Bot, "channel", entry.Channel, "err", err.Error())
+	}
+}
+
+// formText returns the text of the form as it is recorded in the message
+// log.
+func formText(form flamingo.Form) string {
+	if form.Text == "" {
+		return form.Title
+	}
+
+	if form.Title == "" {
+		return form.Text
+	}
+
+	return form.Title + "\n" + form.Text
+}
@@ -0,0 +1,311 @@
+package platform
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+)
+
+// reconnectDelay is the time to wait before running again a connection that
+// failed.
+const reconnectDelay = 5 * time.Second
+
+type botClient struct {
+	sync.RWMutex
+	id            string
+	conn          Connection
+	conversations map[string]*conversation
+	delegate      handlerDelegate
+	stopped       chan struct{}
+	done          chan struct{}
+}
+
+func newBotClient(id string, delegate handlerDelegate) *botClient {
+	return &botClient{
+		id:            id,
+		conversations: make(map[string]*conversation),
+		delegate:      delegate,
+		stopped:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (c *botClient) run() {
+	defer close(c.done)
+	for {
+		err := c.runConnection()
+		select {
+		case <-c.stopped:
+			return
+		default:
+		}
+
+		if err == ErrInvalidCredentials {
+			log15.Crit("invalid credentials for bot, removing it", "bot", c.id)
+			// the bot is removed in another goroutine because it waits for
+			// this one to finish
+			go c.delegate.removeBot(c.id)
+			return
+		}
+
+		if err != nil {
+			log15.Error("bot connection failed, reconnecting", "bot", c.id, "err", err.Error())
+		}
+
+		select {
+		case <-c.stopped:
+			return
+		case <-c.delegate.Clock().After(reconnectDelay):
+		}
+	}
+}
+
+func (c *botClient) runConnection() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				log15.Error("panic caught on bot connection", "err", e.Error())
+			}
+
+			if handler := c.delegate.ErrorHandler(); handler != nil {
+				handler(r)
+			}
+		}
+	}()
+
+	log15.Info("starting bot connection", "bot", c.id)
+	return c.conn.Run()
+}
+
+// Message implements the Events interface.
+func (c *botClient) Message(msg flamingo.Message) {
+	conv, err := c.conversationFor(msg.Channel)
+	if err != nil {
+		log15.Error("unable to create conversation for bot", "channel", msg.Channel.ID, "bot", c.id, "error", err.Error())
+		return
+	}
+
+	log15.Debug("message for channel", "channel", msg.Channel.ID, "text", msg.Text, "from", msg.User.ID)
+	logEntry(c.delegate.MessageLog(), flamingo.LogEntry{
+		Kind:    flamingo.MessageReceived,
+		Bot:     c.id,
+		Channel: msg.Channel.ID,
+		ID:      msg.ID,
+		Time:    c.delegate.Clock().Now(),
+		User:    msg.User,
+		Text:    msg.Text,
+	})
+	conv.sendMessage(msg)
+}
+
+// Action implements the Events interface.
+func (c *botClient) Action(action ActionEvent) {
+	conv, err := c.conversationFor(action.Action.Channel)
+	if err != nil {
+		log15.Error("unable to create conversation for bot", "channel", action.Action.Channel.ID, "bot", c.id, "error", err.Error())
+		return
+	}
+
+	userAction := action.Action.UserAction
+	logEntry(c.delegate.MessageLog(), flamingo.LogEntry{
+		Kind:    flamingo.ActionReceived,
+		Bot:     c.id,
+		Channel: action.Action.Channel.ID,
+		ID:      action.Action.OriginalMessage.ID,
+		Time:    c.delegate.Clock().Now(),
+		User:    action.Action.User,
+		Text:    userAction.Value,
+		Action:  &userAction,
+	})
+	conv.sendAction(action)
+}
+
+// Joined implements the Events interface.
+func (c *botClient) Joined(channel flamingo.Channel) {
+	c.RLock()
+	_, ok := c.conversations[channel.ID]
+	c.RUnlock()
+	if ok {
+		return
+	}
+
+	conv, _, err := c.openConversation(c.newStoredConversation(channel.ID), channel, true)
+	if err != nil {
+		log15.Error("unable to create conversation for bot", "channel", channel.ID, "bot", c.id, "error", err.Error())
+		return
+	}
+
+	conv.handleIntro()
+}
+
+// Left implements the Events interface.
+func (c *botClient) Left(channel string) {
+	c.Lock()
+	conv, ok := c.conversations[channel]
+	delete(c.conversations, channel)
+	c.Unlock()
+
+	if ok {
+		log15.Debug("bot left channel, stopping conversation", "channel", channel, "bot", c.id)
+		conv.stop()
+	}
+
+	err := c.delegate.Storage().RemoveConversation(flamingo.StoredConversation{
+		ID:    channel,
+		BotID: c.id,
+	})
+	if err != nil {
+		log15.Error("unable to remove conversation", "channel", channel, "bot", c.id, "error", err.Error())
+	}
+}
+
+// conversationFor returns the conversation of the given channel, creating it
+// if it does not exist yet. Conversations that were not stored yet are
+// introduced.
+func (c *botClient) conversationFor(channel flamingo.Channel) (*conversation, error) {
+	c.RLock()
+	conv, ok := c.conversations[channel.ID]
+	c.RUnlock()
+	if ok {
+		return conv, nil
+	}
+
+	conv, alreadyStored, err := c.openConversation(c.newStoredConversation(channel.ID), channel, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !alreadyStored {
+		conv.handleIntro()
+	}
+
+	return conv, nil
+}
+
+func (c *botClient) newStoredConversation(channel string) flamingo.StoredConversation {
+	return flamingo.StoredConversation{
+		ID:        channel,
+		BotID:     c.id,
+		CreatedAt: c.delegate.Clock().Now(),
+	}
+}
+
+// openConversation starts the given conversation and stores it if it was not
+// already stored. If lookup is true and the conversation was already stored,
+// the stored data will be attached to the conversation instead of the given one.
+func (c *botClient) openConversation(stored flamingo.StoredConversation, channel flamingo.Channel, lookup bool) (*conversation, bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	if conv, ok := c.conversations[stored.ID]; ok {
+		return conv, true, nil
+	}
+
+	storage := c.delegate.Storage()
+	ok, err := storage.ConversationExists(stored)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !ok {
+		if err := storage.StoreConversation(stored); err != nil {
+			return nil, false, err
+		}
+	} else if lookup {
+		stored, err = c.storedConversation(stored)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	log15.Debug("starting conversation for bot", "channel", stored.ID, "bot", c.id)
+	conv := newConversation(c.id, channel, c.conn, c.delegate)
+	conv.stored = stored
+	c.conversations[stored.ID] = conv
+	go conv.run()
+	return conv, ok, nil
+}
+
+func (c *botClient) storedConversation(conversation flamingo.StoredConversation) (flamingo.StoredConversation, error) {
+	convs, err := c.delegate.Storage().LoadConversations(flamingo.StoredBot{ID: c.id})
+	if err != nil {
+		return conversation, err
+	}
+
+	for _, conv := range convs {
+		if conv.ID == conversation.ID {
+			return conv, nil
+		}
+	}
+
+	return conversation, nil
+}
+
+func (c *botClient) addConversation(conversation flamingo.StoredConversation) error {
+	conversation.BotID = c.id
+	channel, err := c.conn.Channel(conversation.ID)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = c.openConversation(conversation, channel, false)
+	return err
+}
+
+func (c *botClient) handleJob(job flamingo.Job) (conversations uint64, errors uint64) {
+	var wg sync.WaitGroup
+	c.RLock()
+	for _, conv := range c.conversations {
+		conversations++
+		wg.Add(1)
+		go func(conv *conversation) {
+			defer wg.Done()
+			if err := conv.handleJob(job); err != nil {
+				atomic.AddUint64(&errors, 1)
+			}
+		}(conv)
+	}
+	c.RUnlock()
+	wg.Wait()
+	return
+}
+
+func (c *botClient) broadcastTargets(filter flamingo.BroadcastFilter) []flamingo.BroadcastTarget {
+	c.RLock()
+	defer c.RUnlock()
+	var targets []flamingo.BroadcastTarget
+	for _, conv := range c.conversations {
+		target := flamingo.BroadcastTarget{
+			BotID:        c.id,
+			Channel:      conv.channel,
+			Conversation: conv.stored,
+		}
+
+		// the bot is only created for the conversations that pass the filter
+		if filter(target) {
+			target.Bot = conv.createBot()
+			targets = append(targets, target)
+		}
+	}
+
+	return targets
+}
+
+func (c *botClient) stop() {
+	close(c.stopped)
+	if err := c.conn.Close(); err != nil {
+		log15.Error("error closing bot connection", "bot", c.id, "err", err.Error())
+	}
+	<-c.done
+
+	c.Lock()
+	defer c.Unlock()
+	for id, conv := range c.conversations {
+		log15.Debug("shutting down conversation", "channel", id)
+		conv.stop()
+		log15.Debug("shut down conversation", "channel", id)
+	}
+}
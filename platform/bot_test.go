@@ -0,0 +1,230 @@
+package platform
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+func newTestBot(api API) (*bot, chan flamingo.Message) {
+	msgs := make(chan flamingo.Message, 10)
+	return &bot{
+		id:      "bot",
+		channel: flamingo.Channel{ID: "C1"},
+		api:     api,
+		msgs:    msgs,
+		actions: make(chan ActionEvent, 10),
+		clock:   flamingo.NewClock(),
+	}, msgs
+}
+
+type replierMock struct {
+	*apiMock
+	replyTo string
+}
+
+func (r *replierMock) ReplyMessage(channel string, replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error) {
+	r.replyTo = replyTo.ID
+	return r.PostMessage(channel, msg)
+}
+
+func TestBotSay(t *testing.T) {
+	require := require.New(t)
+	api := newAPIMock()
+	b, _ := newTestBot(api)
+
+	id, err := b.Say(flamingo.NewOutgoingMessage("hi"))
+	require.Nil(err)
+	require.Equal("1", id)
+
+	_, err = b.Say(flamingo.OutgoingMessage{Text: "there", ChannelID: "C2"})
+	require.Nil(err)
+
+	_, err = b.Reply(flamingo.Message{User: flamingo.User{Username: "jane"}}, flamingo.NewOutgoingMessage("yes"))
+	require.Nil(err)
+
+	id, channel, err := b.SayTo("jane", flamingo.NewOutgoingMessage("psst"))
+	require.Nil(err)
+	require.Equal("4", id)
+	require.Equal("Djane", channel)
+
+	_, _, err = b.SayTo("nobody", flamingo.NewOutgoingMessage("psst"))
+	require.NotNil(err)
+
+	require.Equal([]posted{
+		{channel: "C1", text: "hi"},
+		{channel: "C2", text: "there"},
+		{channel: "C1", text: "@jane: yes"},
+		{channel: "Djane", text: "psst"},
+	}, api.all())
+}
+
+func TestBotReplier(t *testing.T) {
+	require := require.New(t)
+	api := &replierMock{apiMock: newAPIMock()}
+	b, _ := newTestBot(api)
+
+	_, err := b.Reply(flamingo.Message{ID: "42"}, flamingo.NewOutgoingMessage("yes"))
+	require.Nil(err)
+	require.Equal("42", api.replyTo)
+	require.Equal([]posted{{channel: "C1", text: "yes"}}, api.all())
+}
+
+func TestBotFormsAndImages(t *testing.T) {
+	require := require.New(t)
+	api := newAPIMock()
+	b, _ := newTestBot(api)
+
+	_, err := b.Form(flamingo.Form{Title: "form"})
+	require.Nil(err)
+	_, channel, err := b.SendFormTo("jane", flamingo.Form{Title: "dm"})
+	require.Nil(err)
+	require.Equal("Djane", channel)
+	_, err = b.Image(flamingo.Image{URL: "http://img"})
+	require.Nil(err)
+
+	_, err = b.UpdateMessage("1", "new")
+	require.Nil(err)
+	_, err = b.UpdateForm("2", flamingo.Form{Title: "new form"})
+	require.Nil(err)
+
+	posted := api.all()
+	require.Equal("form", posted[0].form.Title)
+	require.Equal("dm", posted[1].form.Title)
+	require.Equal("http://img", posted[2].image.URL)
+	require.Equal(map[string]string{"1": "new", "2": "new form"}, api.updated)
+}
+
+func TestBotAskUntil(t *testing.T) {
+	require := require.New(t)
+	api := newAPIMock()
+	b, msgs := newTestBot(api)
+	msgs <- flamingo.Message{Text: "no"}
+	msgs <- flamingo.Message{Text: "yes"}
+
+	_, msg, err := b.AskUntil(flamingo.NewOutgoingMessage("ok?"), func(m flamingo.Message) *flamingo.OutgoingMessage {
+		if m.Text == "yes" {
+			return nil
+		}
+		retry := flamingo.NewOutgoingMessage("again?")
+		return &retry
+	})
+	require.Nil(err)
+	require.Equal("yes", msg.Text)
+	require.Equal(2, len(api.all()))
+	require.Equal("again?", api.all()[1].text)
+}
+
+func TestBotConversation(t *testing.T) {
+	require := require.New(t)
+	api := newAPIMock()
+	b, msgs := newTestBot(api)
+	msgs <- flamingo.Message{Text: "a"}
+	msgs <- flamingo.Message{Text: "b"}
+
+	ids, replies, err := b.Conversation(flamingo.Conversation{
+		flamingo.NewOutgoingMessage("1?"),
+		flamingo.NewOutgoingMessage("2?"),
+	})
+	require.Nil(err)
+	require.Equal([]string{"1", "2"}, ids)
+	require.Equal("a", replies[0].Text)
+	require.Equal("b", replies[1].Text)
+}
+
+func TestBotWaitForActions(t *testing.T) {
+	require := require.New(t)
+	api := newAPIMock()
+	b, msgs := newTestBot(api)
+	msgs <- flamingo.Message{Text: "hey"}
+	b.InvokeAction("other", flamingo.User{ID: "U1"}, flamingo.UserAction{Value: "x"})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		b.InvokeAction("wanted", flamingo.User{ID: "U1"}, flamingo.UserAction{Value: "y"})
+	}()
+
+	action, err := b.WaitForAction("wanted", flamingo.ReplyPolicy("click it"))
+	require.Nil(err)
+	require.Equal("y", action.UserAction.Value)
+	require.Equal("U1", action.User.ID)
+	require.Equal("C1", action.Channel.ID)
+	require.Equal([]posted{
+		{channel: "C1", text: "click it"},
+		{channel: "C1", text: "click it"},
+	}, api.all())
+}
+
+func TestBotHistory(t *testing.T) {
+	require := require.New(t)
+	api := newAPIMock()
+	b, _ := newTestBot(api)
+
+	_, err := b.History(10)
+	require.Equal(flamingo.ErrNoMessageLog, err)
+
+	b.log = flamingo.NewMessageLog(flamingo.RetentionPolicy{})
+	_, err = b.Say(flamingo.NewOutgoingMessage("hi"))
+	require.Nil(err)
+	_, _, err = b.SayTo("jane", flamingo.NewOutgoingMessage("psst"))
+	require.Nil(err)
+	id, err := b.Form(flamingo.Form{Title: "title", Text: "text"})
+	require.Nil(err)
+	_, err = b.UpdateMessage(id, "updated")
+	require.Nil(err)
+
+	entries, err := b.History(0)
+	require.Nil(err)
+	require.Equal(3, len(entries))
+	require.Equal("hi", entries[0].Text)
+	require.Equal(flamingo.MessageSent, entries[0].Kind)
+	require.Equal("title\ntext", entries[1].Text)
+	require.Equal(id, entries[1].ID)
+
+	entries, err = b.History(1)
+	require.Nil(err)
+	require.Equal(1, len(entries))
+	require.Equal(flamingo.MessageUpdated, entries[0].Kind)
+	require.Equal("updated", entries[0].Text)
+
+	entries, err = b.log.History(flamingo.LogQuery{Bot: "bot", Channel: "Djane"})
+	require.Nil(err)
+	require.Equal(1, len(entries))
+	require.Equal("psst", entries[0].Text)
+}
+
+func TestBotHistoryBetween(t *testing.T) {
+	require := require.New(t)
+	api := newAPIMock()
+	b, _ := newTestBot(api)
+
+	start := time.Date(2017, time.March, 1, 10, 0, 0, 0, time.UTC)
+	clock := flamingo.NewFakeClock(start)
+	b.clock = clock
+
+	_, err := b.HistoryBetween(start, time.Time{}, 0)
+	require.Equal(flamingo.ErrNoMessageLog, err)
+
+	b.log = flamingo.NewMessageLog(flamingo.RetentionPolicy{})
+	for _, text := range []string{"a", "b", "c", "d"} {
+		_, err := b.Say(flamingo.NewOutgoingMessage(text))
+		require.Nil(err)
+		clock.Advance(time.Minute)
+	}
+
+	texts := func(entries []flamingo.LogEntry, err error) []string {
+		require.Nil(err)
+		var result []string
+		for _, e := range entries {
+			result = append(result, e.Text)
+		}
+		return result
+	}
+
+	require.Equal([]string{"b", "c", "d"}, texts(b.HistoryBetween(start.Add(time.Minute), time.Time{}, 0)))
+	require.Equal([]string{"a", "b"}, texts(b.HistoryBetween(time.Time{}, start.Add(2*time.Minute), 0)))
+	require.Equal([]string{"c"}, texts(b.HistoryBetween(start.Add(time.Minute), start.Add(3*time.Minute), 1)))
+}
@@ -0,0 +1,489 @@
+package platform
+
+import (
+	"io"
+	"sync"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/storage"
+)
+
+// Options are the configurable options of a platform client.
+type Options struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+}
+
+// Client is a flamingo.Client for any Platform. Platform-specific clients
+// usually embed it and extend Run and Stop to start and stop their own
+// services, such as webhook servers.
+type Client struct {
+	sync.RWMutex
+	platform       Platform
+	options        Options
+	controllers    []flamingo.Controller
+	actionHandlers map[string]flamingo.ActionHandler
+	middlewares    []flamingo.Middleware
+	bots           map[string]*botClient
+	introHandler   flamingo.IntroHandler
+	errorHandler   flamingo.ErrorHandler
+	storage        flamingo.Storage
+	messageLog     flamingo.MessageLog
+	scheduler      *flamingo.Scheduler
+	shutdown       chan struct{}
+}
+
+// NewClient creates a new Client for the given platform.
+func NewClient(platform Platform, options Options) *Client {
+	if options.Clock == nil {
+		options.Clock = flamingo.NewClock()
+	}
+
+	cli := &Client{
+		platform:       platform,
+		options:        options,
+		actionHandlers: make(map[string]flamingo.ActionHandler),
+		bots:           make(map[string]*botClient),
+		storage:        storage.NewMemory(),
+		shutdown:       make(chan struct{}, 1),
+	}
+
+	cli.scheduler = flamingo.NewScheduler(cli.runJob, options.Clock)
+	cli.SetLogOutput(nil)
+	return cli
+}
+
+// SetLogOutput will write the logs to the given io.Writer.
+func (c *Client) SetLogOutput(w io.Writer) {
+	handler := log15.StdoutHandler
+	if w != nil {
+		handler = log15.MultiHandler(
+			log15.StdoutHandler,
+			log15.StreamHandler(w, log15.LogfmtFormat()),
+		)
+	}
+
+	var maxLvl = log15.LvlInfo
+	if c.options.Debug {
+		maxLvl = log15.LvlDebug
+	}
+
+	log15.Root().SetHandler(log15.LvlFilterHandler(maxLvl, handler))
+}
+
+// Clock returns the clock of the client.
+func (c *Client) Clock() flamingo.Clock {
+	return c.options.Clock
+}
+
+// Use adds one or more middlewares.
+func (c *Client) Use(middlewares ...flamingo.Middleware) {
+	c.Lock()
+	defer c.Unlock()
+	for _, m := range middlewares {
+		if m != nil {
+			c.middlewares = append(c.middlewares, m)
+		}
+	}
+}
+
+// AddController adds a new Controller to the Client.
+func (c *Client) AddController(ctrl flamingo.Controller) {
+	c.Lock()
+	defer c.Unlock()
+	c.controllers = append(c.controllers, ctrl)
+}
+
+// ControllerFor returns the handler of the first controller that can handle
+// the given message, wrapped with the middlewares of the client.
+func (c *Client) ControllerFor(msg flamingo.Message) (flamingo.HandlerFunc, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, ctrl := range c.controllers {
+		if ctrl.CanHandle(msg) {
+			return c.wrap(ctrl.Handle), true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *Client) wrap(handler flamingo.HandlerFunc) flamingo.HandlerFunc {
+	if len(c.middlewares) == 0 {
+		return handler
+	}
+
+	var middlewares = make([]flamingo.Middleware, len(c.middlewares))
+	copy(middlewares, c.middlewares)
+
+	return func(bot flamingo.Bot, msg flamingo.Message) error {
+		var (
+			idx  int
+			next flamingo.HandlerFunc
+		)
+
+		next = func(bot flamingo.Bot, msg flamingo.Message) error {
+			idx++
+			if idx >= len(middlewares) {
+				return handler(bot, msg)
+			}
+
+			return middlewares[idx](bot, msg, next)
+		}
+
+		return middlewares[0](bot, msg, next)
+	}
+}
+
+// AddActionHandler adds an ActionHandler for the given ID.
+func (c *Client) AddActionHandler(id string, handler flamingo.ActionHandler) {
+	c.Lock()
+	defer c.Unlock()
+	log15.Debug("added action handler", "id", id)
+	c.actionHandlers[id] = handler
+}
+
+// ActionHandler returns the ActionHandler for the given ID.
+func (c *Client) ActionHandler(id string) (flamingo.ActionHandler, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	handler, ok := c.actionHandlers[id]
+	return handler, ok
+}
+
+// SetIntroHandler sets the IntroHandler for the client.
+func (c *Client) SetIntroHandler(handler flamingo.IntroHandler) {
+	c.Lock()
+	defer c.Unlock()
+	c.introHandler = handler
+}
+
+// HandleIntro runs the IntroHandler of the client, if any, on the given
+// channel.
+func (c *Client) HandleIntro(bot flamingo.Bot, channel flamingo.Channel) {
+	c.RLock()
+	handler := c.introHandler
+	c.RUnlock()
+
+	if handler == nil {
+		log15.Warn("there is no intro handler, ignoring")
+		return
+	}
+
+	if err := handler.HandleIntro(bot, channel); err != nil {
+		log15.Error("error handling intro", "channel", channel.ID, "err", err.Error())
+	}
+}
+
+// SetErrorHandler sets the error handler of the client.
+func (c *Client) SetErrorHandler(handler flamingo.ErrorHandler) {
+	c.Lock()
+	defer c.Unlock()
+	c.errorHandler = handler
+}
+
+// ErrorHandler returns the error handler of the client.
+func (c *Client) ErrorHandler() flamingo.ErrorHandler {
+	c.RLock()
+	defer c.RUnlock()
+	return c.errorHandler
+}
+
+// SetStorage sets the storage to be used to store conversations and bots.
+func (c *Client) SetStorage(storage flamingo.Storage) {
+	c.Lock()
+	defer c.Unlock()
+	c.storage = storage
+}
+
+// Storage returns the storage of the client.
+func (c *Client) Storage() flamingo.Storage {
+	c.RLock()
+	defer c.RUnlock()
+	return c.storage
+}
+
+// AddBot adds a new bot with an ID and a token and connects it to the
+// platform. The bot is connected without holding the lock of the client, so
+// a slow connection does not block the rest of its methods.
+func (c *Client) AddBot(id, token string, extra interface{}) {
+	bot := flamingo.StoredBot{
+		ID:        id,
+		Token:     token,
+		CreatedAt: c.options.Clock.Now(),
+		Extra:     extra,
+	}
+
+	storage := c.Storage()
+	ok, err := storage.BotExists(bot)
+	if err != nil {
+		log15.Error("unable to check if bot exists", "id", id, "err", err.Error())
+		return
+	}
+
+	if !ok {
+		if err := storage.StoreBot(bot); err != nil {
+			log15.Error("unable to add bot", "id", id, "err", err.Error())
+			return
+		}
+	}
+
+	c.RLock()
+	_, ok = c.bots[id]
+	c.RUnlock()
+	if ok {
+		return
+	}
+
+	b := newBotClient(id, c)
+	conn, err := c.platform.Connect(bot, b)
+	if err != nil {
+		log15.Error("unable to connect bot", "id", id, "err", err.Error())
+		return
+	}
+
+	c.Lock()
+	if _, ok := c.bots[id]; ok {
+		// the bot was added by someone else while connecting
+		c.Unlock()
+		if err := conn.Close(); err != nil {
+			log15.Error("unable to close duplicated connection", "id", id, "err", err.Error())
+		}
+		return
+	}
+
+	b.conn = conn
+	c.bots[id] = b
+	c.Unlock()
+	go b.run()
+}
+
+// removeBot stops the bot with the given ID and removes it, along with its
+// conversations, from the storage so it is not loaded again.
+func (c *Client) removeBot(id string) {
+	c.Lock()
+	b, ok := c.bots[id]
+	delete(c.bots, id)
+	c.Unlock()
+
+	if ok {
+		log15.Debug("shutting down bot", "id", id)
+		b.stop()
+	}
+
+	if err := c.Storage().RemoveBot(flamingo.StoredBot{ID: id}); err != nil {
+		log15.Error("unable to remove bot", "id", id, "err", err.Error())
+	}
+}
+
+// Events returns the Events of the bot with the given ID, which can be used
+// to deliver events received outside the bot connection, such as the ones
+// coming from a webhook.
+func (c *Client) Events(bot string) (Events, error) {
+	c.RLock()
+	defer c.RUnlock()
+	b, ok := c.bots[bot]
+	if !ok {
+		return nil, ErrBotNotFound
+	}
+	return b, nil
+}
+
+// Connection returns the connection of the bot with the given ID.
+func (c *Client) Connection(bot string) (Connection, error) {
+	c.RLock()
+	defer c.RUnlock()
+	b, ok := c.bots[bot]
+	if !ok {
+		return nil, ErrBotNotFound
+	}
+	return b.conn, nil
+}
+
+// AddScheduledJob will run the given Job forever according to the
+// given schedule.
+func (c *Client) AddScheduledJob(name string, schedule flamingo.ScheduleTime, job flamingo.Job) error {
+	return c.scheduler.Add(name, schedule, job)
+}
+
+// RemoveScheduledJob removes the scheduled job with the given name.
+func (c *Client) RemoveScheduledJob(name string) error {
+	return c.scheduler.Remove(name)
+}
+
+// RunJob runs the scheduled job with the given name immediately.
+func (c *Client) RunJob(name string) (flamingo.JobRun, error) {
+	return c.scheduler.Run(name)
+}
+
+// PauseJob pauses the scheduled job with the given name.
+func (c *Client) PauseJob(name string) error {
+	return c.scheduler.Pause(name)
+}
+
+// ResumeJob resumes the scheduled job with the given name.
+func (c *Client) ResumeJob(name string) error {
+	return c.scheduler.Resume(name)
+}
+
+// JobHistory returns the recorded runs of the scheduled job with the given
+// name.
+func (c *Client) JobHistory(name string) ([]flamingo.JobRun, error) {
+	return c.scheduler.History(name)
+}
+
+// SetJobHistory sets the store in which the runs of the scheduled jobs are
+// recorded.
+func (c *Client) SetJobHistory(history flamingo.JobHistory) {
+	c.scheduler.SetHistory(history)
+}
+
+// SetMessageLog sets the log in which the messages and actions received and
+// the messages sent by the bots are recorded.
+func (c *Client) SetMessageLog(log flamingo.MessageLog) {
+	c.Lock()
+	defer c.Unlock()
+	c.messageLog = log
+}
+
+// MessageLog returns the message log of the client, if any.
+func (c *Client) MessageLog() flamingo.MessageLog {
+	c.RLock()
+	defer c.RUnlock()
+	return c.messageLog
+}
+
+func (c *Client) runJob(job flamingo.Job) (conversations uint64, errors uint64) {
+	var (
+		wg  sync.WaitGroup
+		mut sync.Mutex
+	)
+
+	c.RLock()
+	for _, b := range c.bots {
+		wg.Add(1)
+		go func(b *botClient) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					if err, ok := r.(error); ok {
+						log15.Error("panic caught running scheduled job", "err", err.Error())
+					}
+
+					if handler := c.ErrorHandler(); handler != nil {
+						handler(r)
+					}
+				}
+			}()
+
+			convs, errs := b.handleJob(job)
+			mut.Lock()
+			conversations += convs
+			errors += errs
+			mut.Unlock()
+		}(b)
+	}
+	c.RUnlock()
+
+	wg.Wait()
+	return
+}
+
+// Broadcast sends the message to all the conversations that pass the filter.
+func (c *Client) Broadcast(msg flamingo.Sendable, filter flamingo.BroadcastFilter, opts flamingo.BroadcastOptions) (flamingo.BroadcastReport, error) {
+	report := <-c.BroadcastAsync(msg, filter, opts)
+	return report, report.Err()
+}
+
+// BroadcastAsync is the same as Broadcast, but it does not block.
+func (c *Client) BroadcastAsync(msg flamingo.Sendable, filter flamingo.BroadcastFilter, opts flamingo.BroadcastOptions) <-chan flamingo.BroadcastReport {
+	if opts.IsTransient == nil {
+		if checker, ok := c.platform.(TransientChecker); ok {
+			opts.IsTransient = checker.IsTransient
+		}
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = c.options.Clock
+	}
+
+	var targets []flamingo.BroadcastTarget
+	c.RLock()
+	for _, b := range c.bots {
+		targets = append(targets, b.broadcastTargets(filter)...)
+	}
+	c.RUnlock()
+
+	log15.Debug("broadcasting message", "conversations", len(targets))
+	return flamingo.RunBroadcast(msg, targets, opts)
+}
+
+func (c *Client) loadFromStorage() error {
+	log15.Info("Loading data from storage...")
+	defer log15.Info("Loaded data from storage...")
+
+	bots, err := c.Storage().LoadBots()
+	if err != nil {
+		return err
+	}
+
+	for _, b := range bots {
+		c.AddBot(b.ID, b.Token, b.Extra)
+
+		c.RLock()
+		bot, ok := c.bots[b.ID]
+		c.RUnlock()
+		if !ok {
+			continue
+		}
+
+		convs, err := c.Storage().LoadConversations(b)
+		if err != nil {
+			return err
+		}
+
+		for _, conv := range convs {
+			if err := bot.addConversation(conv); err != nil {
+				log15.Error("error starting conversation", "conversation", conv.ID, "bot", b.ID, "err", err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Run loads the bots and conversations from the storage, starts the
+// scheduled jobs and blocks until the client is stopped.
+func (c *Client) Run() error {
+	if err := c.loadFromStorage(); err != nil {
+		return err
+	}
+
+	c.scheduler.Start()
+	<-c.shutdown
+	return nil
+}
+
+// Stop stops all the bots and the scheduled jobs.
+func (c *Client) Stop() error {
+	c.Lock()
+	bots := c.bots
+	c.bots = make(map[string]*botClient)
+	c.Unlock()
+
+	for id, bot := range bots {
+		log15.Debug("shutting down bot", "id", id)
+		bot.stop()
+		log15.Debug("shut down bot", "id", id)
+	}
+
+	c.scheduler.Stop()
+	c.shutdown <- struct{}{}
+	return nil
+}
@@ -0,0 +1,535 @@
+package platform
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/storage"
+)
+
+type echoController struct{}
+
+func (echoController) CanHandle(msg flamingo.Message) bool {
+	return msg.Text != "ignored"
+}
+
+func (echoController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	_, err := bot.Say(flamingo.NewOutgoingMessage("echo: " + msg.Text))
+	return err
+}
+
+type introRecorder struct {
+	sync.Mutex
+	channels []string
+}
+
+func (r *introRecorder) HandleIntro(bot flamingo.Bot, channel flamingo.Channel) error {
+	r.Lock()
+	defer r.Unlock()
+	r.channels = append(r.channels, channel.ID)
+	return nil
+}
+
+func (r *introRecorder) all() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.channels...)
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			require.FailNow(t, "condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestClient() (*Client, *platformMock) {
+	p := newPlatformMock()
+	return NewClient(p, Options{}), p
+}
+
+func message(channel, text string) flamingo.Message {
+	return flamingo.Message{
+		ID:      "1",
+		Type:    testClient,
+		User:    flamingo.User{ID: "U1", Username: "user"},
+		Channel: flamingo.Channel{ID: channel, Type: testClient},
+		Text:    text,
+	}
+}
+
+func TestClientMessages(t *testing.T) {
+	require := require.New(t)
+	cli, p := newTestClient()
+	intros := new(introRecorder)
+	var calls []string
+	var mut sync.Mutex
+	cli.AddController(echoController{})
+	cli.SetIntroHandler(intros)
+	cli.Use(func(b flamingo.Bot, m flamingo.Message, next flamingo.HandlerFunc) error {
+		mut.Lock()
+		calls = append(calls, "first")
+		mut.Unlock()
+		return next(b, m)
+	}, nil, func(b flamingo.Bot, m flamingo.Message, next flamingo.HandlerFunc) error {
+		mut.Lock()
+		calls = append(calls, "second")
+		mut.Unlock()
+		return next(b, m)
+	})
+
+	cli.AddBot("bot", "token", nil)
+	conn := p.conn("bot")
+	require.NotNil(conn)
+	<-conn.runs
+
+	conn.events.Message(message("C1", "hello"))
+	eventually(t, func() bool { return len(conn.all()) == 1 })
+	conn.events.Message(message("C1", "ignored"))
+	conn.events.Message(message("C1", "again"))
+	eventually(t, func() bool { return len(conn.all()) == 2 })
+
+	posted := conn.all()
+	require.Equal("C1", posted[0].channel)
+	require.Equal("echo: hello", posted[0].text)
+	require.Equal("echo: again", posted[1].text)
+	require.Equal([]string{"C1"}, intros.all())
+
+	mut.Lock()
+	require.Equal([]string{"first", "second", "first", "second"}, calls)
+	mut.Unlock()
+
+	ok, err := cli.Storage().ConversationExists(flamingo.StoredConversation{ID: "C1", BotID: "bot"})
+	require.Nil(err)
+	require.True(ok)
+	require.Nil(cli.Stop())
+}
+
+func TestClientActions(t *testing.T) {
+	require := require.New(t)
+	cli, p := newTestClient()
+	actions := make(chan flamingo.Action, 1)
+	cli.AddActionHandler("group", func(b flamingo.Bot, a flamingo.Action) {
+		actions <- a
+	})
+
+	cli.AddBot("bot", "token", nil)
+	<-p.conn("bot").runs
+	events, err := cli.Events("bot")
+	require.Nil(err)
+
+	events.Action(ActionEvent{ID: "unknown", Action: flamingo.Action{Channel: flamingo.Channel{ID: "C1"}}})
+	events.Action(ActionEvent{
+		ID: "group",
+		Action: flamingo.Action{
+			UserAction: flamingo.UserAction{Name: "yes", Value: "yes"},
+			Channel:    flamingo.Channel{ID: "C1"},
+		},
+	})
+
+	select {
+	case a := <-actions:
+		require.Equal("yes", a.UserAction.Value)
+	case <-time.After(time.Second):
+		require.FailNow("action not handled")
+	}
+
+	_, err = cli.Events("foo")
+	require.Equal(ErrBotNotFound, err)
+	require.Nil(cli.Stop())
+}
+
+func TestClientMessageLog(t *testing.T) {
+	require := require.New(t)
+	cli, p := newTestClient()
+	log := flamingo.NewMessageLog(flamingo.RetentionPolicy{})
+	cli.SetMessageLog(log)
+	cli.AddController(echoController{})
+
+	cli.AddBot("bot", "token", nil)
+	conn := p.conn("bot")
+	<-conn.runs
+
+	conn.events.Message(message("C1", "hello"))
+	eventually(t, func() bool { return len(conn.all()) == 1 })
+	conn.events.Action(ActionEvent{
+		ID: "group",
+		Action: flamingo.Action{
+			UserAction: flamingo.UserAction{Name: "answer", Value: "yes"},
+			User:       flamingo.User{ID: "U1", Extra: "ignored"},
+			Channel:    flamingo.Channel{ID: "C1"},
+		},
+	})
+
+	entries, err := log.History(flamingo.LogQuery{Bot: "bot", Channel: "C1"})
+	require.Nil(err)
+	require.Equal(3, len(entries))
+	require.Equal(flamingo.MessageReceived, entries[0].Kind)
+	require.Equal("hello", entries[0].Text)
+	require.Equal("U1", entries[0].User.ID)
+	require.Equal(flamingo.MessageSent, entries[1].Kind)
+	require.Equal("echo: hello", entries[1].Text)
+	require.Equal(flamingo.ActionReceived, entries[2].Kind)
+	require.Equal(&flamingo.UserAction{Name: "answer", Value: "yes"}, entries[2].Action)
+	require.Nil(entries[2].User.Extra)
+	require.Nil(cli.Stop())
+}
+
+func TestClientJoinedAndLeft(t *testing.T) {
+	require := require.New(t)
+	cli, p := newTestClient()
+	intros := new(introRecorder)
+	cli.SetIntroHandler(intros)
+	cli.AddBot("bot", "token", nil)
+	conn := p.conn("bot")
+	<-conn.runs
+
+	conn.events.Joined(flamingo.Channel{ID: "C1"})
+	conn.events.Joined(flamingo.Channel{ID: "C1"})
+	require.Equal([]string{"C1"}, intros.all())
+
+	report, err := cli.Broadcast(flamingo.NewOutgoingMessage("hi"), flamingo.All(), flamingo.BroadcastOptions{})
+	require.Nil(err)
+	require.Equal(uint64(1), report.Conversations())
+
+	stored := flamingo.StoredConversation{ID: "C1", BotID: "bot"}
+	ok, err := cli.Storage().ConversationExists(stored)
+	require.Nil(err)
+	require.True(ok)
+
+	conn.events.Left("C1")
+	report, _ = cli.Broadcast(flamingo.NewOutgoingMessage("hi"), flamingo.All(), flamingo.BroadcastOptions{})
+	require.Equal(uint64(0), report.Conversations())
+
+	ok, err = cli.Storage().ConversationExists(stored)
+	require.Nil(err)
+	require.False(ok)
+	require.Nil(cli.Stop())
+}
+
+func TestClientJobsAndBroadcast(t *testing.T) {
+	require := require.New(t)
+	cli, p := newTestClient()
+	cli.AddBot("bot", "token", nil)
+	conn := p.conn("bot")
+	<-conn.runs
+	conn.events.Joined(flamingo.Channel{ID: "C1"})
+	conn.events.Joined(flamingo.Channel{ID: "C2", IsDM: true})
+
+	job := func(b flamingo.Bot, ch flamingo.Channel) error {
+		if ch.IsDM {
+			return errors.New("fail")
+		}
+		_, err := b.Say(flamingo.NewOutgoingMessage("job"))
+		return err
+	}
+	require.Nil(cli.AddScheduledJob("job", flamingo.NewIntervalSchedule(time.Hour), job))
+	require.Equal(flamingo.ErrJobExists, cli.AddScheduledJob("job", flamingo.NewIntervalSchedule(time.Hour), job))
+
+	run, err := cli.RunJob("job")
+	require.Nil(err)
+	require.Equal(uint64(2), run.Conversations)
+	require.Equal(uint64(1), run.Errors)
+
+	runs, err := cli.JobHistory("job")
+	require.Nil(err)
+	require.Equal(1, len(runs))
+	require.Nil(cli.PauseJob("job"))
+	require.Nil(cli.ResumeJob("job"))
+	require.Nil(cli.RemoveScheduledJob("job"))
+	require.Equal(flamingo.ErrJobNotFound, cli.PauseJob("job"))
+
+	report, err := cli.Broadcast(flamingo.NewOutgoingMessage("hi"), flamingo.OnlyDMs(), flamingo.BroadcastOptions{})
+	require.Nil(err)
+	require.Equal(uint64(1), report.Conversations())
+	require.Equal("C2", report.Deliveries[0].ChannelID)
+	require.Nil(cli.Stop())
+}
+
+type transientPlatform struct {
+	*platformMock
+}
+
+func (transientPlatform) IsTransient(err error) bool {
+	return err.Error() == "slow down"
+}
+
+func TestClientBroadcastFilterBeforeBot(t *testing.T) {
+	require := require.New(t)
+	cli, p := newTestClient()
+	cli.AddBot("bot", "token", nil)
+	conn := p.conn("bot")
+	<-conn.runs
+	conn.events.Joined(flamingo.Channel{ID: "C1"})
+	conn.events.Joined(flamingo.Channel{ID: "C2"})
+
+	var withBot int
+	report, err := cli.Broadcast(flamingo.NewOutgoingMessage("hi"), func(target flamingo.BroadcastTarget) bool {
+		if target.Bot != nil {
+			withBot++
+		}
+		return target.Channel.ID == "C2"
+	}, flamingo.BroadcastOptions{})
+	require.Nil(err)
+	require.Equal(0, withBot, "bots are only created for the targets that pass the filter")
+	require.Equal(uint64(1), report.Conversations())
+	require.Equal("C2", report.Deliveries[0].ChannelID)
+	require.Nil(cli.Stop())
+}
+
+func TestClientBroadcastTransient(t *testing.T) {
+	require := require.New(t)
+	p := transientPlatform{newPlatformMock()}
+	cli := NewClient(p, Options{})
+	cli.AddBot("bot", "token", nil)
+	conn := p.conn("bot")
+	<-conn.runs
+	conn.events.Joined(flamingo.Channel{ID: "C1"})
+	conn.err = errors.New("slow down")
+
+	report, err := cli.Broadcast(flamingo.NewOutgoingMessage("hi"), flamingo.All(), flamingo.BroadcastOptions{
+		Retries:    1,
+		RetryDelay: time.Millisecond,
+	})
+	require.Equal(flamingo.ErrAllMessagesLost, err)
+	require.Equal(2, report.Deliveries[0].Attempts)
+	require.Nil(cli.Stop())
+}
+
+func TestClientLoadFromStorage(t *testing.T) {
+	require := require.New(t)
+	cli, p := newTestClient()
+	store := storage.NewMemory()
+	require.Nil(store.StoreBot(flamingo.StoredBot{ID: "bot", Token: "token"}))
+	require.Nil(store.StoreConversation(flamingo.StoredConversation{ID: "C1", BotID: "bot"}))
+	cli.SetStorage(store)
+	intros := new(introRecorder)
+	cli.SetIntroHandler(intros)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cli.Run()
+	}()
+
+	eventually(t, func() bool { return p.conn("bot") != nil })
+	conn := p.conn("bot")
+	<-conn.runs
+	eventually(t, func() bool {
+		report, _ := cli.Broadcast(flamingo.NewOutgoingMessage("hi"), flamingo.All(), flamingo.BroadcastOptions{})
+		return report.Conversations() == 1
+	})
+
+	report, _ := cli.Broadcast(flamingo.NewOutgoingMessage("hi"), flamingo.All(), flamingo.BroadcastOptions{})
+	require.Equal("C1", report.Deliveries[0].ChannelID)
+	require.Equal(0, len(intros.all()))
+
+	require.Nil(cli.Stop())
+	require.Nil(<-done)
+}
+
+// blockingController echoes the messages once the release channel is
+// closed, so the conversation is busy while it handles a message.
+type blockingController struct {
+	release chan struct{}
+}
+
+func (blockingController) CanHandle(msg flamingo.Message) bool {
+	return true
+}
+
+func (c blockingController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	<-c.release
+	return echoController{}.Handle(bot, msg)
+}
+
+func TestClientRunWithFakeClock(t *testing.T) {
+	require := require.New(t)
+	clock := flamingo.NewFakeClock(time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC))
+	p := newPlatformMock()
+	cli := NewClient(p, Options{Clock: clock})
+	release := make(chan struct{})
+	cli.AddController(blockingController{release})
+	cli.AddScheduledJob("job", flamingo.NewIntervalSchedule(time.Hour), func(b flamingo.Bot, _ flamingo.Channel) error {
+		_, err := b.Say(flamingo.NewOutgoingMessage("job"))
+		return err
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cli.Run()
+	}()
+
+	cli.AddBot("bot", "token", nil)
+	conn := p.conn("bot")
+	<-conn.runs
+
+	// the second message arrives while the first one is being handled, so
+	// it is requeued, which must not wait for the fake clock
+	conn.events.Message(message("C1", "one"))
+	conn.events.Message(message("C1", "two"))
+	close(release)
+	eventually(t, func() bool { return len(conn.all()) == 2 })
+
+	require.True(clock.BlockUntil(1, time.Second), "only the scheduler waits for the clock")
+	clock.Advance(time.Hour)
+	eventually(t, func() bool { return len(conn.all()) == 3 })
+	require.Equal("job", conn.all()[2].text)
+
+	require.Nil(cli.Stop())
+	require.Nil(<-done)
+}
+
+func TestClientLeftReleasesSenders(t *testing.T) {
+	require := require.New(t)
+	cli, p := newTestClient()
+	release := make(chan struct{})
+	defer close(release)
+	cli.AddController(blockingController{release})
+	cli.AddBot("bot", "token", nil)
+	conn := p.conn("bot")
+	<-conn.runs
+
+	// the conversation is busy with the first message, so the rest of them
+	// are requeued until the conversation is stopped
+	conn.events.Message(message("C1", "one"))
+	sent := make(chan struct{})
+	go func() {
+		conn.events.Message(message("C1", "two"))
+		conn.events.Message(message("C1", "three"))
+		close(sent)
+	}()
+
+	cli.RLock()
+	b := cli.bots["bot"]
+	cli.RUnlock()
+	b.RLock()
+	conv := b.conversations["C1"]
+	b.RUnlock()
+	conn.events.Left("C1")
+
+	stopped := make(chan struct{})
+	go func() {
+		bot := conv.createBot()
+		for i := 0; i < 3; i++ {
+			conv.sendMessage(message("C1", "after stop"))
+			conv.sendAction(ActionEvent{ID: "foo"})
+			bot.InvokeAction("foo", flamingo.User{}, flamingo.UserAction{})
+		}
+		close(stopped)
+	}()
+
+	for _, ch := range []chan struct{}{sent, stopped} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			require.FailNow("senders are blocked on a stopped conversation")
+		}
+	}
+	require.Nil(cli.Stop())
+}
+
+func TestClientReconnect(t *testing.T) {
+	require := require.New(t)
+	clock := flamingo.NewFakeClock(time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC))
+	p := newPlatformMock()
+	p.fail = errors.New("connection lost")
+	cli := NewClient(p, Options{Clock: clock})
+	cli.AddBot("bot", "token", nil)
+
+	conn := p.conn("bot")
+	<-conn.runs
+	require.True(clock.BlockUntil(1, time.Second))
+	clock.Advance(reconnectDelay)
+	<-conn.runs
+
+	conn.mut.Lock()
+	require.Equal(2, conn.started)
+	conn.mut.Unlock()
+
+	_, err := cli.Connection("bot")
+	require.Nil(err)
+	require.Nil(cli.Stop())
+}
+
+func TestClientInvalidCredentials(t *testing.T) {
+	require := require.New(t)
+	p := newPlatformMock()
+	p.fail = ErrInvalidCredentials
+	cli := NewClient(p, Options{})
+	cli.AddBot("bot", "token", nil)
+	require.Nil(cli.Storage().StoreConversation(flamingo.StoredConversation{ID: "C1", BotID: "bot"}))
+
+	conn := p.conn("bot")
+	<-conn.runs
+	eventually(t, func() bool {
+		_, err := cli.Connection("bot")
+		return err == ErrBotNotFound
+	})
+
+	ok, err := cli.Storage().BotExists(flamingo.StoredBot{ID: "bot"})
+	require.Nil(err)
+	require.False(ok)
+
+	ok, err = cli.Storage().ConversationExists(flamingo.StoredConversation{ID: "C1", BotID: "bot"})
+	require.Nil(err)
+	require.False(ok)
+
+	conn.mut.Lock()
+	require.Equal(1, conn.started)
+	conn.mut.Unlock()
+	require.Nil(cli.Stop())
+}
+
+// slowPlatform connects the bots once the release channel is closed, and
+// notifies in the connecting channel when a connection starts.
+type slowPlatform struct {
+	*platformMock
+	connecting chan struct{}
+	release    chan struct{}
+}
+
+func (p slowPlatform) Connect(bot flamingo.StoredBot, events Events) (Connection, error) {
+	p.connecting <- struct{}{}
+	<-p.release
+	return p.platformMock.Connect(bot, events)
+}
+
+func TestClientAddBotConnectsWithoutLock(t *testing.T) {
+	require := require.New(t)
+	p := slowPlatform{newPlatformMock(), make(chan struct{}, 1), make(chan struct{})}
+	cli := NewClient(p, Options{})
+
+	added := make(chan struct{})
+	go func() {
+		cli.AddBot("bot", "token", nil)
+		close(added)
+	}()
+	<-p.connecting
+
+	done := make(chan struct{})
+	go func() {
+		cli.SetErrorHandler(nil)
+		cli.Storage()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.FailNow("the client is locked while the bot connects")
+	}
+
+	close(p.release)
+	<-added
+	_, err := cli.Connection("bot")
+	require.Nil(err)
+	require.Nil(cli.Stop())
+}
@@ -0,0 +1,219 @@
+package platform
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+)
+
+type handlerDelegate interface {
+	ControllerFor(flamingo.Message) (flamingo.HandlerFunc, bool)
+	ActionHandler(string) (flamingo.ActionHandler, bool)
+	HandleIntro(flamingo.Bot, flamingo.Channel)
+	Storage() flamingo.Storage
+	ErrorHandler() flamingo.ErrorHandler
+	Clock() flamingo.Clock
+	MessageLog() flamingo.MessageLog
+	removeBot(id string)
+}
+
+type conversation struct {
+	sync.RWMutex
+	working  bool
+	bot      string
+	channel  flamingo.Channel
+	stored   flamingo.StoredConversation
+	api      API
+	actions  chan ActionEvent
+	messages chan flamingo.Message
+	shutdown chan struct{}
+	closed   chan struct{}
+	done     chan struct{}
+	delegate handlerDelegate
+	clock    flamingo.Clock
+	log      flamingo.MessageLog
+}
+
+func newConversation(bot string, channel flamingo.Channel, api API, delegate handlerDelegate) *conversation {
+	return &conversation{
+		bot:      bot,
+		channel:  channel,
+		api:      api,
+		actions:  make(chan ActionEvent, 1),
+		messages: make(chan flamingo.Message, 1),
+		shutdown: make(chan struct{}, 1),
+		closed:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		delegate: delegate,
+		clock:    delegate.Clock(),
+		log:      delegate.MessageLog(),
+	}
+}
+
+func (c *conversation) run() {
+	defer c.recoverAndRestart()
+
+	for {
+		select {
+		case <-c.shutdown:
+			c.closed <- struct{}{}
+			return
+		case msg, ok := <-c.messages:
+			if !ok {
+				continue
+			}
+
+			if c.isWorking() {
+				go c.sendMessage(msg)
+				<-time.After(50 * time.Millisecond)
+				continue
+			}
+
+			c.handleMessage(msg)
+
+		case action, ok := <-c.actions:
+			if !ok {
+				continue
+			}
+
+			if c.isWorking() {
+				go c.sendAction(action)
+				<-time.After(50 * time.Millisecond)
+				continue
+			}
+
+			c.handleAction(action)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// sendMessage delivers the message to the conversation. The message is
+// dropped if the conversation is stopped before it can be delivered.
+func (c *conversation) sendMessage(msg flamingo.Message) {
+	select {
+	case c.messages <- msg:
+	case <-c.done:
+		log15.Debug("conversation stopped, dropping message", "bot", c.bot, "channel", c.channel.ID)
+	}
+}
+
+// sendAction delivers the action to the conversation. The action is
+// dropped if the conversation is stopped before it can be delivered.
+func (c *conversation) sendAction(action ActionEvent) {
+	select {
+	case c.actions <- action:
+	case <-c.done:
+		log15.Debug("conversation stopped, dropping action", "bot", c.bot, "channel", c.channel.ID, "id", action.ID)
+	}
+}
+
+func (c *conversation) isWorking() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.working
+}
+
+func (c *conversation) setWorking(working bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.working = working
+}
+
+func (c *conversation) handleMessage(msg flamingo.Message) {
+	handler, ok := c.delegate.ControllerFor(msg)
+	if !ok {
+		log15.Warn("no controller for message", "text", msg.Text)
+		return
+	}
+
+	c.setWorking(true)
+	go func() {
+		defer c.recoverWithLog("panic caught handling msg")
+		defer c.setWorking(false)
+
+		if err := handler(c.createBot(), msg); err != nil {
+			log15.Error("error handling message", "error", err.Error())
+		}
+	}()
+}
+
+func (c *conversation) handleAction(action ActionEvent) {
+	handler, ok := c.delegate.ActionHandler(action.ID)
+	if !ok {
+		log15.Warn("no handler for action", "id", action.ID)
+		return
+	}
+
+	c.setWorking(true)
+	go func() {
+		defer c.recoverWithLog("panic caught handling action")
+		defer c.setWorking(false)
+
+		handler(c.createBot(), action.Action)
+	}()
+}
+
+func (c *conversation) recoverWithLog(msg string) {
+	if r := recover(); r != nil {
+		if err, ok := r.(error); ok {
+			log15.Error(msg, "err", err.Error())
+		}
+
+		if handler := c.delegate.ErrorHandler(); handler != nil {
+			handler(r)
+		}
+	}
+}
+
+func (c *conversation) recoverAndRestart() {
+	if r := recover(); r != nil {
+		if err, ok := r.(error); ok {
+			log15.Error("panic caught on conversation", "err", err.Error())
+		}
+
+		if handler := c.delegate.ErrorHandler(); handler != nil {
+			handler(r)
+		}
+
+		log15.Info("restarting conversation")
+		go c.run()
+	}
+}
+
+func (c *conversation) createBot() flamingo.Bot {
+	return &bot{
+		id:      c.bot,
+		channel: c.channel,
+		api:     c.api,
+		msgs:    c.messages,
+		actions: c.actions,
+		done:    c.done,
+		clock:   c.clock,
+		log:     c.log,
+	}
+}
+
+func (c *conversation) handleIntro() {
+	c.delegate.HandleIntro(c.createBot(), c.channel)
+}
+
+func (c *conversation) handleJob(job flamingo.Job) error {
+	err := job(c.createBot(), c.channel)
+	if err != nil {
+		log15.Error("error running job", "bot", c.bot, "channel", c.channel.ID, "err", err.Error())
+	}
+	return err
+}
+
+// stop stops the conversation. The data channels are never closed, so
+// senders that are still blocked on them are released through done instead.
+func (c *conversation) stop() {
+	close(c.done)
+	c.shutdown <- struct{}{}
+	close(c.shutdown)
+	<-c.closed
+}
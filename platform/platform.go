@@ -0,0 +1,103 @@
+// Package platform provides the pieces shared by all the flamingo clients:
+// registration of controllers and handlers, dispatching of messages and
+// actions to conversations, storage, scheduled jobs and broadcasts. A client for a new chat platform only needs to implement
+// Platform and Connection and wrap a Client created with NewClient.
+package platform
+
+import (
+	"errors"
+
+	"github.com/src-d/flamingo"
+)
+
+var (
+	// ErrBotNotFound occurs when an event is received for a bot that is not
+	// running in the client.
+	ErrBotNotFound = errors.New("bot not found")
+	// ErrNotSupported occurs when an operation is not supported by the
+	// platform.
+	ErrNotSupported = errors.New("operation not supported by the platform")
+	// ErrInvalidCredentials is returned by the connections whose bot
+	// credentials were rejected by the platform, such as a revoked token.
+	ErrInvalidCredentials = errors.New("the credentials of the bot are not valid")
+)
+
+// Platform is a chat platform able to connect bots.
+type Platform interface {
+	// Type returns the type of client messages, users and channels of this
+	// platform come from.
+	Type() flamingo.ClientType
+	// Connect creates a connection for the given bot. The connection will
+	// notify all the events it receives to the given Events.
+	Connect(bot flamingo.StoredBot, events Events) (Connection, error)
+}
+
+// TransientChecker is implemented by platforms that can tell if an error
+// returned by their API is temporary and the request can be retried.
+type TransientChecker interface {
+	// IsTransient reports whether the error is transient.
+	IsTransient(error) bool
+}
+
+// Connection is the connection of a single bot to the platform.
+type Connection interface {
+	API
+	// Channel returns the channel with the given ID.
+	Channel(id string) (flamingo.Channel, error)
+	// Run receives events until Close is called. If it returns an error
+	// before being closed, it will be run again, unless the error is
+	// ErrInvalidCredentials, in which case the bot is stopped and removed,
+	// along with its conversations, from the storage.
+	Run() error
+	// Close stops receiving events.
+	Close() error
+}
+
+// API is the set of operations bots use to talk to the users of the platform.
+// All the methods that post or update a message return its ID.
+type API interface {
+	// PostMessage posts a message in the channel with the given ID.
+	PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error)
+	// PostForm posts a form in the channel with the given ID.
+	PostForm(channel string, form flamingo.Form) (string, error)
+	// PostImage posts an image in the channel with the given ID.
+	PostImage(channel string, img flamingo.Image) (string, error)
+	// UpdateMessage replaces the text of a message.
+	UpdateMessage(channel, id, text string) (string, error)
+	// UpdateForm replaces a message with the given form.
+	UpdateForm(channel, id string, form flamingo.Form) (string, error)
+	// DirectChannel returns the ID of the direct conversation with the user
+	// with the given username or ID, opening it if needed.
+	DirectChannel(user string) (string, error)
+}
+
+// Replier is implemented by APIs that can reply to a specific message. If
+// the API does not implement it, replies are sent as messages mentioning the
+// user.
+type Replier interface {
+	// ReplyMessage posts the message in the channel as a reply to the given
+	// message.
+	ReplyMessage(channel string, replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error)
+}
+
+// ActionEvent is an action performed by a user along with the ID of the
+// group of buttons it comes from, which identifies its ActionHandler.
+type ActionEvent struct {
+	// ID is the ID of the action, usually the ID of the button group.
+	ID string
+	// Action is the action performed.
+	Action flamingo.Action
+}
+
+// Events receives the events of a single bot connection.
+type Events interface {
+	// Message notifies a new message. Messages sent by the bot itself must
+	// not be notified.
+	Message(flamingo.Message)
+	// Action notifies a new action performed by a user.
+	Action(ActionEvent)
+	// Joined notifies the bot joined or was invited to a channel.
+	Joined(flamingo.Channel)
+	// Left notifies the bot is no longer part of a channel.
+	Left(channel string)
+}
@@ -0,0 +1,150 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/src-d/flamingo"
+)
+
+const testClient flamingo.ClientType = 1 << 30
+
+type posted struct {
+	channel string
+	text    string
+	form    *flamingo.Form
+	image   *flamingo.Image
+}
+
+type apiMock struct {
+	sync.Mutex
+	posted  []posted
+	updated map[string]string
+	err     error
+}
+
+func newAPIMock() *apiMock {
+	return &apiMock{updated: make(map[string]string)}
+}
+
+func (a *apiMock) post(p posted) (string, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.err != nil {
+		return "", a.err
+	}
+
+	a.posted = append(a.posted, p)
+	return fmt.Sprint(len(a.posted)), nil
+}
+
+func (a *apiMock) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	return a.post(posted{channel: channel, text: msg.Text})
+}
+
+func (a *apiMock) PostForm(channel string, form flamingo.Form) (string, error) {
+	return a.post(posted{channel: channel, form: &form})
+}
+
+func (a *apiMock) PostImage(channel string, img flamingo.Image) (string, error) {
+	return a.post(posted{channel: channel, image: &img})
+}
+
+func (a *apiMock) UpdateMessage(channel, id, text string) (string, error) {
+	a.Lock()
+	defer a.Unlock()
+	a.updated[id] = text
+	return id, a.err
+}
+
+func (a *apiMock) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	a.Lock()
+	defer a.Unlock()
+	a.updated[id] = form.Title
+	return id, a.err
+}
+
+func (a *apiMock) DirectChannel(user string) (string, error) {
+	if user == "nobody" {
+		return "", errors.New("user not found")
+	}
+	return "D" + user, nil
+}
+
+func (a *apiMock) all() []posted {
+	a.Lock()
+	defer a.Unlock()
+	var result = make([]posted, len(a.posted))
+	copy(result, a.posted)
+	return result
+}
+
+type connMock struct {
+	*apiMock
+	events  Events
+	runs    chan struct{}
+	closed  chan struct{}
+	fail    error
+	mut     sync.Mutex
+	started int
+}
+
+func (c *connMock) Channel(id string) (flamingo.Channel, error) {
+	return flamingo.Channel{ID: id, Name: "channel " + id, Type: testClient}, nil
+}
+
+func (c *connMock) Run() error {
+	c.mut.Lock()
+	c.started++
+	fail := c.fail
+	c.fail = nil
+	c.mut.Unlock()
+
+	c.runs <- struct{}{}
+	if fail != nil {
+		return fail
+	}
+
+	<-c.closed
+	return nil
+}
+
+func (c *connMock) Close() error {
+	close(c.closed)
+	return nil
+}
+
+type platformMock struct {
+	sync.Mutex
+	conns map[string]*connMock
+	fail  error
+}
+
+func newPlatformMock() *platformMock {
+	return &platformMock{conns: make(map[string]*connMock)}
+}
+
+func (p *platformMock) Type() flamingo.ClientType {
+	return testClient
+}
+
+func (p *platformMock) Connect(bot flamingo.StoredBot, events Events) (Connection, error) {
+	p.Lock()
+	defer p.Unlock()
+	conn := &connMock{
+		apiMock: newAPIMock(),
+		events:  events,
+		runs:    make(chan struct{}, 10),
+		closed:  make(chan struct{}),
+		fail:    p.fail,
+	}
+	p.conns[bot.ID] = conn
+	return conn, nil
+}
+
+func (p *platformMock) conn(id string) *connMock {
+	p.Lock()
+	defer p.Unlock()
+	return p.conns[id]
+}
@@ -0,0 +1,185 @@
+package record
+
+import "github.com/src-d/flamingo"
+
+// recordingBot is a bot that records everything it sends and the messages
+// and actions it waits for.
+type recordingBot struct {
+	flamingo.Bot
+	recorder *Recorder
+	channel  flamingo.Channel
+}
+
+func (b *recordingBot) channelFor(msg flamingo.OutgoingMessage) flamingo.Channel {
+	if msg.ChannelID != "" && msg.ChannelID != b.channel.ID {
+		return flamingo.Channel{ID: msg.ChannelID}
+	}
+	return b.channel
+}
+
+func (b *recordingBot) recordSent(e Entry, err error) {
+	e.Bot = b.ID()
+	if e.Channel.ID == "" {
+		e.Channel = b.channel
+	}
+
+	if err != nil {
+		e.Error = err.Error()
+	}
+	b.recorder.record(e)
+}
+
+func (b *recordingBot) Reply(replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error) {
+	id, err := b.Bot.Reply(replyTo, msg)
+	b.recordSent(Entry{
+		Kind:    KindReply,
+		Channel: b.channelFor(msg),
+		ID:      id,
+		Text:    msg.Text,
+		Sender:  msg.Sender,
+		ReplyTo: replyTo.ID,
+	}, err)
+	return id, err
+}
+
+func (b *recordingBot) Ask(msg flamingo.OutgoingMessage) (string, flamingo.Message, error) {
+	id, err := b.Say(msg)
+	if err != nil {
+		return "", flamingo.Message{}, err
+	}
+
+	message, err := b.WaitForMessage()
+	return id, message, err
+}
+
+func (b *recordingBot) Conversation(convo flamingo.Conversation) ([]string, []flamingo.Message, error) {
+	var messages = make([]flamingo.Message, 0, len(convo))
+	var ids = make([]string, 0, len(convo))
+	for _, m := range convo {
+		id, msg, err := b.Ask(m)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ids = append(ids, id)
+		messages = append(messages, msg)
+	}
+
+	return ids, messages, nil
+}
+
+func (b *recordingBot) Say(msg flamingo.OutgoingMessage) (string, error) {
+	id, err := b.Bot.Say(msg)
+	b.recordSent(Entry{
+		Kind:    KindSay,
+		Channel: b.channelFor(msg),
+		ID:      id,
+		Text:    msg.Text,
+		Sender:  msg.Sender,
+	}, err)
+	return id, err
+}
+
+func (b *recordingBot) SayTo(user string, msg flamingo.OutgoingMessage) (string, string, error) {
+	id, channel, err := b.Bot.SayTo(user, msg)
+	b.recordSent(Entry{
+		Kind:    KindSayTo,
+		Channel: flamingo.Channel{ID: channel, IsDM: true},
+		ID:      id,
+		To:      user,
+		Text:    msg.Text,
+		Sender:  msg.Sender,
+	}, err)
+	return id, channel, err
+}
+
+func (b *recordingBot) Form(form flamingo.Form) (string, error) {
+	id, err := b.Bot.Form(form)
+	b.recordSent(Entry{
+		Kind: KindForm,
+		ID:   id,
+		Form: NewForm(form),
+	}, err)
+	return id, err
+}
+
+func (b *recordingBot) SendFormTo(user string, form flamingo.Form) (string, string, error) {
+	id, channel, err := b.Bot.SendFormTo(user, form)
+	b.recordSent(Entry{
+		Kind:    KindFormTo,
+		Channel: flamingo.Channel{ID: channel, IsDM: true},
+		ID:      id,
+		To:      user,
+		Form:    NewForm(form),
+	}, err)
+	return id, channel, err
+}
+
+func (b *recordingBot) Image(img flamingo.Image) (string, error) {
+	id, err := b.Bot.Image(img)
+	b.recordSent(Entry{
+		Kind:  KindImage,
+		ID:    id,
+		Image: &img,
+	}, err)
+	return id, err
+}
+
+func (b *recordingBot) UpdateMessage(id string, replacement string) (string, error) {
+	newID, err := b.Bot.UpdateMessage(id, replacement)
+	b.recordSent(Entry{
+		Kind: KindUpdateMessage,
+		ID:   id,
+		Text: replacement,
+	}, err)
+	return newID, err
+}
+
+func (b *recordingBot) UpdateForm(id string, form flamingo.Form) (string, error) {
+	newID, err := b.Bot.UpdateForm(id, form)
+	b.recordSent(Entry{
+		Kind: KindUpdateForm,
+		ID:   id,
+		Form: NewForm(form),
+	}, err)
+	return newID, err
+}
+
+func (b *recordingBot) WaitForMessage() (flamingo.Message, error) {
+	msg, err := b.Bot.WaitForMessage()
+	if err == nil {
+		b.recorder.recordMessage(b.ID(), msg)
+	}
+	return msg, err
+}
+
+func (b *recordingBot) WaitForAction(id string, policy flamingo.ActionWaitingPolicy) (flamingo.Action, error) {
+	return b.WaitForActions([]string{id}, policy)
+}
+
+func (b *recordingBot) WaitForActions(ids []string, policy flamingo.ActionWaitingPolicy) (flamingo.Action, error) {
+	action, err := b.Bot.WaitForActions(ids, policy)
+	if err == nil {
+		var id string
+		if len(ids) == 1 {
+			id = ids[0]
+		}
+		b.recorder.recordAction(b.ID(), id, action)
+	}
+	return action, err
+}
+
+func (b *recordingBot) AskUntil(msg flamingo.OutgoingMessage, check flamingo.AnswerChecker) (string, flamingo.Message, error) {
+	for {
+		id, m, err := b.Ask(msg)
+		if err != nil {
+			return "", flamingo.Message{}, err
+		}
+
+		errMsg := check(m)
+		if errMsg == nil {
+			return id, m, nil
+		}
+		msg = *errMsg
+	}
+}
@@ -0,0 +1,93 @@
+package record
+
+import "github.com/src-d/flamingo"
+
+// Form is the serializable version of a flamingo.Form.
+type Form struct {
+	Title         string `json:",omitempty"`
+	AuthorIconURL string `json:",omitempty"`
+	AuthorName    string `json:",omitempty"`
+	Text          string `json:",omitempty"`
+	Combine       bool   `json:",omitempty"`
+	Color         string `json:",omitempty"`
+	Footer        string `json:",omitempty"`
+	Fields        []FieldGroup
+}
+
+// FieldGroup is the serializable version of a flamingo.FieldGroup. Only the
+// items of its type are set.
+type FieldGroup struct {
+	// Type is the type of the group: buttons, text_fields, image or text.
+	Type string
+	// ID is the ID of the group, if any.
+	ID         string               `json:",omitempty"`
+	Buttons    []flamingo.Button    `json:",omitempty"`
+	TextFields []flamingo.TextField `json:",omitempty"`
+	Image      *flamingo.Image      `json:",omitempty"`
+	Text       string               `json:",omitempty"`
+}
+
+var groupTypes = map[flamingo.FieldGroupType]string{
+	flamingo.ButtonGroup:    "buttons",
+	flamingo.TextFieldGroup: "text_fields",
+	flamingo.ImageGroup:     "image",
+	flamingo.TextGroup:      "text",
+}
+
+// NewForm converts the given form to its serializable version.
+func NewForm(form flamingo.Form) *Form {
+	f := &Form{
+		Title:         form.Title,
+		AuthorIconURL: form.AuthorIconURL,
+		AuthorName:    form.AuthorName,
+		Text:          form.Text,
+		Combine:       form.Combine,
+		Color:         form.Color,
+		Footer:        form.Footer,
+		Fields:        make([]FieldGroup, 0, len(form.Fields)),
+	}
+
+	for _, g := range form.Fields {
+		group := FieldGroup{
+			Type: groupTypes[g.Type()],
+			ID:   g.ID(),
+		}
+
+		for _, item := range g.Items() {
+			switch item := item.(type) {
+			case flamingo.Button:
+				group.Buttons = append(group.Buttons, item)
+			case flamingo.TextField:
+				group.TextFields = append(group.TextFields, item)
+			case flamingo.Image:
+				img := item
+				group.Image = &img
+			case flamingo.Text:
+				group.Text = string(item)
+			}
+		}
+
+		f.Fields = append(f.Fields, group)
+	}
+
+	return f
+}
+
+// Button returns the button with the given value of the group with the given
+// ID. If the ID is empty, the button is looked up in all the groups. The ID
+// of the group of the button is returned along with it.
+func (f *Form) Button(group, value string) (flamingo.Button, string, bool) {
+	for _, g := range f.Fields {
+		if group != "" && g.ID != group {
+			continue
+		}
+
+		for _, b := range g.Buttons {
+			if b.Value == value {
+				return b, g.ID, true
+			}
+		}
+	}
+
+	return flamingo.Button{}, "", false
+}
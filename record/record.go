@@ -0,0 +1,262 @@
+// Package record records the conversations of the bots of a client into
+// transcripts, so real conversations can be replayed later as golden tests
+// with the flamingotest package.
+//
+// A transcript is written as JSON lines, one Entry per line, with the
+// messages and actions received by the bots and everything the bots sent in
+// response to them, in the order they happened:
+//
+//	f, _ := os.Create("transcript.jsonl")
+//	rec := record.NewRecorder(f, nil)
+//	client.Use(rec.Middleware())
+//	client.AddController(&deployController{})
+//	client.AddActionHandler("deploy", rec.ActionHandler("deploy", handleDeploy))
+//	client.SetIntroHandler(rec.IntroHandler(&introHandler{}))
+//
+// The middleware of the recorder should be the first one added to the
+// client, so the messages are recorded even if other middlewares stop them.
+package record
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Kind is the kind of an entry of a transcript.
+type Kind string
+
+const (
+	// KindMessage is a message received by a bot.
+	KindMessage Kind = "message"
+	// KindAction is an action received by a bot.
+	KindAction Kind = "action"
+	// KindJoin is the start of a conversation of a bot in a channel, which
+	// runs the intro handler.
+	KindJoin Kind = "join"
+	// KindSay is a message sent by a bot.
+	KindSay Kind = "say"
+	// KindReply is a reply to a message sent by a bot.
+	KindReply Kind = "reply"
+	// KindSayTo is a message sent by a bot to a user.
+	KindSayTo Kind = "say_to"
+	// KindForm is a form sent by a bot.
+	KindForm Kind = "form"
+	// KindFormTo is a form sent by a bot to a user.
+	KindFormTo Kind = "form_to"
+	// KindImage is an image sent by a bot.
+	KindImage Kind = "image"
+	// KindUpdateMessage is the replacement of a message of a bot by a text.
+	KindUpdateMessage Kind = "update_message"
+	// KindUpdateForm is the replacement of a message of a bot by a form.
+	KindUpdateForm Kind = "update_form"
+)
+
+// Entry is a message or action received by a bot, or something sent by a
+// bot. Only the fields that make sense for the kind of entry are set.
+type Entry struct {
+	// Time is the time the entry was recorded.
+	Time time.Time
+	// Kind is the kind of entry.
+	Kind Kind
+	// Bot is the ID of the bot.
+	Bot string
+	// Channel is the channel in which the entry happened. For messages and
+	// forms sent to users it is the direct channel with the user.
+	Channel flamingo.Channel
+	// ID is the ID of the message received or sent. For updates, it is the
+	// ID of the message replaced.
+	ID string `json:",omitempty"`
+	// User is the user who sent the message or performed the action.
+	User *flamingo.User `json:",omitempty"`
+	// To is the user the message or form was sent to.
+	To string `json:",omitempty"`
+	// Text is the text of the message.
+	Text string `json:",omitempty"`
+	// Sender is the sender of the message, if the bot changed it.
+	Sender *flamingo.MessageSender `json:",omitempty"`
+	// ReplyTo is the ID of the message replied.
+	ReplyTo string `json:",omitempty"`
+	// Action is the action performed by the user.
+	Action *Action `json:",omitempty"`
+	// Form is the form sent.
+	Form *Form `json:",omitempty"`
+	// Image is the image sent.
+	Image *flamingo.Image `json:",omitempty"`
+	// Error is the error returned while sending, if any.
+	Error string `json:",omitempty"`
+}
+
+// Incoming returns true if the entry was received by the bot instead of sent
+// by it.
+func (e Entry) Incoming() bool {
+	switch e.Kind {
+	case KindMessage, KindAction, KindJoin:
+		return true
+	}
+	return false
+}
+
+// Action is an action performed by a user on a message of a bot.
+type Action struct {
+	// Group is the ID of the action, which is the ID of the field group of
+	// the button clicked. It is empty if the bot was waiting for several
+	// actions.
+	Group string `json:",omitempty"`
+	// Name is the name of the action.
+	Name string
+	// Value is the value of the action.
+	Value string
+	// Message is the ID of the message the action originated from.
+	Message string
+}
+
+// Recorder writes the transcript of the conversations of the bots.
+type Recorder struct {
+	clock flamingo.Clock
+	mut   sync.Mutex
+	enc   *json.Encoder
+}
+
+// NewRecorder creates a new Recorder that writes the transcript to the given
+// writer, taking the time of the entries from the given clock. If the clock
+// is nil, the system time is used.
+func NewRecorder(w io.Writer, clock flamingo.Clock) *Recorder {
+	if clock == nil {
+		clock = flamingo.NewClock()
+	}
+
+	return &Recorder{
+		clock: clock,
+		enc:   json.NewEncoder(w),
+	}
+}
+
+// Middleware returns a middleware that records the messages received by the
+// bots and everything the bots send while handling them.
+func (r *Recorder) Middleware() flamingo.Middleware {
+	return func(bot flamingo.Bot, msg flamingo.Message, next flamingo.HandlerFunc) error {
+		r.recordMessage(bot.ID(), msg)
+		return next(r.wrap(bot, msg.Channel), msg)
+	}
+}
+
+// ActionHandler returns an ActionHandler that records the actions with the
+// given ID and everything the bot sends while the given handler handles
+// them.
+func (r *Recorder) ActionHandler(id string, handler flamingo.ActionHandler) flamingo.ActionHandler {
+	return func(bot flamingo.Bot, action flamingo.Action) {
+		r.recordAction(bot.ID(), id, action)
+		handler(r.wrap(bot, action.Channel), action)
+	}
+}
+
+// IntroHandler returns an IntroHandler that records the start of the
+// conversations and everything the bot sends while the given handler
+// introduces it.
+func (r *Recorder) IntroHandler(handler flamingo.IntroHandler) flamingo.IntroHandler {
+	return &introHandler{r, handler}
+}
+
+type introHandler struct {
+	recorder *Recorder
+	handler  flamingo.IntroHandler
+}
+
+func (h *introHandler) HandleIntro(bot flamingo.Bot, channel flamingo.Channel) error {
+	h.recorder.record(Entry{
+		Kind:    KindJoin,
+		Bot:     bot.ID(),
+		Channel: channel,
+	})
+	return h.handler.HandleIntro(h.recorder.wrap(bot, channel), channel)
+}
+
+func (r *Recorder) wrap(bot flamingo.Bot, channel flamingo.Channel) flamingo.Bot {
+	if b, ok := bot.(*recordingBot); ok {
+		return b
+	}
+	return &recordingBot{bot, r, channel}
+}
+
+func (r *Recorder) recordMessage(bot string, msg flamingo.Message) {
+	user := msg.User
+	r.record(Entry{
+		Time:    msg.Time,
+		Kind:    KindMessage,
+		Bot:     bot,
+		Channel: msg.Channel,
+		ID:      msg.ID,
+		User:    &user,
+		Text:    msg.Text,
+	})
+}
+
+func (r *Recorder) recordAction(bot, id string, action flamingo.Action) {
+	user := action.User
+	r.record(Entry{
+		Kind:    KindAction,
+		Bot:     bot,
+		Channel: action.Channel,
+		User:    &user,
+		Action: &Action{
+			Group:   id,
+			Name:    action.UserAction.Name,
+			Value:   action.UserAction.Value,
+			Message: action.OriginalMessage.ID,
+		},
+	})
+}
+
+// record writes the entry to the transcript. The client-specific data of
+// users and channels is not recorded, as it may not be serializable.
+func (r *Recorder) record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = r.clock.Now()
+	}
+
+	e.Channel = stripChannel(e.Channel)
+	if e.User != nil {
+		u := *e.User
+		u.Extra = nil
+		e.User = &u
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if err := r.enc.Encode(e); err != nil {
+		log15.Error("error recording transcript entry", "kind", e.Kind, "err", err.Error())
+	}
+}
+
+func stripChannel(ch flamingo.Channel) flamingo.Channel {
+	ch.Extra = nil
+	if len(ch.Users) > 0 {
+		users := make([]flamingo.User, len(ch.Users))
+		for i, u := range ch.Users {
+			u.Extra = nil
+			users[i] = u
+		}
+		ch.Users = users
+	}
+	return ch
+}
+
+// ReadTranscript reads all the entries of the transcript in the given reader.
+func ReadTranscript(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	dec := json.NewDecoder(r)
+	for {
+		var e Entry
+		if err := dec.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
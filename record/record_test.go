@@ -0,0 +1,193 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+type botMock struct {
+	flamingo.Bot
+	lastID int
+	msgs   []flamingo.Message
+	err    error
+}
+
+func (b *botMock) ID() string { return "bot" }
+
+func (b *botMock) nextID() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	b.lastID++
+	return strconv.Itoa(b.lastID), nil
+}
+
+func (b *botMock) Say(flamingo.OutgoingMessage) (string, error) {
+	return b.nextID()
+}
+
+func (b *botMock) Reply(flamingo.Message, flamingo.OutgoingMessage) (string, error) {
+	return b.nextID()
+}
+
+func (b *botMock) SayTo(user string, _ flamingo.OutgoingMessage) (string, string, error) {
+	id, err := b.nextID()
+	return id, "D" + user, err
+}
+
+func (b *botMock) Form(flamingo.Form) (string, error) {
+	return b.nextID()
+}
+
+func (b *botMock) UpdateMessage(id string, _ string) (string, error) {
+	return id, b.err
+}
+
+func (b *botMock) WaitForMessage() (flamingo.Message, error) {
+	msg := b.msgs[0]
+	b.msgs = b.msgs[1:]
+	return msg, nil
+}
+
+func (b *botMock) WaitForActions(ids []string, _ flamingo.ActionWaitingPolicy) (flamingo.Action, error) {
+	return flamingo.Action{
+		UserAction:      flamingo.UserAction{Name: "yes", Value: "yes"},
+		OriginalMessage: flamingo.Message{ID: "2"},
+	}, nil
+}
+
+var now = time.Date(2017, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+func TestRecorder(t *testing.T) {
+	require := require.New(t)
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, flamingo.NewFakeClock(now))
+
+	channel := flamingo.Channel{
+		ID:    "C1",
+		Name:  "general",
+		Users: []flamingo.User{{ID: "U1", Extra: make(chan int)}},
+		Extra: func() {},
+	}
+	bot := &botMock{msgs: []flamingo.Message{
+		{ID: "m2", Channel: channel, Text: "Jane", User: flamingo.User{ID: "U1"}},
+	}}
+
+	err := rec.IntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		_, err := b.Say(flamingo.NewOutgoingMessage("hello"))
+		return err
+	})).HandleIntro(bot, channel)
+	require.Nil(err)
+
+	msg := flamingo.Message{ID: "m1", Channel: channel, Text: "deploy", User: flamingo.User{ID: "U1", Username: "jane"}}
+	err = rec.Middleware()(bot, msg, func(b flamingo.Bot, msg flamingo.Message) error {
+		if _, err := b.Form(flamingo.Form{
+			Text: "Deploy?",
+			Fields: []flamingo.FieldGroup{
+				flamingo.NewButtonGroup("deploy", flamingo.NewButton("Yes", "yes")),
+				flamingo.Text("text"),
+			},
+		}); err != nil {
+			return err
+		}
+
+		if _, err := b.WaitForAction("deploy", flamingo.IgnorePolicy()); err != nil {
+			return err
+		}
+
+		if _, _, err := b.Ask(flamingo.NewOutgoingMessage("name?")); err != nil {
+			return err
+		}
+
+		if _, _, err := b.SayTo("U1", flamingo.NewOutgoingMessage("psst")); err != nil {
+			return err
+		}
+
+		_, err := b.Reply(msg, flamingo.NewOutgoingMessage("done"))
+		return err
+	})
+	require.Nil(err)
+
+	rec.ActionHandler("deploy", func(b flamingo.Bot, action flamingo.Action) {
+		bot.err = errors.New("message_not_found")
+		b.UpdateMessage(action.OriginalMessage.ID, "deployed")
+	})(bot, flamingo.Action{
+		UserAction:      flamingo.UserAction{Name: "yes", Value: "yes"},
+		Channel:         channel,
+		OriginalMessage: flamingo.Message{ID: "2"},
+	})
+
+	entries, err := ReadTranscript(&buf)
+	require.Nil(err)
+
+	var kinds []Kind
+	for _, e := range entries {
+		kinds = append(kinds, e.Kind)
+		require.Equal("bot", e.Bot)
+		require.Equal(now, e.Time)
+		require.Nil(e.Channel.Extra)
+	}
+
+	require.Equal([]Kind{
+		KindJoin, KindSay,
+		KindMessage, KindForm, KindAction, KindSay, KindMessage, KindSayTo, KindReply,
+		KindAction, KindUpdateMessage,
+	}, kinds)
+
+	require.Equal("C1", entries[0].Channel.ID)
+	require.Nil(entries[0].Channel.Users[0].Extra)
+	require.Equal("hello", entries[1].Text)
+	require.Equal("jane", entries[2].User.Username)
+	require.Equal("m1", entries[2].ID)
+	require.Equal(&Form{
+		Text: "Deploy?",
+		Fields: []FieldGroup{
+			{Type: "buttons", ID: "deploy", Buttons: []flamingo.Button{flamingo.NewButton("Yes", "yes")}},
+			{Type: "text", Text: "text"},
+		},
+	}, entries[3].Form)
+	require.Equal("2", entries[3].ID)
+	require.Equal(&Action{Group: "deploy", Name: "yes", Value: "yes", Message: "2"}, entries[4].Action)
+	require.Equal("Jane", entries[6].Text)
+	require.Equal("U1", entries[7].To)
+	require.Equal("DU1", entries[7].Channel.ID)
+	require.Equal("m1", entries[8].ReplyTo)
+	require.Equal("C1", entries[8].Channel.ID)
+	require.Equal("2", entries[10].ID)
+	require.Equal("message_not_found", entries[10].Error)
+}
+
+func TestFormButton(t *testing.T) {
+	require := require.New(t)
+	form := NewForm(flamingo.Form{
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewButtonGroup("a", flamingo.NewButton("Yes", "yes")),
+			flamingo.NewButtonGroup("b", flamingo.NewButton("No", "no")),
+		},
+	})
+
+	_, group, ok := form.Button("", "no")
+	require.True(ok)
+	require.Equal("b", group)
+
+	_, _, ok = form.Button("a", "no")
+	require.False(ok)
+}
+
+func TestReadTranscript(t *testing.T) {
+	_, err := ReadTranscript(bytes.NewBufferString(`{"Kind":"message"}` + "\n{"))
+	require.NotNil(t, err)
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
@@ -0,0 +1,274 @@
+package flamingo
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultJobHistorySize is the number of runs per job kept by the job history
+// schedulers are created with.
+const DefaultJobHistorySize = 100
+
+// JobRunner runs the given job on all the conversations of a client and
+// returns the number of conversations it ran on and the number of them in
+// which the job failed.
+type JobRunner func(Job) (conversations uint64, errors uint64)
+
+type scheduledJob struct {
+	name     string
+	job      Job
+	schedule ScheduleTime
+	paused   bool
+	stop     chan struct{}
+}
+
+// Scheduler runs named jobs according to their schedules, keeps the history
+// of their runs and allows them to be triggered, paused and resumed at runtime.
+// It is meant to be used by the implementations of Client.
+type Scheduler struct {
+	sync.RWMutex
+	runner  JobRunner
+	clock   Clock
+	history JobHistory
+	jobs    []*scheduledJob
+	running bool
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates a new Scheduler that will execute the jobs using the
+// given JobRunner and measure time with the given Clock.
+func NewScheduler(runner JobRunner, clock Clock) *Scheduler {
+	return &Scheduler{
+		runner:  runner,
+		clock:   clock,
+		history: NewJobHistory(DefaultJobHistorySize),
+	}
+}
+
+// SetHistory sets the JobHistory in which the runs will be recorded.
+func (s *Scheduler) SetHistory(history JobHistory) {
+	s.Lock()
+	defer s.Unlock()
+	s.history = history
+}
+
+// Add adds a new job with the given name. If the scheduler is already running
+// the job is started right away.
+func (s *Scheduler) Add(name string, schedule ScheduleTime, job Job) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.find(name) != nil {
+		return ErrJobExists
+	}
+
+	j := &scheduledJob{
+		name:     name,
+		job:      job,
+		schedule: schedule,
+	}
+	s.jobs = append(s.jobs, j)
+	if s.running {
+		s.start(j)
+	}
+
+	return nil
+}
+
+// Remove removes the job with the given name, which will not be run anymore.
+// A run of the job that is in progress is not interrupted.
+func (s *Scheduler) Remove(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, j := range s.jobs {
+		if j.name == name {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			if s.running {
+				close(j.stop)
+			}
+			return nil
+		}
+	}
+	return ErrJobNotFound
+}
+
+// Len returns the number of jobs in the scheduler.
+func (s *Scheduler) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.jobs)
+}
+
+// Start starts running all the jobs according to their schedule.
+func (s *Scheduler) Start() {
+	s.Lock()
+	defer s.Unlock()
+	if s.running {
+		return
+	}
+
+	s.running = true
+	for _, j := range s.jobs {
+		s.start(j)
+	}
+}
+
+func (s *Scheduler) start(j *scheduledJob) {
+	j.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.loop(j, j.stop)
+}
+
+// Stop stops all the jobs and waits until the ones that are being run finish.
+func (s *Scheduler) Stop() {
+	s.Lock()
+	if !s.running {
+		s.Unlock()
+		return
+	}
+
+	s.running = false
+	for _, j := range s.jobs {
+		close(j.stop)
+	}
+	s.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(j *scheduledJob, stop <-chan struct{}) {
+	defer s.wg.Done()
+
+	due := j.schedule.Next(s.clock.Now())
+	for {
+		// a zero time means the job will never run again, so wait only
+		// for the scheduler to be stopped.
+		var timer <-chan time.Time
+		if !due.IsZero() {
+			timer = s.clock.After(due.Sub(s.clock.Now()))
+		}
+
+		select {
+		case <-timer:
+			next, misfires := nextRun(j.schedule, due, s.clock.Now())
+			if !s.isPaused(j) {
+				s.run(j, due, misfires)
+			}
+			due = next
+		case <-stop:
+			return
+		}
+	}
+}
+
+// nextRun returns the first time after now the job should run, given it
+// was due at the given time, and how many times it should have run between
+// both times.
+func nextRun(schedule ScheduleTime, due, now time.Time) (time.Time, uint64) {
+	var misfires uint64
+	next := schedule.Next(due)
+	for !next.IsZero() && !next.After(now) {
+		following := schedule.Next(next)
+		if !following.After(next) {
+			break
+		}
+
+		misfires++
+		next = following
+	}
+
+	return next, misfires
+}
+
+func (s *Scheduler) isPaused(j *scheduledJob) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return j.paused
+}
+
+func (s *Scheduler) run(j *scheduledJob, scheduled time.Time, misfires uint64) (JobRun, error) {
+	run := JobRun{
+		Job:       j.name,
+		Manual:    scheduled.IsZero(),
+		Scheduled: scheduled,
+		Misfires:  misfires,
+		Start:     s.clock.Now(),
+	}
+	run.Conversations, run.Errors = s.runner(j.job)
+	run.End = s.clock.Now()
+
+	s.RLock()
+	history := s.history
+	s.RUnlock()
+
+	return run, history.Record(run)
+}
+
+// Run executes the job with the given name immediately, even if it is
+// paused, and returns the record of the run.
+func (s *Scheduler) Run(name string) (JobRun, error) {
+	s.RLock()
+	j := s.find(name)
+	s.RUnlock()
+	if j == nil {
+		return JobRun{}, ErrJobNotFound
+	}
+
+	return s.run(j, time.Time{}, 0)
+}
+
+// Pause prevents the job with the given name from being run by its schedule
+// until it is resumed.
+func (s *Scheduler) Pause(name string) error {
+	return s.setPaused(name, true)
+}
+
+// Resume makes the job with the given name run by its schedule again.
+func (s *Scheduler) Resume(name string) error {
+	return s.setPaused(name, false)
+}
+
+func (s *Scheduler) setPaused(name string, paused bool) error {
+	s.Lock()
+	defer s.Unlock()
+	j := s.find(name)
+	if j == nil {
+		return ErrJobNotFound
+	}
+
+	j.paused = paused
+	return nil
+}
+
+// IsPaused reports whether the job with the given name is paused.
+func (s *Scheduler) IsPaused(name string) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	j := s.find(name)
+	if j == nil {
+		return false, ErrJobNotFound
+	}
+
+	return j.paused, nil
+}
+
+// History returns the recorded runs of the job with the given name.
+func (s *Scheduler) History(name string) ([]JobRun, error) {
+	s.RLock()
+	j := s.find(name)
+	history := s.history
+	s.RUnlock()
+	if j == nil {
+		return nil, ErrJobNotFound
+	}
+
+	return history.Runs(name)
+}
+
+func (s *Scheduler) find(name string) *scheduledJob {
+	for _, j := range s.jobs {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package flamingo
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHistory(t *testing.T) {
+	require := require.New(t)
+	h := NewJobHistory(2)
+
+	for i := 0; i < 3; i++ {
+		require.Nil(h.Record(JobRun{Job: "foo", Conversations: uint64(i)}))
+	}
+	require.Nil(h.Record(JobRun{Job: "bar"}))
+
+	runs, err := h.Runs("foo")
+	require.Nil(err)
+	require.Equal(2, len(runs))
+	require.Equal(uint64(1), runs[0].Conversations)
+	require.Equal(uint64(2), runs[1].Conversations)
+
+	runs, err = h.Runs("bar")
+	require.Nil(err)
+	require.Equal(1, len(runs))
+
+	runs, err = h.Runs("baz")
+	require.Nil(err)
+	require.Equal(0, len(runs))
+}
+
+type runnerMock struct {
+	calls int32
+}
+
+func (r *runnerMock) run(job Job) (uint64, uint64) {
+	atomic.AddInt32(&r.calls, 1)
+	if err := job(nil, Channel{}); err != nil {
+		return 1, 1
+	}
+	return 1, 0
+}
+
+func (r *runnerMock) count() int {
+	return int(atomic.LoadInt32(&r.calls))
+}
+
+func noopJob(Bot, Channel) error {
+	return nil
+}
+
+func TestSchedulerAdd(t *testing.T) {
+	require := require.New(t)
+	s := NewScheduler(new(runnerMock).run, NewClock())
+
+	require.Nil(s.Add("foo", NewIntervalSchedule(time.Hour), noopJob))
+	require.Equal(ErrJobExists, s.Add("foo", NewIntervalSchedule(time.Hour), noopJob))
+	require.Equal(1, s.Len())
+}
+
+func TestSchedulerRun(t *testing.T) {
+	require := require.New(t)
+	runner := new(runnerMock)
+	s := NewScheduler(runner.run, NewClock())
+	require.Nil(s.Add("foo", NewIntervalSchedule(time.Hour), noopJob))
+
+	run, err := s.Run("foo")
+	require.Nil(err)
+	require.Equal("foo", run.Job)
+	require.True(run.Manual)
+	require.Equal(uint64(1), run.Conversations)
+	require.Equal(uint64(0), run.Errors)
+	require.False(run.End.Before(run.Start))
+	require.Equal(1, runner.count())
+
+	runs, err := s.History("foo")
+	require.Nil(err)
+	require.Equal([]JobRun{run}, runs)
+
+	_, err = s.Run("bar")
+	require.Equal(ErrJobNotFound, err)
+
+	_, err = s.History("bar")
+	require.Equal(ErrJobNotFound, err)
+}
+
+var epoch = time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)
+
+func waitForRuns(t *testing.T, r *runnerMock, n int) {
+	deadline := time.After(time.Second)
+	for r.count() < n {
+		select {
+		case <-deadline:
+			require.FailNow(t, "job was not run", "expected %d runs, got %d", n, r.count())
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestSchedulerStartAndStop(t *testing.T) {
+	require := require.New(t)
+	runner := new(runnerMock)
+	clock := NewFakeClock(epoch)
+	s := NewScheduler(runner.run, clock)
+	require.Nil(s.Add("foo", NewIntervalSchedule(time.Minute), noopJob))
+
+	s.Start()
+	require.True(clock.BlockUntil(1, time.Second))
+	clock.Advance(59 * time.Second)
+	require.Equal(0, runner.count())
+
+	clock.Advance(time.Second)
+	waitForRuns(t, runner, 1)
+	require.True(clock.BlockUntil(1, time.Second))
+	s.Stop()
+
+	runs, err := s.History("foo")
+	require.Nil(err)
+	require.Equal(1, len(runs))
+	require.False(runs[0].Manual)
+	require.Equal(epoch.Add(time.Minute), runs[0].Scheduled)
+	require.Equal(uint64(0), runs[0].Misfires)
+
+	clock.Advance(time.Hour)
+	require.Equal(1, runner.count())
+}
+
+func TestSchedulerMisfires(t *testing.T) {
+	require := require.New(t)
+	runner := new(runnerMock)
+	clock := NewFakeClock(epoch)
+	s := NewScheduler(runner.run, clock)
+	require.Nil(s.Add("foo", NewIntervalSchedule(time.Minute), noopJob))
+
+	s.Start()
+	defer s.Stop()
+	require.True(clock.BlockUntil(1, time.Second))
+	clock.Advance(3*time.Minute + 30*time.Second)
+	waitForRuns(t, runner, 1)
+	require.True(clock.BlockUntil(1, time.Second))
+
+	runs, err := s.History("foo")
+	require.Nil(err)
+	require.Equal(1, len(runs))
+	require.Equal(epoch.Add(time.Minute), runs[0].Scheduled)
+	require.Equal(uint64(2), runs[0].Misfires)
+
+	clock.Advance(30 * time.Second)
+	waitForRuns(t, runner, 2)
+	require.True(clock.BlockUntil(1, time.Second))
+
+	runs, err = s.History("foo")
+	require.Nil(err)
+	require.Equal(2, len(runs))
+	require.Equal(epoch.Add(4*time.Minute), runs[1].Scheduled)
+	require.Equal(uint64(0), runs[1].Misfires)
+}
+
+func TestSchedulerPauseAndResume(t *testing.T) {
+	require := require.New(t)
+	runner := new(runnerMock)
+	clock := NewFakeClock(epoch)
+	s := NewScheduler(runner.run, clock)
+	require.Nil(s.Add("foo", NewIntervalSchedule(time.Minute), noopJob))
+	require.Nil(s.Pause("foo"))
+
+	paused, err := s.IsPaused("foo")
+	require.Nil(err)
+	require.True(paused)
+
+	s.Start()
+	defer s.Stop()
+	require.True(clock.BlockUntil(1, time.Second))
+	clock.Advance(time.Minute)
+	require.True(clock.BlockUntil(1, time.Second))
+	require.Equal(0, runner.count())
+
+	_, err = s.Run("foo")
+	require.Nil(err)
+	require.Equal(1, runner.count())
+
+	require.Nil(s.Resume("foo"))
+	clock.Advance(time.Minute)
+	waitForRuns(t, runner, 2)
+
+	require.Equal(ErrJobNotFound, s.Pause("bar"))
+	require.Equal(ErrJobNotFound, s.Resume("bar"))
+	_, err = s.IsPaused("bar")
+	require.Equal(ErrJobNotFound, err)
+}
+
+func TestSchedulerAddWhileRunning(t *testing.T) {
+	runner := new(runnerMock)
+	clock := NewFakeClock(epoch)
+	s := NewScheduler(runner.run, clock)
+	s.Start()
+	defer s.Stop()
+
+	require.Nil(t, s.Add("foo", NewIntervalSchedule(time.Minute), noopJob))
+	require.True(t, clock.BlockUntil(1, time.Second))
+	clock.Advance(time.Minute)
+	waitForRuns(t, runner, 1)
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	require := require.New(t)
+	runner := new(runnerMock)
+	clock := NewFakeClock(epoch)
+	s := NewScheduler(runner.run, clock)
+	require.Nil(s.Add("foo", NewIntervalSchedule(time.Minute), noopJob))
+	require.Nil(s.Add("bar", NewIntervalSchedule(time.Hour), noopJob))
+	s.Start()
+	require.True(clock.BlockUntil(2, time.Second))
+
+	require.Nil(s.Remove("foo"))
+	require.Equal(ErrJobNotFound, s.Remove("foo"))
+	require.Equal(1, s.Len())
+	_, err := s.Run("foo")
+	require.Equal(ErrJobNotFound, err)
+
+	clock.Advance(time.Minute)
+	s.Stop()
+	require.Equal(0, runner.count())
+}
+
+func TestSchedulerNeverRuns(t *testing.T) {
+	runner := new(runnerMock)
+	clock := NewFakeClock(epoch)
+	s := NewScheduler(runner.run, clock)
+	require.Nil(t, s.Add("foo", NewDayTimeSchedule(nil, 0, 0, 0), noopJob))
+	s.Start()
+	clock.Advance(24 * time.Hour)
+	s.Stop()
+	require.Equal(t, 0, runner.count())
+	require.Equal(t, 0, clock.Timers())
+}
@@ -6,14 +6,7 @@ import (
 )
 
 func convertAction(action slack.AttachmentActionCallback, api slackAPI) (flamingo.Action, error) {
-	var userAction flamingo.UserAction
-	for _, a := range action.Actions {
-		userAction = flamingo.UserAction{
-			Name:  a.Name,
-			Value: a.Value,
-		}
-	}
-
+	userAction := convertUserAction(action)
 	info, err := api.GetUserInfo(action.User.ID)
 	if err != nil {
 		return flamingo.Action{}, err
@@ -32,3 +25,16 @@ func convertAction(action slack.AttachmentActionCallback, api slackAPI) (flaming
 		Extra:           action,
 	}, nil
 }
+
+// convertUserAction returns the last action performed by the user in the
+// callback.
+func convertUserAction(action slack.AttachmentActionCallback) flamingo.UserAction {
+	var userAction flamingo.UserAction
+	for _, a := range action.Actions {
+		userAction = flamingo.UserAction{
+			Name:  a.Name,
+			Value: a.Value,
+		}
+	}
+	return userAction
+}
@@ -0,0 +1,241 @@
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mvader/slack"
+)
+
+// DefaultAPIURL is the URL of the Slack Web API.
+const DefaultAPIURL = "https://slack.com/api/"
+
+// APIError is an error returned by the Slack Web API.
+type APIError struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// Code is the error code returned by slack, such as "channel_not_found".
+	Code string
+}
+
+func (e *APIError) Error() string {
+	return e.Code
+}
+
+// Temporary reports whether the request can be retried later.
+func (e *APIError) Temporary() bool {
+	if e.Status == http.StatusTooManyRequests || e.Status >= 500 {
+		return true
+	}
+
+	for _, code := range transientErrors {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// api performs the requests of a single bot to the Slack Web API.
+type api struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newAPI(url, token string, client *http.Client) *api {
+	return &api{
+		url:    strings.TrimRight(url, "/") + "/",
+		token:  token,
+		client: client,
+	}
+}
+
+// call performs a request to the given method with the given params and
+// decodes the response in result, if it is not nil.
+func (a *api) call(method string, params url.Values, result interface{}) error {
+	params.Set("token", a.token)
+	resp, err := a.client.PostForm(a.url+method, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var data json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return &APIError{Status: resp.StatusCode, Code: "ratelimited"}
+		}
+		return &APIError{Status: resp.StatusCode, Code: resp.Status}
+	}
+
+	var r struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+
+	if !r.Ok {
+		return &APIError{Status: resp.StatusCode, Code: r.Error}
+	}
+
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+
+	return nil
+}
+
+func encodeAttachments(params url.Values, attachments []slack.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(attachments)
+	if err != nil {
+		return err
+	}
+
+	params.Set("attachments", string(data))
+	return nil
+}
+
+func (a *api) PostMessage(channel, text string, params slack.PostMessageParameters) (string, string, error) {
+	values := url.Values{
+		"channel": {channel},
+		"text":    {text},
+	}
+
+	if params.Username != "" {
+		values.Set("username", params.Username)
+	}
+
+	if params.AsUser {
+		values.Set("as_user", "true")
+	}
+
+	if params.IconURL != "" {
+		values.Set("icon_url", params.IconURL)
+	}
+
+	if params.IconEmoji != "" {
+		values.Set("icon_emoji", params.IconEmoji)
+	}
+
+	if params.Parse != "" {
+		values.Set("parse", params.Parse)
+	}
+
+	if params.LinkNames != 0 {
+		values.Set("link_names", fmt.Sprint(params.LinkNames))
+	}
+
+	if params.UnfurlLinks {
+		values.Set("unfurl_links", "true")
+	}
+
+	if params.UnfurlMedia {
+		values.Set("unfurl_media", "true")
+	}
+
+	if params.Markdown {
+		values.Set("mrkdwn", "true")
+	}
+
+	if err := encodeAttachments(values, params.Attachments); err != nil {
+		return "", "", err
+	}
+
+	var r struct {
+		Channel string `json:"channel"`
+		Ts      string `json:"ts"`
+	}
+	err := a.call("chat.postMessage", values, &r)
+	return r.Channel, r.Ts, err
+}
+
+func (a *api) UpdateMessage(channel, ts, text string, params slack.UpdateMessageParameters) (string, string, string, error) {
+	values := url.Values{
+		"channel": {channel},
+		"ts":      {ts},
+		"text":    {text},
+	}
+
+	if err := encodeAttachments(values, params.Attachments); err != nil {
+		return "", "", "", err
+	}
+
+	var r struct {
+		Channel string `json:"channel"`
+		Ts      string `json:"ts"`
+		Text    string `json:"text"`
+	}
+	err := a.call("chat.update", values, &r)
+	return r.Channel, r.Ts, r.Text, err
+}
+
+func (a *api) GetUserInfo(user string) (*slack.User, error) {
+	var r struct {
+		User slack.User `json:"user"`
+	}
+	if err := a.call("users.info", url.Values{"user": {user}}, &r); err != nil {
+		return nil, err
+	}
+	return &r.User, nil
+}
+
+func (a *api) GetUserByUsername(username string) (*slack.User, error) {
+	var r struct {
+		Members []slack.User `json:"members"`
+	}
+	if err := a.call("users.list", url.Values{}, &r); err != nil {
+		return nil, err
+	}
+
+	for _, u := range r.Members {
+		if u.Name == username {
+			return &u, nil
+		}
+	}
+
+	return nil, errors.New("not_found")
+}
+
+func (a *api) GetChannelInfo(channel string) (*slack.Channel, error) {
+	var r struct {
+		Channel slack.Channel `json:"channel"`
+	}
+	if err := a.call("channels.info", url.Values{"channel": {channel}}, &r); err != nil {
+		return nil, err
+	}
+	return &r.Channel, nil
+}
+
+func (a *api) OpenIMChannel(user string) (bool, bool, string, error) {
+	var r struct {
+		NoOp        bool `json:"no_op"`
+		AlreadyOpen bool `json:"already_open"`
+		Channel     struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	err := a.call("im.open", url.Values{"user": {user}}, &r)
+	return r.NoOp, r.AlreadyOpen, r.Channel.ID, err
+}
+
+// startRTM requests a new RTM session and returns the URL of its websocket.
+func (a *api) startRTM() (string, error) {
+	var r struct {
+		URL string `json:"url"`
+	}
+	if err := a.call("rtm.start", url.Values{}, &r); err != nil {
+		return "", err
+	}
+	return r.URL, nil
+}
@@ -2,15 +2,18 @@ package slack
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
-	"reflect"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/mvader/slack"
 	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+	"github.com/src-d/flamingo/slacktest"
 	"github.com/src-d/flamingo/storage"
 	"github.com/stretchr/testify/require"
 )
@@ -39,37 +42,6 @@ func (c *helloCtrl) HandleIntro(b flamingo.Bot, channel flamingo.Channel) error
 	return nil
 }
 
-func TestControllerFor(t *testing.T) {
-	require := require.New(t)
-	cli := newClient("", ClientOptions{})
-	var ctrl flamingo.Controller = &helloCtrl{}
-	cli.AddController(ctrl)
-
-	result, ok := cli.ControllerFor(flamingo.Message{Text: "hello"})
-	require.True(ok)
-	require.Equal(reflect.ValueOf(ctrl.Handle).Pointer(), reflect.ValueOf(result).Pointer())
-
-	result, ok = cli.ControllerFor(flamingo.Message{Text: "goodbye"})
-	require.False(ok)
-	require.Nil(result)
-}
-
-func TestActionHandler(t *testing.T) {
-	require := require.New(t)
-	cli := newClient("", ClientOptions{})
-	var handler flamingo.ActionHandler = func(b flamingo.Bot, a flamingo.Action) {
-	}
-	cli.AddActionHandler("foo", handler)
-
-	result, ok := cli.ActionHandler("foo")
-	require.True(ok)
-	require.Equal(reflect.ValueOf(handler).Pointer(), reflect.ValueOf(result).Pointer())
-
-	result, ok = cli.ActionHandler("bar")
-	require.False(ok)
-	require.Nil(result)
-}
-
 func TestRunAndStopWebhook(t *testing.T) {
 	require := require.New(t)
 	cli := newClient("xAB3yVzGS4BQ3O9FACTa8Ho4", ClientOptions{
@@ -94,52 +66,36 @@ func TestRunAndStopWebhook(t *testing.T) {
 	require.NotNil(err)
 }
 
-type clientBotMock struct {
-	sync.RWMutex
-	stopped       bool
-	actions       []slack.AttachmentActionCallback
-	channels      []string
-	handledJobs   int
-	conversations []string
-}
-
-func (b *clientBotMock) stop() {
-	b.Lock()
-	defer b.Unlock()
-	b.stopped = true
-}
-
-func (b *clientBotMock) handleAction(channel string, action slack.AttachmentActionCallback) {
-	b.Lock()
-	defer b.Unlock()
-	b.channels = append(b.channels, channel)
-	b.actions = append(b.actions, action)
-}
-
-func (b *clientBotMock) handleJob(job flamingo.Job) {
-	b.Lock()
-	defer b.Unlock()
-	b.handledJobs++
+func newMockedClient(token string, options ClientOptions) (*slackClient, *slackRTMMock) {
+	cli := newClient(token, options)
+	mock := newSlackRTMMock()
+	cli.platform.newRTM = func(string) slackRTM {
+		return mock
+	}
+	return cli, mock
 }
 
-func (b *clientBotMock) addConversation(id string) error {
-	b.Lock()
-	defer b.Unlock()
-	b.conversations = append(b.conversations, id)
-	return nil
+func eventually(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			require.FailNow(t, "condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
 }
 
 func TestRunAndStop(t *testing.T) {
 	require := require.New(t)
-	cli := newClient("xAB3yVzGS4BQ3O9FACTa8Ho4", ClientOptions{
+	cli, _ := newMockedClient("xAB3yVzGS4BQ3O9FACTa8Ho4", ClientOptions{
 		Webhook: WebhookOptions{Addr: "127.0.0.1:8787", Enabled: true},
-		Debug:   true,
 	})
 
-	bot := &clientBotMock{}
-	bot2 := &clientBotMock{}
-	cli.bots["bot"] = bot
-	cli.bots["bot2"] = bot2
+	actions := make(chan flamingo.Action, 1)
+	cli.AddActionHandler("test_callback", func(_ flamingo.Bot, action flamingo.Action) {
+		actions <- action
+	})
+	cli.AddBot("bot", "foo", nil)
 
 	var stopped = make(chan struct{}, 1)
 	go func() {
@@ -154,213 +110,293 @@ func TestRunAndStop(t *testing.T) {
 	require.Nil(err)
 	require.Equal(resp.StatusCode, http.StatusOK)
 
-	require.Nil(cli.Stop())
-	<-time.After(50 * time.Millisecond)
+	select {
+	case action := <-actions:
+		require.Equal("channel", action.Channel.ID)
+		require.Equal("user", action.User.Username)
+	case <-time.After(time.Second):
+		require.FailNow("action was not handled")
+	}
 
+	require.Nil(cli.Stop())
 	select {
 	case <-stopped:
-	case <-time.After(50 * time.Millisecond):
+	case <-time.After(time.Second):
 		require.FailNow("did not stop")
 	}
-	require.True(bot.stopped)
-	require.Equal(1, len(bot.actions))
-	require.Equal("test_callback", bot.actions[0].CallbackID)
-	require.Equal("channel", bot.channels[0])
-
-	require.True(bot2.stopped)
-	require.Equal(0, len(bot2.actions))
-}
-
-func TestSetIntroHandler(t *testing.T) {
-	cli := newClient("", ClientOptions{})
-	ctrl := &helloCtrl{}
-	cli.SetIntroHandler(ctrl)
-	require.Equal(t, reflect.ValueOf(ctrl).Pointer(), reflect.ValueOf(cli.introHandler).Pointer())
 }
 
-func TestHandleIntro(t *testing.T) {
-	cli := newClient("", ClientOptions{})
+func TestMessages(t *testing.T) {
+	require := require.New(t)
+	cli, mock := newMockedClient("", ClientOptions{})
 	ctrl := &helloCtrl{}
-	cli.HandleIntro(nil, flamingo.Channel{})
+	cli.AddController(ctrl)
 	cli.SetIntroHandler(ctrl)
-	cli.HandleIntro(nil, flamingo.Channel{})
-	require.Equal(t, 1, ctrl.calledIntro)
-}
-
-func TestStartScheduledJobsAndStop(t *testing.T) {
-	cli := newClient("", ClientOptions{})
-	cli.AddScheduledJob(flamingo.NewIntervalSchedule(1*time.Second), func(_ flamingo.Bot, _ flamingo.Channel) error {
-		require.FailNow(t, "scheduled job was run")
-		return nil
-	})
-
+	cli.AddBot("UBOT", "foo", nil)
 	go cli.Run()
-	<-time.After(50 * time.Millisecond)
-	cli.Stop()
-}
+	defer cli.Stop()
 
-func TestScheduledJobs(t *testing.T) {
-	cli := newClient("", ClientOptions{})
-	cli.AddScheduledJob(flamingo.NewIntervalSchedule(50*time.Millisecond), func(_ flamingo.Bot, _ flamingo.Channel) error {
-		return nil
-	})
-	mock := &clientBotMock{}
-	cli.bots["foo"] = mock
+	mock.events <- slack.RTMEvent{Data: &slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "U1", Text: "hello"}}}
+	mock.events <- slack.RTMEvent{Data: &slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "UBOT", Text: "hello"}}}
+	mock.events <- slack.RTMEvent{Data: &slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "U1", Text: "hello"}}}
 
-	go cli.Run()
-	defer cli.Stop()
+	eventually(t, func() bool {
+		ctrl.RLock()
+		defer ctrl.RUnlock()
+		return len(ctrl.msgs) == 2
+	})
 
-	<-time.After(90 * time.Millisecond)
-	mock.RLock()
-	defer mock.RUnlock()
-	require.Equal(t, 1, mock.handledJobs)
+	ctrl.RLock()
+	defer ctrl.RUnlock()
+	require.Equal(1, ctrl.calledIntro)
+	require.Equal("channel", ctrl.msgs[0].Channel.Name)
+	require.Equal("U1", ctrl.msgs[0].User.ID)
 }
 
 func TestLoadFromStorage(t *testing.T) {
+	require := require.New(t)
 	cli := newClient("", ClientOptions{})
+	var tokens []string
+	var mut sync.Mutex
+	cli.platform.newRTM = func(token string) slackRTM {
+		mut.Lock()
+		defer mut.Unlock()
+		tokens = append(tokens, token)
+		return newSlackRTMMock()
+	}
+
 	storage := storage.NewMemory()
 	storage.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo"})
 	storage.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1"})
 	cli.SetStorage(storage)
-	require.Nil(t, cli.loadFromStorage())
-	_, ok := cli.bots["1"]
-	require.True(t, ok)
-
-	_, ok = cli.bots["1"].(*botClient).conversations["2"]
-	require.True(t, ok)
-}
+	go cli.Run()
+	defer cli.Stop()
 
-func TestSave(t *testing.T) {
-	cli := newClient("", ClientOptions{})
-	storage := storage.NewMemory()
-	cli.SetStorage(storage)
-	cli.AddBot("1", "foo", nil)
-	cli.bots["1"].addConversation("2")
+	eventually(t, func() bool {
+		_, err := cli.Connection("1")
+		return err == nil
+	})
 
-	ok, _ := storage.BotExists(flamingo.StoredBot{ID: "1"})
-	require.True(t, ok)
-	ok, _ = storage.ConversationExists(flamingo.StoredConversation{ID: "2"})
-	require.True(t, ok)
+	mut.Lock()
+	defer mut.Unlock()
+	require.Equal([]string{"foo"}, tokens)
 }
 
-func TestAddBotOnlyOnce(t *testing.T) {
+func TestInvalidAuth(t *testing.T) {
 	require := require.New(t)
-	cli := newClient("", ClientOptions{})
+	cli, mock := newMockedClient("", ClientOptions{})
 	storage := storage.NewMemory()
 	cli.SetStorage(storage)
-	cli.AddBot("1", "foo", nil)
-	cli.AddBot("2", "foo", nil)
-	cli.AddBot("1", "foo", nil)
+	cli.AddBot("UBOT", "foo", nil)
+	go cli.Run()
+	defer cli.Stop()
 
-	bots, _ := storage.LoadBots()
-	require.Equal(2, len(bots))
-	require.Equal(2, len(cli.loadedBots))
+	mock.events <- slack.RTMEvent{Data: &slack.InvalidAuthEvent{}}
+	eventually(t, func() bool {
+		_, err := cli.Connection("UBOT")
+		return err == platform.ErrBotNotFound
+	})
+
+	ok, err := storage.BotExists(flamingo.StoredBot{ID: "UBOT"})
+	require.Nil(err)
+	require.False(ok)
 }
 
-func TestWrap(t *testing.T) {
+func TestBroadcastRetries(t *testing.T) {
 	require := require.New(t)
-	cli := newClient("", ClientOptions{})
+	cli, mock := newMockedClient("", ClientOptions{})
+	cli.AddBot("UBOT", "foo", nil)
+	go cli.Run()
+	defer cli.Stop()
 
-	var result []string
-	cli.Use(func(bot flamingo.Bot, msg flamingo.Message, next flamingo.HandlerFunc) error {
-		result = append(result, "1")
-		return next(bot, msg)
+	mock.events <- slack.RTMEvent{Data: &slack.IMCreatedEvent{User: "U1", Channel: slack.ChannelCreatedInfo{ID: "D1"}}}
+	eventually(t, func() bool {
+		convs, err := cli.Storage().LoadConversations(flamingo.StoredBot{ID: "UBOT"})
+		return err == nil && len(convs) == 1
 	})
 
-	cli.Use(func(bot flamingo.Bot, msg flamingo.Message, next flamingo.HandlerFunc) error {
-		result = append(result, "2")
-		return next(bot, msg)
+	var calls int
+	mock.Lock()
+	mock.callback = func(args postMessageArgs) bool {
+		calls++
+		return calls > 2
+	}
+	mock.err = errors.New("ratelimited")
+	mock.Unlock()
+
+	var progress []int
+	report, err := cli.Broadcast(flamingo.NewOutgoingMessage("foo"), flamingo.All(), flamingo.BroadcastOptions{
+		Retries:    3,
+		RetryDelay: time.Millisecond,
+		Progress: func(d flamingo.Delivery, done, total int) {
+			progress = append(progress, done, total)
+		},
 	})
+	require.Nil(err)
+	require.Equal(1, len(report.Deliveries))
+	require.Equal(3, report.Deliveries[0].Attempts)
+	require.True(report.Deliveries[0].Delivered())
+	require.Equal([]int{1, 1}, progress)
+
+	mock.Lock()
+	calls = 0
+	mock.err = errors.New("channel_not_found")
+	mock.Unlock()
+	report, err = cli.Broadcast(flamingo.NewOutgoingMessage("foo"), flamingo.All(), flamingo.BroadcastOptions{Retries: 3, RetryDelay: time.Millisecond})
+	require.Equal(flamingo.ErrAllMessagesLost, err)
+	require.Equal(1, report.Deliveries[0].Attempts)
+	require.Equal("channel_not_found", report.Deliveries[0].Error)
+}
+
+func TestIsTransientError(t *testing.T) {
+	require := require.New(t)
+	require.True(isTransientError(errors.New("ratelimited")))
+	require.True(isTransientError(errors.New("slack rate limit exceeded, retry after 1s")))
+	require.False(isTransientError(errors.New("channel_not_found")))
+}
+
+type deployCtrl struct{}
+
+func (deployCtrl) CanHandle(msg flamingo.Message) bool {
+	return true
+}
+
+func (deployCtrl) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	if msg.Text != "deploy" {
+		_, err := bot.Reply(msg, flamingo.NewOutgoingMessage("echo: "+msg.Text))
+		return err
+	}
 
-	handler := cli.wrap(func(_ flamingo.Bot, msg flamingo.Message) error {
-		result = append(result, msg.Text)
-		return nil
+	_, err := bot.Form(flamingo.Form{
+		Text: "Deploy?",
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewButtonGroup("deploy",
+				flamingo.NewButton("Yes", "yes"),
+				flamingo.NewButton("No", "no"),
+			),
+		},
 	})
+	return err
+}
 
-	require.Nil(handler(nil, flamingo.Message{Text: "3"}))
-	require.Equal([]string{"1", "2", "3"}, result)
+func (deployCtrl) HandleIntro(bot flamingo.Bot, channel flamingo.Channel) error {
+	_, err := bot.Say(flamingo.NewOutgoingMessage("hello " + channel.Name))
+	return err
 }
 
-func TestBroadcast(t *testing.T) {
+func TestEndToEnd(t *testing.T) {
 	require := require.New(t)
-	mock := newSlackRTMMock()
+	srv := slacktest.NewServer()
+	defer srv.Close()
+	srv.AddBot("xoxb-test", "UBOT", "flamingo")
+	srv.AddUser("U1", "jane")
+	srv.AddChannel("C1", "general", "U1", "UBOT")
+
+	cli := newClient("tok", ClientOptions{
+		APIURL:  srv.APIURL(),
+		Webhook: WebhookOptions{Enabled: true, Addr: "127.0.0.1:8990"},
+	})
+	cli.AddController(deployCtrl{})
+	cli.SetIntroHandler(deployCtrl{})
+	cli.AddActionHandler("deploy", func(bot flamingo.Bot, action flamingo.Action) {
+		bot.UpdateMessage(action.OriginalMessage.ID, action.User.Username+" said "+action.UserAction.Value)
+	})
+	cli.AddBot("UBOT", "xoxb-test", nil)
+	go cli.Run()
+	defer cli.Stop()
 
-	bot1 := &botClient{
-		id:  "bot1",
-		rtm: mock,
-		conversations: map[string]*botConversation{
-			"conv1-1": {
-				rtm:     mock,
-				channel: flamingo.Channel{ID: "1"},
-			},
-			"conv1-2": {
-				rtm:     mock,
-				channel: flamingo.Channel{ID: "2"},
-			},
-		},
-	}
-	bot2 := &botClient{
-		id:  "bot2",
-		rtm: mock,
-		conversations: map[string]*botConversation{
-			"conv2-1": {
-				rtm:     mock,
-				channel: flamingo.Channel{ID: "3"},
-			},
-			"conv2-2": {
-				rtm:     mock,
-				channel: flamingo.Channel{ID: "4"},
-			},
-		},
-	}
-	bot3 := &botClient{
-		id:  "bot3",
-		rtm: mock,
-		conversations: map[string]*botConversation{
-			"conv3-1": {
-				rtm:     mock,
-				channel: flamingo.Channel{ID: "5"},
-			},
-			"conv3-2": {
-				rtm:     mock,
-				channel: flamingo.Channel{ID: "6"},
-			},
-		},
-	}
-	cli := &slackClient{
-		bots: map[string]clientBot{
-			"bot1": bot1,
-			"bot2": bot2,
-			"bot3": bot3,
-		},
-	}
+	srv.SendMessage("C1", "U1", "hi")
+	msgs, err := srv.WaitForMessages(2, time.Second)
+	require.Nil(err)
+	require.Equal("hello general", msgs[0].Text)
+	require.Equal("@jane: echo: hi", msgs[1].Text)
+	require.Equal("C1", msgs[1].Channel)
 
-	filter := func(bot string, channel flamingo.Channel) bool {
-		return bot != "bot1" && channel.ID != "3"
+	srv.SendMessage("C1", "U1", "deploy")
+	msgs, err = srv.WaitForMessages(3, time.Second)
+	require.Nil(err)
+	form := msgs[2]
+	_, callbackID, ok := form.Action("no")
+	require.True(ok)
+	require.Equal("UBOT::C1::deploy", callbackID)
+
+	// the webhook may take a moment to start listening
+	for i := 0; i < 10; i++ {
+		if err = srv.Click("http://127.0.0.1:8990", "tok", form, "U1", "no"); err == nil {
+			break
+		}
+		<-time.After(50 * time.Millisecond)
 	}
-	bots, convs, errors, err := cli.Broadcast(flamingo.NewOutgoingMessage("foo"), filter)
 	require.Nil(err)
-	require.Equal(uint64(0), errors)
-	require.Equal(uint64(2), bots)
-	require.Equal(uint64(3), convs)
-	require.Equal(int(convs), len(mock.msgs))
+
+	msgs, err = srv.WaitForMessages(4, time.Second)
+	require.Nil(err)
+	require.True(msgs[3].Updated)
+	require.Equal(form.Timestamp, msgs[3].Timestamp)
+	require.Equal("jane said no", msgs[3].Text)
 }
 
-func TestSend(t *testing.T) {
+func TestClientsWithDifferentAPIURLs(t *testing.T) {
 	require := require.New(t)
-	mock := newapiMock(nil)
-	bot := &bot{
-		id:  "bar",
-		api: mock,
-		channel: flamingo.Channel{
-			ID: "foo",
-		},
+	var servers []*slacktest.Server
+	for i := 0; i < 2; i++ {
+		srv := slacktest.NewServer()
+		defer srv.Close()
+		srv.AddBot("xoxb-test", "UBOT", "flamingo")
+		srv.AddUser("U1", "jane")
+		srv.AddChannel("C1", "general", "U1", "UBOT")
+		servers = append(servers, srv)
+
+		cli := newClient("", ClientOptions{APIURL: srv.APIURL()})
+		cli.AddController(deployCtrl{})
+		cli.AddBot("UBOT", "xoxb-test", nil)
+		go cli.Run()
+		defer cli.Stop()
 	}
 
-	require.Nil(send(bot, flamingo.NewOutgoingMessage("foo")))
-	require.Nil(send(bot, flamingo.Image{URL: "foo"}))
-	require.Nil(send(bot, flamingo.Form{Text: "foo"}))
-	require.Equal(len(mock.msgs), 3)
+	for i, srv := range servers {
+		text := fmt.Sprint("hi ", i)
+		srv.SendMessage("C1", "U1", text)
+		msgs, err := srv.WaitForMessages(1, time.Second)
+		require.Nil(err)
+		require.Equal("@jane: echo: "+text, msgs[0].Text)
+	}
+}
+
+func TestInvalidAuthEndToEnd(t *testing.T) {
+	require := require.New(t)
+	srv := slacktest.NewServer()
+	defer srv.Close()
+
+	cli := newClient("", ClientOptions{APIURL: srv.APIURL()})
+	cli.AddBot("UBOT", "xoxb-revoked", nil)
+	go cli.Run()
+	defer cli.Stop()
+
+	eventually(t, func() bool {
+		_, err := cli.Connection("UBOT")
+		return err == platform.ErrBotNotFound
+	})
+
+	ok, err := cli.Storage().BotExists(flamingo.StoredBot{ID: "UBOT"})
+	require.Nil(err)
+	require.False(ok)
+}
+
+func TestAPIError(t *testing.T) {
+	require := require.New(t)
+	srv := slacktest.NewServer()
+	defer srv.Close()
+	srv.AddBot("xoxb-test", "UBOT", "flamingo")
+
+	api := newAPI(srv.APIURL(), "xoxb-test", http.DefaultClient)
+	_, _, err := api.PostMessage("C1", "hi", slack.PostMessageParameters{})
+	require.Equal(&APIError{Status: http.StatusOK, Code: "channel_not_found"}, err)
+	require.False(isTransientError(err))
+
+	require.True((&APIError{Status: http.StatusOK, Code: "ratelimited"}).Temporary())
+	require.True((&APIError{Status: http.StatusTooManyRequests}).Temporary())
+	require.True((&APIError{Status: http.StatusBadGateway}).Temporary())
 }
 
 func newClient(token string, options ClientOptions) *slackClient {
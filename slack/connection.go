@@ -0,0 +1,277 @@
+package slack
+
+import (
+	"strings"
+	"sync"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/mvader/slack"
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+type slackAPI interface {
+	PostMessage(string, string, slack.PostMessageParameters) (string, string, error)
+	UpdateMessage(string, string, string, slack.UpdateMessageParameters) (string, string, string, error)
+	GetUserInfo(string) (*slack.User, error)
+	GetUserByUsername(string) (*slack.User, error)
+	GetChannelInfo(string) (*slack.Channel, error)
+	OpenIMChannel(string) (bool, bool, string, error)
+}
+
+// slackRTM is the real time messaging connection of a bot, along with the
+// Web API used to talk to the users.
+type slackRTM interface {
+	slackAPI
+	IncomingEvents() chan slack.RTMEvent
+	ManageConnection()
+	Disconnect() error
+}
+
+type connection struct {
+	id       string
+	rtm      slackRTM
+	events   platform.Events
+	mut      sync.RWMutex
+	channels map[string]flamingo.Channel
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newConnection(bot flamingo.StoredBot, rtm slackRTM, events platform.Events) *connection {
+	return &connection{
+		id:       bot.ID,
+		rtm:      rtm,
+		events:   events,
+		channels: make(map[string]flamingo.Channel),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (c *connection) Run() error {
+	log15.Info("starting real time", "bot", c.id)
+	for {
+		select {
+		case <-c.closed:
+			return nil
+		case e := <-c.rtm.IncomingEvents():
+			if err := c.handleRTMEvent(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *connection) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.rtm.Disconnect()
+	})
+	return err
+}
+
+func (c *connection) handleRTMEvent(e slack.RTMEvent) error {
+	log15.Debug("received event of type", "type", e.Type)
+
+	switch evt := e.Data.(type) {
+	case *slack.MessageEvent:
+		// For now, ignore all messages that are not new messages
+		switch evt.SubType {
+		case "", "me_message", "bot_message":
+			c.handleMessageEvent(evt)
+		}
+
+	case *slack.LatencyReport:
+		log15.Debug("Current latency", "latency", evt.Value)
+
+	case *slack.RTMError:
+		log15.Error("Real Time Error", "error", evt.Error())
+
+	case *slack.IMCreatedEvent:
+		c.handleJoined(evt.Channel.ID, evt.User)
+
+	case *slack.GroupJoinedEvent:
+		c.handleJoined(evt.Channel.ID, evt.Channel.Members...)
+
+	case *slack.ChannelJoinedEvent:
+		c.handleJoined(evt.Channel.ID, evt.Channel.Members...)
+
+	case *slack.ChannelLeftEvent:
+		c.handleLeft(evt.Channel)
+
+	case *slack.GroupLeftEvent:
+		c.handleLeft(evt.Channel)
+
+	case *slack.InvalidAuthEvent:
+		return platform.ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+func (c *connection) handleMessageEvent(evt *slack.MessageEvent) {
+	if evt.BotID == c.id || evt.User == c.id {
+		log15.Debug("got message from self, ignoring")
+		return
+	}
+
+	channel, err := c.Channel(evt.Channel)
+	if err != nil {
+		log15.Error("unable to get channel of message", "channel", evt.Channel, "bot", c.id, "err", err.Error())
+		return
+	}
+
+	userID := evt.User
+	if userID == "" {
+		userID = evt.BotID
+	}
+
+	user, err := c.rtm.GetUserInfo(userID)
+	if err != nil {
+		log15.Error("unable to find user", "id", userID, "err", err.Error())
+		return
+	}
+
+	c.events.Message(newMessage(convertUser(user), channel, evt.Msg))
+}
+
+// handleAction delivers an action received through the webhook for the
+// given channel. The callback ID of the action must be the ID of its button
+// group.
+func (c *connection) handleAction(channel string, callback slack.AttachmentActionCallback) {
+	action, err := convertAction(callback, c.rtm)
+	if err != nil {
+		log15.Error("error converting action", "err", err.Error())
+		return
+	}
+
+	if action.Channel, err = c.Channel(channel); err != nil {
+		log15.Error("unable to get channel of action", "channel", channel, "bot", c.id, "err", err.Error())
+		return
+	}
+
+	if action.OriginalMessage.ID == "" {
+		action.OriginalMessage.ID = callback.MessageTs
+	}
+
+	c.events.Action(platform.ActionEvent{
+		ID:     callback.CallbackID,
+		Action: action,
+	})
+}
+
+func (c *connection) handleJoined(id string, members ...string) {
+	channel, err := c.Channel(id)
+	if err != nil {
+		log15.Error("unable to get joined channel", "channel", id, "bot", c.id, "err", err.Error())
+		return
+	}
+
+	if len(members) > 0 {
+		channel.Users = usersWithIDs(members)
+		c.mut.Lock()
+		c.channels[id] = channel
+		c.mut.Unlock()
+	}
+
+	c.events.Joined(channel)
+}
+
+func (c *connection) handleLeft(id string) {
+	c.mut.Lock()
+	delete(c.channels, id)
+	c.mut.Unlock()
+	c.events.Left(id)
+}
+
+// Channel returns the channel with the given ID. Channel IDs prefixed with C
+// are channels, whose info is requested to slack, prefixed with G are
+// groups and prefixed with D are directs.
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	c.mut.RLock()
+	channel, ok := c.channels[id]
+	c.mut.RUnlock()
+	if ok {
+		return channel, nil
+	}
+
+	if strings.HasPrefix(id, "C") {
+		ch, err := c.rtm.GetChannelInfo(id)
+		if err != nil {
+			return flamingo.Channel{}, err
+		}
+
+		channel = flamingo.Channel{
+			ID:    ch.ID,
+			Name:  ch.Name,
+			Type:  flamingo.SlackClient,
+			Extra: ch,
+			Users: usersWithIDs(ch.Members),
+		}
+	} else {
+		channel = flamingo.Channel{
+			ID:   id,
+			Type: flamingo.SlackClient,
+			IsDM: strings.HasPrefix(id, "D"),
+		}
+	}
+
+	c.mut.Lock()
+	c.channels[id] = channel
+	c.mut.Unlock()
+	return channel, nil
+}
+
+func usersWithIDs(ids []string) []flamingo.User {
+	users := make([]flamingo.User, len(ids))
+	for i, id := range ids {
+		users[i] = flamingo.User{ID: id}
+	}
+	return users
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	_, ts, err := c.rtm.PostMessage(channel, msg.Text, createPostParams(msg))
+	return ts, err
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	_, ts, err := c.rtm.PostMessage(channel, " ", formToMessage(c.id, channel, form))
+	return ts, err
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	_, ts, err := c.rtm.PostMessage(channel, " ", imageToMessage(img))
+	return ts, err
+}
+
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	_, ts, _, err := c.rtm.UpdateMessage(channel, id, text, slack.NewUpdateMessageParameters())
+	return ts, err
+}
+
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	params := slack.NewUpdateMessageParameters()
+	params.Attachments = formToMessage(c.id, channel, form).Attachments
+	_, ts, _, err := c.rtm.UpdateMessage(channel, id, " ", params)
+	return ts, err
+}
+
+// DirectChannel opens the direct conversation with the user with the given
+// username or, if there is no user with that username, ID.
+func (c *connection) DirectChannel(user string) (string, error) {
+	userID := user
+	if u, err := c.rtm.GetUserByUsername(user); err == nil {
+		userID = u.ID
+	}
+
+	_, _, id, err := c.rtm.OpenIMChannel(userID)
+	if err != nil {
+		log15.Error("error opening IM channel", "user", user, "userID", userID, "err", err.Error())
+		return "", err
+	}
+
+	return id, nil
+}
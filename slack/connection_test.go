@@ -0,0 +1,337 @@
+package slack
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mvader/slack"
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+	"github.com/stretchr/testify/require"
+)
+
+type postMessageArgs struct {
+	channel string
+	text    string
+	params  slack.PostMessageParameters
+}
+
+type updateMessageArgs struct {
+	channel string
+	id      string
+	text    string
+	params  slack.UpdateMessageParameters
+}
+
+type apiMock struct {
+	sync.Mutex
+	users        map[string]*slack.User
+	msgs         []postMessageArgs
+	updates      []updateMessageArgs
+	channelInfos int
+	callback     func(postMessageArgs) bool
+	err          error
+}
+
+func newAPIMock() *apiMock {
+	return &apiMock{users: make(map[string]*slack.User)}
+}
+
+func (m *apiMock) setUser(user *slack.User) {
+	m.users[user.Name] = user
+}
+
+func (m *apiMock) GetUserByUsername(username string) (*slack.User, error) {
+	u, ok := m.users[username]
+	if !ok {
+		return nil, errors.New("not_found")
+	}
+
+	return u, nil
+}
+
+func (m *apiMock) PostMessage(channel, text string, params slack.PostMessageParameters) (string, string, error) {
+	m.Lock()
+	defer m.Unlock()
+	args := postMessageArgs{channel, text, params}
+	m.msgs = append(m.msgs, args)
+	if m.callback != nil && !m.callback(args) {
+		return "", "", m.err
+	}
+
+	return channel, "ts", nil
+}
+
+func (m *apiMock) sent() []postMessageArgs {
+	m.Lock()
+	defer m.Unlock()
+	return append([]postMessageArgs(nil), m.msgs...)
+}
+
+func (m *apiMock) UpdateMessage(channel, id, text string, params slack.UpdateMessageParameters) (string, string, string, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.updates = append(m.updates, updateMessageArgs{channel, id, text, params})
+	return channel, id, text, nil
+}
+
+func (m *apiMock) GetUserInfo(id string) (*slack.User, error) {
+	if id == "unknown" {
+		return nil, errors.New("user_not_found")
+	}
+
+	return &slack.User{
+		ID:       id,
+		Name:     "user",
+		RealName: "real name",
+	}, nil
+}
+
+func (m *apiMock) GetChannelInfo(id string) (*slack.Channel, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.channelInfos++
+	ch := &slack.Channel{}
+	ch.ID = id
+	ch.Name = "channel"
+	ch.Members = []string{"U1", "U2"}
+	return ch, nil
+}
+
+func (m *apiMock) OpenIMChannel(user string) (bool, bool, string, error) {
+	return true, true, "D" + user, nil
+}
+
+type slackRTMMock struct {
+	*apiMock
+	events chan slack.RTMEvent
+}
+
+func newSlackRTMMock() *slackRTMMock {
+	return &slackRTMMock{
+		apiMock: newAPIMock(),
+		events:  make(chan slack.RTMEvent),
+	}
+}
+
+func (m *slackRTMMock) IncomingEvents() chan slack.RTMEvent {
+	return m.events
+}
+
+func (m *slackRTMMock) ManageConnection() {}
+
+func (m *slackRTMMock) Disconnect() error {
+	return nil
+}
+
+type eventsMock struct {
+	messages []flamingo.Message
+	actions  []platform.ActionEvent
+	joined   []flamingo.Channel
+	left     []string
+}
+
+func (e *eventsMock) Message(m flamingo.Message)    { e.messages = append(e.messages, m) }
+func (e *eventsMock) Action(a platform.ActionEvent) { e.actions = append(e.actions, a) }
+func (e *eventsMock) Joined(ch flamingo.Channel)    { e.joined = append(e.joined, ch) }
+func (e *eventsMock) Left(ch string)                { e.left = append(e.left, ch) }
+
+func newTestConnection() (*connection, *slackRTMMock, *eventsMock) {
+	rtm := newSlackRTMMock()
+	events := new(eventsMock)
+	return newConnection(flamingo.StoredBot{ID: "UBOT"}, rtm, events), rtm, events
+}
+
+func TestHandleRTMEvent(t *testing.T) {
+	require := require.New(t)
+	conn, _, events := newTestConnection()
+
+	evts := []interface{}{
+		&slack.LatencyReport{},
+		&slack.RTMError{},
+		&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "U1", Text: "hello", Timestamp: "1458170917.164398"}},
+		&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "UBOT", Text: "self"}},
+		&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", BotID: "UBOT", Text: "self"}},
+		&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "U1", Text: "edited", SubType: "message_changed"}},
+		&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "unknown", Text: "who?"}},
+		&slack.MessageEvent{Msg: slack.Msg{Channel: "G1", BotID: "B1", Text: "from bot", SubType: "bot_message"}},
+		&slack.IMCreatedEvent{User: "U1", Channel: slack.ChannelCreatedInfo{ID: "D1"}},
+		&slack.ChannelLeftEvent{Channel: "C1"},
+		&slack.GroupLeftEvent{Channel: "G1"},
+	}
+
+	for _, e := range evts {
+		require.Nil(conn.handleRTMEvent(slack.RTMEvent{Data: e}))
+	}
+
+	require.Equal(2, len(events.messages))
+	msg := events.messages[0]
+	require.Equal("1458170917.164398", msg.ID)
+	require.Equal("hello", msg.Text)
+	require.Equal(flamingo.SlackClient, msg.Type)
+	require.Equal("U1", msg.User.ID)
+	require.Equal("user", msg.User.Username)
+	require.Equal("C1", msg.Channel.ID)
+	require.Equal("channel", msg.Channel.Name)
+	require.Equal("B1", events.messages[1].User.ID)
+	require.Equal("G1", events.messages[1].Channel.ID)
+
+	require.Equal(1, len(events.joined))
+	require.Equal("D1", events.joined[0].ID)
+	require.True(events.joined[0].IsDM)
+	require.Equal([]flamingo.User{{ID: "U1"}}, events.joined[0].Users)
+	require.Equal([]string{"C1", "G1"}, events.left)
+
+	require.Equal(platform.ErrInvalidCredentials, conn.handleRTMEvent(slack.RTMEvent{Data: &slack.InvalidAuthEvent{}}))
+}
+
+func TestHandleJoinedEvents(t *testing.T) {
+	require := require.New(t)
+	conn, _, events := newTestConnection()
+
+	group := &slack.GroupJoinedEvent{}
+	group.Channel.ID = "G1"
+	group.Channel.Members = []string{"U3"}
+	channel := &slack.ChannelJoinedEvent{}
+	channel.Channel.ID = "C1"
+
+	require.Nil(conn.handleRTMEvent(slack.RTMEvent{Data: group}))
+	require.Nil(conn.handleRTMEvent(slack.RTMEvent{Data: channel}))
+
+	require.Equal(2, len(events.joined))
+	require.Equal("G1", events.joined[0].ID)
+	require.False(events.joined[0].IsDM)
+	require.Equal([]flamingo.User{{ID: "U3"}}, events.joined[0].Users)
+	require.Equal("channel", events.joined[1].Name)
+	require.Equal([]flamingo.User{{ID: "U1"}, {ID: "U2"}}, events.joined[1].Users)
+}
+
+func TestChannel(t *testing.T) {
+	require := require.New(t)
+	conn, rtm, _ := newTestConnection()
+
+	ch, err := conn.Channel("C1")
+	require.Nil(err)
+	require.Equal("channel", ch.Name)
+	require.Equal(flamingo.SlackClient, ch.Type)
+	require.False(ch.IsDM)
+
+	_, err = conn.Channel("C1")
+	require.Nil(err)
+	require.Equal(1, rtm.channelInfos)
+
+	ch, err = conn.Channel("D1")
+	require.Nil(err)
+	require.True(ch.IsDM)
+	require.Equal(1, rtm.channelInfos)
+}
+
+func TestConnectionHandleAction(t *testing.T) {
+	require := require.New(t)
+	conn, _, events := newTestConnection()
+
+	conn.handleAction("C1", slack.AttachmentActionCallback{
+		CallbackID: "foo",
+		MessageTs:  "1458170866.000004",
+		Actions:    []slack.AttachmentAction{{Name: "yes", Value: "1"}},
+		User:       slack.User{ID: "U1"},
+	})
+
+	require.Equal(1, len(events.actions))
+	action := events.actions[0]
+	require.Equal("foo", action.ID)
+	require.Equal("1", action.Action.UserAction.Value)
+	require.Equal("user", action.Action.User.Username)
+	require.Equal("C1", action.Action.Channel.ID)
+	require.Equal("channel", action.Action.Channel.Name)
+	require.Equal("1458170866.000004", action.Action.OriginalMessage.ID)
+
+	conn.handleAction("C1", slack.AttachmentActionCallback{
+		CallbackID: "foo",
+		User:       slack.User{ID: "unknown"},
+	})
+	require.Equal(1, len(events.actions))
+}
+
+func TestConnectionAPI(t *testing.T) {
+	require := require.New(t)
+	conn, rtm, _ := newTestConnection()
+	form := flamingo.Form{
+		Title:   "title",
+		Combine: true,
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewButtonGroup("baz", flamingo.NewButton("Yes", "yes")),
+		},
+	}
+
+	id, err := conn.PostMessage("C1", flamingo.NewOutgoingMessage("hi"))
+	require.Nil(err)
+	require.Equal("ts", id)
+
+	_, err = conn.PostForm("C1", form)
+	require.Nil(err)
+	_, err = conn.PostImage("C1", flamingo.Image{URL: "foo"})
+	require.Nil(err)
+
+	id, err = conn.UpdateMessage("C1", "1", "changed")
+	require.Nil(err)
+	require.Equal("1", id)
+	_, err = conn.UpdateForm("C1", "1", form)
+	require.Nil(err)
+
+	require.Equal(3, len(rtm.msgs))
+	require.Equal("hi", rtm.msgs[0].text)
+	require.Equal(" ", rtm.msgs[1].text)
+	require.Equal("UBOT::C1::baz", rtm.msgs[1].params.Attachments[0].CallbackID)
+	require.Equal("foo", rtm.msgs[2].params.Attachments[0].ImageURL)
+
+	require.Equal(2, len(rtm.updates))
+	require.Equal("changed", rtm.updates[0].text)
+	require.Equal(0, len(rtm.updates[0].params.Attachments))
+	require.Equal(" ", rtm.updates[1].text)
+	require.Equal(1, len(rtm.updates[1].params.Attachments))
+}
+
+func TestDirectChannel(t *testing.T) {
+	require := require.New(t)
+	conn, rtm, _ := newTestConnection()
+	rtm.setUser(&slack.User{ID: "U1", Name: "jane"})
+
+	id, err := conn.DirectChannel("jane")
+	require.Nil(err)
+	require.Equal("DU1", id)
+
+	id, err = conn.DirectChannel("U2")
+	require.Nil(err)
+	require.Equal("DU2", id)
+}
+
+func TestConnectionRunAndClose(t *testing.T) {
+	require := require.New(t)
+	conn, rtm, events := newTestConnection()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.Run()
+	}()
+
+	rtm.events <- slack.RTMEvent{Data: &slack.ChannelLeftEvent{Channel: "C1"}}
+	require.Nil(conn.Close())
+	require.Nil(conn.Close())
+
+	select {
+	case err := <-done:
+		require.Nil(err)
+	case <-time.After(time.Second):
+		require.FailNow("connection did not stop")
+	}
+	require.Equal([]string{"C1"}, events.left)
+
+	go func() {
+		done <- newConnection(flamingo.StoredBot{ID: "UBOT"}, rtm, events).Run()
+	}()
+	rtm.events <- slack.RTMEvent{Data: &slack.InvalidAuthEvent{}}
+	require.Equal(platform.ErrInvalidCredentials, <-done)
+}
@@ -0,0 +1,205 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/mvader/slack"
+)
+
+const (
+	pingInterval   = 30 * time.Second
+	reconnectDelay = 5 * time.Second
+)
+
+// invalidAuthErrors are the errors returned by slack when the token of the
+// bot can not be used anymore.
+var invalidAuthErrors = []string{
+	"invalid_auth",
+	"not_authed",
+	"account_inactive",
+	"token_revoked",
+}
+
+// rtmEvents are the constructors of the RTM events delivered to the
+// connection, by their type.
+var rtmEvents = map[string]func() interface{}{
+	"message":        func() interface{} { return &slack.MessageEvent{} },
+	"im_created":     func() interface{} { return &slack.IMCreatedEvent{} },
+	"channel_joined": func() interface{} { return &slack.ChannelJoinedEvent{} },
+	"group_joined":   func() interface{} { return &slack.GroupJoinedEvent{} },
+	"channel_left":   func() interface{} { return &slack.ChannelLeftEvent{} },
+	"group_left":     func() interface{} { return &slack.GroupLeftEvent{} },
+}
+
+// rtm is the real time messaging connection of a bot. It uses its own
+// Web API URL and HTTP client, so several clients can talk to different
+// servers at the same time.
+type rtm struct {
+	*api
+	events chan slack.RTMEvent
+	mut    sync.Mutex
+	ws     *websocket.Conn
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newRTM(url, token string, client *http.Client) *rtm {
+	return &rtm{
+		api:    newAPI(url, token, client),
+		events: make(chan slack.RTMEvent),
+		done:   make(chan struct{}),
+	}
+}
+
+func (r *rtm) IncomingEvents() chan slack.RTMEvent {
+	return r.events
+}
+
+// ManageConnection keeps the bot connected to slack, starting a new RTM
+// session every time the previous one is lost, until it is disconnected or
+// its credentials are not valid.
+func (r *rtm) ManageConnection() {
+	for {
+		err := r.runSession()
+		if isInvalidAuth(err) {
+			r.send("invalid_auth", &slack.InvalidAuthEvent{})
+			return
+		}
+
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		if err != nil {
+			log15.Warn("slack RTM session lost, reconnecting", "err", err.Error())
+		}
+
+		select {
+		case <-r.done:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (r *rtm) runSession() error {
+	url, err := r.startRTM()
+	if err != nil {
+		return err
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	r.mut.Lock()
+	select {
+	case <-r.done:
+		r.mut.Unlock()
+		return nil
+	default:
+	}
+	r.ws = ws
+	r.mut.Unlock()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go r.ping(ws, stopped)
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var head struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &head); err != nil {
+			log15.Error("invalid RTM event", "err", err.Error())
+			continue
+		}
+
+		newEvent, ok := rtmEvents[head.Type]
+		if !ok {
+			continue
+		}
+
+		evt := newEvent()
+		if err := json.Unmarshal(data, evt); err != nil {
+			log15.Error("invalid RTM event", "type", head.Type, "err", err.Error())
+			continue
+		}
+
+		r.send(head.Type, evt)
+	}
+}
+
+// ping sends a ping through the websocket every pingInterval so slack does
+// not close the connection, until stopped is closed.
+func (r *rtm) ping(ws *websocket.Conn, stopped <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for id := 1; ; id++ {
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+		}
+
+		r.mut.Lock()
+		err := ws.WriteJSON(map[string]string{"id": strconv.Itoa(id), "type": "ping"})
+		r.mut.Unlock()
+		if err != nil {
+			ws.Close()
+			return
+		}
+	}
+}
+
+// send delivers the event, unless the connection is disconnected before it
+// can be delivered.
+func (r *rtm) send(typ string, data interface{}) {
+	select {
+	case r.events <- slack.RTMEvent{Type: typ, Data: data}:
+	case <-r.done:
+	}
+}
+
+func (r *rtm) Disconnect() error {
+	var err error
+	r.once.Do(func() {
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		close(r.done)
+		if r.ws != nil {
+			err = r.ws.Close()
+		}
+	})
+	return err
+}
+
+func isInvalidAuth(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, e := range invalidAuthErrors {
+		if err.Error() == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package slacktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// rtmConn is the RTM websocket connection of a bot.
+type rtmConn struct {
+	mut  sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *rtmConn) send(event interface{}) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.conn.WriteJSON(event)
+}
+
+func (c *rtmConn) close() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.conn.Close()
+}
+
+// serveRTM upgrades the connection of a bot to the RTM websocket, says hello
+// and sends the events that were waiting for the bot to connect. Then, it
+// answers the pings of the bot until the connection is closed.
+func (s *Server) serveRTM(w http.ResponseWriter, r *http.Request) {
+	s.mut.Lock()
+	bot, ok := s.bots[r.URL.Query().Get("token")]
+	s.mut.Unlock()
+	if !ok {
+		http.Error(w, "invalid_auth", http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	conn := &rtmConn{conn: ws}
+	s.mut.Lock()
+	if old, ok := s.conns[bot.ID]; ok {
+		old.close()
+	}
+	s.conns[bot.ID] = conn
+	pending := s.pending[bot.ID]
+	delete(s.pending, bot.ID)
+
+	// the events are sent with the lock held so they are not mixed with
+	// the ones sent in the meantime
+	conn.send(map[string]string{"type": "hello"})
+	for _, e := range pending {
+		conn.send(e)
+	}
+	s.mut.Unlock()
+
+	defer func() {
+		s.mut.Lock()
+		if s.conns[bot.ID] == conn {
+			delete(s.conns, bot.ID)
+		}
+		s.mut.Unlock()
+		conn.close()
+	}()
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg struct {
+			ID   json.RawMessage `json:"id"`
+			Type string          `json:"type"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		if msg.Type == "ping" {
+			conn.send(map[string]interface{}{"type": "pong", "reply_to": msg.ID})
+		}
+	}
+}
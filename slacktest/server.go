@@ -0,0 +1,513 @@
+// Package slacktest provides a fake Slack server to run end-to-end tests of
+// the slack client without connecting to Slack.
+//
+// The server implements the methods of the Web API used by the client and
+// the RTM websocket. Every message posted or updated by the bots is recorded,
+// and the tests can send events to the bots through their RTM connections
+// and click the buttons of their messages, which posts an interactive
+// message callback to the webhook of the client, as Slack does:
+//
+//	srv := slacktest.NewServer()
+//	defer srv.Close()
+//	srv.AddBot("xoxb-token", "UBOT", "flamingo")
+//	srv.AddUser("U1", "jane")
+//	srv.AddChannel("C1", "general", "U1", "UBOT")
+//
+//	cli := slack.NewClient("", slack.ClientOptions{APIURL: srv.APIURL()})
+//	cli.AddBot("UBOT", "xoxb-token", nil)
+//	go cli.Run()
+//
+//	srv.SendMessage("C1", "U1", "hello")
+//	msgs, err := srv.WaitForMessages(1, time.Second)
+package slacktest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mvader/slack"
+)
+
+// team is the team of the fake server.
+var team = map[string]string{
+	"id":     "T0000001",
+	"name":   "Test",
+	"domain": "test",
+}
+
+// Message is a message posted or updated by a bot through the Web API.
+type Message struct {
+	// Bot is the ID of the bot that posted the message.
+	Bot string
+	// Channel is the ID of the channel of the message.
+	Channel string
+	// Timestamp is the timestamp of the message, which is its ID.
+	Timestamp string
+	// Text is the text of the message.
+	Text string
+	// Username is the name the message was posted as, if any.
+	Username string
+	// IconURL is the URL of the icon the message was posted with, if any.
+	IconURL string
+	// AsUser is true if the message was posted as the bot user.
+	AsUser bool
+	// Attachments are the attachments of the message.
+	Attachments []slack.Attachment
+	// Updated is true if the message replaced the previous message with the
+	// same timestamp.
+	Updated bool
+}
+
+// Action returns the action with the given value of the attachments of the
+// message, along with the callback ID of its attachment.
+func (m Message) Action(value string) (slack.AttachmentAction, string, bool) {
+	for _, a := range m.Attachments {
+		for _, action := range a.Actions {
+			if action.Value == value {
+				return action, a.CallbackID, true
+			}
+		}
+	}
+
+	return slack.AttachmentAction{}, "", false
+}
+
+type user struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name,omitempty"`
+	IsBot    bool   `json:"is_bot"`
+}
+
+type channel struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name,omitempty"`
+	Created   int64    `json:"created"`
+	IsChannel bool     `json:"is_channel,omitempty"`
+	IsGroup   bool     `json:"is_group,omitempty"`
+	IsIM      bool     `json:"is_im,omitempty"`
+	IsMember  bool     `json:"is_member,omitempty"`
+	User      string   `json:"user,omitempty"`
+	Members   []string `json:"members,omitempty"`
+}
+
+// Server is a fake Slack server.
+type Server struct {
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+
+	mut      sync.Mutex
+	bots     map[string]user
+	users    map[string]user
+	channels map[string]channel
+	ims      map[string]string
+	messages []Message
+	conns    map[string]*rtmConn
+	pending  map[string][]interface{}
+	lastTs   int
+	changed  chan struct{}
+}
+
+// NewServer creates and starts a new fake Slack server. It must be closed
+// with Close once it is no longer used.
+func NewServer() *Server {
+	s := &Server{
+		bots:     make(map[string]user),
+		users:    make(map[string]user),
+		channels: make(map[string]channel),
+		ims:      make(map[string]string),
+		conns:    make(map[string]*rtmConn),
+		pending:  make(map[string][]interface{}),
+		changed:  make(chan struct{}),
+	}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+// URL returns the URL of the server.
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// APIURL returns the base URL of the Web API of the server, to be given to
+// the slack client.
+func (s *Server) APIURL() string {
+	return s.server.URL + "/api/"
+}
+
+// Close closes the RTM connections and stops the server.
+func (s *Server) Close() {
+	s.mut.Lock()
+	for _, c := range s.conns {
+		c.close()
+	}
+	s.mut.Unlock()
+	s.server.Close()
+}
+
+// AddBot adds a bot user with the given ID and name that can connect with
+// the given token.
+func (s *Server) AddBot(token, id, name string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	u := user{ID: id, Name: name, IsBot: true}
+	s.bots[token] = u
+	s.users[id] = u
+}
+
+// AddUser adds a user with the given ID and name.
+func (s *Server) AddUser(id, name string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.users[id] = user{ID: id, Name: name, RealName: name}
+}
+
+// AddChannel adds a channel with the given ID, name and members. Channel IDs
+// starting with G are private groups and the rest are public channels.
+func (s *Server) AddChannel(id, name string, members ...string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.channels[id] = channel{
+		ID:        id,
+		Name:      name,
+		Created:   time.Now().Unix(),
+		IsChannel: !strings.HasPrefix(id, "G"),
+		IsGroup:   strings.HasPrefix(id, "G"),
+		IsMember:  true,
+		Members:   members,
+	}
+}
+
+// Messages returns all the messages posted or updated by the bots.
+func (s *Server) Messages() []Message {
+	msgs, _ := s.snapshot()
+	return msgs
+}
+
+func (s *Server) snapshot() ([]Message, <-chan struct{}) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	msgs := make([]Message, len(s.messages))
+	copy(msgs, s.messages)
+	return msgs, s.changed
+}
+
+// WaitForMessages waits until the bots posted or updated at least n
+// messages and returns all of them, or returns an error if they did not
+// before the timeout.
+func (s *Server) WaitForMessages(n int, timeout time.Duration) ([]Message, error) {
+	deadline := time.After(timeout)
+	for {
+		msgs, changed := s.snapshot()
+		if len(msgs) >= n {
+			return msgs, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return msgs, fmt.Errorf("slacktest: expected %d messages, got %d", n, len(msgs))
+		}
+	}
+}
+
+// SendMessage sends a message event with the given text, written by the
+// user with the given ID in the channel with the given ID, to all the bots.
+// It returns the timestamp of the message.
+func (s *Server) SendMessage(channel, user, text string) string {
+	s.mut.Lock()
+	ts := s.nextTs()
+	s.mut.Unlock()
+
+	s.Broadcast(map[string]string{
+		"type":    "message",
+		"channel": channel,
+		"user":    user,
+		"text":    text,
+		"ts":      ts,
+	})
+	return ts
+}
+
+// Broadcast sends the given event to all the bots.
+func (s *Server) Broadcast(event interface{}) {
+	s.mut.Lock()
+	var bots []string
+	for _, b := range s.bots {
+		bots = append(bots, b.ID)
+	}
+	s.mut.Unlock()
+
+	for _, b := range bots {
+		s.SendEvent(b, event)
+	}
+}
+
+// SendEvent sends the given event, encoded as JSON, to the bot with the
+// given ID through its RTM connection. If the bot is not connected yet, the
+// event is sent as soon as it connects.
+func (s *Server) SendEvent(bot string, event interface{}) {
+	s.mut.Lock()
+	conn, ok := s.conns[bot]
+	if !ok {
+		s.pending[bot] = append(s.pending[bot], event)
+	}
+	s.mut.Unlock()
+
+	if ok {
+		conn.send(event)
+	}
+}
+
+// Click clicks, as the user with the given ID, the button with the given
+// value of a message posted by a bot. The interactive message callback is
+// posted to the given URL of the webhook of the client along with the given
+// verification token.
+func (s *Server) Click(webhookURL, token string, msg Message, userID, value string) error {
+	action, callbackID, ok := msg.Action(value)
+	if !ok {
+		return fmt.Errorf("slacktest: message %s has no button with value %q", msg.Timestamp, value)
+	}
+
+	s.mut.Lock()
+	u, ok := s.users[userID]
+	ch := s.channels[msg.Channel]
+	actionTs := s.nextTs()
+	s.mut.Unlock()
+	if !ok {
+		return fmt.Errorf("slacktest: user %s not found", userID)
+	}
+
+	original, err := json.Marshal(map[string]interface{}{
+		"type":        "message",
+		"bot_id":      msg.Bot,
+		"text":        msg.Text,
+		"ts":          msg.Timestamp,
+		"attachments": msg.Attachments,
+	})
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"actions":          []slack.AttachmentAction{{Name: action.Name, Type: action.Type, Value: action.Value}},
+		"callback_id":      callbackID,
+		"team":             team,
+		"channel":          map[string]string{"id": msg.Channel, "name": ch.Name},
+		"user":             map[string]string{"id": u.ID, "name": u.Name},
+		"action_ts":        actionTs,
+		"message_ts":       msg.Timestamp,
+		"attachment_id":    "1",
+		"token":            token,
+		"original_message": json.RawMessage(original),
+		"response_url":     s.URL() + "/actions",
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.PostForm(webhookURL, url.Values{"payload": {string(payload)}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slacktest: webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// nextTs returns a new unique message timestamp. It must be called with the
+// lock held.
+func (s *Server) nextTs() string {
+	s.lastTs++
+	return fmt.Sprintf("1475496000.%06d", s.lastTs)
+}
+
+func (s *Server) record(m Message) {
+	s.messages = append(s.messages, m)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// ServeHTTP serves the Web API and the RTM websocket.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/ws" {
+		s.serveRTM(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	r.ParseForm()
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+
+	s.mut.Lock()
+	result, err := s.call(method, r.Form)
+	s.mut.Unlock()
+
+	if err != nil {
+		result = map[string]interface{}{"ok": false, "error": err.Error()}
+	} else {
+		result["ok"] = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// call runs the Web API method with the given parameters. It must be called
+// with the lock held.
+func (s *Server) call(method string, params url.Values) (map[string]interface{}, error) {
+	bot, ok := s.bots[params.Get("token")]
+	if !ok {
+		return nil, errors.New("invalid_auth")
+	}
+
+	switch method {
+	case "auth.test":
+		return map[string]interface{}{
+			"user_id": bot.ID,
+			"user":    bot.Name,
+			"team_id": team["id"],
+			"team":    team["name"],
+		}, nil
+	case "rtm.start", "rtm.connect":
+		return s.rtmStart(params.Get("token"), bot), nil
+	case "chat.postMessage", "chat.update":
+		return s.postMessage(bot, method == "chat.update", params)
+	case "users.info":
+		u, ok := s.users[params.Get("user")]
+		if !ok {
+			return nil, errors.New("user_not_found")
+		}
+		return map[string]interface{}{"user": u}, nil
+	case "users.list":
+		return map[string]interface{}{"members": s.userList()}, nil
+	case "channels.info", "groups.info", "conversations.info":
+		ch, ok := s.channels[params.Get("channel")]
+		if !ok {
+			return nil, errors.New("channel_not_found")
+		}
+
+		key := "channel"
+		if method == "groups.info" {
+			key = "group"
+		}
+		return map[string]interface{}{key: ch}, nil
+	case "im.open":
+		id, ok := s.ims[params.Get("user")]
+		if ok {
+			return map[string]interface{}{
+				"no_op":        true,
+				"already_open": true,
+				"channel":      map[string]string{"id": id},
+			}, nil
+		}
+
+		if _, ok := s.users[params.Get("user")]; !ok {
+			return nil, errors.New("user_not_found")
+		}
+
+		id = "D" + strings.TrimPrefix(params.Get("user"), "U")
+		s.ims[params.Get("user")] = id
+		s.channels[id] = channel{ID: id, IsIM: true, User: params.Get("user"), Created: time.Now().Unix()}
+		return map[string]interface{}{"channel": map[string]string{"id": id}}, nil
+	}
+
+	return nil, errors.New("unknown_method")
+}
+
+func (s *Server) userList() []user {
+	users := make([]user, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	return users
+}
+
+func (s *Server) rtmStart(token string, bot user) map[string]interface{} {
+	var channels, groups, ims []channel
+	for _, ch := range s.channels {
+		switch {
+		case ch.IsIM:
+			ims = append(ims, ch)
+		case ch.IsGroup:
+			groups = append(groups, ch)
+		default:
+			channels = append(channels, ch)
+		}
+	}
+
+	ws := "ws" + strings.TrimPrefix(s.server.URL, "http") + "/ws?token=" + url.QueryEscape(token)
+	return map[string]interface{}{
+		"url":      ws,
+		"self":     map[string]string{"id": bot.ID, "name": bot.Name},
+		"team":     team,
+		"users":    s.userList(),
+		"channels": channels,
+		"groups":   groups,
+		"ims":      ims,
+		"bots":     []interface{}{},
+	}
+}
+
+func (s *Server) postMessage(bot user, update bool, params url.Values) (map[string]interface{}, error) {
+	ch := params.Get("channel")
+	if _, ok := s.channels[ch]; !ok {
+		return nil, errors.New("channel_not_found")
+	}
+
+	msg := Message{
+		Bot:      bot.ID,
+		Channel:  ch,
+		Text:     params.Get("text"),
+		Username: params.Get("username"),
+		IconURL:  params.Get("icon_url"),
+		AsUser:   params.Get("as_user") == "true",
+		Updated:  update,
+	}
+
+	if update {
+		msg.Timestamp = params.Get("ts")
+		if !s.posted(ch, msg.Timestamp) {
+			return nil, errors.New("message_not_found")
+		}
+	} else {
+		msg.Timestamp = s.nextTs()
+	}
+
+	if a := params.Get("attachments"); a != "" {
+		if err := json.Unmarshal([]byte(a), &msg.Attachments); err != nil {
+			return nil, errors.New("invalid_attachments")
+		}
+	}
+
+	s.record(msg)
+	return map[string]interface{}{
+		"channel": ch,
+		"ts":      msg.Timestamp,
+		"text":    msg.Text,
+	}, nil
+}
+
+func (s *Server) posted(channel, ts string) bool {
+	for _, m := range s.messages {
+		if m.Channel == channel && m.Timestamp == ts {
+			return true
+		}
+	}
+	return false
+}
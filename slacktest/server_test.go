@@ -0,0 +1,177 @@
+package slacktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mvader/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer() *Server {
+	srv := NewServer()
+	srv.AddBot("xoxb-test", "UBOT", "flamingo")
+	srv.AddUser("U1", "jane")
+	srv.AddChannel("C1", "general", "U1", "UBOT")
+	return srv
+}
+
+func call(srv *Server, method string, params url.Values) (map[string]interface{}, error) {
+	resp, err := http.PostForm(srv.APIURL()+method, params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result, err
+}
+
+func TestWebAPI(t *testing.T) {
+	require := require.New(t)
+	srv := newTestServer()
+	defer srv.Close()
+
+	result, err := call(srv, "auth.test", url.Values{"token": {"nope"}})
+	require.Nil(err)
+	require.Equal(false, result["ok"])
+	require.Equal("invalid_auth", result["error"])
+
+	result, err = call(srv, "auth.test", url.Values{"token": {"xoxb-test"}})
+	require.Nil(err)
+	require.Equal(true, result["ok"])
+	require.Equal("UBOT", result["user_id"])
+
+	result, err = call(srv, "channels.info", url.Values{"token": {"xoxb-test"}, "channel": {"C1"}})
+	require.Nil(err)
+	require.Equal("general", result["channel"].(map[string]interface{})["name"])
+
+	result, err = call(srv, "im.open", url.Values{"token": {"xoxb-test"}, "user": {"U1"}})
+	require.Nil(err)
+	require.Equal("D1", result["channel"].(map[string]interface{})["id"])
+
+	attachments, err := json.Marshal([]slack.Attachment{{
+		CallbackID: "bot::C1::deploy",
+		Actions: []slack.AttachmentAction{
+			{Name: "yes", Type: "button", Value: "yes"},
+		},
+	}})
+	require.Nil(err)
+
+	result, err = call(srv, "chat.postMessage", url.Values{
+		"token":       {"xoxb-test"},
+		"channel":     {"C1"},
+		"text":        {"deploy?"},
+		"attachments": {string(attachments)},
+	})
+	require.Nil(err)
+	require.Equal(true, result["ok"])
+	ts := result["ts"].(string)
+
+	result, err = call(srv, "chat.update", url.Values{"token": {"xoxb-test"}, "channel": {"C1"}, "ts": {"1"}, "text": {"nope"}})
+	require.Nil(err)
+	require.Equal("message_not_found", result["error"])
+
+	result, err = call(srv, "chat.update", url.Values{"token": {"xoxb-test"}, "channel": {"C1"}, "ts": {ts}, "text": {"deploying"}})
+	require.Nil(err)
+	require.Equal(true, result["ok"])
+
+	msgs, err := srv.WaitForMessages(2, time.Second)
+	require.Nil(err)
+	require.Equal("deploy?", msgs[0].Text)
+	require.False(msgs[0].Updated)
+	require.Equal("deploying", msgs[1].Text)
+	require.Equal(ts, msgs[1].Timestamp)
+	require.True(msgs[1].Updated)
+
+	action, callbackID, ok := msgs[0].Action("yes")
+	require.True(ok)
+	require.Equal("yes", action.Name)
+	require.Equal("bot::C1::deploy", callbackID)
+
+	_, err = srv.WaitForMessages(3, 10*time.Millisecond)
+	require.NotNil(err)
+}
+
+func TestRTM(t *testing.T) {
+	require := require.New(t)
+	srv := newTestServer()
+	defer srv.Close()
+
+	// events sent before the bot connects are queued
+	srv.SendMessage("C1", "U1", "hi")
+
+	result, err := call(srv, "rtm.start", url.Values{"token": {"xoxb-test"}})
+	require.Nil(err)
+	require.Equal("UBOT", result["self"].(map[string]interface{})["id"])
+
+	ws, _, err := websocket.DefaultDialer.Dial(result["url"].(string), nil)
+	require.Nil(err)
+	defer ws.Close()
+
+	expectEvent := func(typ, text string) {
+		var evt map[string]interface{}
+		require.Nil(ws.SetReadDeadline(time.Now().Add(time.Second)))
+		require.Nil(ws.ReadJSON(&evt))
+		require.Equal(typ, evt["type"])
+		if text != "" {
+			require.Equal(text, evt["text"])
+		}
+	}
+
+	expectEvent("hello", "")
+	expectEvent("message", "hi")
+
+	srv.SendMessage("C1", "U1", "bye")
+	expectEvent("message", "bye")
+
+	require.Nil(ws.WriteJSON(map[string]interface{}{"id": 1, "type": "ping"}))
+	expectEvent("pong", "")
+
+	_, _, err = websocket.DefaultDialer.Dial(strings.Replace(result["url"].(string), "xoxb-test", "nope", 1), nil)
+	require.NotNil(err)
+}
+
+func TestClick(t *testing.T) {
+	require := require.New(t)
+	srv := newTestServer()
+	defer srv.Close()
+
+	var payload slack.AttachmentActionCallback
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.Unmarshal([]byte(r.FormValue("payload")), &payload)
+	}))
+	defer webhook.Close()
+
+	msg := Message{
+		Bot:       "UBOT",
+		Channel:   "C1",
+		Timestamp: "1475496000.000001",
+		Text:      "deploy?",
+		Attachments: []slack.Attachment{{
+			CallbackID: "bot::C1::deploy",
+			Actions: []slack.AttachmentAction{
+				{Name: "yes", Type: "button", Value: "yes"},
+			},
+		}},
+	}
+
+	require.NotNil(srv.Click(webhook.URL, "tok", msg, "U1", "no"))
+	require.NotNil(srv.Click(webhook.URL, "tok", msg, "U2", "yes"))
+	require.Nil(srv.Click(webhook.URL, "tok", msg, "U1", "yes"))
+
+	require.Equal("bot::C1::deploy", payload.CallbackID)
+	require.Equal("tok", payload.Token)
+	require.Equal("C1", payload.Channel.ID)
+	require.Equal("U1", payload.User.ID)
+	require.Equal("yes", payload.Actions[0].Value)
+	require.Equal("1475496000.000001", payload.MessageTs)
+	require.Equal("deploy?", payload.OriginalMessage.Text)
+}
@@ -1,6 +1,14 @@
 package flamingo
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrConversationNotFound is returned by the storages when the conversation
+// to update is not stored.
+var ErrConversationNotFound = errors.New("conversation not found")
 
 // StoredBot is the minimal data snapshot to start a previously
 // running bot instance. It is meant to be stored.
@@ -11,7 +19,9 @@ type StoredBot struct {
 	Token string
 	// CreatedAt is the time it was first saved.
 	CreatedAt time.Time
-	// Extra allows clients to store client-specific data needed.
+	// Extra allows clients to store client-specific data needed. Its type
+	// should be registered with RegisterExtra so it can be decoded back
+	// from the storages that encode it.
 	Extra interface{}
 }
 
@@ -24,15 +34,87 @@ type StoredConversation struct {
 	BotID string
 	// CreatedAt is the time it was first saved.
 	CreatedAt time.Time
-	// Extra allows clients to store client-specific data needed.
+	// Extra allows clients to store client-specific data needed. Its type
+	// should be registered with RegisterExtra so it can be decoded back
+	// from the storages that encode it.
 	Extra interface{}
 }
 
+// storedBot and storedConversation have the fields of the stored types but
+// not their methods, so they can be encoded without recursion.
+type (
+	storedBot          StoredBot
+	storedConversation StoredConversation
+)
+
+// MarshalJSON encodes the bot as JSON along with the name of the registered
+// type of its Extra.
+func (b StoredBot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		storedBot
+		ExtraType string `json:",omitempty"`
+	}{storedBot(b), ExtraName(b.Extra)})
+}
+
+// UnmarshalJSON decodes the bot from JSON, with its Extra decoded into the
+// registered type it was encoded from.
+func (b *StoredBot) UnmarshalJSON(data []byte) error {
+	var v struct {
+		storedBot
+		Extra     json.RawMessage
+		ExtraType string
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	extra, err := DecodeExtra(v.ExtraType, v.Extra)
+	if err != nil {
+		return err
+	}
+
+	*b = StoredBot(v.storedBot)
+	b.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the conversation as JSON along with the name of the
+// registered type of its Extra.
+func (c StoredConversation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		storedConversation
+		ExtraType string `json:",omitempty"`
+	}{storedConversation(c), ExtraName(c.Extra)})
+}
+
+// UnmarshalJSON decodes the conversation from JSON, with its Extra decoded
+// into the registered type it was encoded from.
+func (c *StoredConversation) UnmarshalJSON(data []byte) error {
+	var v struct {
+		storedConversation
+		Extra     json.RawMessage
+		ExtraType string
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	extra, err := DecodeExtra(v.ExtraType, v.Extra)
+	if err != nil {
+		return err
+	}
+
+	*c = StoredConversation(v.storedConversation)
+	c.Extra = extra
+	return nil
+}
+
 // Storage is a service to store and retrieve conversations and bots stored.
 type Storage interface {
 	// StoreBot saves the given bot.
 	StoreBot(StoredBot) error
-	// StoreConversation saves the given conversation.
+	// StoreConversation saves the given conversation of its bot, replacing
+	// the stored one if the bot already had a conversation with the same ID.
 	StoreConversation(StoredConversation) error
 	// LoadBots retrieves all stored bots.
 	LoadBots() ([]StoredBot, error)
@@ -40,6 +122,16 @@ type Storage interface {
 	LoadConversations(StoredBot) ([]StoredConversation, error)
 	// BotExists checks if the bot is already stored.
 	BotExists(StoredBot) (bool, error)
-	// ConversationExists checks if the conversation is already stored.
+	// ConversationExists checks if the conversation is already stored for
+	// its bot. Conversations with the same ID of other bots are not taken
+	// into account.
 	ConversationExists(StoredConversation) (bool, error)
+	// UpdateConversation replaces the stored data of the given conversation
+	// of its bot. It returns ErrConversationNotFound if the conversation is
+	// not stored.
+	UpdateConversation(StoredConversation) error
+	// RemoveBot removes the given bot along with all its conversations.
+	RemoveBot(StoredBot) error
+	// RemoveConversation removes the given conversation of its bot.
+	RemoveConversation(StoredConversation) error
 }
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/src-d/flamingo"
+	"go.etcd.io/bbolt"
+	"golang.org/x/net/context"
+)
+
+var (
+	boltBots          = []byte("bots")
+	boltConversations = []byte("conversations")
+)
+
+type boltStorage struct {
+	db *bbolt.DB
+}
+
+// NewBolt creates a new storage that saves bots and conversations in the
+// given bbolt database. Bots are saved in a bucket and the conversations of
+// each bot in a bucket of their own. Every write is done in a transaction, so
+// no data is lost if the process dies while saving. The database must be
+// closed by the caller once the storage is no longer used.
+func NewBolt(db *bbolt.DB) (flamingo.Storage, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{boltBots, boltConversations} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStorage{db}, nil
+}
+
+func (s *boltStorage) StoreBot(bot flamingo.StoredBot) error {
+	bytes, err := json.Marshal(bot)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBots).Put([]byte(bot.ID), bytes)
+	})
+}
+
+func (s *boltStorage) StoreConversation(conv flamingo.StoredConversation) error {
+	bytes, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		convs, err := tx.Bucket(boltConversations).CreateBucketIfNotExists([]byte(conv.BotID))
+		if err != nil {
+			return err
+		}
+
+		return convs.Put([]byte(conv.ID), bytes)
+	})
+}
+
+func (s *boltStorage) LoadBots() ([]flamingo.StoredBot, error) {
+	var bots []flamingo.StoredBot
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBots).ForEach(func(_, v []byte) error {
+			var bot flamingo.StoredBot
+			if err := json.Unmarshal(v, &bot); err != nil {
+				return err
+			}
+
+			bots = append(bots, bot)
+			return nil
+		})
+	})
+	return bots, err
+}
+
+func (s *boltStorage) LoadConversations(bot flamingo.StoredBot) ([]flamingo.StoredConversation, error) {
+	var convs []flamingo.StoredConversation
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltConversations).Bucket([]byte(bot.ID))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(_, v []byte) error {
+			var conv flamingo.StoredConversation
+			if err := json.Unmarshal(v, &conv); err != nil {
+				return err
+			}
+
+			convs = append(convs, conv)
+			return nil
+		})
+	})
+	return convs, err
+}
+
+func (s *boltStorage) BotExists(bot flamingo.StoredBot) (bool, error) {
+	var ok bool
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		ok = tx.Bucket(boltBots).Get([]byte(bot.ID)) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (s *boltStorage) ConversationExists(conv flamingo.StoredConversation) (bool, error) {
+	var ok bool
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		convs := tx.Bucket(boltConversations).Bucket([]byte(conv.BotID))
+		ok = convs != nil && convs.Get([]byte(conv.ID)) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (s *boltStorage) UpdateConversation(conv flamingo.StoredConversation) error {
+	bytes, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		convs := tx.Bucket(boltConversations).Bucket([]byte(conv.BotID))
+		if convs == nil || convs.Get([]byte(conv.ID)) == nil {
+			return flamingo.ErrConversationNotFound
+		}
+
+		return convs.Put([]byte(conv.ID), bytes)
+	})
+}
+
+func (s *boltStorage) RemoveBot(bot flamingo.StoredBot) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(boltBots).Delete([]byte(bot.ID)); err != nil {
+			return err
+		}
+
+		if tx.Bucket(boltConversations).Bucket([]byte(bot.ID)) == nil {
+			return nil
+		}
+		return tx.Bucket(boltConversations).DeleteBucket([]byte(bot.ID))
+	})
+}
+
+func (s *boltStorage) RemoveConversation(conv flamingo.StoredConversation) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		convs := tx.Bucket(boltConversations).Bucket([]byte(conv.BotID))
+		if convs == nil {
+			return nil
+		}
+		return convs.Delete([]byte(conv.ID))
+	})
+}
+
+// QueryBots walks the bots from the cursor of the query in the order of
+// their IDs, which is the order of the keys of the bucket, until the page is
+// full.
+func (s *boltStorage) QueryBots(ctx context.Context, q flamingo.BotQuery) (flamingo.BotPage, error) {
+	var after []byte
+	if q.After != "" {
+		keys, err := q.After.Keys(1)
+		if err != nil {
+			return flamingo.BotPage{}, err
+		}
+		after = []byte(keys[0])
+	}
+
+	var page flamingo.BotPage
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(boltBots).Cursor()
+		for k, v := seekAfter(c, after); k != nil; k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var bot flamingo.StoredBot
+			if err := json.Unmarshal(v, &bot); err != nil {
+				return err
+			}
+
+			if !q.Match(bot) {
+				continue
+			}
+
+			if len(page.Bots) == q.PageSize() {
+				page.Next = flamingo.NewCursor(page.Bots[len(page.Bots)-1].ID)
+				return nil
+			}
+			page.Bots = append(page.Bots, bot)
+		}
+		return nil
+	})
+	if err != nil {
+		return flamingo.BotPage{}, err
+	}
+
+	return page, nil
+}
+
+// QueryConversations walks the buckets of the bots and their conversations
+// from the cursor of the query in the order of their IDs, which is the order
+// of the keys of the buckets, until the page is full.
+func (s *boltStorage) QueryConversations(ctx context.Context, q flamingo.ConversationQuery) (flamingo.ConversationPage, error) {
+	var afterBot, afterID []byte
+	if q.After != "" {
+		keys, err := q.After.Keys(2)
+		if err != nil {
+			return flamingo.ConversationPage{}, err
+		}
+		afterBot, afterID = []byte(keys[0]), []byte(keys[1])
+	}
+
+	var page flamingo.ConversationPage
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bots := tx.Bucket(boltConversations)
+		bc := bots.Cursor()
+		bot, _ := bc.First()
+		if len(afterBot) > 0 {
+			bot, _ = bc.Seek(afterBot)
+		}
+
+		for ; bot != nil; bot, _ = bc.Next() {
+			convs := bots.Bucket(bot)
+			if convs == nil || !matchBotID(q.BotIDs, string(bot)) {
+				continue
+			}
+
+			c := convs.Cursor()
+			k, v := c.First()
+			if bytes.Equal(bot, afterBot) {
+				k, v = seekAfter(c, afterID)
+			}
+
+			for ; k != nil; k, v = c.Next() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				var conv flamingo.StoredConversation
+				if err := json.Unmarshal(v, &conv); err != nil {
+					return err
+				}
+
+				if !q.Match(conv) {
+					continue
+				}
+
+				if len(page.Conversations) == q.PageSize() {
+					last := page.Conversations[len(page.Conversations)-1]
+					page.Next = flamingo.NewCursor(last.BotID, last.ID)
+					return nil
+				}
+				page.Conversations = append(page.Conversations, conv)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return flamingo.ConversationPage{}, err
+	}
+
+	return page, nil
+}
+
+// seekAfter moves the cursor to the first key after the given one, or to the
+// first key if the given one is empty.
+func seekAfter(c *bbolt.Cursor, key []byte) ([]byte, []byte) {
+	if len(key) == 0 {
+		return c.First()
+	}
+
+	k, v := c.Seek(key)
+	if k != nil && bytes.Equal(k, key) {
+		return c.Next()
+	}
+	return k, v
+}
+
+func matchBotID(ids []string, id string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
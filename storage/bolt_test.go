@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStorage(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-bolt")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flamingo.db")
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	require.Nil(err)
+
+	storage, err := NewBolt(db)
+	require.Nil(err)
+	RunStorageTest(storage, t)
+
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{
+		ID:    "2",
+		BotID: "1",
+		Extra: map[string]interface{}{"foo": "bar"},
+	}))
+	require.NotNil(storage.StoreBot(flamingo.StoredBot{ID: "5", Extra: func() {}}))
+	require.Nil(db.Close())
+
+	db, err = bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	require.Nil(err)
+	defer db.Close()
+
+	storage, err = NewBolt(db)
+	require.Nil(err)
+
+	bots, err := storage.LoadBots()
+	require.Nil(err)
+	require.Equal(2, len(bots))
+
+	ok, err := storage.ConversationExists(flamingo.StoredConversation{ID: "3", BotID: "1"})
+	require.Nil(err)
+	require.True(ok)
+
+	convs, err := storage.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(2, len(convs))
+	require.Equal("2", convs[0].ID)
+	require.Equal(map[string]interface{}{"foo": "bar"}, convs[0].Extra)
+}
+
+func TestBoltStorageReadOnly(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-bolt")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flamingo.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	require.Nil(err)
+	require.Nil(db.Close())
+
+	db, err = bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true})
+	require.Nil(err)
+	defer db.Close()
+
+	_, err = NewBolt(db)
+	require.NotNil(err)
+}
+
+func TestBoltStorageUpdate(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-bolt")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	db, err := bbolt.Open(filepath.Join(dir, "flamingo.db"), 0600, nil)
+	require.Nil(err)
+	defer db.Close()
+
+	storage, err := NewBolt(db)
+	require.Nil(err)
+	RunStorageUpdateTest(storage, t)
+}
+
+func TestBoltStorageExtra(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-bolt")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	db, err := bbolt.Open(filepath.Join(dir, "flamingo.db"), 0600, nil)
+	require.Nil(err)
+	defer db.Close()
+
+	storage, err := NewBolt(db)
+	require.Nil(err)
+	RunStorageExtraTest(storage, t)
+}
+
+func TestBoltStorageV2(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-bolt")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	db, err := bbolt.Open(filepath.Join(dir, "flamingo.db"), 0600, nil)
+	require.Nil(err)
+	defer db.Close()
+
+	storage, err := NewBolt(db)
+	require.Nil(err)
+	require.Implements((*flamingo.Querier)(nil), storage)
+	RunStorageV2Test(flamingo.NewStorageV2(storage), t)
+}
@@ -2,11 +2,57 @@ package storage
 
 import (
 	"testing"
+	"time"
 
 	"github.com/src-d/flamingo"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
 )
 
+type testExtra struct {
+	Foo string
+}
+
+func init() {
+	flamingo.RegisterExtra("storage.testExtra", testExtra{})
+}
+
+// RunStorageExtraTest checks that the registered types of the Extra of bots
+// and conversations are kept by a storage, which must be empty.
+func RunStorageExtraTest(storage flamingo.Storage, t *testing.T) {
+	require := require.New(t)
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "1", Extra: testExtra{"bot"}}))
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "2", Extra: map[string]interface{}{"Foo": "bar"}}))
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{ID: "3", BotID: "1", Extra: testExtra{"conv"}}))
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{ID: "4", BotID: "1"}))
+	checkStorageExtra(storage, t)
+}
+
+func checkStorageExtra(storage flamingo.Storage, t *testing.T) {
+	require := require.New(t)
+	bots, err := storage.LoadBots()
+	require.Nil(err)
+	require.Equal(2, len(bots))
+	for _, b := range bots {
+		if b.ID == "1" {
+			require.Equal(testExtra{"bot"}, b.Extra)
+		} else {
+			require.Equal(map[string]interface{}{"Foo": "bar"}, b.Extra)
+		}
+	}
+
+	convs, err := storage.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(2, len(convs))
+	for _, c := range convs {
+		if c.ID == "3" {
+			require.Equal(testExtra{"conv"}, c.Extra)
+		} else {
+			require.Nil(c.Extra)
+		}
+	}
+}
+
 func RunStorageTest(storage flamingo.Storage, t *testing.T) {
 	require := require.New(t)
 	ok, err := storage.BotExists(flamingo.StoredBot{ID: "1"})
@@ -41,6 +87,28 @@ func RunStorageTest(storage flamingo.Storage, t *testing.T) {
 		BotID: "1",
 	}))
 
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{
+		ID:    "2",
+		BotID: "1",
+	}))
+
+	ok, err = storage.ConversationExists(flamingo.StoredConversation{
+		ID: "2", BotID: "4",
+	})
+	require.Nil(err)
+	require.False(ok)
+
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{
+		ID:    "2",
+		BotID: "4",
+	}))
+
+	ok, err = storage.ConversationExists(flamingo.StoredConversation{
+		ID: "2", BotID: "4",
+	})
+	require.Nil(err)
+	require.True(ok)
+
 	bots, err := storage.LoadBots()
 	require.Nil(err)
 	require.Equal(2, len(bots))
@@ -49,7 +117,315 @@ func RunStorageTest(storage flamingo.Storage, t *testing.T) {
 	require.Nil(err)
 	require.Equal(2, len(convs))
 
+	convs, err = storage.LoadConversations(flamingo.StoredBot{ID: "4"})
+	require.Nil(err)
+	require.Equal(1, len(convs))
+
 	convs, err = storage.LoadConversations(flamingo.StoredBot{ID: "2"})
 	require.Nil(err)
 	require.Equal(0, len(convs))
 }
+
+// RunStorageUpdateTest checks the updates and removals of a storage, which
+// must be empty.
+func RunStorageUpdateTest(storage flamingo.Storage, t *testing.T) {
+	require := require.New(t)
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "1"}))
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "4"}))
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1"}))
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{ID: "3", BotID: "1"}))
+
+	require.Nil(storage.UpdateConversation(flamingo.StoredConversation{
+		ID:    "2",
+		BotID: "1",
+		Extra: "foo",
+	}))
+
+	convs, err := storage.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(2, len(convs))
+	for _, c := range convs {
+		if c.ID == "2" {
+			require.Equal("foo", c.Extra)
+		} else {
+			require.Nil(c.Extra)
+		}
+	}
+
+	require.Equal(flamingo.ErrConversationNotFound, storage.UpdateConversation(flamingo.StoredConversation{
+		ID:    "5",
+		BotID: "1",
+	}))
+	require.Equal(flamingo.ErrConversationNotFound, storage.UpdateConversation(flamingo.StoredConversation{
+		ID:    "2",
+		BotID: "4",
+	}))
+
+	runStorageRemoveTest(storage, t)
+}
+
+func runStorageRemoveTest(storage flamingo.Storage, t *testing.T) {
+	require := require.New(t)
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{
+		ID:    "6",
+		BotID: "4",
+	}))
+
+	require.Nil(storage.RemoveConversation(flamingo.StoredConversation{
+		ID:    "2",
+		BotID: "1",
+	}))
+	require.Nil(storage.RemoveConversation(flamingo.StoredConversation{
+		ID:    "7",
+		BotID: "1",
+	}))
+
+	ok, err := storage.ConversationExists(flamingo.StoredConversation{
+		ID: "2", BotID: "1",
+	})
+	require.Nil(err)
+	require.False(ok)
+
+	convs, err := storage.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(1, len(convs))
+	require.Equal("3", convs[0].ID)
+
+	require.Nil(storage.RemoveBot(flamingo.StoredBot{ID: "1"}))
+	require.Nil(storage.RemoveBot(flamingo.StoredBot{ID: "8"}))
+
+	ok, err = storage.BotExists(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.False(ok)
+
+	ok, err = storage.ConversationExists(flamingo.StoredConversation{
+		ID: "3", BotID: "1",
+	})
+	require.Nil(err)
+	require.False(ok)
+
+	convs, err = storage.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(0, len(convs))
+
+	bots, err := storage.LoadBots()
+	require.Nil(err)
+	require.Equal(1, len(bots))
+	require.Equal("4", bots[0].ID)
+
+	ok, err = storage.ConversationExists(flamingo.StoredConversation{
+		ID: "6", BotID: "4",
+	})
+	require.Nil(err)
+	require.True(ok)
+}
+
+// RunStorageV2Test checks the queries and the cancellation of a StorageV2,
+// which must be empty.
+func RunStorageV2Test(storage flamingo.StorageV2, t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	created := time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)
+	hour := func(n int) time.Time {
+		return created.Add(time.Duration(n) * time.Hour)
+	}
+
+	for i, id := range []string{"e", "b", "a", "d", "c"} {
+		bot := flamingo.StoredBot{ID: id, CreatedAt: hour(int(id[0] - 'a'))}
+		if i == 1 {
+			bot.Extra = "x"
+		}
+		require.Nil(storage.StoreBot(ctx, bot))
+	}
+
+	for _, c := range []flamingo.StoredConversation{
+		{BotID: "c", ID: "2", CreatedAt: hour(2), Extra: "x"},
+		{BotID: "a", ID: "3", CreatedAt: hour(2)},
+		{BotID: "a", ID: "1", CreatedAt: hour(0)},
+		{BotID: "e", ID: "1", CreatedAt: hour(4)},
+		{BotID: "a", ID: "2", CreatedAt: hour(1)},
+		{BotID: "c", ID: "1", CreatedAt: hour(1)},
+	} {
+		require.Nil(storage.StoreConversation(ctx, c))
+	}
+
+	botIDs := func(q flamingo.BotQuery) ([]string, flamingo.Cursor) {
+		page, err := storage.QueryBots(ctx, q)
+		require.Nil(err)
+		var ids []string
+		for _, b := range page.Bots {
+			ids = append(ids, b.ID)
+		}
+		return ids, page.Next
+	}
+
+	convIDs := func(q flamingo.ConversationQuery) ([]string, flamingo.Cursor) {
+		page, err := storage.QueryConversations(ctx, q)
+		require.Nil(err)
+		var ids []string
+		for _, c := range page.Conversations {
+			ids = append(ids, c.BotID+c.ID)
+		}
+		return ids, page.Next
+	}
+
+	ids, next := botIDs(flamingo.BotQuery{Limit: 2})
+	require.Equal([]string{"a", "b"}, ids)
+	require.NotEqual(flamingo.Cursor(""), next)
+	ids, next = botIDs(flamingo.BotQuery{Limit: 2, After: next})
+	require.Equal([]string{"c", "d"}, ids)
+	ids, next = botIDs(flamingo.BotQuery{Limit: 2, After: next})
+	require.Equal([]string{"e"}, ids)
+	require.Equal(flamingo.Cursor(""), next)
+
+	ids, next = botIDs(flamingo.BotQuery{})
+	require.Equal([]string{"a", "b", "c", "d", "e"}, ids)
+	require.Equal(flamingo.Cursor(""), next)
+
+	ids, _ = botIDs(flamingo.BotQuery{IDs: []string{"d", "b", "z"}})
+	require.Equal([]string{"b", "d"}, ids)
+
+	ids, _ = botIDs(flamingo.BotQuery{CreatedAfter: hour(1), CreatedBefore: hour(4)})
+	require.Equal([]string{"c", "d"}, ids)
+
+	ids, next = botIDs(flamingo.BotQuery{Limit: 1, Extra: func(e interface{}) bool { return e == "x" }})
+	require.Equal([]string{"b"}, ids)
+	require.Equal(flamingo.Cursor(""), next)
+
+	ids, next = convIDs(flamingo.ConversationQuery{Limit: 4})
+	require.Equal([]string{"a1", "a2", "a3", "c1"}, ids)
+	ids, next = convIDs(flamingo.ConversationQuery{Limit: 4, After: next})
+	require.Equal([]string{"c2", "e1"}, ids)
+	require.Equal(flamingo.Cursor(""), next)
+
+	ids, _ = convIDs(flamingo.ConversationQuery{BotIDs: []string{"c", "d"}})
+	require.Equal([]string{"c1", "c2"}, ids)
+
+	ids, next = convIDs(flamingo.ConversationQuery{IDs: []string{"1"}, Limit: 1})
+	require.Equal([]string{"a1"}, ids)
+	ids, next = convIDs(flamingo.ConversationQuery{IDs: []string{"1"}, Limit: 1, After: next})
+	require.Equal([]string{"c1"}, ids)
+	ids, next = convIDs(flamingo.ConversationQuery{IDs: []string{"1"}, Limit: 1, After: next})
+	require.Equal([]string{"e1"}, ids)
+	require.Equal(flamingo.Cursor(""), next)
+
+	ids, _ = convIDs(flamingo.ConversationQuery{CreatedAfter: hour(0), CreatedBefore: hour(3)})
+	require.Equal([]string{"a2", "a3", "c1", "c2"}, ids)
+
+	ids, _ = convIDs(flamingo.ConversationQuery{Extra: func(e interface{}) bool { return e == "x" }})
+	require.Equal([]string{"c2"}, ids)
+
+	var all []string
+	require.Nil(flamingo.ForEachConversation(ctx, storage, flamingo.ConversationQuery{Limit: 2}, func(c flamingo.StoredConversation) error {
+		all = append(all, c.BotID+c.ID)
+		return nil
+	}))
+	require.Equal([]string{"a1", "a2", "a3", "c1", "c2", "e1"}, all)
+
+	_, err := storage.QueryBots(ctx, flamingo.BotQuery{After: "garbage"})
+	require.Equal(flamingo.ErrInvalidCursor, err)
+	_, err = storage.QueryConversations(ctx, flamingo.ConversationQuery{After: flamingo.NewCursor("a")})
+	require.Equal(flamingo.ErrInvalidCursor, err)
+
+	ok, err := storage.ConversationExists(ctx, flamingo.StoredConversation{BotID: "a", ID: "1"})
+	require.Nil(err)
+	require.True(ok)
+	require.Nil(storage.UpdateConversation(ctx, flamingo.StoredConversation{BotID: "a", ID: "1", Extra: "x"}))
+	require.Nil(storage.RemoveConversation(ctx, flamingo.StoredConversation{BotID: "a", ID: "2"}))
+	require.Nil(storage.RemoveBot(ctx, flamingo.StoredBot{ID: "c"}))
+
+	ids, _ = convIDs(flamingo.ConversationQuery{})
+	require.Equal([]string{"a1", "a3", "e1"}, ids)
+
+	ok, err = storage.BotExists(ctx, flamingo.StoredBot{ID: "c"})
+	require.Nil(err)
+	require.False(ok)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = storage.QueryBots(cancelled, flamingo.BotQuery{})
+	require.Equal(context.Canceled, err)
+	_, err = storage.QueryConversations(cancelled, flamingo.ConversationQuery{})
+	require.Equal(context.Canceled, err)
+	require.Equal(context.Canceled, storage.StoreBot(cancelled, flamingo.StoredBot{ID: "f"}))
+	require.Equal(context.Canceled, storage.StoreConversation(cancelled, flamingo.StoredConversation{BotID: "a", ID: "4"}))
+	require.Equal(context.Canceled, storage.UpdateConversation(cancelled, flamingo.StoredConversation{BotID: "a", ID: "1"}))
+	require.Equal(context.Canceled, storage.RemoveBot(cancelled, flamingo.StoredBot{ID: "a"}))
+	require.Equal(context.Canceled, storage.RemoveConversation(cancelled, flamingo.StoredConversation{BotID: "a", ID: "1"}))
+	_, err = storage.BotExists(cancelled, flamingo.StoredBot{ID: "a"})
+	require.Equal(context.Canceled, err)
+	_, err = storage.ConversationExists(cancelled, flamingo.StoredConversation{BotID: "a", ID: "1"})
+	require.Equal(context.Canceled, err)
+
+	require.Equal(context.Canceled, flamingo.ForEachBot(cancelled, storage, flamingo.BotQuery{}, func(flamingo.StoredBot) error {
+		return nil
+	}))
+}
+
+// RunMessageLogTest checks the entries logged in an empty message log
+// created with the given function.
+func RunMessageLogTest(newLog func(flamingo.RetentionPolicy) flamingo.MessageLog, t *testing.T) {
+	require := require.New(t)
+	log := newLog(flamingo.RetentionPolicy{MaxEntries: 3, MaxAge: time.Hour})
+	base := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	entries := []flamingo.LogEntry{
+		{Kind: flamingo.MessageReceived, Bot: "1", Channel: "a", ID: "m1", Time: base, User: flamingo.User{ID: "u", Username: "user"}, Text: "hi"},
+		{Kind: flamingo.MessageSent, Bot: "1", Channel: "a", ID: "m2", Time: base.Add(time.Minute), Text: "hello"},
+		{Kind: flamingo.MessageReceived, Bot: "1", Channel: "b", ID: "m3", Time: base.Add(2 * time.Minute), Text: "other"},
+		{Kind: flamingo.ActionReceived, Bot: "1", Channel: "a", ID: "m2", Time: base.Add(3 * time.Minute), Text: "yes", Action: &flamingo.UserAction{Name: "answer", Value: "yes"}},
+		{Kind: flamingo.MessageUpdated, Bot: "1", Channel: "a", ID: "m2", Time: base.Add(4 * time.Minute), Text: "bye"},
+	}
+	for _, e := range entries {
+		require.Nil(log.Log(e))
+	}
+
+	result, err := log.History(flamingo.LogQuery{Bot: "1", Channel: "a"})
+	require.Nil(err)
+	require.Equal([]string{"hello", "yes", "bye"}, logTexts(result), "only the last 3 entries are kept")
+	require.Equal(flamingo.ActionReceived, result[1].Kind)
+	require.Equal(&flamingo.UserAction{Name: "answer", Value: "yes"}, result[1].Action)
+	require.Nil(result[0].Action)
+	require.Equal("m2", result[0].ID)
+	require.True(result[0].Time.Equal(base.Add(time.Minute)))
+
+	result, err = log.History(flamingo.LogQuery{Bot: "1", Channel: "a", Limit: 2})
+	require.Nil(err)
+	require.Equal([]string{"yes", "bye"}, logTexts(result))
+
+	result, err = log.History(flamingo.LogQuery{
+		Bot:     "1",
+		Channel: "a",
+		Since:   base.Add(time.Minute),
+		Until:   base.Add(4 * time.Minute),
+	})
+	require.Nil(err)
+	require.Equal([]string{"hello", "yes"}, logTexts(result))
+
+	result, err = log.History(flamingo.LogQuery{Bot: "2", Channel: "a"})
+	require.Nil(err)
+	require.Equal(0, len(result))
+
+	require.Nil(log.Log(flamingo.LogEntry{Kind: flamingo.MessageReceived, Bot: "1", Channel: "c", Time: base, User: flamingo.User{ID: "u", Username: "user"}, Text: "first"}))
+	result, err = log.History(flamingo.LogQuery{Bot: "1", Channel: "c"})
+	require.Nil(err)
+	require.Equal(1, len(result))
+	require.Equal(flamingo.User{ID: "u", Username: "user"}, result[0].User)
+
+	require.Nil(log.Prune(base.Add(time.Hour + 150*time.Second)))
+	result, err = log.History(flamingo.LogQuery{Bot: "1", Channel: "a"})
+	require.Nil(err)
+	require.Equal([]string{"yes", "bye"}, logTexts(result), "entries older than an hour are pruned")
+
+	result, err = log.History(flamingo.LogQuery{Bot: "1", Channel: "b"})
+	require.Nil(err)
+	require.Equal(0, len(result))
+}
+
+func logTexts(entries []flamingo.LogEntry) []string {
+	var texts []string
+	for _, e := range entries {
+		texts = append(texts, e.Text)
+	}
+	return texts
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/src-d/flamingo"
+)
+
+// ErrConflict is returned by Copy when a bot or conversation is already in
+// the destination storage and the conflict policy is Fail.
+var ErrConflict = errors.New("the data is already in the destination storage")
+
+// ConflictPolicy decides what Copy does with the bots and conversations that
+// are already in the destination storage.
+type ConflictPolicy byte
+
+const (
+	// Skip keeps the data of the destination storage.
+	Skip ConflictPolicy = iota
+	// Overwrite replaces the data of the destination storage with the data
+	// of the source storage.
+	Overwrite
+	// Fail does not copy anything if any bot or conversation is already in
+	// the destination storage.
+	Fail
+)
+
+// CopyOptions are the configurable options of Copy.
+type CopyOptions struct {
+	// Conflict is the policy for the data that is already in the destination
+	// storage. If none is given, Skip is used.
+	Conflict ConflictPolicy
+	// DryRun will report what would be copied without saving anything.
+	DryRun bool
+}
+
+// CopyReport is the result of a copy between storages.
+type CopyReport struct {
+	// Bots is the number of bots copied.
+	Bots int
+	// Conversations is the number of conversations copied.
+	Conversations int
+	// Conflicts are the descriptions of the bots and conversations that were
+	// already in the destination storage.
+	Conflicts []string
+}
+
+// Copy copies all the bots and conversations of the source storage to the
+// destination storage, which can be a storage of a different kind, such as
+// when moving from a file storage to a SQL one. The data that is already in
+// the destination storage is handled according to the conflict policy of
+// the options.
+func Copy(src, dst flamingo.Storage, options CopyOptions) (CopyReport, error) {
+	var report CopyReport
+	bots, err := src.LoadBots()
+	if err != nil {
+		return report, fmt.Errorf("unable to load bots: %s", err)
+	}
+
+	type botData struct {
+		bot      flamingo.StoredBot
+		exists   bool
+		convs    []flamingo.StoredConversation
+		existing []bool
+	}
+
+	// everything is checked before saving anything, so nothing is copied if
+	// the copy must fail because of a conflict
+	var data []botData
+	for _, b := range bots {
+		d := botData{bot: b}
+		if d.exists, err = dst.BotExists(b); err != nil {
+			return report, fmt.Errorf("unable to check if bot %s exists: %s", b.ID, err)
+		}
+
+		if d.exists {
+			report.Conflicts = append(report.Conflicts, fmt.Sprintf("bot %s", b.ID))
+		}
+
+		if d.convs, err = src.LoadConversations(b); err != nil {
+			return report, fmt.Errorf("unable to load conversations of bot %s: %s", b.ID, err)
+		}
+
+		for _, c := range d.convs {
+			ok, err := dst.ConversationExists(c)
+			if err != nil {
+				return report, fmt.Errorf("unable to check if conversation %s of bot %s exists: %s", c.ID, b.ID, err)
+			}
+
+			if ok {
+				report.Conflicts = append(report.Conflicts, fmt.Sprintf("conversation %s of bot %s", c.ID, b.ID))
+			}
+			d.existing = append(d.existing, ok)
+		}
+
+		data = append(data, d)
+	}
+
+	if options.Conflict == Fail && len(report.Conflicts) > 0 {
+		return report, ErrConflict
+	}
+
+	for _, d := range data {
+		if !d.exists || options.Conflict == Overwrite {
+			if !options.DryRun {
+				if err := dst.StoreBot(d.bot); err != nil {
+					return report, fmt.Errorf("unable to store bot %s: %s", d.bot.ID, err)
+				}
+			}
+			report.Bots++
+		}
+
+		for i, c := range d.convs {
+			if d.existing[i] && options.Conflict != Overwrite {
+				continue
+			}
+
+			if !options.DryRun {
+				if err := dst.StoreConversation(c); err != nil {
+					return report, fmt.Errorf("unable to store conversation %s of bot %s: %s", c.ID, d.bot.ID, err)
+				}
+			}
+			report.Conversations++
+		}
+	}
+
+	return report, nil
+}
+
+// DumpVersion is the version of the format of the dumps written by Export.
+const DumpVersion = 1
+
+// Dump is the content of a storage as it is written by Export.
+type Dump struct {
+	// Version is the version of the format of the dump.
+	Version int
+	// Bots are the bots of the storage.
+	Bots []DumpedBot
+}
+
+// DumpedBot is a bot of a dump, along with its conversations.
+type DumpedBot struct {
+	Bot           flamingo.StoredBot
+	Conversations []flamingo.StoredConversation
+}
+
+// Export writes all the bots and conversations of the storage to the writer
+// as a JSON dump, which can be imported into any other storage with Import.
+func Export(storage flamingo.Storage, w io.Writer) error {
+	bots, err := storage.LoadBots()
+	if err != nil {
+		return err
+	}
+
+	// the bots are copied before sorting them, because some storages return
+	// the data they keep in memory
+	bots = append([]flamingo.StoredBot(nil), bots...)
+	sort.Sort(flamingo.BotsByID(bots))
+	dump := Dump{Version: DumpVersion}
+	for _, b := range bots {
+		convs, err := storage.LoadConversations(b)
+		if err != nil {
+			return err
+		}
+
+		// the conversations are copied before sorting them, because some
+		// storages return the data they keep in memory
+		convs = append([]flamingo.StoredConversation(nil), convs...)
+		sort.Sort(flamingo.ConversationsByID(convs))
+		dump.Bots = append(dump.Bots, DumpedBot{Bot: b, Conversations: convs})
+	}
+
+	return json.NewEncoder(w).Encode(dump)
+}
+
+// Import reads a dump written by Export and copies its bots and
+// conversations to the storage with the given options. Nothing is copied if
+// the dump has bots or conversations without ID or more than once.
+func Import(r io.Reader, storage flamingo.Storage, options CopyOptions) (CopyReport, error) {
+	var dump Dump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return CopyReport{}, fmt.Errorf("invalid dump: %s", err)
+	}
+
+	if dump.Version < 1 || dump.Version > DumpVersion {
+		return CopyReport{}, fmt.Errorf("unsupported dump version %d", dump.Version)
+	}
+
+	src := NewMemory()
+	for _, b := range dump.Bots {
+		if err := importBot(src, b); err != nil {
+			return CopyReport{}, fmt.Errorf("invalid dump: %s", err)
+		}
+	}
+
+	return Copy(src, storage, options)
+}
+
+// importBot stores the dumped bot and its conversations in the given storage,
+// failing if any of them is already stored, as a valid dump has no
+// duplicates.
+func importBot(storage flamingo.Storage, dumped DumpedBot) error {
+	if dumped.Bot.ID == "" {
+		return errors.New("bot without ID")
+	}
+
+	ok, err := storage.BotExists(dumped.Bot)
+	if err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("duplicated bot %q", dumped.Bot.ID)
+	}
+
+	if err := storage.StoreBot(dumped.Bot); err != nil {
+		return err
+	}
+
+	for _, c := range dumped.Conversations {
+		c.BotID = dumped.Bot.ID
+		if c.ID == "" {
+			return fmt.Errorf("conversation without ID in bot %q", c.BotID)
+		}
+
+		ok, err := storage.ConversationExists(c)
+		if err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("duplicated conversation %q in bot %q", c.ID, c.BotID)
+		}
+
+		if err := storage.StoreConversation(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/stretchr/testify/require"
+)
+
+func newCopySource(t *testing.T) flamingo.Storage {
+	require := require.New(t)
+	created := time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)
+	src := NewMemory()
+	require.Nil(src.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo", CreatedAt: created, Extra: testExtra{"bot"}}))
+	require.Nil(src.StoreBot(flamingo.StoredBot{ID: "2", Token: "bar"}))
+	require.Nil(src.StoreConversation(flamingo.StoredConversation{ID: "3", BotID: "1", CreatedAt: created, Extra: testExtra{"conv"}}))
+	require.Nil(src.StoreConversation(flamingo.StoredConversation{ID: "4", BotID: "1"}))
+	require.Nil(src.StoreConversation(flamingo.StoredConversation{ID: "3", BotID: "2"}))
+	return src
+}
+
+func TestCopy(t *testing.T) {
+	require := require.New(t)
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	dst, err := NewSQL(db, SQLite)
+	require.Nil(err)
+
+	report, err := Copy(newCopySource(t), dst, CopyOptions{})
+	require.Nil(err)
+	require.Equal(CopyReport{Bots: 2, Conversations: 3}, report)
+
+	bots, err := dst.LoadBots()
+	require.Nil(err)
+	require.Equal(2, len(bots))
+	require.Equal("foo", bots[0].Token)
+	require.Equal(testExtra{"bot"}, bots[0].Extra)
+	require.Equal(time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC), bots[0].CreatedAt.UTC())
+
+	convs, err := dst.LoadConversations(bots[0])
+	require.Nil(err)
+	require.Equal(2, len(convs))
+	require.Equal(testExtra{"conv"}, convs[0].Extra)
+
+	convs, err = dst.LoadConversations(bots[1])
+	require.Nil(err)
+	require.Equal(1, len(convs))
+}
+
+func TestCopyConflicts(t *testing.T) {
+	require := require.New(t)
+	newDst := func() flamingo.Storage {
+		dst := NewMemory()
+		require.Nil(dst.StoreBot(flamingo.StoredBot{ID: "1", Token: "old"}))
+		require.Nil(dst.StoreConversation(flamingo.StoredConversation{ID: "3", BotID: "1", Extra: "old"}))
+		return dst
+	}
+
+	token := func(s flamingo.Storage, id string) string {
+		bots, err := s.LoadBots()
+		require.Nil(err)
+		for _, b := range bots {
+			if b.ID == id {
+				return b.Token
+			}
+		}
+		return ""
+	}
+
+	dst := newDst()
+	report, err := Copy(newCopySource(t), dst, CopyOptions{Conflict: Skip})
+	require.Nil(err)
+	require.Equal(1, report.Bots)
+	require.Equal(2, report.Conversations)
+	require.Equal([]string{"bot 1", "conversation 3 of bot 1"}, report.Conflicts)
+	require.Equal("old", token(dst, "1"))
+	require.Equal("bar", token(dst, "2"))
+
+	convs, err := dst.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(2, len(convs))
+	require.Equal("old", convs[0].Extra)
+
+	dst = newDst()
+	report, err = Copy(newCopySource(t), dst, CopyOptions{Conflict: Overwrite})
+	require.Nil(err)
+	require.Equal(2, report.Bots)
+	require.Equal(3, report.Conversations)
+	require.Equal(2, len(report.Conflicts))
+	require.Equal("foo", token(dst, "1"))
+
+	convs, err = dst.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(2, len(convs))
+	require.Equal(testExtra{"conv"}, convs[0].Extra)
+
+	dst = newDst()
+	report, err = Copy(newCopySource(t), dst, CopyOptions{Conflict: Fail})
+	require.Equal(ErrConflict, err)
+	require.Equal(2, len(report.Conflicts))
+	require.Equal("", token(dst, "2"))
+
+	dst = newDst()
+	report, err = Copy(newCopySource(t), dst, CopyOptions{DryRun: true})
+	require.Nil(err)
+	require.Equal(1, report.Bots)
+	require.Equal(2, report.Conversations)
+	require.Equal("", token(dst, "2"))
+}
+
+func TestExportImport(t *testing.T) {
+	require := require.New(t)
+	var buf bytes.Buffer
+	require.Nil(Export(newCopySource(t), &buf))
+
+	var again bytes.Buffer
+	require.Nil(Export(newCopySource(t), &again))
+	require.Equal(buf.String(), again.String())
+	require.True(strings.Contains(buf.String(), `"Version":1`))
+	require.True(strings.Contains(buf.String(), `"ExtraType":"storage.testExtra"`))
+
+	dst := NewMemory()
+	report, err := Import(&buf, dst, CopyOptions{})
+	require.Nil(err)
+	require.Equal(CopyReport{Bots: 2, Conversations: 3}, report)
+
+	convs, err := dst.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(2, len(convs))
+
+	_, err = Import(strings.NewReader("garbage"), dst, CopyOptions{})
+	require.NotNil(err)
+
+	_, err = Import(strings.NewReader(`{"Version":2}`), dst, CopyOptions{})
+	require.NotNil(err)
+}
+
+func TestImportInvalid(t *testing.T) {
+	dumps := []string{
+		`{"Version":1,"Bots":[{"Bot":{"ID":""}}]}`,
+		`{"Version":1,"Bots":[{"Bot":{"ID":"1"}},{"Bot":{"ID":"1"}}]}`,
+		`{"Version":1,"Bots":[{"Bot":{"ID":"1"},"Conversations":[{"ID":""}]}]}`,
+		`{"Version":1,"Bots":[{"Bot":{"ID":"1"},"Conversations":[{"ID":"2"},{"ID":"2"}]}]}`,
+	}
+
+	for _, dump := range dumps {
+		dst := NewMemory()
+		report, err := Import(strings.NewReader(dump), dst, CopyOptions{})
+		require.NotNil(t, err, dump)
+		require.Equal(t, CopyReport{}, report, dump)
+
+		bots, err := dst.LoadBots()
+		require.Nil(t, err)
+		require.Equal(t, 0, len(bots), "nothing is imported from %s", dump)
+	}
+}
+
+func TestImportUnknownExtra(t *testing.T) {
+	require := require.New(t)
+	dump := `{"Version":1,"Bots":[{"Bot":{"ID":"1","Extra":{"SyncToken":"s1"},"ExtraType":"unknown.BotExtra"}}]}`
+
+	dst := NewMemory()
+	_, err := Import(strings.NewReader(dump), dst, CopyOptions{})
+	require.Nil(err)
+
+	var buf bytes.Buffer
+	require.Nil(Export(dst, &buf))
+	require.True(strings.Contains(buf.String(), `"Extra":{"SyncToken":"s1"},"ExtraType":"unknown.BotExtra"`))
+}
@@ -0,0 +1,405 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/src-d/flamingo"
+)
+
+// encryptedPrefix starts all the values encrypted by the encrypted storage,
+// followed by the ID of the key and the encrypted value encoded in base64,
+// separated by colons.
+const encryptedPrefix = "enc:"
+
+var (
+	// ErrNoKeys is returned by the encrypted storage when its key provider
+	// has no keys to encrypt the data with.
+	ErrNoKeys = errors.New("there are no keys to encrypt the data")
+	// ErrUnknownKey is returned by the encrypted storage when the data was
+	// encrypted with a key its key provider does not have.
+	ErrUnknownKey = errors.New("the data was encrypted with an unknown key")
+)
+
+// Key is a key used to encrypt the data of a storage.
+type Key struct {
+	// ID identifies the key among the keys of a provider. It is saved along
+	// with the data encrypted with the key, so the data can still be
+	// decrypted after the key is rotated. It cannot contain colons.
+	ID string
+	// Secret is the AES key, which must be 16, 24 or 32 bytes long.
+	Secret []byte
+}
+
+// KeyProvider provides the keys used to encrypt the data of a storage.
+type KeyProvider interface {
+	// Keys returns the available keys. The first one is the current key,
+	// which is used to encrypt, and the rest are older keys only used to
+	// decrypt the data that was encrypted with them.
+	Keys() ([]Key, error)
+}
+
+// StaticKeys is a KeyProvider with a fixed list of keys.
+type StaticKeys []Key
+
+// Keys returns the keys of the list.
+func (k StaticKeys) Keys() ([]Key, error) {
+	return k, nil
+}
+
+// ParseKeys parses a list of keys separated by commas or whitespace. Every
+// key is written as its ID and its secret encoded in base64, separated by a
+// colon, such as "2017:c2VjcmV0LXNlY3JldC1zZWNyZXQh".
+func ParseKeys(text string) ([]Key, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+
+	var keys []Key
+	for _, f := range fields {
+		parts := strings.SplitN(f, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid key %q, expecting id:secret", f)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret of key %s: %s", parts[0], err)
+		}
+
+		keys = append(keys, Key{ID: parts[0], Secret: secret})
+	}
+	return keys, nil
+}
+
+type envKeys string
+
+// NewEnvKeys creates a KeyProvider that reads the keys from the environment
+// variable with the given name every time they are needed, written as
+// ParseKeys expects.
+func NewEnvKeys(name string) KeyProvider {
+	return envKeys(name)
+}
+
+func (k envKeys) Keys() ([]Key, error) {
+	return ParseKeys(os.Getenv(string(k)))
+}
+
+type fileKeys string
+
+// NewFileKeys creates a KeyProvider that reads the keys from the file at the
+// given path every time they are needed, so the keys can be rotated without
+// restarting the process. The keys are written as ParseKeys expects, usually
+// one per line.
+func NewFileKeys(path string) KeyProvider {
+	return fileKeys(path)
+}
+
+func (k fileKeys) Keys() ([]Key, error) {
+	bytes, err := ioutil.ReadFile(string(k))
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeys(string(bytes))
+}
+
+// EncryptedOptions are the configurable options of the encrypted storage.
+type EncryptedOptions struct {
+	// EncryptExtra will encrypt the Extra of the bots and conversations too,
+	// not only the tokens of the bots.
+	EncryptExtra bool
+}
+
+type encryptedStorage struct {
+	flamingo.Storage
+	keys    KeyProvider
+	options EncryptedOptions
+}
+
+// NewEncrypted creates a new storage that encrypts the tokens of the bots,
+// and optionally the Extra of bots and conversations, with AES-GCM before
+// saving them in the given storage, and decrypts them when they are loaded.
+// Data is encrypted with the current key of the provider and decrypted with
+// the key it was encrypted with, so keys can be rotated by adding a new
+// current key to the provider and calling Reencrypt. Every encrypted value is
+// bound to the bot or conversation it belongs to, and it cannot be decrypted
+// if it is copied to another one. Data saved before the
+// storage was encrypted is loaded as it is, and it is encrypted the next time
+// it is saved.
+func NewEncrypted(storage flamingo.Storage, keys KeyProvider, options EncryptedOptions) flamingo.Storage {
+	return &encryptedStorage{
+		Storage: storage,
+		keys:    keys,
+		options: options,
+	}
+}
+
+// Reencrypt loads all the bots and conversations of the storage and saves
+// them again. With an encrypted storage, it encrypts all the data with the
+// current key, so the older keys can be removed from the provider after it.
+func Reencrypt(storage flamingo.Storage) error {
+	bots, err := storage.LoadBots()
+	if err != nil {
+		return err
+	}
+
+	for _, b := range bots {
+		if err := storage.StoreBot(b); err != nil {
+			return err
+		}
+
+		convs, err := storage.LoadConversations(b)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range convs {
+			if err := storage.StoreConversation(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *encryptedStorage) StoreBot(bot flamingo.StoredBot) error {
+	var err error
+	if bot.Token != "" {
+		if bot.Token, err = s.encrypt([]byte(bot.Token), botRecord(bot.ID, "token")); err != nil {
+			return err
+		}
+	}
+
+	if bot.Extra, err = s.encryptExtra(bot.Extra, botRecord(bot.ID, "extra")); err != nil {
+		return err
+	}
+
+	return s.Storage.StoreBot(bot)
+}
+
+func (s *encryptedStorage) StoreConversation(conv flamingo.StoredConversation) error {
+	conv, err := s.encryptConversation(conv)
+	if err != nil {
+		return err
+	}
+
+	return s.Storage.StoreConversation(conv)
+}
+
+func (s *encryptedStorage) UpdateConversation(conv flamingo.StoredConversation) error {
+	conv, err := s.encryptConversation(conv)
+	if err != nil {
+		return err
+	}
+
+	return s.Storage.UpdateConversation(conv)
+}
+
+func (s *encryptedStorage) LoadBots() ([]flamingo.StoredBot, error) {
+	bots, err := s.Storage.LoadBots()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.keys.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	// the loaded data is copied, because some storages return the data they
+	// keep in memory
+	result := make([]flamingo.StoredBot, len(bots))
+	for i, b := range bots {
+		token, err := decrypt(keys, b.Token, botRecord(b.ID, "token"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt token of bot %s: %s", b.ID, err)
+		}
+		b.Token = string(token)
+
+		if b.Extra, err = decryptExtra(keys, b.Extra, botRecord(b.ID, "extra")); err != nil {
+			return nil, fmt.Errorf("unable to decrypt extra of bot %s: %s", b.ID, err)
+		}
+		result[i] = b
+	}
+
+	return result, nil
+}
+
+func (s *encryptedStorage) LoadConversations(bot flamingo.StoredBot) ([]flamingo.StoredConversation, error) {
+	convs, err := s.Storage.LoadConversations(bot)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.keys.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]flamingo.StoredConversation, len(convs))
+	for i, c := range convs {
+		if c.Extra, err = decryptExtra(keys, c.Extra, conversationRecord(c.BotID, c.ID, "extra")); err != nil {
+			return nil, fmt.Errorf("unable to decrypt extra of conversation %s: %s", c.ID, err)
+		}
+		result[i] = c
+	}
+
+	return result, nil
+}
+
+// encrypt encrypts the data of the given record with the current key.
+func (s *encryptedStorage) encrypt(data []byte, record []string) (string, error) {
+	keys, err := s.keys.Keys()
+	if err != nil {
+		return "", err
+	}
+
+	if len(keys) == 0 {
+		return "", ErrNoKeys
+	}
+	return encrypt(keys[0], data, record)
+}
+
+func (s *encryptedStorage) encryptConversation(conv flamingo.StoredConversation) (flamingo.StoredConversation, error) {
+	var err error
+	conv.Extra, err = s.encryptExtra(conv.Extra, conversationRecord(conv.BotID, conv.ID, "extra"))
+	return conv, err
+}
+
+// encryptedExtra is the Extra as it is encrypted, along with the name of its
+// registered type.
+type encryptedExtra struct {
+	Type  string `json:",omitempty"`
+	Value json.RawMessage
+}
+
+func (s *encryptedStorage) encryptExtra(extra interface{}, record []string) (interface{}, error) {
+	if !s.options.EncryptExtra || extra == nil {
+		return extra, nil
+	}
+
+	value, err := json.Marshal(extra)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(encryptedExtra{flamingo.ExtraName(extra), value})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.encrypt(bytes, record)
+}
+
+func decryptExtra(keys []Key, extra interface{}, record []string) (interface{}, error) {
+	text, ok := extra.(string)
+	if !ok || !strings.HasPrefix(text, encryptedPrefix) {
+		return extra, nil
+	}
+
+	bytes, err := decrypt(keys, text, record)
+	if err != nil {
+		return nil, err
+	}
+
+	var e encryptedExtra
+	if err := json.Unmarshal(bytes, &e); err != nil {
+		return nil, err
+	}
+
+	return flamingo.DecodeExtra(e.Type, e.Value)
+}
+
+// botRecord identifies a field of a bot for the encryption of its value.
+func botRecord(bot, field string) []string {
+	return []string{"bot", bot, field}
+}
+
+// conversationRecord identifies a field of a conversation for the encryption
+// of its value.
+func conversationRecord(bot, conv, field string) []string {
+	return []string{"conversation", bot, conv, field}
+}
+
+// additionalData returns the data that is authenticated along with a value
+// encrypted with the given key for the given record, so an encrypted value
+// cannot be decrypted if it is moved to another record.
+func additionalData(key Key, record []string) []byte {
+	return []byte(strings.Join(append([]string{key.ID}, record...), "\x00"))
+}
+
+func encrypt(key Key, data []byte, record []string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, data, additionalData(key, record))
+	return encryptedPrefix + key.ID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt decrypts the given text of the given record with the key it was
+// encrypted with. Texts that were not encrypted are returned as they are.
+func decrypt(keys []Key, text string, record []string) ([]byte, error) {
+	if !strings.HasPrefix(text, encryptedPrefix) {
+		return []byte(text), nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(text, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid encrypted value")
+	}
+
+	var key *Key
+	for i := range keys {
+		if keys[i].ID == parts[0] {
+			key = &keys[i]
+			break
+		}
+	}
+
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(*key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("invalid encrypted value")
+	}
+
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, additionalData(*key, record))
+}
+
+func newGCM(key Key) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %s", key.ID, err)
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/src-d/flamingo"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKey1 = Key{ID: "1", Secret: []byte("0123456789abcdef")}
+	testKey2 = Key{ID: "2", Secret: []byte("0123456789abcdef0123456789abcdef")}
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	keys := StaticKeys{testKey1}
+	RunStorageTest(NewEncrypted(NewMemory(), keys, EncryptedOptions{EncryptExtra: true}), t)
+	RunStorageUpdateTest(NewEncrypted(NewMemory(), keys, EncryptedOptions{EncryptExtra: true}), t)
+	RunStorageExtraTest(NewEncrypted(NewMemory(), keys, EncryptedOptions{EncryptExtra: true}), t)
+	RunStorageExtraTest(NewEncrypted(NewMemory(), keys, EncryptedOptions{}), t)
+}
+
+func TestEncryptedStorageFile(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-encrypted")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flamingo.json")
+	file, err := NewFile(path)
+	require.Nil(err)
+
+	storage := NewEncrypted(file, StaticKeys{testKey1}, EncryptedOptions{EncryptExtra: true})
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "1", Token: "secret-token", Extra: testExtra{"secret-bot"}}))
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1", Extra: testExtra{"secret-conv"}}))
+
+	info, err := os.Stat(path)
+	require.Nil(err)
+	require.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	bytes, err := ioutil.ReadFile(path)
+	require.Nil(err)
+	require.False(strings.Contains(string(bytes), "secret"))
+
+	bots, err := file.LoadBots()
+	require.Nil(err)
+	require.True(strings.HasPrefix(bots[0].Token, "enc:1:"))
+
+	// the data kept in memory by the file storage is not decrypted
+	convs, err := storage.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(testExtra{"secret-conv"}, convs[0].Extra)
+
+	convs, err = file.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.True(strings.HasPrefix(convs[0].Extra.(string), "enc:1:"))
+
+	file, err = NewFile(path)
+	require.Nil(err)
+	storage = NewEncrypted(file, StaticKeys{testKey1}, EncryptedOptions{})
+
+	bots, err = storage.LoadBots()
+	require.Nil(err)
+	require.Equal(1, len(bots))
+	require.Equal("secret-token", bots[0].Token)
+	require.Equal(testExtra{"secret-bot"}, bots[0].Extra)
+}
+
+func TestEncryptedStorageRotation(t *testing.T) {
+	require := require.New(t)
+	mem := NewMemory()
+	old := NewEncrypted(mem, StaticKeys{testKey1}, EncryptedOptions{EncryptExtra: true})
+	require.Nil(old.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo"}))
+	require.Nil(old.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1", Extra: "bar"}))
+
+	rotated := NewEncrypted(mem, StaticKeys{testKey2, testKey1}, EncryptedOptions{EncryptExtra: true})
+	bots, err := rotated.LoadBots()
+	require.Nil(err)
+	require.Equal("foo", bots[0].Token)
+
+	require.Nil(Reencrypt(rotated))
+
+	bots, err = mem.LoadBots()
+	require.Nil(err)
+	require.True(strings.HasPrefix(bots[0].Token, "enc:2:"))
+
+	storage := NewEncrypted(mem, StaticKeys{testKey2}, EncryptedOptions{})
+	bots, err = storage.LoadBots()
+	require.Nil(err)
+	require.Equal("foo", bots[0].Token)
+
+	convs, err := storage.LoadConversations(bots[0])
+	require.Nil(err)
+	require.Equal("bar", convs[0].Extra)
+
+	_, err = old.LoadBots()
+	require.NotNil(err)
+	require.True(strings.Contains(err.Error(), ErrUnknownKey.Error()))
+
+	_, err = old.LoadConversations(bots[0])
+	require.NotNil(err)
+}
+
+func TestEncryptedStoragePlaintext(t *testing.T) {
+	require := require.New(t)
+	mem := NewMemory()
+	require.Nil(mem.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo", Extra: "bar"}))
+
+	storage := NewEncrypted(mem, StaticKeys{testKey1}, EncryptedOptions{EncryptExtra: true})
+	bots, err := storage.LoadBots()
+	require.Nil(err)
+	require.Equal(flamingo.StoredBot{ID: "1", Token: "foo", Extra: "bar"}, bots[0])
+
+	require.Nil(Reencrypt(storage))
+	bots, err = mem.LoadBots()
+	require.Nil(err)
+	require.True(strings.HasPrefix(bots[0].Token, "enc:1:"))
+	require.True(strings.HasPrefix(bots[0].Extra.(string), "enc:1:"))
+}
+
+func TestEncryptedStorageErrors(t *testing.T) {
+	require := require.New(t)
+	mem := NewMemory()
+	storage := NewEncrypted(mem, StaticKeys{}, EncryptedOptions{EncryptExtra: true})
+	require.Equal(ErrNoKeys, storage.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo"}))
+	require.Equal(ErrNoKeys, storage.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1", Extra: "foo"}))
+	require.Equal(ErrNoKeys, storage.UpdateConversation(flamingo.StoredConversation{ID: "2", BotID: "1", Extra: "foo"}))
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "1"}))
+
+	storage = NewEncrypted(mem, StaticKeys{{ID: "1", Secret: []byte("short")}}, EncryptedOptions{})
+	require.NotNil(storage.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo"}))
+
+	for _, token := range []string{"enc:1", "enc:1:not base64", "enc:1:" + base64.StdEncoding.EncodeToString([]byte("short")), "enc:1:" + base64.StdEncoding.EncodeToString([]byte("some data that is long enough"))} {
+		require.Nil(mem.StoreBot(flamingo.StoredBot{ID: "1", Token: token}))
+		_, err := NewEncrypted(mem, StaticKeys{testKey1}, EncryptedOptions{}).LoadBots()
+		require.NotNil(err, token)
+	}
+
+	storage = NewEncrypted(mem, NewFileKeys("/does/not/exist"), EncryptedOptions{})
+	_, err := storage.LoadBots()
+	require.NotNil(err)
+	require.NotNil(storage.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo"}))
+}
+
+func TestEncryptedStorageMovedValues(t *testing.T) {
+	require := require.New(t)
+	mem := NewMemory()
+	storage := NewEncrypted(mem, StaticKeys{testKey1}, EncryptedOptions{EncryptExtra: true})
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo", Extra: "bar"}))
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1", Extra: "baz"}))
+
+	bots, err := mem.LoadBots()
+	require.Nil(err)
+	convs, err := mem.LoadConversations(bots[0])
+	require.Nil(err)
+
+	require.Nil(mem.RemoveBot(bots[0]))
+	require.Nil(mem.StoreBot(flamingo.StoredBot{ID: "3", Token: bots[0].Token}))
+	_, err = storage.LoadBots()
+	require.NotNil(err)
+
+	require.Nil(mem.StoreBot(flamingo.StoredBot{ID: "3", Extra: bots[0].Token}))
+	_, err = storage.LoadBots()
+	require.NotNil(err)
+
+	require.Nil(mem.StoreConversation(flamingo.StoredConversation{ID: "4", BotID: "3", Extra: convs[0].Extra}))
+	_, err = storage.LoadConversations(flamingo.StoredBot{ID: "3"})
+	require.NotNil(err)
+}
+
+func TestParseKeys(t *testing.T) {
+	require := require.New(t)
+	keys, err := ParseKeys("2:" + base64.StdEncoding.EncodeToString(testKey2.Secret) + ",\n 1:" + base64.StdEncoding.EncodeToString(testKey1.Secret) + "\n")
+	require.Nil(err)
+	require.Equal([]Key{testKey2, testKey1}, keys)
+
+	keys, err = ParseKeys("")
+	require.Nil(err)
+	require.Equal(0, len(keys))
+
+	_, err = ParseKeys("foo")
+	require.NotNil(err)
+
+	_, err = ParseKeys(":Zm9v")
+	require.NotNil(err)
+
+	_, err = ParseKeys("1:not base64")
+	require.NotNil(err)
+}
+
+func TestKeyProviders(t *testing.T) {
+	require := require.New(t)
+	text := "1:" + base64.StdEncoding.EncodeToString(testKey1.Secret)
+
+	require.Nil(os.Setenv("FLAMINGO_TEST_KEYS", text))
+	defer os.Unsetenv("FLAMINGO_TEST_KEYS")
+	keys, err := NewEnvKeys("FLAMINGO_TEST_KEYS").Keys()
+	require.Nil(err)
+	require.Equal([]Key{testKey1}, keys)
+
+	f, err := ioutil.TempFile("", "flamingo-keys")
+	require.Nil(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(text + "\n")
+	require.Nil(err)
+	require.Nil(f.Close())
+
+	keys, err = NewFileKeys(f.Name()).Keys()
+	require.Nil(err)
+	require.Equal([]Key{testKey1}, keys)
+}
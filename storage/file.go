@@ -4,21 +4,29 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"sort"
 	"sync"
 
 	"github.com/src-d/flamingo"
 )
 
+// fileVersion is the version of the format of the files of the file storage.
+// Version 0 files tracked the existing conversations by their ID alone and
+// could have the same conversation stored more than once for a bot.
+const fileVersion = 1
+
+// botStorage is the format of the files of the file storage.
 type botStorage struct {
-	Bots                  map[string]flamingo.StoredBot
-	Conversations         map[string][]flamingo.StoredConversation
-	ExistingConversations map[string]bool
+	Version       int
+	Bots          map[string]flamingo.StoredBot
+	Conversations map[string][]flamingo.StoredConversation
 }
 
 type fileStorage struct {
 	sync.RWMutex
-	file string
-	data botStorage
+	file  string
+	bots  map[string]flamingo.StoredBot
+	convs map[string]map[string]flamingo.StoredConversation
 }
 
 // NewFile creates a new storage that will be saved to a disk file.
@@ -28,9 +36,10 @@ type fileStorage struct {
 // Read operations are very cheap, since they are done in-memory and kept up to
 // date with the writes. Write operations are slower, though, because every
 // time a save operation is performed, it will truncate the file and write all
-// again.
+// again. If the process dies while saving, the data may be lost, so NewBolt
+// or NewSQL should be used instead when that is a concern.
 func NewFile(file string) (flamingo.Storage, error) {
-	storage := &fileStorage{file: file}
+	storage := newFileStorage(file)
 	if err := storage.load(); err != nil {
 		return nil, err
 	}
@@ -38,36 +47,65 @@ func NewFile(file string) (flamingo.Storage, error) {
 	return storage, nil
 }
 
-func newBotStorage() botStorage {
-	return botStorage{
-		Bots:                  make(map[string]flamingo.StoredBot),
-		Conversations:         make(map[string][]flamingo.StoredConversation),
-		ExistingConversations: make(map[string]bool),
+func newFileStorage(file string) *fileStorage {
+	return &fileStorage{
+		file:  file,
+		bots:  make(map[string]flamingo.StoredBot),
+		convs: make(map[string]map[string]flamingo.StoredConversation),
 	}
 }
 
+// load reads the file of the storage, if it exists. Files of older versions
+// may have the same conversation stored more than once for a bot, in which
+// case only the last stored copy is kept and the file is saved again in the
+// current format.
 func (s *fileStorage) load() error {
 	s.Lock()
 	defer s.Unlock()
 	bytes, err := ioutil.ReadFile(s.file)
 	if os.IsNotExist(err) {
-		s.data = newBotStorage()
 		return nil
 	} else if err != nil {
 		return err
 	}
 
-	var storage botStorage
-	if err := json.Unmarshal(bytes, &storage); err != nil {
+	var data botStorage
+	if err := json.Unmarshal(bytes, &data); err != nil {
 		return err
 	}
 
-	s.data = storage
+	for id, b := range data.Bots {
+		s.bots[id] = b
+	}
+
+	for bot, convs := range data.Conversations {
+		s.convs[bot] = make(map[string]flamingo.StoredConversation)
+		for _, c := range convs {
+			s.convs[bot][c.ID] = c
+		}
+	}
+
+	if data.Version < fileVersion {
+		return s.save()
+	}
 	return nil
 }
 
 func (s *fileStorage) save() error {
-	bytes, err := json.Marshal(s.data)
+	data := botStorage{
+		Version:       fileVersion,
+		Bots:          s.bots,
+		Conversations: make(map[string][]flamingo.StoredConversation),
+	}
+
+	for bot, convs := range s.convs {
+		for _, c := range convs {
+			data.Conversations[bot] = append(data.Conversations[bot], c)
+		}
+		sort.Sort(flamingo.ConversationsByID(data.Conversations[bot]))
+	}
+
+	bytes, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
@@ -76,21 +114,25 @@ func (s *fileStorage) save() error {
 		return err
 	}
 
-	return ioutil.WriteFile(s.file, bytes, 0777)
+	return ioutil.WriteFile(s.file, bytes, 0600)
 }
 
 func (s *fileStorage) StoreBot(bot flamingo.StoredBot) error {
 	s.Lock()
 	defer s.Unlock()
-	s.data.Bots[bot.ID] = bot
+	s.bots[bot.ID] = bot
 	return s.save()
 }
 
 func (s *fileStorage) StoreConversation(conv flamingo.StoredConversation) error {
 	s.Lock()
 	defer s.Unlock()
-	s.data.Conversations[conv.BotID] = append(s.data.Conversations[conv.BotID], conv)
-	s.data.ExistingConversations[conv.ID] = true
+	convs, ok := s.convs[conv.BotID]
+	if !ok {
+		convs = make(map[string]flamingo.StoredConversation)
+		s.convs[conv.BotID] = convs
+	}
+	convs[conv.ID] = conv
 	return s.save()
 }
 
@@ -98,7 +140,7 @@ func (s *fileStorage) LoadBots() ([]flamingo.StoredBot, error) {
 	s.Lock()
 	defer s.Unlock()
 	var bots []flamingo.StoredBot
-	for _, b := range s.data.Bots {
+	for _, b := range s.bots {
 		bots = append(bots, b)
 	}
 	return bots, nil
@@ -107,19 +149,49 @@ func (s *fileStorage) LoadBots() ([]flamingo.StoredBot, error) {
 func (s *fileStorage) LoadConversations(bot flamingo.StoredBot) ([]flamingo.StoredConversation, error) {
 	s.Lock()
 	defer s.Unlock()
-	return s.data.Conversations[bot.ID], nil
+	var convs []flamingo.StoredConversation
+	for _, c := range s.convs[bot.ID] {
+		convs = append(convs, c)
+	}
+	sort.Sort(flamingo.ConversationsByID(convs))
+	return convs, nil
 }
 
 func (s *fileStorage) BotExists(bot flamingo.StoredBot) (bool, error) {
 	s.Lock()
 	defer s.Unlock()
-	_, ok := s.data.Bots[bot.ID]
+	_, ok := s.bots[bot.ID]
 	return ok, nil
 }
 
 func (s *fileStorage) ConversationExists(conv flamingo.StoredConversation) (bool, error) {
 	s.Lock()
 	defer s.Unlock()
-	_, ok := s.data.ExistingConversations[conv.ID]
+	_, ok := s.convs[conv.BotID][conv.ID]
 	return ok, nil
 }
+
+func (s *fileStorage) UpdateConversation(conv flamingo.StoredConversation) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.convs[conv.BotID][conv.ID]; !ok {
+		return flamingo.ErrConversationNotFound
+	}
+	s.convs[conv.BotID][conv.ID] = conv
+	return s.save()
+}
+
+func (s *fileStorage) RemoveBot(bot flamingo.StoredBot) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.bots, bot.ID)
+	delete(s.convs, bot.ID)
+	return s.save()
+}
+
+func (s *fileStorage) RemoveConversation(conv flamingo.StoredConversation) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.convs[conv.BotID], conv.ID)
+	return s.save()
+}
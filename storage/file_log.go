@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/src-d/flamingo"
+)
+
+type logKey struct {
+	bot     string
+	channel string
+}
+
+type fileMessageLog struct {
+	sync.RWMutex
+	file    string
+	policy  flamingo.RetentionPolicy
+	entries map[logKey][]flamingo.LogEntry
+}
+
+// NewFileLog creates a new message log that is saved to a disk file, with
+// the given retention policy. Entries are appended to the file as JSON, one
+// per line, and kept in memory to be read. The entries removed because of
+// the retention policy are only removed from the file when Prune is called,
+// which writes it again with the entries that are kept. When the file is
+// loaded, the policy is enforced on every conversation as of the time of its
+// newest entry, as Log does, and the entries that expired since then are
+// left for Prune.
+func NewFileLog(file string, policy flamingo.RetentionPolicy) (flamingo.MessageLog, error) {
+	l := &fileMessageLog{
+		file:    file,
+		policy:  policy,
+		entries: make(map[logKey][]flamingo.LogEntry),
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *fileMessageLog) load() error {
+	f, err := os.Open(l.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		var entry flamingo.LogEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		key := logKey{entry.Bot, entry.Channel}
+		l.entries[key] = append(l.entries[key], entry)
+	}
+
+	for key, entries := range l.entries {
+		l.entries[key] = l.policy.Retain(entries, entries[len(entries)-1].Time)
+	}
+	return nil
+}
+
+func (l *fileMessageLog) Log(entry flamingo.LogEntry) error {
+	l.Lock()
+	defer l.Unlock()
+	f, err := os.OpenFile(l.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(entry); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	key := logKey{entry.Bot, entry.Channel}
+	l.entries[key] = l.policy.Retain(append(l.entries[key], entry), entry.Time)
+	return nil
+}
+
+func (l *fileMessageLog) History(q flamingo.LogQuery) ([]flamingo.LogEntry, error) {
+	l.RLock()
+	defer l.RUnlock()
+	return q.Select(l.entries[logKey{q.Bot, q.Channel}]), nil
+}
+
+func (l *fileMessageLog) Prune(now time.Time) error {
+	l.Lock()
+	defer l.Unlock()
+	for key, entries := range l.entries {
+		entries = l.policy.Retain(entries, now)
+		if len(entries) == 0 {
+			delete(l.entries, key)
+		} else {
+			l.entries[key] = entries
+		}
+	}
+
+	return l.save()
+}
+
+// save writes all the entries kept in memory to a temporary file, which then
+// replaces the log file, so the entries are not lost if the process dies
+// while saving.
+func (l *fileMessageLog) save() error {
+	tmp := l.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, entries := range l.entries {
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, l.file)
+}
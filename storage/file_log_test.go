@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMessageLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flamingo-log")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	RunMessageLogTest(func(policy flamingo.RetentionPolicy) flamingo.MessageLog {
+		log, err := NewFileLog(filepath.Join(dir, "log.json"), policy)
+		require.Nil(t, err)
+		return log
+	}, t)
+}
+
+func TestFileMessageLogReopen(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-log")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "log.json")
+	policy := flamingo.RetentionPolicy{MaxEntries: 2}
+	log, err := NewFileLog(file, policy)
+	require.Nil(err)
+
+	base := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, text := range []string{"a", "b", "c"} {
+		require.Nil(log.Log(flamingo.LogEntry{
+			Kind:    flamingo.MessageReceived,
+			Bot:     "1",
+			Channel: "a",
+			Time:    base.Add(time.Duration(i) * time.Minute),
+			Text:    text,
+		}))
+	}
+
+	bytes, err := ioutil.ReadFile(file)
+	require.Nil(err)
+	require.Equal(3, strings.Count(string(bytes), "\n"), "removed entries stay in the file until pruned")
+
+	log, err = NewFileLog(file, policy)
+	require.Nil(err)
+	result, err := log.History(flamingo.LogQuery{Bot: "1", Channel: "a"})
+	require.Nil(err)
+	require.Equal([]string{"b", "c"}, logTexts(result), "the policy is enforced on the loaded entries")
+
+	require.Nil(log.Prune(base))
+	bytes, err = ioutil.ReadFile(file)
+	require.Nil(err)
+	require.Equal(2, strings.Count(string(bytes), "\n"))
+
+	log, err = NewFileLog(file, policy)
+	require.Nil(err)
+	result, err = log.History(flamingo.LogQuery{Bot: "1", Channel: "a"})
+	require.Nil(err)
+	require.Equal([]string{"b", "c"}, logTexts(result))
+}
+
+func TestFileMessageLogUnmarshalFail(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "flamingo-log")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "log.json")
+	require.Nil(ioutil.WriteFile(file, []byte("{"), 0600))
+	_, err = NewFileLog(file, flamingo.RetentionPolicy{})
+	require.NotNil(err)
+}
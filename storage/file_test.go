@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -68,8 +69,86 @@ func TestFileStorageSaveMarshalFail(t *testing.T) {
 }
 
 func TestFileStorageSaveRemoveFileFail(t *testing.T) {
-	storage := fileStorage{file: "/etc/passwd", data: newBotStorage()}
+	storage := newFileStorage("/etc/passwd")
 
 	bot := flamingo.StoredBot{ID: "1"}
 	require.NotNil(t, storage.StoreBot(bot))
 }
+
+func TestFileStorageUpdate(t *testing.T) {
+	require := require.New(t)
+	storage, err := NewFile("./bar.json")
+	require.Nil(err)
+	RunStorageUpdateTest(storage, t)
+
+	storage, err = NewFile("./bar.json")
+	require.Nil(err)
+	ok, err := storage.BotExists(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.False(ok)
+
+	require.Nil(os.Remove("./bar.json"))
+}
+
+func TestFileStorageExtra(t *testing.T) {
+	require := require.New(t)
+	storage, err := NewFile("./baz.json")
+	require.Nil(err)
+	defer os.Remove("./baz.json")
+	RunStorageExtraTest(storage, t)
+
+	storage, err = NewFile("./baz.json")
+	require.Nil(err)
+	checkStorageExtra(storage, t)
+}
+
+func TestFileStorageMigration(t *testing.T) {
+	require := require.New(t)
+	f, err := ioutil.TempFile("", "migration")
+	require.Nil(err)
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(`{
+		"Bots": {"1": {"ID": "1"}, "4": {"ID": "4"}},
+		"Conversations": {
+			"1": [
+				{"ID": "2", "BotID": "1"},
+				{"ID": "3", "BotID": "1"},
+				{"ID": "2", "BotID": "1", "Extra": "foo"}
+			]
+		},
+		"ExistingConversations": {"2": true, "3": true}
+	}`)
+	require.Nil(err)
+	require.Nil(f.Close())
+
+	storage, err := NewFile(f.Name())
+	require.Nil(err)
+
+	convs, err := storage.LoadConversations(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.Equal(2, len(convs))
+	require.Equal("2", convs[0].ID)
+	require.Equal("foo", convs[0].Extra)
+	require.Equal("3", convs[1].ID)
+
+	ok, err := storage.ConversationExists(flamingo.StoredConversation{ID: "2", BotID: "4"})
+	require.Nil(err)
+	require.False(ok)
+
+	bytes, err := ioutil.ReadFile(f.Name())
+	require.Nil(err)
+
+	var data botStorage
+	require.Nil(json.Unmarshal(bytes, &data))
+	require.Equal(fileVersion, data.Version)
+	require.Equal(2, len(data.Conversations["1"]))
+}
+
+func TestFileStorageV2(t *testing.T) {
+	require := require.New(t)
+	storage, err := NewFile("./qux.json")
+	require.Nil(err)
+	defer os.Remove("./qux.json")
+	RunStorageV2Test(flamingo.NewStorageV2(storage), t)
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/src-d/flamingo"
@@ -8,17 +9,15 @@ import (
 
 type memoryStorage struct {
 	sync.RWMutex
-	bots          map[string]*flamingo.StoredBot
-	convs         map[string][]*flamingo.StoredConversation
-	existingConvs map[string]struct{}
+	bots  map[string]*flamingo.StoredBot
+	convs map[string]map[string]*flamingo.StoredConversation
 }
 
 // NewMemory creates a new in-memory storage for bots and conversations.
 func NewMemory() flamingo.Storage {
 	return &memoryStorage{
-		bots:          make(map[string]*flamingo.StoredBot),
-		convs:         make(map[string][]*flamingo.StoredConversation),
-		existingConvs: make(map[string]struct{}),
+		bots:  make(map[string]*flamingo.StoredBot),
+		convs: make(map[string]map[string]*flamingo.StoredConversation),
 	}
 }
 
@@ -32,8 +31,12 @@ func (s *memoryStorage) StoreBot(bot flamingo.StoredBot) error {
 func (s *memoryStorage) StoreConversation(conv flamingo.StoredConversation) error {
 	s.Lock()
 	defer s.Unlock()
-	s.convs[conv.BotID] = append(s.convs[conv.BotID], &conv)
-	s.existingConvs[conv.ID] = struct{}{}
+	convs, ok := s.convs[conv.BotID]
+	if !ok {
+		convs = make(map[string]*flamingo.StoredConversation)
+		s.convs[conv.BotID] = convs
+	}
+	convs[conv.ID] = &conv
 	return nil
 }
 
@@ -54,6 +57,7 @@ func (s *memoryStorage) LoadConversations(bot flamingo.StoredBot) ([]flamingo.St
 	for _, c := range s.convs[bot.ID] {
 		convs = append(convs, *c)
 	}
+	sort.Sort(flamingo.ConversationsByID(convs))
 	return convs, nil
 }
 
@@ -67,6 +71,31 @@ func (s *memoryStorage) BotExists(bot flamingo.StoredBot) (bool, error) {
 func (s *memoryStorage) ConversationExists(conv flamingo.StoredConversation) (bool, error) {
 	s.Lock()
 	defer s.Unlock()
-	_, ok := s.existingConvs[conv.ID]
+	_, ok := s.convs[conv.BotID][conv.ID]
 	return ok, nil
 }
+
+func (s *memoryStorage) UpdateConversation(conv flamingo.StoredConversation) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.convs[conv.BotID][conv.ID]; !ok {
+		return flamingo.ErrConversationNotFound
+	}
+	s.convs[conv.BotID][conv.ID] = &conv
+	return nil
+}
+
+func (s *memoryStorage) RemoveBot(bot flamingo.StoredBot) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.bots, bot.ID)
+	delete(s.convs, bot.ID)
+	return nil
+}
+
+func (s *memoryStorage) RemoveConversation(conv flamingo.StoredConversation) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.convs[conv.BotID], conv.ID)
+	return nil
+}
@@ -1,7 +1,27 @@
 package storage
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/src-d/flamingo"
+)
 
 func TestMemoryStorage(t *testing.T) {
 	RunStorageTest(NewMemory(), t)
 }
+
+func TestMemoryStorageUpdate(t *testing.T) {
+	RunStorageUpdateTest(NewMemory(), t)
+}
+
+func TestMemoryStorageExtra(t *testing.T) {
+	RunStorageExtraTest(NewMemory(), t)
+}
+
+func TestMemoryStorageV2(t *testing.T) {
+	RunStorageV2Test(flamingo.NewStorageV2(NewMemory()), t)
+}
+
+func TestMemoryMessageLog(t *testing.T) {
+	RunMessageLogTest(flamingo.NewMessageLog, t)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/src-d/flamingo"
+)
+
+// redisUpdateScript sets the field of the hash only if it already exists.
+var redisUpdateScript = redis.NewScript(1, `
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+type redisStorage struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedis creates a new storage that saves bots and conversations in Redis,
+// so they can be shared by several instances of the clients. All the keys
+// used by the storage start with the given prefix, such as "staging:", which
+// allows several environments to share the same Redis. Bots are saved in a
+// hash and the conversations of each bot in a hash of their own. The pool
+// must be closed by the caller once the storage is no longer used.
+func NewRedis(pool *redis.Pool, prefix string) flamingo.Storage {
+	return &redisStorage{pool: pool, prefix: prefix}
+}
+
+func (s *redisStorage) botsKey() string {
+	return s.prefix + "bots"
+}
+
+func (s *redisStorage) conversationsKey(bot string) string {
+	return s.prefix + "conversations:" + bot
+}
+
+func (s *redisStorage) StoreBot(bot flamingo.StoredBot) error {
+	bytes, err := json.Marshal(bot)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("HSET", s.botsKey(), bot.ID, bytes)
+	return err
+}
+
+func (s *redisStorage) StoreConversation(conv flamingo.StoredConversation) error {
+	bytes, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("HSET", s.conversationsKey(conv.BotID), conv.ID, bytes)
+	return err
+}
+
+func (s *redisStorage) LoadBots() ([]flamingo.StoredBot, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	values, err := redis.ByteSlices(conn.Do("HVALS", s.botsKey()))
+	if err != nil {
+		return nil, err
+	}
+
+	var bots []flamingo.StoredBot
+	for _, v := range values {
+		var bot flamingo.StoredBot
+		if err := json.Unmarshal(v, &bot); err != nil {
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+	return bots, nil
+}
+
+func (s *redisStorage) LoadConversations(bot flamingo.StoredBot) ([]flamingo.StoredConversation, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	values, err := redis.ByteSlices(conn.Do("HVALS", s.conversationsKey(bot.ID)))
+	if err != nil {
+		return nil, err
+	}
+
+	var convs []flamingo.StoredConversation
+	for _, v := range values {
+		var conv flamingo.StoredConversation
+		if err := json.Unmarshal(v, &conv); err != nil {
+			return nil, err
+		}
+		convs = append(convs, conv)
+	}
+	return convs, nil
+}
+
+func (s *redisStorage) BotExists(bot flamingo.StoredBot) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	return redis.Bool(conn.Do("HEXISTS", s.botsKey(), bot.ID))
+}
+
+func (s *redisStorage) ConversationExists(conv flamingo.StoredConversation) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	return redis.Bool(conn.Do("HEXISTS", s.conversationsKey(conv.BotID), conv.ID))
+}
+
+func (s *redisStorage) UpdateConversation(conv flamingo.StoredConversation) error {
+	bytes, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	ok, err := redis.Bool(redisUpdateScript.Do(conn, s.conversationsKey(conv.BotID), conv.ID, bytes))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return flamingo.ErrConversationNotFound
+	}
+	return nil
+}
+
+func (s *redisStorage) RemoveBot(bot flamingo.StoredBot) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("HDEL", s.botsKey(), bot.ID)
+	conn.Send("DEL", s.conversationsKey(bot.ID))
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func (s *redisStorage) RemoveConversation(conv flamingo.StoredConversation) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("HDEL", s.conversationsKey(conv.BotID), conv.ID)
+	return err
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/gomodule/redigo/redis"
+	"github.com/src-d/flamingo"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisPool(t *testing.T) (*miniredis.Miniredis, *redis.Pool) {
+	srv, err := miniredis.Run()
+	require.Nil(t, err)
+
+	addr := srv.Addr()
+	return srv, &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	}
+}
+
+func TestRedisStorage(t *testing.T) {
+	srv, pool := newRedisPool(t)
+	defer srv.Close()
+	defer pool.Close()
+
+	RunStorageTest(NewRedis(pool, ""), t)
+}
+
+func TestRedisStoragePrefix(t *testing.T) {
+	require := require.New(t)
+	srv, pool := newRedisPool(t)
+	defer srv.Close()
+	defer pool.Close()
+
+	staging, prod := NewRedis(pool, "staging:"), NewRedis(pool, "prod:")
+	require.Nil(staging.StoreBot(flamingo.StoredBot{ID: "1", Extra: map[string]interface{}{"foo": "bar"}}))
+	require.Nil(staging.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1"}))
+	require.NotNil(staging.StoreBot(flamingo.StoredBot{ID: "3", Extra: func() {}}))
+
+	require.True(srv.Exists("staging:bots"))
+	require.True(srv.Exists("staging:conversations:1"))
+
+	ok, err := prod.BotExists(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.False(ok)
+
+	ok, err = prod.ConversationExists(flamingo.StoredConversation{ID: "2", BotID: "1"})
+	require.Nil(err)
+	require.False(ok)
+
+	bots, err := staging.LoadBots()
+	require.Nil(err)
+	require.Equal(1, len(bots))
+	require.Equal(map[string]interface{}{"foo": "bar"}, bots[0].Extra)
+
+	srv.HSet("staging:bots", "4", "garbage")
+	_, err = staging.LoadBots()
+	require.NotNil(err)
+
+	srv.Close()
+	_, err = staging.BotExists(flamingo.StoredBot{ID: "1"})
+	require.NotNil(err)
+}
+
+func TestRedisStorageUpdate(t *testing.T) {
+	srv, pool := newRedisPool(t)
+	defer srv.Close()
+	defer pool.Close()
+
+	RunStorageUpdateTest(NewRedis(pool, "test:"), t)
+	require.False(t, srv.Exists("test:conversations:1"))
+}
+
+func TestRedisStorageExtra(t *testing.T) {
+	srv, pool := newRedisPool(t)
+	defer srv.Close()
+	defer pool.Close()
+
+	RunStorageExtraTest(NewRedis(pool, ""), t)
+}
+
+func TestRedisStorageV2(t *testing.T) {
+	srv, pool := newRedisPool(t)
+	defer srv.Close()
+	defer pool.Close()
+
+	RunStorageV2Test(flamingo.NewStorageV2(NewRedis(pool, "")), t)
+}
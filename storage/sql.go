@@ -0,0 +1,512 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"golang.org/x/net/context"
+)
+
+// SQLDialect is the dialect of SQL of the database used by a SQL storage.
+type SQLDialect byte
+
+const (
+	// SQLite is the dialect of SQLite databases.
+	SQLite SQLDialect = iota
+	// PostgreSQL is the dialect of PostgreSQL databases.
+	PostgreSQL
+)
+
+// sqlMigrations are the changes to the schema of the SQL storage, in order.
+// Migrations that were already applied are never run again, so new changes
+// must always be added at the end. Each migration may have several
+// statements. The first one creates the whole schema of the first release
+// of the storage.
+var sqlMigrations = []string{
+	// The message log orders the entries by seq, which is assigned by the
+	// database, and logged_at is the time of the entries in nanoseconds since
+	// the epoch.
+	`CREATE TABLE flamingo_bots (
+		id VARCHAR(255) NOT NULL PRIMARY KEY,
+		token TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		extra TEXT,
+		extra_type VARCHAR(255)
+	);
+	CREATE TABLE flamingo_conversations (
+		bot_id VARCHAR(255) NOT NULL,
+		id VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		extra TEXT,
+		extra_type VARCHAR(255),
+		PRIMARY KEY (bot_id, id)
+	);
+	CREATE TABLE flamingo_messages (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		bot_id VARCHAR(255) NOT NULL,
+		channel_id VARCHAR(255) NOT NULL,
+		kind INTEGER NOT NULL,
+		message_id VARCHAR(255) NOT NULL,
+		logged_at BIGINT NOT NULL,
+		user_data TEXT NOT NULL,
+		message_text TEXT NOT NULL,
+		action_data TEXT
+	);
+	CREATE INDEX flamingo_messages_conversation ON flamingo_messages (bot_id, channel_id, seq);
+	CREATE INDEX flamingo_messages_logged_at ON flamingo_messages (logged_at)`,
+}
+
+type sqlStorage struct {
+	db      *sql.DB
+	dialect SQLDialect
+}
+
+// NewSQL creates a new storage that saves bots and conversations in the
+// given SQL database, which speaks the given dialect. The tables of the
+// storage are created, or migrated to the latest version of the schema, if
+// needed. Any driver of database/sql can be used, such as
+// github.com/mattn/go-sqlite3 for SQLite or github.com/lib/pq for
+// PostgreSQL. The client-specific data of bots and conversations is saved
+// encoded as JSON, along with the name of its type if it was registered with
+// flamingo.RegisterExtra.
+func NewSQL(db *sql.DB, dialect SQLDialect) (flamingo.Storage, error) {
+	s := &sqlStorage{db: db, dialect: dialect}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// migrate runs all the migrations that were not applied to the database yet,
+// each one in its own transaction along with the update of the version of
+// the schema. The version is only updated if it did not change since it was
+// read, so when several processes migrate the same database at the same
+// time every migration is run only once.
+func (s *sqlStorage) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS flamingo_schema (
+		id INTEGER NOT NULL PRIMARY KEY,
+		version INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("unable to create schema table: %s", err)
+	}
+
+	_, err = s.db.Exec(`INSERT INTO flamingo_schema (id, version) VALUES (1, 0) ON CONFLICT (id) DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("unable to initialize schema version: %s", err)
+	}
+
+	for {
+		var version int
+		err := s.db.QueryRow(`SELECT version FROM flamingo_schema WHERE id = 1`).Scan(&version)
+		if err != nil {
+			return fmt.Errorf("unable to get schema version: %s", err)
+		}
+
+		if version >= len(sqlMigrations) {
+			return nil
+		}
+
+		err = s.transaction(func(tx *sql.Tx) error {
+			result, err := tx.Exec(
+				s.query(`UPDATE flamingo_schema SET version = ? WHERE id = 1 AND version = ?`),
+				version+1, version,
+			)
+			if err != nil {
+				return err
+			}
+
+			// another process applied the migration in the meantime, so the
+			// version is read again
+			n, err := result.RowsAffected()
+			if err != nil || n == 0 {
+				return err
+			}
+
+			_, err = tx.Exec(s.schema(sqlMigrations[version]))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("unable to run migration %d: %s", version+1, err)
+		}
+	}
+}
+
+// query rewrites the placeholders of the query for the dialect of the
+// database.
+func (s *sqlStorage) query(q string) string {
+	if s.dialect != PostgreSQL {
+		return q
+	}
+
+	parts := strings.Split(q, "?")
+	var buf = []string{parts[0]}
+	for i, p := range parts[1:] {
+		buf = append(buf, fmt.Sprintf("$%d", i+1), p)
+	}
+	return strings.Join(buf, "")
+}
+
+// schema rewrites the column types of the migration that are not the same in
+// all the dialects, which are written in the dialect of SQLite.
+func (s *sqlStorage) schema(q string) string {
+	if s.dialect != PostgreSQL {
+		return q
+	}
+
+	return strings.Replace(q, "INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY", -1)
+}
+
+// transaction runs fn in a transaction, which is committed if fn returns no
+// error and rolled back otherwise.
+func (s *sqlStorage) transaction(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sqlExtra is the client-specific data of a bot or conversation as it is
+// saved in the database.
+type sqlExtra struct {
+	data sql.NullString
+	typ  sql.NullString
+}
+
+func encodeExtra(extra interface{}) (sqlExtra, error) {
+	if extra == nil {
+		return sqlExtra{}, nil
+	}
+
+	bytes, err := json.Marshal(extra)
+	if err != nil {
+		return sqlExtra{}, err
+	}
+
+	name := flamingo.ExtraName(extra)
+	return sqlExtra{
+		data: sql.NullString{String: string(bytes), Valid: true},
+		typ:  sql.NullString{String: name, Valid: name != ""},
+	}, nil
+}
+
+func decodeExtra(extra sqlExtra) (interface{}, error) {
+	if !extra.data.Valid {
+		return nil, nil
+	}
+
+	return flamingo.DecodeExtra(extra.typ.String, []byte(extra.data.String))
+}
+
+func (s *sqlStorage) StoreBot(bot flamingo.StoredBot) error {
+	extra, err := encodeExtra(bot.Extra)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		s.query(`INSERT INTO flamingo_bots (id, token, created_at, extra, extra_type) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET token = excluded.token, created_at = excluded.created_at,
+			extra = excluded.extra, extra_type = excluded.extra_type`),
+		bot.ID, bot.Token, bot.CreatedAt.UTC(), extra.data, extra.typ,
+	)
+	return err
+}
+
+func (s *sqlStorage) StoreConversation(conv flamingo.StoredConversation) error {
+	extra, err := encodeExtra(conv.Extra)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		s.query(`INSERT INTO flamingo_conversations (bot_id, id, created_at, extra, extra_type) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (bot_id, id) DO UPDATE SET created_at = excluded.created_at,
+			extra = excluded.extra, extra_type = excluded.extra_type`),
+		conv.BotID, conv.ID, conv.CreatedAt.UTC(), extra.data, extra.typ,
+	)
+	return err
+}
+
+func (s *sqlStorage) LoadBots() ([]flamingo.StoredBot, error) {
+	return s.queryBots(`SELECT id, token, created_at, extra, extra_type FROM flamingo_bots ORDER BY id`)
+}
+
+func (s *sqlStorage) LoadConversations(bot flamingo.StoredBot) ([]flamingo.StoredConversation, error) {
+	return s.queryConversations(
+		`SELECT bot_id, id, created_at, extra, extra_type FROM flamingo_conversations WHERE bot_id = ? ORDER BY id`,
+		bot.ID,
+	)
+}
+
+func (s *sqlStorage) queryBots(query string, args ...interface{}) ([]flamingo.StoredBot, error) {
+	rows, err := s.db.Query(s.query(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bots []flamingo.StoredBot
+	for rows.Next() {
+		var (
+			bot   flamingo.StoredBot
+			extra sqlExtra
+		)
+		if err := rows.Scan(&bot.ID, &bot.Token, &bot.CreatedAt, &extra.data, &extra.typ); err != nil {
+			return nil, err
+		}
+
+		if bot.Extra, err = decodeExtra(extra); err != nil {
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+
+	return bots, rows.Err()
+}
+
+func (s *sqlStorage) queryConversations(query string, args ...interface{}) ([]flamingo.StoredConversation, error) {
+	rows, err := s.db.Query(s.query(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var convs []flamingo.StoredConversation
+	for rows.Next() {
+		var (
+			conv  flamingo.StoredConversation
+			extra sqlExtra
+		)
+		if err := rows.Scan(&conv.BotID, &conv.ID, &conv.CreatedAt, &extra.data, &extra.typ); err != nil {
+			return nil, err
+		}
+
+		if conv.Extra, err = decodeExtra(extra); err != nil {
+			return nil, err
+		}
+		convs = append(convs, conv)
+	}
+
+	return convs, rows.Err()
+}
+
+// QueryBots queries the bots in batches, filtering them by their IDs and
+// creation time in the database and by their Extra once they are loaded,
+// until the page is full.
+func (s *sqlStorage) QueryBots(ctx context.Context, q flamingo.BotQuery) (flamingo.BotPage, error) {
+	var after string
+	if q.After != "" {
+		keys, err := q.After.Keys(1)
+		if err != nil {
+			return flamingo.BotPage{}, err
+		}
+		after = keys[0]
+	}
+
+	var page flamingo.BotPage
+	batch := q.PageSize() + 1
+	for {
+		if err := ctx.Err(); err != nil {
+			return flamingo.BotPage{}, err
+		}
+
+		where, args := []string{"id > ?"}, []interface{}{after}
+		if len(q.IDs) > 0 {
+			where = append(where, "id IN ("+placeholders(len(q.IDs))+")")
+			args = append(args, stringArgs(q.IDs)...)
+		}
+		where, args = createdWhere(where, args, q.CreatedAfter, q.CreatedBefore)
+
+		bots, err := s.queryBots(
+			`SELECT id, token, created_at, extra, extra_type FROM flamingo_bots
+			WHERE `+strings.Join(where, " AND ")+` ORDER BY id LIMIT ?`,
+			append(args, batch)...,
+		)
+		if err != nil {
+			return flamingo.BotPage{}, err
+		}
+
+		for _, b := range bots {
+			after = b.ID
+			if q.Extra != nil && !q.Extra(b.Extra) {
+				continue
+			}
+
+			if len(page.Bots) == q.PageSize() {
+				page.Next = flamingo.NewCursor(page.Bots[len(page.Bots)-1].ID)
+				return page, nil
+			}
+			page.Bots = append(page.Bots, b)
+		}
+
+		if len(bots) < batch {
+			return page, nil
+		}
+	}
+}
+
+// QueryConversations queries the conversations in batches, filtering them by
+// their bots, IDs and creation time in the database and by their Extra once
+// they are loaded, until the page is full.
+func (s *sqlStorage) QueryConversations(ctx context.Context, q flamingo.ConversationQuery) (flamingo.ConversationPage, error) {
+	var afterBot, afterID string
+	if q.After != "" {
+		keys, err := q.After.Keys(2)
+		if err != nil {
+			return flamingo.ConversationPage{}, err
+		}
+		afterBot, afterID = keys[0], keys[1]
+	}
+
+	var page flamingo.ConversationPage
+	batch := q.PageSize() + 1
+	for {
+		if err := ctx.Err(); err != nil {
+			return flamingo.ConversationPage{}, err
+		}
+
+		where := []string{"(bot_id > ? OR (bot_id = ? AND id > ?))"}
+		args := []interface{}{afterBot, afterBot, afterID}
+		if len(q.BotIDs) > 0 {
+			where = append(where, "bot_id IN ("+placeholders(len(q.BotIDs))+")")
+			args = append(args, stringArgs(q.BotIDs)...)
+		}
+
+		if len(q.IDs) > 0 {
+			where = append(where, "id IN ("+placeholders(len(q.IDs))+")")
+			args = append(args, stringArgs(q.IDs)...)
+		}
+		where, args = createdWhere(where, args, q.CreatedAfter, q.CreatedBefore)
+
+		convs, err := s.queryConversations(
+			`SELECT bot_id, id, created_at, extra, extra_type FROM flamingo_conversations
+			WHERE `+strings.Join(where, " AND ")+` ORDER BY bot_id, id LIMIT ?`,
+			append(args, batch)...,
+		)
+		if err != nil {
+			return flamingo.ConversationPage{}, err
+		}
+
+		for _, c := range convs {
+			afterBot, afterID = c.BotID, c.ID
+			if q.Extra != nil && !q.Extra(c.Extra) {
+				continue
+			}
+
+			if len(page.Conversations) == q.PageSize() {
+				last := page.Conversations[len(page.Conversations)-1]
+				page.Next = flamingo.NewCursor(last.BotID, last.ID)
+				return page, nil
+			}
+			page.Conversations = append(page.Conversations, c)
+		}
+
+		if len(convs) < batch {
+			return page, nil
+		}
+	}
+}
+
+// createdWhere adds the conditions on the creation time to the WHERE clause
+// of a query. Times are saved in UTC, so they are compared in UTC too.
+func createdWhere(where []string, args []interface{}, after, before time.Time) ([]string, []interface{}) {
+	if !after.IsZero() {
+		where = append(where, "created_at > ?")
+		args = append(args, after.UTC())
+	}
+
+	if !before.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, before.UTC())
+	}
+	return where, args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func (s *sqlStorage) BotExists(bot flamingo.StoredBot) (bool, error) {
+	return s.exists(`SELECT 1 FROM flamingo_bots WHERE id = ?`, bot.ID)
+}
+
+func (s *sqlStorage) ConversationExists(conv flamingo.StoredConversation) (bool, error) {
+	return s.exists(
+		`SELECT 1 FROM flamingo_conversations WHERE bot_id = ? AND id = ?`,
+		conv.BotID, conv.ID,
+	)
+}
+
+func (s *sqlStorage) UpdateConversation(conv flamingo.StoredConversation) error {
+	extra, err := encodeExtra(conv.Extra)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(
+		s.query(`UPDATE flamingo_conversations SET created_at = ?, extra = ?, extra_type = ? WHERE bot_id = ? AND id = ?`),
+		conv.CreatedAt.UTC(), extra.data, extra.typ, conv.BotID, conv.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return flamingo.ErrConversationNotFound
+	}
+	return nil
+}
+
+func (s *sqlStorage) RemoveBot(bot flamingo.StoredBot) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(s.query(`DELETE FROM flamingo_conversations WHERE bot_id = ?`), bot.ID); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(s.query(`DELETE FROM flamingo_bots WHERE id = ?`), bot.ID)
+		return err
+	})
+}
+
+func (s *sqlStorage) RemoveConversation(conv flamingo.StoredConversation) error {
+	_, err := s.db.Exec(
+		s.query(`DELETE FROM flamingo_conversations WHERE bot_id = ? AND id = ?`),
+		conv.BotID, conv.ID,
+	)
+	return err
+}
+
+func (s *sqlStorage) exists(query string, args ...interface{}) (bool, error) {
+	var found int
+	err := s.db.QueryRow(s.query(query), args...).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/src-d/flamingo"
+)
+
+type sqlMessageLog struct {
+	s      *sqlStorage
+	policy flamingo.RetentionPolicy
+}
+
+// NewSQLLog creates a new message log that saves the entries in the given
+// SQL database, which speaks the given dialect, with the given retention
+// policy. It uses the same schema as NewSQL, so the same database can hold
+// both the storage and the message log. The entries are ordered by a
+// sequence assigned by the database, so several processes can log entries
+// in the same database at the same time.
+func NewSQLLog(db *sql.DB, dialect SQLDialect, policy flamingo.RetentionPolicy) (flamingo.MessageLog, error) {
+	s := &sqlStorage{db: db, dialect: dialect}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+
+	return &sqlMessageLog{s: s, policy: policy}, nil
+}
+
+func (l *sqlMessageLog) Log(entry flamingo.LogEntry) error {
+	user, err := json.Marshal(entry.User)
+	if err != nil {
+		return err
+	}
+
+	var action sql.NullString
+	if entry.Action != nil {
+		bytes, err := json.Marshal(entry.Action)
+		if err != nil {
+			return err
+		}
+		action = sql.NullString{String: string(bytes), Valid: true}
+	}
+
+	return l.s.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			l.s.query(`INSERT INTO flamingo_messages (bot_id, channel_id, kind, message_id, logged_at, user_data, message_text, action_data) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+			entry.Bot, entry.Channel, int(entry.Kind), entry.ID, entry.Time.UnixNano(), string(user), entry.Text, action,
+		)
+		if err != nil {
+			return err
+		}
+
+		if l.policy.MaxAge > 0 {
+			_, err := tx.Exec(
+				l.s.query(`DELETE FROM flamingo_messages WHERE bot_id = ? AND channel_id = ? AND logged_at < ?`),
+				entry.Bot, entry.Channel, entry.Time.Add(-l.policy.MaxAge).UnixNano(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		// the subquery selects the newest entry that is not kept, if any,
+		// which is removed along with all the older ones
+		if l.policy.MaxEntries > 0 {
+			_, err := tx.Exec(
+				l.s.query(`DELETE FROM flamingo_messages WHERE bot_id = ? AND channel_id = ? AND seq <= (
+					SELECT seq FROM flamingo_messages WHERE bot_id = ? AND channel_id = ?
+					ORDER BY seq DESC LIMIT 1 OFFSET ?
+				)`),
+				entry.Bot, entry.Channel, entry.Bot, entry.Channel, l.policy.MaxEntries,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (l *sqlMessageLog) History(q flamingo.LogQuery) ([]flamingo.LogEntry, error) {
+	var (
+		conds = []string{"bot_id = ?", "channel_id = ?"}
+		args  = []interface{}{q.Bot, q.Channel}
+	)
+
+	if !q.Since.IsZero() {
+		conds = append(conds, "logged_at >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+
+	if !q.Until.IsZero() {
+		conds = append(conds, "logged_at < ?")
+		args = append(args, q.Until.UnixNano())
+	}
+
+	// with a limit, the newest entries are selected and reversed afterwards
+	query := `SELECT kind, message_id, logged_at, user_data, message_text, action_data FROM flamingo_messages WHERE ` +
+		strings.Join(conds, " AND ")
+	if q.Limit > 0 {
+		query += ` ORDER BY seq DESC LIMIT ?`
+		args = append(args, q.Limit)
+	} else {
+		query += ` ORDER BY seq ASC`
+	}
+
+	rows, err := l.s.db.Query(l.s.query(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []flamingo.LogEntry
+	for rows.Next() {
+		var (
+			kind     int
+			loggedAt int64
+			user     string
+			action   sql.NullString
+		)
+		entry := flamingo.LogEntry{Bot: q.Bot, Channel: q.Channel}
+		if err := rows.Scan(&kind, &entry.ID, &loggedAt, &user, &entry.Text, &action); err != nil {
+			return nil, err
+		}
+
+		entry.Kind = flamingo.LogEntryKind(kind)
+		entry.Time = time.Unix(0, loggedAt)
+		if err := json.Unmarshal([]byte(user), &entry.User); err != nil {
+			return nil, err
+		}
+
+		if action.Valid {
+			entry.Action = new(flamingo.UserAction)
+			if err := json.Unmarshal([]byte(action.String), entry.Action); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if q.Limit > 0 {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	return entries, nil
+}
+
+func (l *sqlMessageLog) Prune(now time.Time) error {
+	return l.s.transaction(func(tx *sql.Tx) error {
+		if l.policy.MaxAge > 0 {
+			_, err := tx.Exec(
+				l.s.query(`DELETE FROM flamingo_messages WHERE logged_at < ?`),
+				now.Add(-l.policy.MaxAge).UnixNano(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if l.policy.MaxEntries > 0 {
+			_, err := tx.Exec(
+				l.s.query(`DELETE FROM flamingo_messages WHERE seq <= (
+					SELECT m.seq FROM flamingo_messages m
+					WHERE m.bot_id = flamingo_messages.bot_id AND m.channel_id = flamingo_messages.channel_id
+					ORDER BY m.seq DESC LIMIT 1 OFFSET ?
+				)`),
+				l.policy.MaxEntries,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
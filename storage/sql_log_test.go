@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/src-d/flamingo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLMessageLog(t *testing.T) {
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	RunMessageLogTest(func(policy flamingo.RetentionPolicy) flamingo.MessageLog {
+		log, err := NewSQLLog(db, SQLite, policy)
+		require.Nil(t, err)
+		return log
+	}, t)
+}
+
+func TestSQLMessageLogWithStorage(t *testing.T) {
+	require := require.New(t)
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	_, err := NewSQL(db, SQLite)
+	require.Nil(err)
+
+	log, err := NewSQLLog(db, SQLite, flamingo.RetentionPolicy{})
+	require.Nil(err)
+	require.Nil(log.Log(flamingo.LogEntry{Kind: flamingo.MessageSent, Bot: "1", Channel: "a", Time: time.Now(), Text: "hi"}))
+
+	_, err = NewSQL(db, SQLite)
+	require.Nil(err, "the storage can be opened after the message log")
+
+	result, err := log.History(flamingo.LogQuery{Bot: "1", Channel: "a"})
+	require.Nil(err)
+	require.Equal([]string{"hi"}, logTexts(result))
+}
+
+func TestSQLMessageLogShared(t *testing.T) {
+	require := require.New(t)
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	policy := flamingo.RetentionPolicy{MaxEntries: 3}
+	first, err := NewSQLLog(db, SQLite, policy)
+	require.Nil(err)
+	second, err := NewSQLLog(db, SQLite, policy)
+	require.Nil(err)
+
+	base := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, text := range []string{"a", "b", "c", "d", "e"} {
+		log := first
+		if i%2 == 1 {
+			log = second
+		}
+
+		require.Nil(log.Log(flamingo.LogEntry{
+			Kind:    flamingo.MessageReceived,
+			Bot:     "1",
+			Channel: "a",
+			Time:    base.Add(time.Duration(i) * time.Minute),
+			Text:    text,
+		}))
+	}
+
+	result, err := first.History(flamingo.LogQuery{Bot: "1", Channel: "a"})
+	require.Nil(err)
+	require.Equal([]string{"c", "d", "e"}, logTexts(result))
+
+	result, err = second.History(flamingo.LogQuery{Bot: "1", Channel: "a", Limit: 2})
+	require.Nil(err)
+	require.Equal([]string{"d", "e"}, logTexts(result))
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/src-d/flamingo"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func newSQLite(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "flamingo-sql")
+	require.Nil(t, err)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "flamingo.db"))
+	require.Nil(t, err)
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSQLStorage(t *testing.T) {
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	storage, err := NewSQL(db, SQLite)
+	require.Nil(t, err)
+	RunStorageTest(storage, t)
+}
+
+func TestSQLStorageReopen(t *testing.T) {
+	require := require.New(t)
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	storage, err := NewSQL(db, SQLite)
+	require.Nil(err)
+
+	now := time.Date(2017, time.March, 1, 10, 0, 0, 0, time.UTC)
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "1", Token: "foo", CreatedAt: now}))
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "1", Token: "bar", CreatedAt: now, Extra: map[string]interface{}{"a": "b"}}))
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1", CreatedAt: now}))
+	require.Nil(storage.StoreConversation(flamingo.StoredConversation{ID: "2", BotID: "1", CreatedAt: now, Extra: []interface{}{"c"}}))
+
+	// migrations are not run again
+	storage, err = NewSQL(db, SQLite)
+	require.Nil(err)
+
+	bots, err := storage.LoadBots()
+	require.Nil(err)
+	require.Equal(1, len(bots))
+	require.Equal("bar", bots[0].Token)
+	require.True(now.Equal(bots[0].CreatedAt))
+	require.Equal(map[string]interface{}{"a": "b"}, bots[0].Extra)
+
+	convs, err := storage.LoadConversations(bots[0])
+	require.Nil(err)
+	require.Equal(1, len(convs))
+	require.Equal("1", convs[0].BotID)
+	require.Equal([]interface{}{"c"}, convs[0].Extra)
+
+	var version, count int
+	require.Nil(db.QueryRow(`SELECT version FROM flamingo_schema`).Scan(&version))
+	require.Equal(len(sqlMigrations), version)
+	require.Nil(db.QueryRow(`SELECT COUNT(*) FROM flamingo_schema`).Scan(&count))
+	require.Equal(1, count)
+}
+
+func TestSQLStorageMarshalFail(t *testing.T) {
+	require := require.New(t)
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	storage, err := NewSQL(db, SQLite)
+	require.Nil(err)
+	require.NotNil(storage.StoreBot(flamingo.StoredBot{ID: "1", Extra: func() {}}))
+	require.NotNil(storage.StoreConversation(flamingo.StoredConversation{ID: "1", Extra: func() {}}))
+
+	ok, err := storage.BotExists(flamingo.StoredBot{ID: "1"})
+	require.Nil(err)
+	require.False(ok)
+}
+
+func TestSQLStorageMigrationFail(t *testing.T) {
+	require := require.New(t)
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	_, err := db.Exec(`CREATE TABLE flamingo_conversations (id TEXT)`)
+	require.Nil(err)
+
+	_, err = NewSQL(db, SQLite)
+	require.NotNil(err)
+
+	// the failed migration is rolled back entirely
+	var version int
+	require.Nil(db.QueryRow(`SELECT version FROM flamingo_schema`).Scan(&version))
+	require.Equal(0, version)
+
+	var tables int
+	require.Nil(db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'flamingo_bots'`).Scan(&tables))
+	require.Equal(0, tables)
+}
+
+func TestSQLQuery(t *testing.T) {
+	s := &sqlStorage{dialect: PostgreSQL}
+	require.Equal(t,
+		"UPDATE t SET a = $1 WHERE b = $2",
+		s.query("UPDATE t SET a = ? WHERE b = ?"),
+	)
+
+	s.dialect = SQLite
+	require.Equal(t, "SELECT ?", s.query("SELECT ?"))
+}
+
+func TestSQLSchema(t *testing.T) {
+	s := &sqlStorage{dialect: PostgreSQL}
+	require.Equal(t,
+		"CREATE TABLE t (id BIGSERIAL PRIMARY KEY, a INTEGER)",
+		s.schema("CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT, a INTEGER)"),
+	)
+
+	s.dialect = SQLite
+	require.Equal(t,
+		"CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT)",
+		s.schema("CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT)"),
+	)
+}
+
+func TestSQLStorageUpdate(t *testing.T) {
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	storage, err := NewSQL(db, SQLite)
+	require.Nil(t, err)
+	RunStorageUpdateTest(storage, t)
+}
+
+func TestSQLStorageExtra(t *testing.T) {
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	storage, err := NewSQL(db, SQLite)
+	require.Nil(t, err)
+	RunStorageExtraTest(storage, t)
+}
+
+func TestSQLStorageV2(t *testing.T) {
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	storage, err := NewSQL(db, SQLite)
+	require.Nil(t, err)
+	require.Implements(t, (*flamingo.Querier)(nil), storage)
+	RunStorageV2Test(flamingo.NewStorageV2(storage), t)
+}
+
+func TestSQLStorageQueryCreatedInZones(t *testing.T) {
+	require := require.New(t)
+	db, cleanup := newSQLite(t)
+	defer cleanup()
+
+	storage, err := NewSQL(db, SQLite)
+	require.Nil(err)
+
+	// the same instant is saved and queried in different time zones
+	zone := time.FixedZone("UTC+5", 5*60*60)
+	created := time.Date(2017, time.March, 1, 10, 0, 0, 0, time.UTC)
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "a", CreatedAt: created.In(zone)}))
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "b", CreatedAt: created.Add(500 * time.Millisecond)}))
+	require.Nil(storage.StoreBot(flamingo.StoredBot{ID: "c", CreatedAt: created.Add(time.Hour).In(zone)}))
+
+	page, err := storage.(flamingo.Querier).QueryBots(context.Background(), flamingo.BotQuery{
+		CreatedAfter:  created.Add(-time.Millisecond).In(zone),
+		CreatedBefore: created.Add(time.Second),
+	})
+	require.Nil(err)
+	require.Equal(2, len(page.Bots))
+	require.Equal("a", page.Bots[0].ID)
+	require.True(created.Equal(page.Bots[0].CreatedAt))
+	require.Equal("b", page.Bots[1].ID)
+
+	page, err = storage.(flamingo.Querier).QueryBots(context.Background(), flamingo.BotQuery{
+		CreatedAfter: created,
+	})
+	require.Nil(err)
+	require.Equal(2, len(page.Bots))
+	require.Equal("b", page.Bots[0].ID)
+	require.Equal("c", page.Bots[1].ID)
+}
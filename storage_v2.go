@@ -0,0 +1,426 @@
+package flamingo
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultPageSize is the number of results in a page of a query if the query
+// has no limit.
+const DefaultPageSize = 100
+
+// ErrInvalidCursor is returned by the queries of the storages when their
+// cursor was not returned by a previous page.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is an opaque position in the results of a query, after which the
+// next page starts. The empty cursor is the start of the results.
+type Cursor string
+
+// NewCursor creates a cursor at the result with the given keys, such as the
+// ID of a bot or the IDs of a bot and a conversation. It is meant to be used
+// by the implementations of Querier.
+func NewCursor(keys ...string) Cursor {
+	return Cursor(base64.URLEncoding.EncodeToString([]byte(strings.Join(keys, "\x00"))))
+}
+
+// Keys returns the keys of the result at the cursor, which must have n keys.
+func (c Cursor) Keys(n int) ([]string, error) {
+	data, err := base64.URLEncoding.DecodeString(string(c))
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	keys := strings.Split(string(data), "\x00")
+	if len(keys) != n {
+		return nil, ErrInvalidCursor
+	}
+	return keys, nil
+}
+
+// BotQuery are the filters and the page of a query of bots. Bots have to
+// match all the filters that are given.
+type BotQuery struct {
+	// IDs, if not empty, are the IDs of the bots to return.
+	IDs []string
+	// CreatedAfter, if not zero, only matches the bots created after it.
+	CreatedAfter time.Time
+	// CreatedBefore, if not zero, only matches the bots created before it.
+	CreatedBefore time.Time
+	// Extra, if not nil, only matches the bots whose Extra it returns true
+	// for.
+	Extra func(interface{}) bool
+	// After is the cursor the page starts after. If empty, the page starts
+	// at the first bot.
+	After Cursor
+	// Limit is the maximum number of bots of the page. If zero,
+	// DefaultPageSize is used.
+	Limit int
+}
+
+// Match reports whether the bot matches the filters of the query.
+func (q BotQuery) Match(bot StoredBot) bool {
+	return matchIDs(q.IDs, bot.ID) &&
+		matchCreated(q.CreatedAfter, q.CreatedBefore, bot.CreatedAt) &&
+		(q.Extra == nil || q.Extra(bot.Extra))
+}
+
+// PageSize returns the maximum number of bots of the page.
+func (q BotQuery) PageSize() int {
+	return pageSize(q.Limit)
+}
+
+// ConversationQuery are the filters and the page of a query of
+// conversations. Conversations have to match all the filters that are given.
+type ConversationQuery struct {
+	// BotIDs, if not empty, only matches the conversations of these bots.
+	BotIDs []string
+	// IDs, if not empty, only matches the conversations with these IDs.
+	IDs []string
+	// CreatedAfter, if not zero, only matches the conversations created
+	// after it.
+	CreatedAfter time.Time
+	// CreatedBefore, if not zero, only matches the conversations created
+	// before it.
+	CreatedBefore time.Time
+	// Extra, if not nil, only matches the conversations whose Extra it
+	// returns true for.
+	Extra func(interface{}) bool
+	// After is the cursor the page starts after. If empty, the page starts
+	// at the first conversation.
+	After Cursor
+	// Limit is the maximum number of conversations of the page. If zero,
+	// DefaultPageSize is used.
+	Limit int
+}
+
+// Match reports whether the conversation matches the filters of the query.
+func (q ConversationQuery) Match(conv StoredConversation) bool {
+	return matchIDs(q.BotIDs, conv.BotID) &&
+		matchIDs(q.IDs, conv.ID) &&
+		matchCreated(q.CreatedAfter, q.CreatedBefore, conv.CreatedAt) &&
+		(q.Extra == nil || q.Extra(conv.Extra))
+}
+
+// PageSize returns the maximum number of conversations of the page.
+func (q ConversationQuery) PageSize() int {
+	return pageSize(q.Limit)
+}
+
+func matchIDs(ids []string, id string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func matchCreated(after, before, created time.Time) bool {
+	return (after.IsZero() || created.After(after)) &&
+		(before.IsZero() || created.Before(before))
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	return limit
+}
+
+// BotPage is a page of the results of a query of bots.
+type BotPage struct {
+	// Bots are the bots of the page, sorted by ID.
+	Bots []StoredBot
+	// Next is the cursor of the next page, or empty if this is the last one.
+	Next Cursor
+}
+
+// ConversationPage is a page of the results of a query of conversations.
+type ConversationPage struct {
+	// Conversations are the conversations of the page, sorted by the ID of
+	// their bot and their ID.
+	Conversations []StoredConversation
+	// Next is the cursor of the next page, or empty if this is the last one.
+	Next Cursor
+}
+
+// Querier is a service to query the bots and conversations stored page by
+// page. Storages that can query their data without loading all of it
+// implement it, and NewStorageV2 uses it.
+type Querier interface {
+	// QueryBots returns the page of the bots that match the query.
+	QueryBots(context.Context, BotQuery) (BotPage, error)
+	// QueryConversations returns the page of the conversations that match
+	// the query.
+	QueryConversations(context.Context, ConversationQuery) (ConversationPage, error)
+}
+
+// StorageV2 is a service to store and query bots and conversations for
+// installations with too many of them to load all at once. All operations
+// stop when their context is done.
+type StorageV2 interface {
+	Querier
+	// StoreBot saves the given bot.
+	StoreBot(context.Context, StoredBot) error
+	// StoreConversation saves the given conversation of its bot, replacing
+	// the stored one if the bot already had a conversation with the same ID.
+	StoreConversation(context.Context, StoredConversation) error
+	// UpdateConversation replaces the stored data of the given conversation
+	// of its bot. It returns ErrConversationNotFound if the conversation is
+	// not stored.
+	UpdateConversation(context.Context, StoredConversation) error
+	// BotExists checks if the bot is already stored.
+	BotExists(context.Context, StoredBot) (bool, error)
+	// ConversationExists checks if the conversation is already stored for
+	// its bot.
+	ConversationExists(context.Context, StoredConversation) (bool, error)
+	// RemoveBot removes the given bot along with all its conversations.
+	RemoveBot(context.Context, StoredBot) error
+	// RemoveConversation removes the given conversation of its bot.
+	RemoveConversation(context.Context, StoredConversation) error
+}
+
+type storageV2 struct {
+	storage Storage
+}
+
+// NewStorageV2 adapts the given storage to StorageV2. If the storage is a
+// Querier, the queries are done by it. Otherwise, all the bots, and all the
+// conversations of the bots that may match, are loaded for every query and
+// filtered in memory, which keeps the existing storages working, but not any
+// faster. The operations of the storage cannot be cancelled once they start,
+// so the context is only checked before starting them.
+func NewStorageV2(storage Storage) StorageV2 {
+	return &storageV2{storage}
+}
+
+func (s *storageV2) StoreBot(ctx context.Context, bot StoredBot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.StoreBot(bot)
+}
+
+func (s *storageV2) StoreConversation(ctx context.Context, conv StoredConversation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.StoreConversation(conv)
+}
+
+func (s *storageV2) UpdateConversation(ctx context.Context, conv StoredConversation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.UpdateConversation(conv)
+}
+
+func (s *storageV2) BotExists(ctx context.Context, bot StoredBot) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return s.storage.BotExists(bot)
+}
+
+func (s *storageV2) ConversationExists(ctx context.Context, conv StoredConversation) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return s.storage.ConversationExists(conv)
+}
+
+func (s *storageV2) RemoveBot(ctx context.Context, bot StoredBot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.RemoveBot(bot)
+}
+
+func (s *storageV2) RemoveConversation(ctx context.Context, conv StoredConversation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.RemoveConversation(conv)
+}
+
+func (s *storageV2) QueryBots(ctx context.Context, q BotQuery) (BotPage, error) {
+	if querier, ok := s.storage.(Querier); ok {
+		return querier.QueryBots(ctx, q)
+	}
+
+	var after string
+	if q.After != "" {
+		keys, err := q.After.Keys(1)
+		if err != nil {
+			return BotPage{}, err
+		}
+		after = keys[0]
+	}
+
+	if err := ctx.Err(); err != nil {
+		return BotPage{}, err
+	}
+
+	bots, err := s.storage.LoadBots()
+	if err != nil {
+		return BotPage{}, err
+	}
+
+	var matched []StoredBot
+	for _, b := range bots {
+		if b.ID > after && q.Match(b) {
+			matched = append(matched, b)
+		}
+	}
+	sort.Sort(BotsByID(matched))
+
+	var page BotPage
+	if len(matched) > q.PageSize() {
+		matched = matched[:q.PageSize()]
+		page.Next = NewCursor(matched[len(matched)-1].ID)
+	}
+	page.Bots = matched
+	return page, nil
+}
+
+func (s *storageV2) QueryConversations(ctx context.Context, q ConversationQuery) (ConversationPage, error) {
+	if querier, ok := s.storage.(Querier); ok {
+		return querier.QueryConversations(ctx, q)
+	}
+
+	var afterBot, afterID string
+	if q.After != "" {
+		keys, err := q.After.Keys(2)
+		if err != nil {
+			return ConversationPage{}, err
+		}
+		afterBot, afterID = keys[0], keys[1]
+	}
+
+	var bots []StoredBot
+	if len(q.BotIDs) > 0 {
+		for _, id := range q.BotIDs {
+			bots = append(bots, StoredBot{ID: id})
+		}
+	} else {
+		if err := ctx.Err(); err != nil {
+			return ConversationPage{}, err
+		}
+
+		loaded, err := s.storage.LoadBots()
+		if err != nil {
+			return ConversationPage{}, err
+		}
+
+		// the bots are copied before sorting them, because some storages
+		// return the data they keep in memory
+		bots = append(bots, loaded...)
+	}
+	sort.Sort(BotsByID(bots))
+
+	var page ConversationPage
+	for _, b := range bots {
+		if b.ID < afterBot {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return ConversationPage{}, err
+		}
+
+		convs, err := s.storage.LoadConversations(b)
+		if err != nil {
+			return ConversationPage{}, err
+		}
+
+		var matched []StoredConversation
+		for _, c := range convs {
+			if (b.ID > afterBot || c.ID > afterID) && q.Match(c) {
+				matched = append(matched, c)
+			}
+		}
+		sort.Sort(ConversationsByID(matched))
+
+		for _, c := range matched {
+			if len(page.Conversations) == q.PageSize() {
+				last := page.Conversations[len(page.Conversations)-1]
+				page.Next = NewCursor(last.BotID, last.ID)
+				return page, nil
+			}
+			page.Conversations = append(page.Conversations, c)
+		}
+	}
+
+	return page, nil
+}
+
+// ForEachBot calls fn with every bot that matches the query, querying the
+// storage page by page from the cursor of the query. It stops at the first
+// error returned by the storage or fn, or when the context is done.
+func ForEachBot(ctx context.Context, storage StorageV2, q BotQuery, fn func(StoredBot) error) error {
+	for {
+		page, err := storage.QueryBots(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		for _, b := range page.Bots {
+			if err := fn(b); err != nil {
+				return err
+			}
+		}
+
+		if page.Next == "" {
+			return nil
+		}
+		q.After = page.Next
+	}
+}
+
+// ForEachConversation calls fn with every conversation that matches the
+// query, querying the storage page by page from the cursor of the query. It
+// stops at the first error returned by the storage or fn, or when the
+// context is done.
+func ForEachConversation(ctx context.Context, storage StorageV2, q ConversationQuery, fn func(StoredConversation) error) error {
+	for {
+		page, err := storage.QueryConversations(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range page.Conversations {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+
+		if page.Next == "" {
+			return nil
+		}
+		q.After = page.Next
+	}
+}
+
+// BotsByID sorts stored bots by their ID.
+type BotsByID []StoredBot
+
+func (b BotsByID) Len() int           { return len(b) }
+func (b BotsByID) Less(i, j int) bool { return b[i].ID < b[j].ID }
+func (b BotsByID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// ConversationsByID sorts stored conversations by their ID.
+type ConversationsByID []StoredConversation
+
+func (c ConversationsByID) Len() int           { return len(c) }
+func (c ConversationsByID) Less(i, j int) bool { return c[i].ID < c[j].ID }
+func (c ConversationsByID) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
@@ -0,0 +1,86 @@
+package flamingo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestCursor(t *testing.T) {
+	require := require.New(t)
+	keys, err := NewCursor("bot", "conv").Keys(2)
+	require.Nil(err)
+	require.Equal([]string{"bot", "conv"}, keys)
+
+	_, err = NewCursor("bot").Keys(2)
+	require.Equal(ErrInvalidCursor, err)
+
+	_, err = Cursor("%%").Keys(1)
+	require.Equal(ErrInvalidCursor, err)
+}
+
+func TestQueryMatch(t *testing.T) {
+	require := require.New(t)
+	created := time.Date(2016, time.October, 3, 12, 0, 0, 0, time.UTC)
+	bot := StoredBot{ID: "1", CreatedAt: created, Extra: "foo"}
+
+	require.True(BotQuery{}.Match(bot))
+	require.True(BotQuery{IDs: []string{"2", "1"}}.Match(bot))
+	require.False(BotQuery{IDs: []string{"2"}}.Match(bot))
+	require.True(BotQuery{CreatedAfter: created.Add(-time.Hour), CreatedBefore: created.Add(time.Hour)}.Match(bot))
+	require.False(BotQuery{CreatedAfter: created}.Match(bot))
+	require.False(BotQuery{CreatedBefore: created}.Match(bot))
+	require.False(BotQuery{Extra: func(e interface{}) bool { return e == "bar" }}.Match(bot))
+
+	conv := StoredConversation{ID: "2", BotID: "1", CreatedAt: created}
+	require.True(ConversationQuery{BotIDs: []string{"1"}, IDs: []string{"2"}}.Match(conv))
+	require.False(ConversationQuery{BotIDs: []string{"2"}}.Match(conv))
+	require.False(ConversationQuery{IDs: []string{"1"}}.Match(conv))
+
+	require.Equal(DefaultPageSize, BotQuery{}.PageSize())
+	require.Equal(5, ConversationQuery{Limit: 5}.PageSize())
+}
+
+type pagedStorageMock struct {
+	StorageV2
+	pages []BotPage
+}
+
+func (s *pagedStorageMock) QueryBots(ctx context.Context, q BotQuery) (BotPage, error) {
+	for i, p := range s.pages {
+		if i == 0 && q.After == "" || i > 0 && s.pages[i-1].Next == q.After {
+			return p, nil
+		}
+	}
+	return BotPage{}, ErrInvalidCursor
+}
+
+func TestForEachBot(t *testing.T) {
+	require := require.New(t)
+	storage := &pagedStorageMock{pages: []BotPage{
+		{Bots: []StoredBot{{ID: "1"}, {ID: "2"}}, Next: NewCursor("2")},
+		{Bots: []StoredBot{{ID: "3"}}},
+	}}
+
+	var ids []string
+	require.Nil(ForEachBot(context.Background(), storage, BotQuery{}, func(b StoredBot) error {
+		ids = append(ids, b.ID)
+		return nil
+	}))
+	require.Equal([]string{"1", "2", "3"}, ids)
+
+	fail := errors.New("fail")
+	ids = nil
+	require.Equal(fail, ForEachBot(context.Background(), storage, BotQuery{}, func(b StoredBot) error {
+		ids = append(ids, b.ID)
+		return fail
+	}))
+	require.Equal([]string{"1"}, ids)
+
+	require.Equal(ErrInvalidCursor, ForEachBot(context.Background(), storage, BotQuery{After: "foo"}, func(StoredBot) error {
+		return nil
+	}))
+}
@@ -0,0 +1,219 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultAPIURL is the URL of the Telegram Bot API.
+const DefaultAPIURL = "https://api.telegram.org"
+
+// APIError is an error returned by the Telegram Bot API.
+type APIError struct {
+	// Code is the error code, which matches the HTTP status code.
+	Code int
+	// Description is the description of the error.
+	Description string
+	// RetryAfter is the number of seconds to wait before repeating the
+	// request if the error was caused by flood control.
+	RetryAfter int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram: %d %s", e.Code, e.Description)
+}
+
+// Temporary reports whether the request can be retried later.
+func (e *APIError) Temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+type response struct {
+	Ok          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+type user struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+type chat struct {
+	ID        int64  `json:"id"`
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type message struct {
+	MessageID      int64  `json:"message_id"`
+	From           *user  `json:"from"`
+	Chat           chat   `json:"chat"`
+	Date           int64  `json:"date"`
+	Text           string `json:"text"`
+	Caption        string `json:"caption"`
+	NewChatMembers []user `json:"new_chat_members"`
+	LeftChatMember *user  `json:"left_chat_member"`
+}
+
+type callbackQuery struct {
+	ID      string   `json:"id"`
+	From    user     `json:"from"`
+	Message *message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type chatMember struct {
+	User   user   `json:"user"`
+	Status string `json:"status"`
+}
+
+type chatMemberUpdated struct {
+	Chat          chat       `json:"chat"`
+	From          user       `json:"from"`
+	NewChatMember chatMember `json:"new_chat_member"`
+}
+
+// Update is an incoming update of the Telegram Bot API.
+type Update struct {
+	UpdateID      int64              `json:"update_id"`
+	Message       *message           `json:"message"`
+	EditedMessage *message           `json:"edited_message"`
+	ChannelPost   *message           `json:"channel_post"`
+	CallbackQuery *callbackQuery     `json:"callback_query"`
+	MyChatMember  *chatMemberUpdated `json:"my_chat_member"`
+}
+
+type inlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+type inlineKeyboardMarkup struct {
+	InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type api struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newAPI(url, token string, client *http.Client) *api {
+	return &api{
+		url:    url,
+		token:  token,
+		client: client,
+	}
+}
+
+// call performs a request to the given method with the given params and
+// decodes the result in result, if it is not nil. If cancel is not nil, the
+// request is aborted when it is closed.
+func (a *api) call(method string, params interface{}, result interface{}, cancel <-chan struct{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/bot%s/%s", a.url, a.token, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Cancel = cancel
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return &APIError{Code: resp.StatusCode, Description: resp.Status}
+	}
+
+	if !r.Ok {
+		return &APIError{
+			Code:        r.ErrorCode,
+			Description: r.Description,
+			RetryAfter:  r.Parameters.RetryAfter,
+		}
+	}
+
+	if result != nil {
+		return json.Unmarshal(r.Result, result)
+	}
+
+	return nil
+}
+
+func (a *api) getUpdates(offset int64, timeout time.Duration, cancel <-chan struct{}) ([]Update, error) {
+	var updates []Update
+	err := a.call("getUpdates", map[string]interface{}{
+		"offset":  offset,
+		"timeout": int(timeout / time.Second),
+	}, &updates, cancel)
+	return updates, err
+}
+
+func (a *api) sendMessage(params map[string]interface{}) (*message, error) {
+	var msg message
+	if err := a.call("sendMessage", params, &msg, nil); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (a *api) sendPhoto(params map[string]interface{}) (*message, error) {
+	var msg message
+	if err := a.call("sendPhoto", params, &msg, nil); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (a *api) editMessageText(params map[string]interface{}) error {
+	return a.call("editMessageText", params, nil, nil)
+}
+
+func (a *api) answerCallbackQuery(id string) error {
+	return a.call("answerCallbackQuery", map[string]interface{}{
+		"callback_query_id": id,
+	}, nil, nil)
+}
+
+func (a *api) getChat(id string) (*chat, error) {
+	var c chat
+	if err := a.call("getChat", map[string]interface{}{"chat_id": id}, &c, nil); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (a *api) setWebhook(url, secret string) error {
+	params := map[string]interface{}{"url": url}
+	if secret != "" {
+		params["secret_token"] = secret
+	}
+	return a.call("setWebhook", params, nil, nil)
+}
+
+func (a *api) deleteWebhook() error {
+	return a.call("deleteWebhook", map[string]interface{}{}, nil, nil)
+}
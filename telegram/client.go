@@ -0,0 +1,219 @@
+// Package telegram provides a flamingo.Client for the Telegram Bot API.
+//
+// Bots receive updates either by long polling, the default, or through a
+// webhook served by the client. Button groups of forms are rendered as inline
+// keyboards and the buttons clicked are delivered as actions to the
+// ActionHandler registered with the ID of the group. Because the ID of the
+// group, the name and the value of a button are sent back by Telegram as the
+// callback data of the button, all together must not be longer than 64 bytes
+// and the ID of the group can not contain the "|" character.
+package telegram
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// DefaultPollTimeout is the time the Telegram servers wait for new updates
+// before answering a long polling request.
+const DefaultPollTimeout = 30 * time.Second
+
+// ClientOptions are the configurable options of the telegram client.
+type ClientOptions struct {
+	// Debug will print extra debug log messages.
+	Debug bool
+	// Clock is the source of time of the client. If none is given, the
+	// system time will be used.
+	Clock flamingo.Clock
+	// APIURL is the base URL of the Bot API. If empty, DefaultAPIURL is used.
+	APIURL string
+	// HTTPClient is the client used to perform the requests to the API. If
+	// nil, a client with a timeout long enough for long polling is used.
+	HTTPClient *http.Client
+	// PollTimeout is the timeout of the long polling requests. If zero,
+	// DefaultPollTimeout is used.
+	PollTimeout time.Duration
+	// Webhook contains the options to receive updates through a webhook
+	// instead of long polling.
+	Webhook WebhookOptions
+}
+
+// WebhookOptions are the configurable options of the telegram webhook.
+type WebhookOptions struct {
+	// Enabled will receive updates through the webhook if true.
+	Enabled bool
+	// Addr is the address on which the webhook will be run.
+	Addr string
+	// URL is the public URL of the webhook. The ID of every bot is appended
+	// to it to get the URL of the bot, so it has to be routed to Addr.
+	URL string
+	// SecretToken, if not empty, is sent by Telegram in every request and
+	// requests without it are rejected.
+	SecretToken string
+	// CertFile is the path to the SSL certificate. If given along with
+	// KeyFile, the webhook is served using HTTPS.
+	CertFile string
+	// KeyFile is the path to the SSL key.
+	KeyFile string
+}
+
+type telegramPlatform struct {
+	options ClientOptions
+}
+
+func (p *telegramPlatform) Type() flamingo.ClientType {
+	return flamingo.TelegramClient
+}
+
+func (p *telegramPlatform) Connect(bot flamingo.StoredBot, events platform.Events) (platform.Connection, error) {
+	if bot.Token == "" {
+		return nil, errors.New("telegram: empty bot token")
+	}
+
+	return newConnection(bot, events, p.options), nil
+}
+
+type telegramClient struct {
+	*platform.Client
+	options  ClientOptions
+	mut      sync.Mutex
+	listener net.Listener
+}
+
+// NewClient creates a new Telegram Client with the given options.
+func NewClient(options ClientOptions) flamingo.Client {
+	if options.APIURL == "" {
+		options.APIURL = DefaultAPIURL
+	}
+
+	if options.PollTimeout <= 0 {
+		options.PollTimeout = DefaultPollTimeout
+	}
+
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: options.PollTimeout + 10*time.Second}
+	}
+
+	if options.Webhook.Addr == "" {
+		options.Webhook.Addr = ":8080"
+	}
+
+	return &telegramClient{
+		Client: platform.NewClient(&telegramPlatform{options}, platform.Options{
+			Debug: options.Debug,
+			Clock: options.Clock,
+		}),
+		options: options,
+	}
+}
+
+func (c *telegramClient) Run() error {
+	if c.options.Webhook.Enabled {
+		if c.options.Webhook.URL == "" {
+			return errors.New("telegram: webhook URL is empty")
+		}
+
+		listener, err := c.listen()
+		if err != nil {
+			return err
+		}
+
+		c.mut.Lock()
+		c.listener = listener
+		c.mut.Unlock()
+
+		log15.Info("Starting telegram webhook", "address", c.options.Webhook.Addr)
+		go c.serveWebhook(listener)
+	}
+
+	return c.Client.Run()
+}
+
+func (c *telegramClient) listen() (net.Listener, error) {
+	listener, err := net.Listen("tcp", c.options.Webhook.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.options.Webhook.CertFile == "" || c.options.Webhook.KeyFile == "" {
+		return listener, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.options.Webhook.CertFile, c.options.Webhook.KeyFile)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(listener, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}), nil
+}
+
+func (c *telegramClient) serveWebhook(listener net.Listener) {
+	err := (&http.Server{
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		Handler:      c,
+	}).Serve(listener)
+
+	if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		log15.Error("telegram webhook stopped", "err", err.Error())
+	}
+}
+
+// ServeHTTP receives the updates sent by Telegram to the webhook and
+// delivers them to the bot whose ID is the last element of the path.
+func (c *telegramClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := c.options.Webhook.SecretToken
+	if secret != "" && r.Header.Get("X-Telegram-Bot-Api-Secret-Token") != secret {
+		log15.Warn("received telegram update with invalid secret token")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	conn, err := c.Connection(id)
+	if err != nil {
+		log15.Warn("received telegram update for unknown bot", "bot", id)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var update Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		log15.Error("error decoding telegram update", "err", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	conn.(*connection).handleUpdate(update)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *telegramClient) Stop() error {
+	c.mut.Lock()
+	if c.listener != nil {
+		c.listener.Close()
+		c.listener = nil
+	}
+	c.mut.Unlock()
+
+	return c.Client.Stop()
+}
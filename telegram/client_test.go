@@ -0,0 +1,322 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+type apiCall struct {
+	method string
+	params map[string]interface{}
+}
+
+// fakeAPI is a local stand-in of the Telegram Bot API.
+type fakeAPI struct {
+	sync.Mutex
+	*httptest.Server
+	updates []Update
+	calls   []apiCall
+	lastID  int64
+	fail    map[string]int
+}
+
+func newFakeAPI() *fakeAPI {
+	api := &fakeAPI{fail: make(map[string]int)}
+	api.Server = httptest.NewServer(api)
+	return api
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	var params map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&params)
+
+	a.Lock()
+	if code, ok := a.fail[method]; ok {
+		a.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":          false,
+			"error_code":  code,
+			"description": "failed",
+		})
+		return
+	}
+
+	if method != "getUpdates" {
+		a.calls = append(a.calls, apiCall{method, params})
+	}
+
+	var result interface{} = true
+	switch method {
+	case "getUpdates":
+		offset := int64(params["offset"].(float64))
+		var updates = []Update{}
+		for _, u := range a.updates {
+			if u.UpdateID >= offset {
+				updates = append(updates, u)
+			}
+		}
+		result = updates
+	case "sendMessage", "sendPhoto":
+		a.lastID++
+		result = map[string]interface{}{
+			"message_id": a.lastID,
+			"chat":       map[string]interface{}{"id": 7},
+		}
+	case "getChat":
+		result = map[string]interface{}{"id": 42, "type": "group", "title": "the group"}
+	}
+	a.Unlock()
+
+	if method == "getUpdates" {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (a *fakeAPI) setFail(method string, code int) {
+	a.Lock()
+	defer a.Unlock()
+	a.fail[method] = code
+}
+
+func (a *fakeAPI) push(u Update) {
+	a.Lock()
+	defer a.Unlock()
+	u.UpdateID = int64(len(a.updates) + 1)
+	a.updates = append(a.updates, u)
+}
+
+func (a *fakeAPI) callsTo(method string) []apiCall {
+	a.Lock()
+	defer a.Unlock()
+	var calls []apiCall
+	for _, c := range a.calls {
+		if c.method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+func (a *fakeAPI) waitFor(t *testing.T, method string, n int) []apiCall {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		calls := a.callsTo(method)
+		if len(calls) >= n {
+			return calls
+		}
+
+		if time.Now().After(deadline) {
+			require.FailNow(t, "expected call was not made", "method %s", method)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type echoController struct{}
+
+func (echoController) CanHandle(flamingo.Message) bool { return true }
+
+func (echoController) Handle(bot flamingo.Bot, msg flamingo.Message) error {
+	_, err := bot.Reply(msg, flamingo.NewOutgoingMessage("echo: "+msg.Text))
+	return err
+}
+
+var (
+	sender  = &user{ID: 7, FirstName: "Jane", LastName: "Doe", Username: "jane"}
+	botUser = &user{ID: 123, IsBot: true, Username: "the_bot"}
+	private = chat{ID: 7, Type: "private", Username: "jane"}
+)
+
+func newTestClient(api *fakeAPI, webhook WebhookOptions) flamingo.Client {
+	return NewClient(ClientOptions{
+		APIURL:      api.URL,
+		PollTimeout: time.Second,
+		Webhook:     webhook,
+	})
+}
+
+func TestPolling(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+
+	cli := newTestClient(api, WebhookOptions{})
+	cli.AddController(echoController{})
+	cli.AddBot("bot", "123:secret", nil)
+
+	api.push(Update{Message: &message{MessageID: 1, From: botUser, Chat: private, Text: "self"}})
+	api.push(Update{Message: &message{MessageID: 2, From: sender, Chat: private, Text: "hello", Date: 1475496000}})
+
+	calls := api.waitFor(t, "sendMessage", 1)
+	require.Equal("7", calls[0].params["chat_id"])
+	require.Equal("echo: hello", calls[0].params["text"])
+	require.Equal("2", calls[0].params["reply_to_message_id"])
+	require.Equal(1, len(api.callsTo("deleteWebhook")))
+
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(1, len(api.callsTo("sendMessage")))
+	require.Nil(cli.Stop())
+}
+
+func TestCallbackQuery(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+
+	actions := make(chan flamingo.Action, 1)
+	cli := newTestClient(api, WebhookOptions{})
+	cli.AddActionHandler("confirm", func(b flamingo.Bot, a flamingo.Action) {
+		actions <- a
+	})
+	cli.AddBot("bot", "123:secret", nil)
+
+	api.push(Update{CallbackQuery: &callbackQuery{
+		ID:      "q1",
+		From:    *sender,
+		Message: &message{MessageID: 3, From: botUser, Chat: private, Text: "sure?"},
+		Data:    "confirm|yes|y",
+	}})
+
+	select {
+	case a := <-actions:
+		require.Equal(flamingo.UserAction{Name: "yes", Value: "y"}, a.UserAction)
+		require.Equal("7", a.User.ID)
+		require.Equal("Jane Doe", a.User.Name)
+		require.True(a.Channel.IsDM)
+		require.Equal("3", a.OriginalMessage.ID)
+	case <-time.After(2 * time.Second):
+		require.FailNow("action not received")
+	}
+
+	calls := api.waitFor(t, "answerCallbackQuery", 1)
+	require.Equal("q1", calls[0].params["callback_query_id"])
+	require.Nil(cli.Stop())
+}
+
+func TestJoinedGroup(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+
+	cli := newTestClient(api, WebhookOptions{})
+	intros := make(chan flamingo.Channel, 1)
+	cli.SetIntroHandler(introFunc(func(b flamingo.Bot, ch flamingo.Channel) error {
+		intros <- ch
+		_, err := b.Form(flamingo.Form{
+			Title:  "Hi",
+			Fields: []flamingo.FieldGroup{flamingo.NewButtonGroup("start", flamingo.NewButton("Start", "start"))},
+		})
+		return err
+	}))
+	cli.AddBot("bot", "123:secret", nil)
+
+	group := chat{ID: -100, Type: "group", Title: "devs"}
+	api.push(Update{Message: &message{MessageID: 1, From: sender, Chat: group, NewChatMembers: []user{*botUser}}})
+
+	select {
+	case ch := <-intros:
+		require.Equal("-100", ch.ID)
+		require.Equal("devs", ch.Name)
+		require.False(ch.IsDM)
+	case <-time.After(2 * time.Second):
+		require.FailNow("intro not handled")
+	}
+
+	calls := api.waitFor(t, "sendMessage", 1)
+	require.Equal("HTML", calls[0].params["parse_mode"])
+	require.Equal("<b>Hi</b>", calls[0].params["text"])
+	require.NotNil(calls[0].params["reply_markup"])
+	require.Nil(cli.Stop())
+}
+
+type introFunc func(flamingo.Bot, flamingo.Channel) error
+
+func (f introFunc) HandleIntro(b flamingo.Bot, ch flamingo.Channel) error {
+	return f(b, ch)
+}
+
+func TestWebhook(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+
+	cli := newTestClient(api, WebhookOptions{
+		Enabled:     true,
+		URL:         "https://example.com/telegram/",
+		SecretToken: "s3cr3t",
+	})
+	cli.AddController(echoController{})
+	cli.AddBot("bot", "123:secret", nil)
+
+	calls := api.waitFor(t, "setWebhook", 1)
+	require.Equal("https://example.com/telegram/bot", calls[0].params["url"])
+	require.Equal("s3cr3t", calls[0].params["secret_token"])
+
+	body, err := json.Marshal(Update{UpdateID: 1, Message: &message{MessageID: 2, From: sender, Chat: private, Text: "hook"}})
+	require.Nil(err)
+
+	handler := cli.(http.Handler)
+	req, _ := http.NewRequest("POST", "/telegram/bot", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(http.StatusUnauthorized, w.Code)
+
+	req, _ = http.NewRequest("POST", "/telegram/other", bytes.NewReader(body))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "s3cr3t")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(http.StatusNotFound, w.Code)
+
+	req, _ = http.NewRequest("POST", "/telegram/bot", bytes.NewReader(body))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "s3cr3t")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(http.StatusOK, w.Code)
+
+	calls = api.waitFor(t, "sendMessage", 1)
+	require.Equal("echo: hook", calls[0].params["text"])
+	require.Equal(0, len(api.callsTo("deleteWebhook")))
+	require.Nil(cli.Stop())
+}
+
+func TestBroadcastRateLimited(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+
+	cli := newTestClient(api, WebhookOptions{})
+	cli.AddBot("bot", "123:secret", nil)
+	api.push(Update{Message: &message{MessageID: 1, From: sender, Chat: private, Text: "hi"}})
+
+	var report flamingo.BroadcastReport
+	deadline := time.Now().Add(2 * time.Second)
+	for report.Conversations() == 0 && time.Now().Before(deadline) {
+		report, _ = cli.Broadcast(flamingo.NewOutgoingMessage("news"), flamingo.All(), flamingo.BroadcastOptions{})
+		time.Sleep(5 * time.Millisecond)
+	}
+	require.Equal(uint64(1), report.Conversations())
+	require.Nil(report.Err())
+
+	api.setFail("sendMessage", http.StatusTooManyRequests)
+
+	report, err := cli.Broadcast(flamingo.NewOutgoingMessage("news"), flamingo.All(), flamingo.BroadcastOptions{
+		Retries:    1,
+		RetryDelay: time.Millisecond,
+	})
+	require.Equal(flamingo.ErrAllMessagesLost, err)
+	require.Equal(2, report.Deliveries[0].Attempts)
+	require.Nil(cli.Stop())
+}
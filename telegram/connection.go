@@ -0,0 +1,373 @@
+package telegram
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+// ErrUnknownUser occurs when a direct message is sent to a user by username
+// and the bot has not seen that user yet. Telegram bots can not look up
+// users by their username.
+var ErrUnknownUser = errors.New("telegram: unknown user, use the numeric user ID")
+
+type connection struct {
+	id      string
+	self    string
+	api     *api
+	events  platform.Events
+	options ClientOptions
+	offset  int64
+	mut     sync.RWMutex
+	users   map[string]string
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newConnection(bot flamingo.StoredBot, events platform.Events, options ClientOptions) *connection {
+	// the token of a bot is prefixed by its user ID
+	self := bot.Token
+	if idx := strings.Index(self, ":"); idx >= 0 {
+		self = self[:idx]
+	}
+
+	return &connection{
+		id:      bot.ID,
+		self:    self,
+		api:     newAPI(options.APIURL, bot.Token, options.HTTPClient),
+		events:  events,
+		options: options,
+		users:   make(map[string]string),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (c *connection) Run() error {
+	if c.options.Webhook.Enabled {
+		return c.runWebhook()
+	}
+	return c.runPolling()
+}
+
+func (c *connection) runWebhook() error {
+	url := strings.TrimRight(c.options.Webhook.URL, "/") + "/" + c.id
+	if err := c.api.setWebhook(url, c.options.Webhook.SecretToken); err != nil {
+		return err
+	}
+
+	log15.Info("telegram webhook set", "bot", c.id, "url", url)
+	<-c.closed
+	return nil
+}
+
+func (c *connection) runPolling() error {
+	if err := c.api.deleteWebhook(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-c.closed:
+			return nil
+		default:
+		}
+
+		updates, err := c.api.getUpdates(c.offset, c.options.PollTimeout, c.closed)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		for _, u := range updates {
+			c.offset = u.UpdateID + 1
+			c.handleUpdate(u)
+		}
+	}
+}
+
+func (c *connection) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *connection) handleUpdate(u Update) {
+	switch {
+	case u.Message != nil:
+		c.handleMessage(u.Message)
+	case u.ChannelPost != nil:
+		c.handleMessage(u.ChannelPost)
+	case u.CallbackQuery != nil:
+		c.handleCallback(u.CallbackQuery)
+	case u.MyChatMember != nil:
+		c.handleMembership(u.MyChatMember)
+	default:
+		log15.Debug("ignoring telegram update", "id", u.UpdateID)
+	}
+}
+
+func (c *connection) handleMessage(msg *message) {
+	for _, m := range msg.NewChatMembers {
+		if c.isSelf(m) {
+			c.events.Joined(convertChat(msg.Chat))
+			return
+		}
+	}
+
+	if msg.LeftChatMember != nil {
+		if c.isSelf(*msg.LeftChatMember) {
+			c.events.Left(chatID(msg.Chat))
+		}
+		return
+	}
+
+	if msg.From != nil && c.isSelf(*msg.From) {
+		log15.Debug("got message from self, ignoring")
+		return
+	}
+
+	if msg.Text == "" && msg.Caption == "" {
+		return
+	}
+
+	c.events.Message(c.convertMessage(msg))
+}
+
+func (c *connection) handleMembership(m *chatMemberUpdated) {
+	if !c.isSelf(m.NewChatMember.User) {
+		return
+	}
+
+	switch m.NewChatMember.Status {
+	case "member", "administrator":
+		c.events.Joined(convertChat(m.Chat))
+	case "left", "kicked":
+		c.events.Left(chatID(m.Chat))
+	}
+}
+
+func (c *connection) handleCallback(q *callbackQuery) {
+	if err := c.api.answerCallbackQuery(q.ID); err != nil {
+		log15.Error("unable to answer callback query", "id", q.ID, "err", err.Error())
+	}
+
+	if q.Message == nil {
+		log15.Warn("callback query without message, ignoring", "id", q.ID)
+		return
+	}
+
+	id, action, ok := decodeCallback(q.Data)
+	if !ok {
+		log15.Error("invalid callback data", "data", q.Data)
+		return
+	}
+
+	c.remember(q.From)
+	c.events.Action(platform.ActionEvent{
+		ID: id,
+		Action: flamingo.Action{
+			UserAction:      action,
+			User:            convertUser(q.From),
+			Channel:         convertChat(q.Message.Chat),
+			OriginalMessage: c.convertMessage(q.Message),
+			Extra:           q,
+		},
+	})
+}
+
+func (c *connection) isSelf(u user) bool {
+	return strconv.FormatInt(u.ID, 10) == c.self
+}
+
+func (c *connection) remember(u user) {
+	if u.Username == "" {
+		return
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.users[strings.ToLower(u.Username)] = strconv.FormatInt(u.ID, 10)
+}
+
+func (c *connection) convertMessage(msg *message) flamingo.Message {
+	var u flamingo.User
+	if msg.From != nil {
+		c.remember(*msg.From)
+		u = convertUser(*msg.From)
+	}
+
+	text := msg.Text
+	if text == "" {
+		text = msg.Caption
+	}
+
+	return flamingo.Message{
+		ID:      strconv.FormatInt(msg.MessageID, 10),
+		Type:    flamingo.TelegramClient,
+		User:    u,
+		Channel: convertChat(msg.Chat),
+		Time:    time.Unix(msg.Date, 0),
+		Text:    text,
+		Extra:   msg,
+	}
+}
+
+func convertUser(u user) flamingo.User {
+	return flamingo.User{
+		ID:       strconv.FormatInt(u.ID, 10),
+		Username: u.Username,
+		Name:     strings.TrimSpace(u.FirstName + " " + u.LastName),
+		IsBot:    u.IsBot,
+		Type:     flamingo.TelegramClient,
+		Extra:    u,
+	}
+}
+
+func chatID(ch chat) string {
+	return strconv.FormatInt(ch.ID, 10)
+}
+
+func convertChat(ch chat) flamingo.Channel {
+	name := ch.Title
+	if name == "" {
+		name = ch.Username
+	}
+
+	return flamingo.Channel{
+		ID:    chatID(ch),
+		Name:  name,
+		IsDM:  ch.Type == "private",
+		Type:  flamingo.TelegramClient,
+		Extra: ch,
+	}
+}
+
+func (c *connection) Channel(id string) (flamingo.Channel, error) {
+	ch, err := c.api.getChat(id)
+	if err != nil {
+		return flamingo.Channel{}, err
+	}
+	return convertChat(*ch), nil
+}
+
+func (c *connection) PostMessage(channel string, msg flamingo.OutgoingMessage) (string, error) {
+	return c.send(map[string]interface{}{
+		"chat_id": channel,
+		"text":    msg.Text,
+	})
+}
+
+func (c *connection) ReplyMessage(channel string, replyTo flamingo.Message, msg flamingo.OutgoingMessage) (string, error) {
+	params := map[string]interface{}{
+		"chat_id": channel,
+		"text":    msg.Text,
+	}
+
+	if replyTo.Channel.ID == channel && replyTo.ID != "" {
+		params["reply_to_message_id"] = replyTo.ID
+	}
+
+	return c.send(params)
+}
+
+func (c *connection) PostForm(channel string, form flamingo.Form) (string, error) {
+	text, markup, err := formToMessage(form)
+	if err != nil {
+		return "", err
+	}
+
+	params := map[string]interface{}{
+		"chat_id":    channel,
+		"text":       text,
+		"parse_mode": "HTML",
+	}
+
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+
+	return c.send(params)
+}
+
+func (c *connection) send(params map[string]interface{}) (string, error) {
+	msg, err := c.api.sendMessage(params)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(msg.MessageID, 10), nil
+}
+
+func (c *connection) PostImage(channel string, img flamingo.Image) (string, error) {
+	params := map[string]interface{}{
+		"chat_id": channel,
+		"photo":   img.URL,
+	}
+
+	if img.Text != "" {
+		params["caption"] = img.Text
+	}
+
+	msg, err := c.api.sendPhoto(params)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(msg.MessageID, 10), nil
+}
+
+func (c *connection) UpdateMessage(channel, id, text string) (string, error) {
+	return id, c.api.editMessageText(map[string]interface{}{
+		"chat_id":    channel,
+		"message_id": id,
+		"text":       text,
+	})
+}
+
+func (c *connection) UpdateForm(channel, id string, form flamingo.Form) (string, error) {
+	text, markup, err := formToMessage(form)
+	if err != nil {
+		return "", err
+	}
+
+	params := map[string]interface{}{
+		"chat_id":    channel,
+		"message_id": id,
+		"text":       text,
+		"parse_mode": "HTML",
+	}
+
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+
+	return id, c.api.editMessageText(params)
+}
+
+// DirectChannel returns the ID of the private chat with the user, which is
+// the ID of the user itself. Users can be referred to by username only if
+// the bot has already received a message or action from them.
+func (c *connection) DirectChannel(user string) (string, error) {
+	if _, err := strconv.ParseInt(user, 10, 64); err == nil {
+		return user, nil
+	}
+
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	id, ok := c.users[strings.ToLower(strings.TrimPrefix(user, "@"))]
+	if !ok {
+		return "", ErrUnknownUser
+	}
+
+	return id, nil
+}
@@ -0,0 +1,129 @@
+package telegram
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+	"github.com/src-d/flamingo/platform"
+)
+
+type eventsMock struct {
+	messages []flamingo.Message
+	actions  []platform.ActionEvent
+	joined   []flamingo.Channel
+	left     []string
+}
+
+func (e *eventsMock) Message(m flamingo.Message)    { e.messages = append(e.messages, m) }
+func (e *eventsMock) Action(a platform.ActionEvent) { e.actions = append(e.actions, a) }
+func (e *eventsMock) Joined(ch flamingo.Channel)    { e.joined = append(e.joined, ch) }
+func (e *eventsMock) Left(ch string)                { e.left = append(e.left, ch) }
+
+func newTestConnection(api *fakeAPI, events platform.Events) *connection {
+	return newConnection(flamingo.StoredBot{ID: "bot", Token: "123:secret"}, events, ClientOptions{
+		APIURL:     api.URL,
+		HTTPClient: http.DefaultClient,
+	})
+}
+
+func TestHandleUpdate(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+
+	events := new(eventsMock)
+	conn := newTestConnection(api, events)
+	group := chat{ID: -100, Type: "supergroup", Title: "devs"}
+
+	conn.handleUpdate(Update{Message: &message{MessageID: 1, From: sender, Chat: group, Text: "hi", Date: 1475496000}})
+	conn.handleUpdate(Update{Message: &message{MessageID: 2, From: botUser, Chat: group, Text: "me"}})
+	conn.handleUpdate(Update{Message: &message{MessageID: 3, From: sender, Chat: group}})
+	conn.handleUpdate(Update{Message: &message{MessageID: 4, From: sender, Chat: group, LeftChatMember: botUser}})
+	conn.handleUpdate(Update{MyChatMember: &chatMemberUpdated{Chat: group, From: *sender, NewChatMember: chatMember{User: *botUser, Status: "administrator"}}})
+	conn.handleUpdate(Update{CallbackQuery: &callbackQuery{ID: "q", From: *sender, Data: "invalid"}})
+
+	require.Equal(1, len(events.messages))
+	msg := events.messages[0]
+	require.Equal("1", msg.ID)
+	require.Equal("hi", msg.Text)
+	require.Equal(flamingo.TelegramClient, msg.Type)
+	require.Equal("jane", msg.User.Username)
+	require.Equal("-100", msg.Channel.ID)
+	require.Equal(int64(1475496000), msg.Time.Unix())
+
+	require.Equal([]string{"-100"}, events.left)
+	require.Equal(1, len(events.joined))
+	require.Equal("devs", events.joined[0].Name)
+	require.Equal(0, len(events.actions))
+}
+
+func TestDirectChannel(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+
+	conn := newTestConnection(api, new(eventsMock))
+	id, err := conn.DirectChannel("99")
+	require.Nil(err)
+	require.Equal("99", id)
+
+	_, err = conn.DirectChannel("jane")
+	require.Equal(ErrUnknownUser, err)
+
+	conn.handleUpdate(Update{Message: &message{MessageID: 1, From: sender, Chat: private, Text: "hi"}})
+	id, err = conn.DirectChannel("@Jane")
+	require.Nil(err)
+	require.Equal("7", id)
+}
+
+func TestConnectionAPI(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+
+	conn := newTestConnection(api, new(eventsMock))
+	id, err := conn.PostImage("7", flamingo.Image{URL: "http://img.png", Text: "look"})
+	require.Nil(err)
+	require.Equal("1", id)
+
+	id, err = conn.UpdateMessage("7", "1", "changed")
+	require.Nil(err)
+	require.Equal("1", id)
+
+	_, err = conn.UpdateForm("7", "1", flamingo.Form{Title: "form"})
+	require.Nil(err)
+
+	ch, err := conn.Channel("42")
+	require.Nil(err)
+	require.Equal("the group", ch.Name)
+
+	photo := api.callsTo("sendPhoto")[0].params
+	require.Equal("http://img.png", photo["photo"])
+	require.Equal("look", photo["caption"])
+
+	edits := api.callsTo("editMessageText")
+	require.Equal(2, len(edits))
+	require.Equal("changed", edits[0].params["text"])
+	require.Equal("<b>form</b>", edits[1].params["text"])
+}
+
+func TestAPIError(t *testing.T) {
+	require := require.New(t)
+	api := newFakeAPI()
+	defer api.Close()
+	api.setFail("sendMessage", 429)
+
+	conn := newTestConnection(api, new(eventsMock))
+	_, err := conn.PostMessage("7", flamingo.NewOutgoingMessage("hi"))
+	require.NotNil(err)
+	require.True(flamingo.IsTemporary(err))
+
+	api.setFail("sendMessage", 400)
+	_, err = conn.PostMessage("7", flamingo.NewOutgoingMessage("hi"))
+	require.False(flamingo.IsTemporary(err))
+	require.False(flamingo.IsTemporary(errors.New("foo")))
+}
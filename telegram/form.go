@@ -0,0 +1,140 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/src-d/flamingo"
+)
+
+// callbackSeparator separates the group ID, the name and the value of a
+// button in its callback data.
+const callbackSeparator = "|"
+
+// maxCallbackData is the maximum length in bytes of the callback data of a
+// button allowed by Telegram.
+const maxCallbackData = 64
+
+var (
+	// ErrCallbackTooLong occurs when a form has a button whose group ID, name
+	// and value are longer than the callback data Telegram allows.
+	ErrCallbackTooLong = errors.New("telegram: the group ID, name and value of the button are longer than 64 bytes")
+	// ErrInvalidCallback occurs when a form has a button whose group ID or
+	// name contain the callback separator "|".
+	ErrInvalidCallback = errors.New("telegram: the group ID and name of a button cannot contain \"|\"")
+)
+
+// formToMessage renders the form as an HTML text and an inline keyboard with
+// a row for every group of buttons. It returns an error if the callback data
+// of any button is not valid.
+func formToMessage(form flamingo.Form) (string, *inlineKeyboardMarkup, error) {
+	var (
+		lines    []string
+		keyboard [][]inlineKeyboardButton
+	)
+
+	if form.AuthorName != "" {
+		lines = append(lines, fmt.Sprintf("<i>%s</i>", html.EscapeString(form.AuthorName)))
+	}
+
+	if form.Title != "" {
+		lines = append(lines, fmt.Sprintf("<b>%s</b>", html.EscapeString(form.Title)))
+	}
+
+	if form.Text != "" {
+		lines = append(lines, html.EscapeString(form.Text))
+	}
+
+	for _, g := range form.Fields {
+		switch g.Type() {
+		case flamingo.ButtonGroup:
+			var row []inlineKeyboardButton
+			for _, f := range g.Items() {
+				if b, ok := f.(flamingo.Button); ok {
+					data, err := encodeCallback(g.ID(), b)
+					if err != nil {
+						return "", nil, err
+					}
+
+					row = append(row, inlineKeyboardButton{
+						Text:         b.Text,
+						CallbackData: data,
+					})
+				}
+			}
+			keyboard = append(keyboard, row)
+
+		case flamingo.TextFieldGroup:
+			for _, f := range g.Items() {
+				if tf, ok := f.(flamingo.TextField); ok {
+					lines = append(lines, fmt.Sprintf(
+						"<b>%s</b>: %s",
+						html.EscapeString(tf.Title),
+						html.EscapeString(tf.Value),
+					))
+				}
+			}
+
+		case flamingo.ImageGroup:
+			if img, ok := g.(flamingo.Image); ok {
+				text := img.Text
+				if text == "" {
+					text = img.URL
+				}
+				lines = append(lines, fmt.Sprintf(
+					`<a href="%s">%s</a>`,
+					html.EscapeString(img.URL),
+					html.EscapeString(text),
+				))
+			}
+
+		case flamingo.TextGroup:
+			if t, ok := g.(flamingo.Text); ok {
+				lines = append(lines, html.EscapeString(string(t)))
+			}
+		}
+	}
+
+	if form.Footer != "" {
+		lines = append(lines, fmt.Sprintf("<i>%s</i>", html.EscapeString(form.Footer)))
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, " ")
+	}
+
+	var markup *inlineKeyboardMarkup
+	if len(keyboard) > 0 {
+		markup = &inlineKeyboardMarkup{InlineKeyboard: keyboard}
+	}
+
+	return strings.Join(lines, "\n"), markup, nil
+}
+
+// encodeCallback returns the callback data of the button, which contains
+// the ID of its group, its name and its value. The value is the last part,
+// so it can contain the separator, but the group ID and the name cannot.
+func encodeCallback(group string, b flamingo.Button) (string, error) {
+	if strings.Contains(group, callbackSeparator) || strings.Contains(b.Name, callbackSeparator) {
+		return "", ErrInvalidCallback
+	}
+
+	data := strings.Join([]string{group, b.Name, b.Value}, callbackSeparator)
+	if len(data) > maxCallbackData {
+		return "", ErrCallbackTooLong
+	}
+	return data, nil
+}
+
+// decodeCallback returns the group ID and the action encoded in the
+// callback data of a button.
+func decodeCallback(data string) (string, flamingo.UserAction, bool) {
+	parts := strings.SplitN(data, callbackSeparator, 3)
+	if len(parts) < 3 {
+		return "", flamingo.UserAction{}, false
+	}
+
+	return parts[0], flamingo.UserAction{Name: parts[1], Value: parts[2]}, true
+}
@@ -0,0 +1,95 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/flamingo"
+)
+
+func TestFormToMessage(t *testing.T) {
+	require := require.New(t)
+	text, markup, err := formToMessage(flamingo.Form{
+		AuthorName: "Flamingo",
+		Title:      "Deploy <prod>",
+		Text:       "Are you sure?",
+		Footer:     "footer",
+		Fields: []flamingo.FieldGroup{
+			flamingo.NewTextFieldGroup(
+				flamingo.NewTextField("Branch", "master"),
+			),
+			flamingo.Text("free text"),
+			flamingo.Image{URL: "http://img.png", Text: "graph"},
+			flamingo.NewButtonGroup("deploy",
+				flamingo.NewPrimaryButton("Yes", "yes"),
+				flamingo.NewDangerButton("No", "no"),
+			),
+			flamingo.NewButtonGroup("other",
+				flamingo.Button{Text: "Later", Name: "later", Value: "1h"},
+			),
+		},
+	})
+	require.Nil(err)
+
+	require.Equal(
+		"<i>Flamingo</i>\n"+
+			"<b>Deploy &lt;prod&gt;</b>\n"+
+			"Are you sure?\n"+
+			"<b>Branch</b>: master\n"+
+			"free text\n"+
+			`<a href="http://img.png">graph</a>`+"\n"+
+			"<i>footer</i>",
+		text,
+	)
+
+	require.Equal(&inlineKeyboardMarkup{InlineKeyboard: [][]inlineKeyboardButton{
+		{
+			{Text: "Yes", CallbackData: "deploy|yes|yes"},
+			{Text: "No", CallbackData: "deploy|no|no"},
+		},
+		{
+			{Text: "Later", CallbackData: "other|later|1h"},
+		},
+	}}, markup)
+}
+
+func TestFormToMessageEmpty(t *testing.T) {
+	text, markup, err := formToMessage(flamingo.Form{})
+	require.Nil(t, err)
+	require.Equal(t, " ", text)
+	require.Nil(t, markup)
+}
+
+func TestEncodeCallback(t *testing.T) {
+	require := require.New(t)
+	data, err := encodeCallback("group", flamingo.Button{Name: "name", Value: "a|b"})
+	require.Nil(err)
+	require.Equal("group|name|a|b", data)
+
+	_, err = encodeCallback("gro|up", flamingo.Button{Name: "name"})
+	require.Equal(ErrInvalidCallback, err)
+
+	_, err = encodeCallback("group", flamingo.Button{Name: "na|me"})
+	require.Equal(ErrInvalidCallback, err)
+
+	_, err = encodeCallback("group", flamingo.Button{Name: "name", Value: strings.Repeat("a", 54)})
+	require.Equal(ErrCallbackTooLong, err)
+
+	_, _, err = formToMessage(flamingo.Form{Fields: []flamingo.FieldGroup{
+		flamingo.NewButtonGroup(strings.Repeat("g", 65), flamingo.NewButton("Yes", "yes")),
+	}})
+	require.Equal(ErrCallbackTooLong, err)
+}
+
+func TestDecodeCallback(t *testing.T) {
+	require := require.New(t)
+	id, action, ok := decodeCallback("group|name|a|b")
+	require.True(ok)
+	require.Equal("group", id)
+	require.Equal(flamingo.UserAction{Name: "name", Value: "a|b"}, action)
+
+	_, _, ok = decodeCallback("group|name")
+	require.False(ok)
+}